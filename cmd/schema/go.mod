@@ -0,0 +1,12 @@
+module github.com/smartystreets/messaging/v3/cmd/schema
+
+go 1.13
+
+require (
+	github.com/go-sql-driver/mysql v1.5.0
+	github.com/lib/pq v1.10.4
+	github.com/mattn/go-sqlite3 v1.14.15
+	github.com/smartystreets/messaging/v3 v3.0.0-00010101000000-000000000000
+)
+
+replace github.com/smartystreets/messaging/v3 => ../..
@@ -0,0 +1,66 @@
+// Command schema creates or upgrades the table in which sqlmq stores dispatches until they have been published.
+//
+// Usage:
+//
+//	schema -driver mysql -source "user:password@tcp(localhost:3306)/database" [-table Messages] [-dry-run]
+//
+// The dialect is inferred from the driver, which is one of "mysql", "postgres", or "sqlite3". The command is a module of
+// its own such that the database drivers (one of which requires cgo) aren't dependencies of the library.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/smartystreets/messaging/v3/sqlmq"
+)
+
+func main() {
+	driver := flag.String("driver", "mysql", "The database driver: mysql, postgres, or sqlite3.")
+	source := flag.String("source", "", "The driver-specific data source name of the database.")
+	table := flag.String("table", "Messages", "The name of the table in which dispatches are stored.")
+	dryRun := flag.Bool("dry-run", false, "Print the migrations which would be applied without applying them.")
+	flag.Parse()
+
+	dialect, ok := dialects[*driver]
+	if !ok {
+		log.Fatalf("[ERROR] Unsupported driver [%s].", *driver)
+	}
+
+	manager := sqlmq.NewSchemaManager(
+		sqlmq.Options.DataSource(*driver, *source),
+		sqlmq.Options.Dialect(dialect),
+		sqlmq.Options.TableName(*table),
+	)
+
+	var migrations []sqlmq.Migration
+	var err error
+	if *dryRun {
+		migrations, err = manager.Plan(context.Background())
+	} else {
+		migrations, err = manager.Migrate(context.Background())
+	}
+
+	for _, migration := range migrations {
+		fmt.Println(migration)
+		if *dryRun {
+			fmt.Println("\t" + strings.Join(migration.Statements, "\n\t"))
+		}
+	}
+
+	if err != nil {
+		log.Fatalf("[ERROR] Unable to migrate schema: %s", err)
+	}
+}
+
+var dialects = map[string]sqlmq.Dialect{
+	"mysql":    sqlmq.MySQL,
+	"postgres": sqlmq.PostgreSQL,
+	"sqlite3":  sqlmq.SQLite,
+}
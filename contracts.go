@@ -30,6 +30,11 @@ type StreamConfig struct {
 	// On RabbitMQ, this will re-create queues and exchanges and then bind the associated queue to those exchanges.
 	EstablishTopology bool
 
+	// Verifies, without modifying anything, that the topology already exists with the underlying messaging
+	// infrastructure, if supported. On RabbitMQ, this passively declares the queue and exchanges and reports those
+	// which are missing or which don't match.
+	VerifyTopology bool
+
 	// Indicates whether the stream is the only one that will be opened with the broker.
 	ExclusiveStream bool
 
@@ -40,6 +45,27 @@ type StreamConfig struct {
 	// topic.
 	StreamName string
 
+	// If supported by the underlying messaging infrastructure, requests a temporary stream named by the broker that is
+	// exclusive to the connection and removed once it is no longer consumed, in which case StreamName is ignored. On
+	// RabbitMQ, this declares a server-named, exclusive, auto-delete queue and binds it to each of the Topics; the
+	// generated name is available through rabbitmq.NamedStream.
+	TemporaryStream bool
+
+	// If supported by the underlying messaging infrastructure, only one consumer receives messages from the stream at
+	// any given time while the others wait on standby to take over should the active consumer disappear. On RabbitMQ,
+	// this declares the queue with the x-single-active-consumer argument when establishing topology.
+	SingleActiveConsumer bool
+
+	// If supported by the underlying messaging infrastructure, consumers with a higher priority receive messages
+	// ahead of those with a lower priority. On RabbitMQ, this is the x-priority consumer argument.
+	ConsumerPriority int32
+
+	// If supported by the underlying messaging infrastructure, the highest message priority honored by the stream,
+	// such that messages with a higher Priority are delivered ahead of those with a lower one. Zero disables
+	// prioritization. On RabbitMQ, this declares the queue with the x-max-priority argument (at most 255, though
+	// values of 10 or less are recommended) when establishing topology.
+	MaxPriority uint8
+
 	// If supported by the underlying messaging infrastructure, the topics to which the stream should subscribe. In the
 	// case of RabbitMQ, the names of the exchanges to which the stream should subscribe. In cases like Kafka, this
 	// value is ignored and the StreamName above becomes the topic.
@@ -50,8 +76,17 @@ type StreamConfig struct {
 	Partition uint64
 
 	// If supported by the underlying messaging infrastructure, the sequence at which messages should be read from
-	// the topic. In RabbitMQ, this value is ignored. With Kafka, this value is the starting index on the topic.
+	// the topic. In RabbitMQ, this is the starting offset of a Replayable stream (unless an offset has already been
+	// stored for the stream) and is otherwise ignored. With Kafka, this value is the starting index on the topic.
 	Sequence uint64
+
+	// If supported by the underlying messaging infrastructure, the stream is an append-only log from which messages
+	// are not removed once acknowledged and which may be read from any Sequence. On RabbitMQ, this is a stream queue
+	// (x-queue-type: stream) which is consumed with a BufferCapacity of 64 unless otherwise specified. When the stream
+	// is also an ExclusiveStream, the offset of the latest acknowledged delivery is recorded (see the OffsetStore
+	// option of the rabbitmq package) such that a reopened consumer resumes where it left off; otherwise each consumer
+	// begins reading at the Sequence.
+	Replayable bool
 }
 type Stream interface {
 	Read(ctx context.Context, delivery *Delivery) error
@@ -75,7 +110,9 @@ type Dispatch struct {
 	CorrelationID   uint64 // FUTURE: CausationID and UserID
 	Timestamp       time.Time
 	Expiration      time.Duration
+	DeliverAt       time.Time // when set, the message is held by the infrastructure until this instant
 	Durable         bool
+	Priority        uint8 // higher priorities are delivered first by streams that support prioritization
 	Topic           string
 	Partition       uint64
 	MessageType     string
@@ -87,11 +124,17 @@ type Dispatch struct {
 }
 type Delivery struct {
 	DeliveryID      uint64
+	DeliveryCount   uint64 // the number of times the message has been delivered, including this delivery
+	Sequence        uint64 // the position of the message within a replayable stream (RabbitMQ: the stream offset)
+	Redelivered     bool   // the broker has attempted to deliver this message at least once before
 	SourceID        uint64
 	MessageID       uint64
 	CorrelationID   uint64 // FUTURE: CausationID and UserID
 	Timestamp       time.Time
 	Durable         bool
+	Priority        uint8  // higher priorities are delivered first by streams that support prioritization
+	Topic           string // the topic to which the message was originally written (RabbitMQ: the exchange)
+	Partition       uint64 // the partition to which the message was originally written (RabbitMQ: the routing key)
 	MessageType     string
 	ContentType     string
 	ContentEncoding string
@@ -10,8 +10,15 @@ import (
 type amqpConnector struct{}
 
 func (this amqpConnector) Connect(_ context.Context, socket net.Conn, config Config) (Connection, error) {
-	plainAuth := &amqp.PlainAuth{Username: config.Username, Password: config.Password}
-	amqpConfig := amqp.Config{SASL: []amqp.Authentication{plainAuth}, Vhost: config.VirtualHost}
+	amqpConfig := amqp.Config{
+		SASL:       authentication(config),
+		Vhost:      config.VirtualHost,
+		ChannelMax: int(config.ChannelMax),
+		FrameSize:  int(config.FrameSize),
+		Heartbeat:  config.Heartbeat,
+		Properties: clientProperties(config),
+		Locale:     config.Locale,
+	}
 
 	if connection, err := amqp.Open(socket, amqpConfig); err != nil {
 		return nil, err
@@ -20,6 +27,33 @@ func (this amqpConnector) Connect(_ context.Context, socket net.Conn, config Con
 	}
 }
 
+func authentication(config Config) []amqp.Authentication {
+	if config.ExternalAuth {
+		return []amqp.Authentication{externalAuth{}}
+	}
+
+	return []amqp.Authentication{&amqp.PlainAuth{Username: config.Username, Password: config.Password}}
+}
+
+// externalAuth defers authentication to the broker, e.g. using the identity from the TLS client certificate.
+type externalAuth struct{}
+
+func (externalAuth) Mechanism() string { return "EXTERNAL" }
+func (externalAuth) Response() string  { return "" }
+
+func clientProperties(config Config) amqp.Table {
+	properties := amqp.Table{"product": "github.com/smartystreets/messaging", "platform": "golang"}
+	for key, value := range config.Properties {
+		properties[key] = value
+	}
+
+	if len(config.ConnectionName) > 0 {
+		properties["connection_name"] = config.ConnectionName
+	}
+
+	return properties
+}
+
 type amqpConnection struct{ *amqp.Connection }
 
 func (this amqpConnection) Channel() (Channel, error) {
@@ -32,22 +66,43 @@ func (this amqpConnection) Channel() (Channel, error) {
 
 type amqpChannel struct{ *amqp.Channel }
 
-func (this amqpChannel) DeclareQueue(name string) error {
-	_, err := this.Channel.QueueDeclare(name, true, false, false, false, amqp.Table{})
+func (this amqpChannel) DeclareQueue(name string, arguments amqp.Table) error {
+	_, err := this.Channel.QueueDeclare(name, true, false, false, false, arguments)
+	return err
+}
+func (this amqpChannel) DeclareTemporaryQueue() (string, error) {
+	queue, err := this.Channel.QueueDeclare("", false, true, true, false, amqp.Table{}) // server-named, auto-delete, exclusive
+	return queue.Name, err
+}
+func (this amqpChannel) DeclareExchange(name, kind string, arguments amqp.Table) error {
+	return this.Channel.ExchangeDeclare(name, kind, true, false, false, false, arguments)
+}
+func (this amqpChannel) BindQueue(queue, exchange, key string, arguments amqp.Table) error {
+	return this.Channel.QueueBind(queue, key, exchange, false, arguments)
+}
+func (this amqpChannel) UnbindQueue(queue, exchange, key string, arguments amqp.Table) error {
+	return this.Channel.QueueUnbind(queue, key, exchange, arguments)
+}
+func (this amqpChannel) VerifyQueue(name string) error {
+	_, err := this.Channel.QueueDeclarePassive(name, true, false, false, false, amqp.Table{})
 	return err
 }
-func (this amqpChannel) DeclareExchange(name string) error {
-	return this.Channel.ExchangeDeclare(name, amqp.ExchangeFanout, true, false, false, false, amqp.Table{})
+func (this amqpChannel) VerifyExchange(name string) error {
+	return this.Channel.ExchangeDeclarePassive(name, amqp.ExchangeFanout, true, false, false, false, amqp.Table{})
+}
+func (this amqpChannel) DeleteQueue(name string, ifUnused, ifEmpty bool) error {
+	_, err := this.Channel.QueueDelete(name, ifUnused, ifEmpty, false)
+	return err
 }
-func (this amqpChannel) BindQueue(queue, exchange string) error {
-	return this.Channel.QueueBind(queue, "", exchange, false, amqp.Table{})
+func (this amqpChannel) DeleteExchange(name string) error {
+	return this.Channel.ExchangeDelete(name, false, false)
 }
 
 func (this amqpChannel) BufferCapacity(value uint16) error {
 	return this.Channel.Qos(int(value), 0, false) // false = per-consumer limit
 }
-func (this amqpChannel) Consume(consumerID, queue string) (<-chan amqp.Delivery, error) {
-	return this.Channel.Consume(queue, consumerID, false, false, false, false, amqp.Table{})
+func (this amqpChannel) Consume(consumerID, queue string, arguments amqp.Table) (<-chan amqp.Delivery, error) {
+	return this.Channel.Consume(queue, consumerID, false, false, false, false, arguments)
 }
 func (this amqpChannel) CancelConsumer(consumerID string) error {
 	return this.Channel.Cancel(consumerID, false)
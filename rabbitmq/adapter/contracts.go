@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"net"
+	"time"
 
 	"github.com/streadway/amqp"
 )
@@ -11,9 +12,17 @@ import (
 func New() Connector { return amqpConnector{} }
 
 type Config struct {
-	Username    string
-	Password    string
-	VirtualHost string
+	Username     string
+	Password     string
+	ExternalAuth bool // authenticate using SASL EXTERNAL (e.g. the TLS client certificate) instead of the password
+	VirtualHost  string
+
+	ConnectionName string                 // shown in the broker's management UI
+	Properties     map[string]interface{} // additional client properties advertised to the broker
+	Heartbeat      time.Duration          // less than one second uses the broker's interval
+	ChannelMax     uint16                 // zero uses the broker's limit
+	FrameSize      uint32                 // zero uses the broker's limit
+	Locale         string
 }
 
 type Connector interface {
@@ -22,17 +31,26 @@ type Connector interface {
 
 type Connection interface {
 	Channel() (Channel, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	NotifyBlocked(receiver chan amqp.Blocking) chan amqp.Blocking
 	io.Closer
 }
 
 type Channel interface {
-	DeclareQueue(name string) error
-	DeclareExchange(name string) error
-	BindQueue(queue, exchange string) error
+	DeclareQueue(name string, arguments amqp.Table) error
+	DeclareTemporaryQueue() (string, error)
+	DeclareExchange(name, kind string, arguments amqp.Table) error
+	BindQueue(queue, exchange, key string, arguments amqp.Table) error
+	UnbindQueue(queue, exchange, key string, arguments amqp.Table) error
+	VerifyQueue(name string) error
+	VerifyExchange(name string) error
+	DeleteQueue(name string, ifUnused, ifEmpty bool) error
+	DeleteExchange(name string) error
 
 	BufferCapacity(value uint16) error
-	Consume(consumerID, queue string) (<-chan amqp.Delivery, error)
+	Consume(consumerID, queue string, arguments amqp.Table) (<-chan amqp.Delivery, error)
 	Ack(deliveryTag uint64, multiple bool) error
+	Reject(deliveryTag uint64, requeue bool) error
 	CancelConsumer(consumerID string) error
 
 	Publish(exchange, key string, envelope amqp.Publishing) error
@@ -40,5 +58,6 @@ type Channel interface {
 	TxCommit() error
 	TxRollback() error
 
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
 	io.Closer
 }
@@ -0,0 +1,327 @@
+// Package fake provides an in-process stand-in for a RabbitMQ broker which implements the adapter interfaces such that
+// the rabbitmq package can be exercised realistically without a running broker:
+//
+//	broker := fake.NewBroker()
+//	connector := rabbitmq.New(rabbitmq.Options.Connector(broker), rabbitmq.Options.Dialer(broker))
+//
+// It simulates exchanges (with direct, fanout, topic, and headers routing), durable and temporary queues, message
+// priority and expiration, dead-lettering, consumer prefetch (QoS), single active consumers and consumer priority,
+// acknowledgement, transactions, and the channel-closing errors raised by the broker. Unlike a real broker, which
+// closes the channel asynchronously, publishing to a missing exchange returns the channel-closing error immediately.
+package fake
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
+	"github.com/streadway/amqp"
+)
+
+type Broker struct {
+	mutex       sync.Mutex
+	exchanges   map[string]*exchange
+	queues      map[string]*queue
+	connections map[*connection]struct{}
+	blocking    *amqp.Blocking
+	refusal     error
+	sequence    uint64
+	now         func() time.Time
+}
+
+func NewBroker() *Broker {
+	broker := &Broker{
+		exchanges:   make(map[string]*exchange),
+		queues:      make(map[string]*queue),
+		connections: make(map[*connection]struct{}),
+		now:         time.Now,
+	}
+
+	// the pre-declared exchanges of every virtual host, "" is the default exchange which routes by queue name
+	for name, kind := range map[string]string{
+		"":            amqp.ExchangeDirect,
+		"amq.direct":  amqp.ExchangeDirect,
+		"amq.fanout":  amqp.ExchangeFanout,
+		"amq.topic":   amqp.ExchangeTopic,
+		"amq.headers": amqp.ExchangeHeaders,
+	} {
+		broker.exchanges[name] = newExchange(name, kind, amqp.Table{})
+	}
+
+	return broker
+}
+
+// DialContext opens the (unused) socket handed to Connect, it fails with the error given to Refuse, if any.
+func (this *Broker) DialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.refusal != nil {
+		return nil, this.refusal
+	}
+
+	client, server := net.Pipe()
+	_ = server.Close()
+	return client, nil
+}
+func (this *Broker) Connect(ctx context.Context, socket net.Conn, _ adapter.Config) (adapter.Connection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.refusal != nil {
+		return nil, this.refusal
+	}
+
+	connection := newConnection(this, socket)
+	this.connections[connection] = struct{}{}
+	return connection, nil
+}
+
+// Refuse causes subsequent connection attempts to fail with the error provided until called again with nil.
+func (this *Broker) Refuse(err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.refusal = err
+}
+
+// Disconnect forcibly closes every connection with the code and reason provided (e.g. 320: CONNECTION_FORCED).
+func (this *Broker) Disconnect(code int, reason string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for connection := range this.connections {
+		connection.shutdown(&amqp.Error{Code: code, Reason: reason, Server: true, Recover: soft(code)})
+	}
+	this.dispatch()
+}
+
+// Block notifies every connection that publishing has been blocked, e.g. due to a resource alarm.
+func (this *Broker) Block(reason string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.blocking = &amqp.Blocking{Active: true, Reason: reason}
+	for connection := range this.connections {
+		connection.notifyBlocked(*this.blocking)
+	}
+}
+
+// Unblock notifies every connection that publishing has resumed.
+func (this *Broker) Unblock() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.blocking = nil
+	for connection := range this.connections {
+		connection.notifyBlocked(amqp.Blocking{Active: false})
+	}
+}
+
+// Publish routes a message as though it had been published by another client.
+func (this *Broker) Publish(exchange, key string, publishing amqp.Publishing) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if _, contains := this.exchanges[exchange]; !contains {
+		return notFound("exchange", exchange)
+	}
+
+	this.publish(exchange, key, publishing)
+	this.dispatch()
+	return nil
+}
+
+// Ready returns the number of messages in the queue which are waiting to be delivered.
+func (this *Broker) Ready(name string) int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.expire()
+	if queue, contains := this.queues[name]; contains {
+		return len(queue.messages)
+	}
+	return 0
+}
+
+// Unacknowledged returns the number of messages from the queue which have been delivered but not yet acknowledged.
+func (this *Broker) Unacknowledged(name string) (count int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for connection := range this.connections {
+		for channel := range connection.channels {
+			for _, item := range channel.unacknowledged {
+				if item.queue.name == name {
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
+// Consumers returns the number of consumers of the queue.
+func (this *Broker) Consumers(name string) int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if queue, contains := this.queues[name]; contains {
+		return len(queue.consumers)
+	}
+	return 0
+}
+
+// QueueExists indicates whether the queue has been declared (and not yet deleted).
+func (this *Broker) QueueExists(name string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	_, contains := this.queues[name]
+	return contains
+}
+
+// ExchangeExists indicates whether the exchange has been declared (and not yet deleted).
+func (this *Broker) ExchangeExists(name string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	_, contains := this.exchanges[name]
+	return contains
+}
+
+// Connections returns the number of open connections.
+func (this *Broker) Connections() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return len(this.connections)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// publish routes the message to every matching queue; the caller must hold the mutex.
+func (this *Broker) publish(exchangeName, key string, publishing amqp.Publishing) {
+	for _, queue := range this.route(exchangeName, key, publishing.Headers) {
+		this.enqueue(queue, newMessage(exchangeName, key, publishing))
+	}
+}
+func (this *Broker) route(exchangeName, key string, headers amqp.Table) (queues []*queue) {
+	if len(exchangeName) == 0 {
+		if queue, contains := this.queues[key]; contains {
+			queues = append(queues, queue)
+		}
+		return queues
+	}
+
+	exchange, contains := this.exchanges[exchangeName]
+	if !contains {
+		return nil
+	}
+
+	routed := make(map[string]struct{})
+	for _, binding := range exchange.bindings {
+		if _, duplicate := routed[binding.queue]; duplicate || !exchange.matches(binding, key, headers) {
+			continue
+		}
+
+		if queue, contains := this.queues[binding.queue]; contains {
+			routed[binding.queue] = struct{}{}
+			queues = append(queues, queue)
+		}
+	}
+
+	return queues
+}
+func (this *Broker) enqueue(queue *queue, message *message) {
+	now := this.now()
+	if ttl, ok := queue.messageTTL(message.publishing.Expiration); ok {
+		message.expires = now.Add(ttl)
+		time.AfterFunc(ttl, this.expireLater)
+	}
+
+	queue.enqueue(message)
+}
+func (this *Broker) expireLater() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.dispatch()
+}
+
+// expire dead-letters (or drops) every expired message; the caller must hold the mutex.
+func (this *Broker) expire() {
+	now := this.now()
+	for _, queue := range this.queues {
+		for _, message := range queue.expired(now) {
+			this.deadLetter(queue, message)
+		}
+	}
+}
+func (this *Broker) deadLetter(queue *queue, message *message) {
+	exchange, ok := queue.arguments[deadLetterExchangeArgument].(string)
+	if !ok {
+		return // no dead-letter exchange, the message is dropped
+	}
+
+	key := message.key
+	if value, ok := queue.arguments[deadLetterRoutingKeyArgument].(string); ok {
+		key = value
+	}
+
+	publishing := message.publishing
+	publishing.Expiration = "" // removed so that the message doesn't expire again in the queues to which it's routed
+	this.publish(exchange, key, publishing)
+}
+
+// dispatch delivers ready messages to consumers with available capacity; the caller must hold the mutex.
+func (this *Broker) dispatch() {
+	this.expire()
+
+	for _, queue := range this.queues {
+		for len(queue.messages) > 0 {
+			consumer := queue.nextConsumer()
+			if consumer == nil {
+				break
+			}
+
+			consumer.channel.deliver(consumer, queue, queue.dequeue())
+		}
+	}
+}
+
+// deleteQueue removes the queue, its bindings, and its consumers; the caller must hold the mutex.
+func (this *Broker) deleteQueue(name string) {
+	queue, contains := this.queues[name]
+	if !contains {
+		return
+	}
+
+	delete(this.queues, name)
+	for _, exchange := range this.exchanges {
+		exchange.unbindQueue(name)
+	}
+	for _, consumer := range append([]*consumer(nil), queue.consumers...) {
+		consumer.channel.cancel(consumer)
+	}
+}
+
+func (this *Broker) nextSequence() uint64 {
+	this.sequence++
+	return this.sequence
+}
+
+const (
+	deadLetterExchangeArgument   = "x-dead-letter-exchange"
+	deadLetterRoutingKeyArgument = "x-dead-letter-routing-key"
+	messageTTLArgument           = "x-message-ttl"
+	maxPriorityArgument          = "x-max-priority"
+	singleActiveConsumerArgument = "x-single-active-consumer"
+	consumerPriorityArgument     = "x-priority"
+	queueTypeArgument            = "x-queue-type"
+	deliveryCountHeader          = "x-delivery-count"
+	quorumQueueType              = "quorum"
+)
@@ -0,0 +1,382 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/rabbitmq"
+	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
+	"github.com/streadway/amqp"
+)
+
+func TestBrokerFixture(t *testing.T) {
+	gunit.Run(new(BrokerFixture), t)
+}
+
+type BrokerFixture struct {
+	*gunit.Fixture
+
+	broker     *Broker
+	connection adapter.Connection
+	channel    adapter.Channel
+}
+
+func (this *BrokerFixture) Setup() {
+	this.broker = NewBroker()
+	this.connection = this.connect()
+	this.channel, _ = this.connection.Channel()
+}
+func (this *BrokerFixture) Teardown() {
+	_ = this.connection.Close()
+}
+
+func (this *BrokerFixture) TestExchangesRouteToMatchingBindings() {
+	_ = this.channel.DeclareExchange("direct", amqp.ExchangeDirect, amqp.Table{})
+	_ = this.channel.DeclareExchange("fanout", amqp.ExchangeFanout, amqp.Table{})
+	_ = this.channel.DeclareExchange("topic", amqp.ExchangeTopic, amqp.Table{})
+	_ = this.channel.DeclareExchange("headers", amqp.ExchangeHeaders, amqp.Table{})
+	_ = this.channel.DeclareQueue("queue", amqp.Table{})
+	_ = this.channel.BindQueue("queue", "direct", "key", amqp.Table{})
+	_ = this.channel.BindQueue("queue", "fanout", "", amqp.Table{})
+	_ = this.channel.BindQueue("queue", "topic", "a.*.#", amqp.Table{})
+	_ = this.channel.BindQueue("queue", "headers", "", amqp.Table{"x-match": "any", "kind": "match"})
+
+	this.publish("direct", "key")
+	this.publish("direct", "other")
+	this.publish("fanout", "anything")
+	this.publish("topic", "a.b")
+	this.publish("topic", "a.b.c.d")
+	this.publish("topic", "a")
+	this.So(this.broker.Publish("headers", "", amqp.Publishing{Headers: amqp.Table{"kind": "match"}}), should.BeNil)
+	this.So(this.broker.Publish("headers", "", amqp.Publishing{Headers: amqp.Table{"kind": "other"}}), should.BeNil)
+
+	this.So(this.broker.Ready("queue"), should.Equal, 5)
+}
+func (this *BrokerFixture) TestDefaultExchangeRoutesByQueueName() {
+	_ = this.channel.DeclareQueue("queue", amqp.Table{})
+
+	this.publish("", "queue")
+	this.publish("", "missing")
+
+	this.So(this.broker.Ready("queue"), should.Equal, 1)
+}
+func (this *BrokerFixture) TestUnbindingStopsRouting() {
+	_ = this.channel.DeclareExchange("exchange", amqp.ExchangeFanout, amqp.Table{})
+	_ = this.channel.DeclareQueue("queue", amqp.Table{})
+	_ = this.channel.BindQueue("queue", "exchange", "", amqp.Table{})
+	_ = this.channel.UnbindQueue("queue", "exchange", "", amqp.Table{})
+
+	this.publish("exchange", "")
+
+	this.So(this.broker.Ready("queue"), should.Equal, 0)
+}
+
+func (this *BrokerFixture) TestConsumersReceiveDeliveriesUpToTheirPrefetchLimit() {
+	_ = this.channel.DeclareQueue("queue", amqp.Table{})
+	_ = this.channel.BufferCapacity(2)
+	deliveries, err := this.channel.Consume("consumer", "queue", amqp.Table{})
+	this.So(err, should.BeNil)
+
+	this.publish("", "queue")
+	this.publish("", "queue")
+	this.publish("", "queue")
+
+	first, second := receive(deliveries), receive(deliveries)
+	this.So(first.DeliveryTag, should.Equal, 1)
+	this.So(first.ConsumerTag, should.Equal, "consumer")
+	this.So(second.DeliveryTag, should.Equal, 2)
+	this.So(this.broker.Ready("queue"), should.Equal, 1)
+	this.So(this.broker.Unacknowledged("queue"), should.Equal, 2)
+
+	this.So(this.channel.Ack(second.DeliveryTag, true), should.BeNil)
+
+	this.So(receive(deliveries).DeliveryTag, should.Equal, 3)
+	this.So(this.broker.Ready("queue"), should.Equal, 0)
+	this.So(this.broker.Unacknowledged("queue"), should.Equal, 1)
+}
+func (this *BrokerFixture) TestRejectedMessagesAreRequeuedOrDeadLettered() {
+	_ = this.channel.DeclareExchange("dead-letters", amqp.ExchangeFanout, amqp.Table{})
+	_ = this.channel.DeclareQueue("dead", amqp.Table{})
+	_ = this.channel.BindQueue("dead", "dead-letters", "", amqp.Table{})
+	_ = this.channel.DeclareQueue("queue", amqp.Table{"x-dead-letter-exchange": "dead-letters"})
+	deliveries, _ := this.channel.Consume("consumer", "queue", amqp.Table{})
+	this.publish("", "queue")
+
+	this.So(this.channel.Reject(receive(deliveries).DeliveryTag, true), should.BeNil)
+	redelivered := receive(deliveries)
+	this.So(redelivered.Redelivered, should.BeTrue)
+
+	this.So(this.channel.Reject(redelivered.DeliveryTag, false), should.BeNil)
+	this.So(this.broker.Ready("dead"), should.Equal, 1)
+	this.So(this.broker.Unacknowledged("queue"), should.Equal, 0)
+}
+func (this *BrokerFixture) TestExpiredMessagesAreDeadLettered() {
+	_ = this.channel.DeclareQueue("dead", amqp.Table{})
+	_ = this.channel.DeclareQueue("delay", amqp.Table{
+		"x-message-ttl":             int32(1),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": "dead",
+	})
+
+	this.So(this.channel.Publish("", "delay", amqp.Publishing{Expiration: "60000"}), should.BeNil)
+	time.Sleep(time.Millisecond * 10)
+
+	this.So(this.broker.Ready("delay"), should.Equal, 0)
+	this.So(this.broker.Ready("dead"), should.Equal, 1)
+}
+func (this *BrokerFixture) TestHigherPriorityMessagesAreDeliveredFirst() {
+	_ = this.channel.DeclareQueue("queue", amqp.Table{"x-max-priority": int32(5)})
+	_ = this.channel.Publish("", "queue", amqp.Publishing{Priority: 1, MessageId: "1"})
+	_ = this.channel.Publish("", "queue", amqp.Publishing{Priority: 9, MessageId: "9"})
+	_ = this.channel.Publish("", "queue", amqp.Publishing{Priority: 3, MessageId: "3"})
+	_ = this.channel.Publish("", "queue", amqp.Publishing{Priority: 5, MessageId: "5"})
+
+	deliveries, _ := this.channel.Consume("", "queue", amqp.Table{})
+
+	this.So(receive(deliveries).MessageId, should.Equal, "9")
+	this.So(receive(deliveries).MessageId, should.Equal, "5") // both are capped at the queue's maximum of 5
+	this.So(receive(deliveries).MessageId, should.Equal, "3")
+	this.So(receive(deliveries).MessageId, should.Equal, "1")
+}
+func (this *BrokerFixture) TestCancelledConsumerIsClosedAndItsQueueRetained() {
+	_ = this.channel.DeclareQueue("queue", amqp.Table{})
+	deliveries, _ := this.channel.Consume("consumer", "queue", amqp.Table{})
+
+	this.So(this.channel.CancelConsumer("consumer"), should.BeNil)
+
+	_, open := <-deliveries
+	this.So(open, should.BeFalse)
+	this.So(this.broker.Consumers("queue"), should.Equal, 0)
+	this.So(this.broker.QueueExists("queue"), should.BeTrue)
+}
+
+func (this *BrokerFixture) TestTransactionalPublishesAndAcknowledgementsAreAppliedOnCommit() {
+	_ = this.channel.DeclareQueue("queue", amqp.Table{})
+	deliveries, _ := this.channel.Consume("", "queue", amqp.Table{})
+	this.publish("", "queue")
+	delivery := receive(deliveries)
+	_ = this.channel.Tx()
+
+	_ = this.channel.Ack(delivery.DeliveryTag, false)
+	this.publish("", "other")
+	_ = this.channel.DeclareQueue("other", amqp.Table{})
+	this.publish("", "other")
+	this.So(this.broker.Ready("other"), should.Equal, 0)
+	this.So(this.broker.Unacknowledged("queue"), should.Equal, 1)
+
+	this.So(this.channel.TxCommit(), should.BeNil)
+
+	this.So(this.broker.Ready("other"), should.Equal, 2) // routed upon commit, once the queue exists
+	this.So(this.broker.Unacknowledged("queue"), should.Equal, 0)
+}
+func (this *BrokerFixture) TestTransactionalPublishesAndAcknowledgementsAreDiscardedOnRollback() {
+	_ = this.channel.DeclareQueue("queue", amqp.Table{})
+	deliveries, _ := this.channel.Consume("", "queue", amqp.Table{})
+	this.publish("", "queue")
+	delivery := receive(deliveries)
+	_ = this.channel.Tx()
+
+	_ = this.channel.Ack(delivery.DeliveryTag, false)
+	this.publish("", "queue")
+
+	this.So(this.channel.TxRollback(), should.BeNil)
+	this.So(this.channel.TxCommit(), should.BeNil)
+	this.So(this.broker.Ready("queue"), should.Equal, 0)
+	this.So(this.broker.Unacknowledged("queue"), should.Equal, 1)
+}
+func (this *BrokerFixture) TestCommitOutsideOfTransaction_ChannelClosed() {
+	err := this.channel.TxCommit()
+
+	this.So(err.(*amqp.Error).Code, should.Equal, amqp.PreconditionFailed)
+}
+
+func (this *BrokerFixture) TestPublishingToMissingExchange_ChannelClosedWithError() {
+	closes := this.channel.NotifyClose(make(chan *amqp.Error, 1))
+
+	err := this.channel.Publish("missing", "", amqp.Publishing{})
+
+	this.So(err.(*amqp.Error).Code, should.Equal, amqp.NotFound)
+	this.So(<-closes, should.Equal, err)
+	this.So(this.channel.DeclareQueue("queue", amqp.Table{}), should.Equal, amqp.ErrClosed)
+	this.So(this.channel.Close(), should.Equal, amqp.ErrClosed)
+}
+func (this *BrokerFixture) TestInequivalentRedeclaration_ChannelClosedWithError() {
+	_ = this.channel.DeclareQueue("queue", amqp.Table{"x-max-priority": int32(5)})
+	_ = this.channel.DeclareExchange("exchange", amqp.ExchangeFanout, amqp.Table{})
+
+	this.So(this.channel.DeclareQueue("queue", amqp.Table{"x-max-priority": int64(5)}), should.BeNil)
+	this.So(this.channel.DeclareExchange("exchange", amqp.ExchangeFanout, amqp.Table{}), should.BeNil)
+	this.So(this.reopen().DeclareQueue("queue", amqp.Table{}).(*amqp.Error).Code, should.Equal, amqp.PreconditionFailed)
+	this.So(this.reopen().DeclareExchange("exchange", amqp.ExchangeTopic, amqp.Table{}).(*amqp.Error).Code, should.Equal, amqp.PreconditionFailed)
+}
+func (this *BrokerFixture) TestDeletingQueueInUseOrHoldingMessages_RefusedUnlessUnconditional() {
+	_ = this.channel.DeclareQueue("consumed", amqp.Table{})
+	_ = this.channel.DeclareQueue("held", amqp.Table{})
+	_, _ = this.channel.Consume("", "consumed", amqp.Table{})
+	this.publish("", "held")
+
+	this.So(this.reopen().DeleteQueue("consumed", true, false).(*amqp.Error).Code, should.Equal, amqp.PreconditionFailed)
+	this.So(this.reopen().DeleteQueue("held", false, true).(*amqp.Error).Code, should.Equal, amqp.PreconditionFailed)
+	this.So(this.broker.QueueExists("consumed"), should.BeTrue)
+	this.So(this.broker.QueueExists("held"), should.BeTrue)
+
+	this.So(this.reopen().DeleteQueue("consumed", false, true), should.BeNil)
+	this.So(this.channel.DeleteQueue("held", true, false), should.BeNil)
+	this.So(this.broker.QueueExists("consumed"), should.BeFalse)
+	this.So(this.broker.QueueExists("held"), should.BeFalse)
+}
+func (this *BrokerFixture) TestVerifyingMissingEntities_ChannelClosedWithError() {
+	this.So(this.reopen().VerifyQueue("missing").(*amqp.Error).Code, should.Equal, amqp.NotFound)
+	this.So(this.reopen().VerifyExchange("missing").(*amqp.Error).Code, should.Equal, amqp.NotFound)
+	this.So(this.reopen().BindQueue("missing", "amq.fanout", "", amqp.Table{}).(*amqp.Error).Code, should.Equal, amqp.NotFound)
+	_, err := this.reopen().Consume("", "missing", amqp.Table{})
+	this.So(err.(*amqp.Error).Code, should.Equal, amqp.NotFound)
+}
+func (this *BrokerFixture) TestAcknowledgingUnknownDeliveryTag_ChannelClosedWithError() {
+	err := this.channel.Ack(42, false)
+
+	this.So(err.(*amqp.Error).Code, should.Equal, amqp.PreconditionFailed)
+}
+func (this *BrokerFixture) TestClosingChannel_UnacknowledgedMessagesAreRequeued() {
+	_ = this.channel.DeclareQueue("queue", amqp.Table{})
+	deliveries, _ := this.channel.Consume("", "queue", amqp.Table{})
+	this.publish("", "queue")
+	this.publish("", "queue")
+	_ = receive(deliveries)
+
+	this.So(this.channel.Close(), should.BeNil)
+
+	_, open := <-deliveries
+	this.So(open, should.BeFalse)
+	this.So(this.broker.Ready("queue"), should.Equal, 2)
+	this.So(this.broker.Unacknowledged("queue"), should.Equal, 0)
+}
+
+func (this *BrokerFixture) TestTemporaryQueuesAreExclusiveToTheirConnection() {
+	name, err := this.channel.DeclareTemporaryQueue()
+	this.So(err, should.BeNil)
+
+	other, _ := this.connect().Channel()
+	this.So(other.VerifyQueue(name).(*amqp.Error).Code, should.Equal, amqp.ResourceLocked)
+
+	_ = this.connection.Close()
+	this.So(this.broker.QueueExists(name), should.BeFalse)
+}
+func (this *BrokerFixture) TestTemporaryQueuesAreRemovedOnceTheirLastConsumerIsCancelled() {
+	name, _ := this.channel.DeclareTemporaryQueue()
+	_, _ = this.channel.Consume("first", name, amqp.Table{})
+	_, _ = this.channel.Consume("second", name, amqp.Table{})
+
+	this.So(this.channel.CancelConsumer("first"), should.BeNil)
+	this.So(this.broker.QueueExists(name), should.BeTrue)
+
+	this.So(this.channel.CancelConsumer("second"), should.BeNil)
+	this.So(this.broker.QueueExists(name), should.BeFalse)
+}
+func (this *BrokerFixture) TestDisconnect_ConnectionsAndChannelsNotified() {
+	connectionCloses := this.connection.NotifyClose(make(chan *amqp.Error, 1))
+	channelCloses := this.channel.NotifyClose(make(chan *amqp.Error, 1))
+
+	this.broker.Disconnect(amqp.ConnectionForced, "CONNECTION_FORCED")
+
+	this.So((<-connectionCloses).Code, should.Equal, amqp.ConnectionForced)
+	this.So((<-channelCloses).Code, should.Equal, amqp.ConnectionForced)
+	this.So(this.broker.Connections(), should.Equal, 0)
+	_, err := this.connection.Channel()
+	this.So(err, should.Equal, amqp.ErrClosed)
+}
+func (this *BrokerFixture) TestRefuse_ConnectionAttemptsFail() {
+	refusal := errors.New("refused")
+	this.broker.Refuse(refusal)
+
+	_, err := this.broker.DialContext(context.Background(), "tcp", "localhost")
+
+	this.So(err, should.Equal, refusal)
+}
+func (this *BrokerFixture) TestBlock_ConnectionsNotified() {
+	blocks := this.connection.NotifyBlocked(make(chan amqp.Blocking, 2))
+
+	this.broker.Block("memory")
+	this.broker.Unblock()
+
+	this.So(<-blocks, should.Resemble, amqp.Blocking{Active: true, Reason: "memory"})
+	this.So(<-blocks, should.Resemble, amqp.Blocking{Active: false})
+}
+
+func (this *BrokerFixture) TestRoundTripThroughConnector() {
+	connector := rabbitmq.New(rabbitmq.Options.Connector(this.broker), rabbitmq.Options.Dialer(this.broker))
+	defer func() { _ = connector.Close() }()
+	connection, err := connector.Connect(context.Background())
+	this.So(err, should.BeNil)
+	reader, _ := connection.Reader(context.Background())
+	stream, err := reader.Stream(context.Background(), messaging.StreamConfig{
+		EstablishTopology: true,
+		StreamName:        "queue",
+		Topics:            []string{"topic"},
+	})
+	this.So(err, should.BeNil)
+
+	writer, _ := connection.CommitWriter(context.Background())
+	_, err = writer.Write(context.Background(), messaging.Dispatch{Topic: "topic", MessageType: "type", Payload: []byte("payload")})
+	this.So(err, should.BeNil)
+	this.So(writer.Commit(), should.BeNil)
+
+	var delivery messaging.Delivery
+	this.So(stream.Read(context.Background(), &delivery), should.BeNil)
+	this.So(delivery.MessageType, should.Equal, "type")
+	this.So(delivery.Payload, should.Resemble, []byte("payload"))
+	this.So(stream.Acknowledge(context.Background(), delivery), should.BeNil)
+	this.So(this.broker.Unacknowledged("queue"), should.Equal, 0)
+}
+
+func (this *BrokerFixture) TestStreamRecoversFromChannelLevelErrorWithoutClosingConnection() {
+	connector := rabbitmq.New(rabbitmq.Options.Connector(this.broker), rabbitmq.Options.Dialer(this.broker))
+	defer func() { _ = connector.Close() }()
+	connection, _ := connector.Connect(context.Background())
+	reader, _ := connection.Reader(context.Background())
+	stream, _ := reader.Stream(context.Background(), messaging.StreamConfig{EstablishTopology: true, StreamName: "queue"})
+	this.So(this.broker.Publish("", "queue", amqp.Publishing{MessageId: "1"}), should.BeNil)
+
+	var delivery messaging.Delivery
+	_ = stream.Read(context.Background(), &delivery)
+	err := stream.Acknowledge(context.Background(), messaging.Delivery{DeliveryID: 42}) // unknown delivery tag
+	this.So(err, should.BeNil)
+
+	var redelivery messaging.Delivery
+	this.So(stream.Read(context.Background(), &redelivery), should.BeNil)
+	this.So(redelivery.MessageID, should.Equal, 1)
+	this.So(redelivery.Redelivered, should.BeTrue)
+	this.So(stream.Acknowledge(context.Background(), delivery, redelivery), should.BeNil)
+	this.So(this.broker.Ready("queue"), should.Equal, 0)
+	this.So(this.broker.Unacknowledged("queue"), should.Equal, 0)
+	this.So(this.broker.Connections(), should.Equal, 2)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *BrokerFixture) connect() adapter.Connection {
+	socket, _ := this.broker.DialContext(context.Background(), "tcp", "localhost")
+	connection, _ := this.broker.Connect(context.Background(), socket, adapter.Config{})
+	return connection
+}
+func (this *BrokerFixture) reopen() adapter.Channel {
+	this.channel, _ = this.connection.Channel()
+	return this.channel
+}
+func (this *BrokerFixture) publish(exchange, key string) {
+	_ = this.channel.Publish(exchange, key, amqp.Publishing{})
+}
+
+func receive(deliveries <-chan amqp.Delivery) amqp.Delivery {
+	select {
+	case delivery := <-deliveries:
+		return delivery
+	case <-time.After(time.Second):
+		return amqp.Delivery{}
+	}
+}
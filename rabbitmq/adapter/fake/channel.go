@@ -0,0 +1,454 @@
+package fake
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/streadway/amqp"
+)
+
+type channel struct {
+	broker     *Broker
+	connection *connection
+	closes     []chan *amqp.Error
+	closed     bool
+
+	prefetch       uint16
+	consumers      map[string]*consumer
+	unacknowledged map[uint64]*entry
+	deliveryTag    uint64
+
+	transactional bool
+	publications  []publication
+	settlements   []settlement
+}
+type entry struct {
+	queue    *queue
+	message  *message
+	consumer *consumer
+}
+type publication struct {
+	exchange   string
+	key        string
+	publishing amqp.Publishing
+}
+type settlement struct {
+	deliveryTag uint64
+	multiple    bool
+	ack         bool
+	requeue     bool
+}
+
+func newChannel(broker *Broker, connection *connection) *channel {
+	return &channel{
+		broker:         broker,
+		connection:     connection,
+		consumers:      make(map[string]*consumer),
+		unacknowledged: make(map[uint64]*entry),
+	}
+}
+
+func (this *channel) DeclareQueue(name string, arguments amqp.Table) error {
+	return this.do(func() error {
+		if existing, contains := this.broker.queues[name]; contains {
+			return this.redeclareQueue(existing, arguments)
+		}
+
+		this.broker.queues[name] = newQueue(name, copyTable(arguments), nil)
+		return nil
+	})
+}
+func (this *channel) redeclareQueue(existing *queue, arguments amqp.Table) error {
+	if existing.lockedBy(this.connection) {
+		return this.fail(amqp.ResourceLocked, "RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s'", existing.name)
+	} else if existing.owner != nil {
+		return this.fail(amqp.PreconditionFailed, "PRECONDITION_FAILED - inequivalent arg 'durable' for queue '%s'", existing.name)
+	} else if !equivalentArguments(existing.arguments, arguments) {
+		return this.fail(amqp.PreconditionFailed, "PRECONDITION_FAILED - inequivalent arguments for queue '%s'", existing.name)
+	}
+	return nil
+}
+func (this *channel) DeclareTemporaryQueue() (name string, err error) {
+	err = this.do(func() error {
+		name = "amq.gen-" + strconv.FormatUint(this.broker.nextSequence(), 10)
+		this.broker.queues[name] = newQueue(name, amqp.Table{}, this.connection)
+		return nil
+	})
+	return name, err
+}
+func (this *channel) DeclareExchange(name, kind string, arguments amqp.Table) error {
+	return this.do(func() error {
+		if existing, contains := this.broker.exchanges[name]; contains {
+			if !existing.equivalent(kind, arguments) {
+				return this.fail(amqp.PreconditionFailed, "PRECONDITION_FAILED - inequivalent arg 'type' for exchange '%s'", name)
+			}
+			return nil
+		}
+
+		if reserved(name) {
+			return this.fail(amqp.AccessRefused, "ACCESS_REFUSED - exchange name '%s' contains reserved prefix 'amq.*'", name)
+		} else if !supported(kind) {
+			return this.fail(amqp.CommandInvalid, "COMMAND_INVALID - invalid exchange type '%s'", kind)
+		}
+
+		this.broker.exchanges[name] = newExchange(name, kind, copyTable(arguments))
+		return nil
+	})
+}
+func (this *channel) BindQueue(queue, exchange, key string, arguments amqp.Table) error {
+	return this.do(func() error {
+		target, err := this.binding(queue, exchange)
+		if err != nil {
+			return err
+		}
+
+		target.bind(binding{queue: queue, key: key, arguments: copyTable(arguments)})
+		return nil
+	})
+}
+func (this *channel) UnbindQueue(queue, exchange, key string, arguments amqp.Table) error {
+	return this.do(func() error {
+		target, err := this.binding(queue, exchange)
+		if err != nil {
+			return err
+		}
+
+		target.unbind(binding{queue: queue, key: key, arguments: arguments})
+		return nil
+	})
+}
+func (this *channel) binding(queueName, exchangeName string) (*exchange, error) {
+	if len(exchangeName) == 0 {
+		return nil, this.fail(amqp.AccessRefused, "ACCESS_REFUSED - operation not permitted on the default exchange")
+	}
+
+	queue, contains := this.broker.queues[queueName]
+	if !contains {
+		return nil, this.fail(amqp.NotFound, "NOT_FOUND - no queue '%s'", queueName)
+	} else if queue.lockedBy(this.connection) {
+		return nil, this.fail(amqp.ResourceLocked, "RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s'", queueName)
+	}
+
+	exchange, contains := this.broker.exchanges[exchangeName]
+	if !contains {
+		return nil, this.fail(amqp.NotFound, "NOT_FOUND - no exchange '%s'", exchangeName)
+	}
+
+	return exchange, nil
+}
+func (this *channel) VerifyQueue(name string) error {
+	return this.do(func() error {
+		if queue, contains := this.broker.queues[name]; !contains {
+			return this.fail(amqp.NotFound, "NOT_FOUND - no queue '%s'", name)
+		} else if queue.lockedBy(this.connection) {
+			return this.fail(amqp.ResourceLocked, "RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s'", name)
+		}
+		return nil
+	})
+}
+func (this *channel) VerifyExchange(name string) error {
+	return this.do(func() error {
+		if _, contains := this.broker.exchanges[name]; !contains {
+			return this.fail(amqp.NotFound, "NOT_FOUND - no exchange '%s'", name)
+		}
+		return nil
+	})
+}
+func (this *channel) DeleteQueue(name string, ifUnused, ifEmpty bool) error {
+	return this.do(func() error {
+		if queue, contains := this.broker.queues[name]; contains && queue.lockedBy(this.connection) {
+			return this.fail(amqp.ResourceLocked, "RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s'", name)
+		} else if contains && ifUnused && len(queue.consumers) > 0 {
+			return this.fail(amqp.PreconditionFailed, "PRECONDITION_FAILED - queue '%s' in use", name)
+		} else if contains && ifEmpty && len(queue.messages) > 0 {
+			return this.fail(amqp.PreconditionFailed, "PRECONDITION_FAILED - queue '%s' not empty", name)
+		}
+
+		this.broker.deleteQueue(name)
+		return nil
+	})
+}
+func (this *channel) DeleteExchange(name string) error {
+	return this.do(func() error {
+		if len(name) == 0 || reserved(name) {
+			return this.fail(amqp.AccessRefused, "ACCESS_REFUSED - operation not permitted on exchange '%s'", name)
+		}
+
+		delete(this.broker.exchanges, name)
+		return nil
+	})
+}
+
+func (this *channel) BufferCapacity(value uint16) error {
+	return this.do(func() error {
+		this.prefetch = value // like the broker, it applies to consumers created afterward
+		return nil
+	})
+}
+func (this *channel) Consume(consumerID, queueName string, arguments amqp.Table) (deliveries <-chan amqp.Delivery, err error) {
+	err = this.do(func() error {
+		if len(consumerID) == 0 {
+			consumerID = "amq.ctag-" + strconv.FormatUint(this.broker.nextSequence(), 10)
+		}
+
+		queue, contains := this.broker.queues[queueName]
+		if !contains {
+			return this.fail(amqp.NotFound, "NOT_FOUND - no queue '%s'", queueName)
+		} else if queue.lockedBy(this.connection) {
+			return this.fail(amqp.ResourceLocked, "RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s'", queueName)
+		} else if _, contains := this.consumers[consumerID]; contains {
+			return this.fail(amqp.NotAllowed, "NOT_ALLOWED - attempt to reuse consumer tag '%s'", consumerID)
+		}
+
+		priority, _ := integer(arguments[consumerPriorityArgument])
+		consumer := newConsumer(this, queue, consumerID, this.prefetch, priority)
+		this.consumers[consumerID] = consumer
+		queue.subscribe(consumer)
+		deliveries = consumer.output
+		return nil
+	})
+	return deliveries, err
+}
+func (this *channel) CancelConsumer(consumerID string) error {
+	return this.do(func() error {
+		if consumer, contains := this.consumers[consumerID]; contains {
+			this.cancel(consumer)
+		}
+		return nil
+	})
+}
+
+// cancel stops the consumer and requeues the deliveries it hasn't yet handed over; the caller must hold the mutex.
+func (this *channel) cancel(consumer *consumer) {
+	delete(this.consumers, consumer.tag)
+	consumer.queue.unsubscribe(consumer)
+
+	pending := consumer.stop()
+	for i := len(pending) - 1; i >= 0; i-- {
+		this.settle(pending[i].DeliveryTag, false, false, true)
+	}
+
+	if queue := consumer.queue; queue.autoDelete && len(queue.consumers) == 0 {
+		this.broker.deleteQueue(queue.name)
+	}
+}
+
+func (this *channel) Ack(deliveryTag uint64, multiple bool) error {
+	return this.acknowledge(settlement{deliveryTag: deliveryTag, multiple: multiple, ack: true})
+}
+func (this *channel) Nack(deliveryTag uint64, multiple, requeue bool) error {
+	return this.acknowledge(settlement{deliveryTag: deliveryTag, multiple: multiple, requeue: requeue})
+}
+func (this *channel) Reject(deliveryTag uint64, requeue bool) error {
+	return this.acknowledge(settlement{deliveryTag: deliveryTag, requeue: requeue})
+}
+func (this *channel) acknowledge(value settlement) error {
+	return this.do(func() error {
+		if _, contains := this.unacknowledged[value.deliveryTag]; !contains && (value.deliveryTag > 0 || !value.multiple) {
+			return this.fail(amqp.PreconditionFailed, "PRECONDITION_FAILED - unknown delivery tag %d", value.deliveryTag)
+		}
+
+		if this.transactional {
+			this.settlements = append(this.settlements, value)
+		} else {
+			this.settle(value.deliveryTag, value.multiple, value.ack, value.requeue)
+		}
+		return nil
+	})
+}
+
+// settle acknowledges (or rejects) the delivery, including all prior deliveries when multiple is set.
+func (this *channel) settle(deliveryTag uint64, multiple, ack, requeue bool) {
+	var tags []uint64
+	for tag := range this.unacknowledged {
+		if tag == deliveryTag || (multiple && (deliveryTag == 0 || tag < deliveryTag)) {
+			tags = append(tags, tag)
+		}
+	}
+
+	// highest first such that requeued messages regain their original order at the head of the queue
+	sort.Slice(tags, func(i, j int) bool { return tags[i] > tags[j] })
+	for _, tag := range tags {
+		item := this.unacknowledged[tag]
+		delete(this.unacknowledged, tag)
+		item.consumer.unacked--
+
+		if ack || this.broker.queues[item.queue.name] != item.queue {
+			continue // acknowledged, or the queue has since been deleted
+		} else if requeue {
+			item.queue.requeue(item.message)
+		} else {
+			this.broker.deadLetter(item.queue, item.message)
+		}
+	}
+}
+
+func (this *channel) Publish(exchange, key string, publishing amqp.Publishing) error {
+	return this.do(func() error {
+		if _, contains := this.broker.exchanges[exchange]; !contains {
+			return this.fail(amqp.NotFound, "NOT_FOUND - no exchange '%s'", exchange)
+		}
+
+		if this.transactional {
+			this.publications = append(this.publications, publication{exchange: exchange, key: key, publishing: publishing})
+		} else {
+			this.broker.publish(exchange, key, publishing)
+		}
+		return nil
+	})
+}
+func (this *channel) Tx() error {
+	return this.do(func() error {
+		this.transactional = true
+		return nil
+	})
+}
+func (this *channel) TxCommit() error {
+	return this.do(func() error {
+		if !this.transactional {
+			return this.fail(amqp.PreconditionFailed, "PRECONDITION_FAILED - channel is not transactional")
+		}
+
+		for _, item := range this.publications {
+			if _, contains := this.broker.exchanges[item.exchange]; contains {
+				this.broker.publish(item.exchange, item.key, item.publishing)
+			}
+		}
+		for _, item := range this.settlements {
+			this.settle(item.deliveryTag, item.multiple, item.ack, item.requeue)
+		}
+
+		this.publications, this.settlements = nil, nil
+		return nil
+	})
+}
+func (this *channel) TxRollback() error {
+	return this.do(func() error {
+		if !this.transactional {
+			return this.fail(amqp.PreconditionFailed, "PRECONDITION_FAILED - channel is not transactional")
+		}
+
+		this.publications, this.settlements = nil, nil
+		return nil
+	})
+}
+
+func (this *channel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	this.broker.mutex.Lock()
+	defer this.broker.mutex.Unlock()
+
+	if this.closed {
+		close(receiver)
+	} else {
+		this.closes = append(this.closes, receiver)
+	}
+
+	return receiver
+}
+func (this *channel) Close() error {
+	this.broker.mutex.Lock()
+	defer this.broker.mutex.Unlock()
+
+	if this.closed {
+		return amqp.ErrClosed
+	}
+
+	this.shutdown(nil)
+	this.broker.dispatch()
+	return nil
+}
+
+// shutdown closes the channel, stops its consumers, and requeues its unacknowledged messages; the caller must hold
+// the mutex.
+func (this *channel) shutdown(err *amqp.Error) {
+	if this.closed {
+		return
+	}
+	this.closed = true
+
+	for _, receiver := range this.closes {
+		notifyClosing(receiver, err)
+	}
+	this.closes = nil
+
+	for _, consumer := range this.consumers {
+		this.cancel(consumer)
+	}
+	this.settle(0, true, false, true)
+
+	this.publications, this.settlements = nil, nil
+	delete(this.connection.channels, this)
+}
+
+// do performs the operation while holding the mutex and then delivers any messages which have become ready.
+func (this *channel) do(operation func() error) error {
+	this.broker.mutex.Lock()
+	defer this.broker.mutex.Unlock()
+
+	if this.closed {
+		return amqp.ErrClosed
+	}
+
+	err := operation()
+	this.broker.dispatch()
+	return err
+}
+
+// fail closes the channel with the error, as the broker does when an operation cannot be performed. Like the broker,
+// hard errors (e.g. 530: NOT_ALLOWED) close the entire connection rather than only the channel.
+func (this *channel) fail(code int, format string, args ...interface{}) error {
+	err := &amqp.Error{Code: code, Reason: fmt.Sprintf(format, args...), Server: true, Recover: soft(code)}
+	if err.Recover {
+		this.shutdown(err)
+	} else {
+		this.connection.shutdown(err)
+	}
+	return err
+}
+
+// deliver hands the message to the consumer; the caller must hold the mutex.
+func (this *channel) deliver(consumer *consumer, queue *queue, message *message) {
+	this.deliveryTag++
+	this.unacknowledged[this.deliveryTag] = &entry{queue: queue, message: message, consumer: consumer}
+	consumer.unacked++
+
+	delivery := queue.delivery(message)
+	delivery.Acknowledger = this
+	delivery.ConsumerTag = consumer.tag
+	delivery.DeliveryTag = this.deliveryTag
+	consumer.push(delivery)
+}
+
+func notFound(kind, name string) error {
+	reason := fmt.Sprintf("NOT_FOUND - no %s '%s'", kind, name)
+	return &amqp.Error{Code: amqp.NotFound, Reason: reason, Server: true, Recover: true}
+}
+
+// soft indicates whether the error only closes the channel, in which case the amqp library marks it as recoverable.
+func soft(code int) bool {
+	switch code {
+	case amqp.ContentTooLarge, amqp.NoRoute, amqp.NoConsumers, amqp.AccessRefused, amqp.NotFound, amqp.ResourceLocked, amqp.PreconditionFailed:
+		return true
+	default:
+		return false
+	}
+}
+func reserved(name string) bool {
+	return len(name) >= 4 && name[:4] == "amq."
+}
+func supported(kind string) bool {
+	switch kind {
+	case amqp.ExchangeDirect, amqp.ExchangeFanout, amqp.ExchangeTopic, amqp.ExchangeHeaders:
+		return true
+	default:
+		return false
+	}
+}
+func copyTable(source amqp.Table) amqp.Table {
+	target := amqp.Table{}
+	for key, value := range source {
+		target[key] = value
+	}
+	return target
+}
@@ -0,0 +1,131 @@
+package fake
+
+import (
+	"net"
+
+	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
+	"github.com/streadway/amqp"
+)
+
+type connection struct {
+	broker   *Broker
+	socket   net.Conn
+	channels map[*channel]struct{}
+	closes   []chan *amqp.Error
+	blocks   []chan amqp.Blocking
+	closed   bool
+}
+
+func newConnection(broker *Broker, socket net.Conn) *connection {
+	return &connection{broker: broker, socket: socket, channels: make(map[*channel]struct{})}
+}
+
+func (this *connection) Channel() (adapter.Channel, error) {
+	this.broker.mutex.Lock()
+	defer this.broker.mutex.Unlock()
+
+	if this.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	channel := newChannel(this.broker, this)
+	this.channels[channel] = struct{}{}
+	return channel, nil
+}
+func (this *connection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	this.broker.mutex.Lock()
+	defer this.broker.mutex.Unlock()
+
+	if this.closed {
+		close(receiver)
+	} else {
+		this.closes = append(this.closes, receiver)
+	}
+
+	return receiver
+}
+func (this *connection) NotifyBlocked(receiver chan amqp.Blocking) chan amqp.Blocking {
+	this.broker.mutex.Lock()
+	defer this.broker.mutex.Unlock()
+
+	if this.closed {
+		close(receiver)
+		return receiver
+	}
+
+	this.blocks = append(this.blocks, receiver)
+	if this.broker.blocking != nil {
+		notifyBlocking(receiver, *this.broker.blocking)
+	}
+
+	return receiver
+}
+func (this *connection) Close() error {
+	this.broker.mutex.Lock()
+	defer this.broker.mutex.Unlock()
+
+	if this.closed {
+		return amqp.ErrClosed
+	}
+
+	this.shutdown(nil)
+	this.broker.dispatch()
+	return nil
+}
+
+// shutdown closes the connection, its channels, and its exclusive queues; the caller must hold the broker's mutex.
+func (this *connection) shutdown(err *amqp.Error) {
+	if this.closed {
+		return
+	}
+	this.closed = true
+
+	for channel := range this.channels {
+		channel.shutdown(err)
+	}
+
+	for name, queue := range this.broker.queues {
+		if queue.owner == this {
+			this.broker.deleteQueue(name)
+		}
+	}
+
+	for _, receiver := range this.closes {
+		notifyClosing(receiver, err)
+	}
+	for _, receiver := range this.blocks {
+		close(receiver)
+	}
+	this.closes, this.blocks = nil, nil
+
+	delete(this.broker.connections, this)
+	_ = this.socket.Close()
+}
+func (this *connection) notifyBlocked(value amqp.Blocking) {
+	for _, receiver := range this.blocks {
+		notifyBlocking(receiver, value)
+	}
+}
+
+// notifyClosing sends the error (if any) and then closes the receiver, as the amqp library does. Because the mutex
+// is held, a receiver which isn't ready is notified in the background rather than blocking the broker.
+func notifyClosing(receiver chan *amqp.Error, err *amqp.Error) {
+	if err == nil {
+		close(receiver)
+		return
+	}
+
+	select {
+	case receiver <- err:
+		close(receiver)
+	default:
+		go func() { receiver <- err; close(receiver) }()
+	}
+}
+func notifyBlocking(receiver chan amqp.Blocking, value amqp.Blocking) {
+	select {
+	case receiver <- value:
+	default:
+		go func() { defer func() { recover() }(); receiver <- value }() // the receiver may be closed in the meantime
+	}
+}
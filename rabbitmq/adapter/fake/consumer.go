@@ -0,0 +1,96 @@
+package fake
+
+import "github.com/streadway/amqp"
+
+type consumer struct {
+	channel  *channel
+	queue    *queue
+	tag      string
+	prefetch uint16
+	priority int64
+	unacked  int
+
+	pending []amqp.Delivery
+	signal  chan struct{}
+	done    chan struct{}
+	output  chan amqp.Delivery
+}
+
+func newConsumer(channel *channel, queue *queue, tag string, prefetch uint16, priority int64) *consumer {
+	consumer := &consumer{
+		channel:  channel,
+		queue:    queue,
+		tag:      tag,
+		prefetch: prefetch,
+		priority: priority,
+		signal:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		output:   make(chan amqp.Delivery),
+	}
+	go consumer.pump()
+	return consumer
+}
+
+// available indicates whether the consumer may receive another message without exceeding its prefetch limit.
+func (this *consumer) available() bool {
+	return this.prefetch == 0 || this.unacked < int(this.prefetch)
+}
+
+// push enqueues the delivery to be handed over by the pump; the caller must hold the mutex.
+func (this *consumer) push(delivery amqp.Delivery) {
+	this.pending = append(this.pending, delivery)
+
+	select {
+	case this.signal <- struct{}{}:
+	default:
+	}
+}
+
+// stop ends the pump (closing the output) and returns the deliveries not yet handed over; the caller must hold the
+// mutex.
+func (this *consumer) stop() (pending []amqp.Delivery) {
+	close(this.done)
+	pending, this.pending = this.pending, nil
+	return pending
+}
+
+// pump hands deliveries to the consumer in order without ever blocking the broker while holding its mutex.
+func (this *consumer) pump() {
+	defer close(this.output)
+
+	for {
+		delivery, ok := this.next()
+		if !ok {
+			select {
+			case <-this.signal:
+				continue
+			case <-this.done:
+				return
+			}
+		}
+
+		select {
+		case this.output <- delivery:
+		case <-this.done:
+			return
+		}
+	}
+}
+func (this *consumer) next() (amqp.Delivery, bool) {
+	this.channel.broker.mutex.Lock()
+	defer this.channel.broker.mutex.Unlock()
+
+	select {
+	case <-this.done:
+		return amqp.Delivery{}, false
+	default:
+	}
+
+	if len(this.pending) == 0 {
+		return amqp.Delivery{}, false
+	}
+
+	delivery := this.pending[0]
+	this.pending = this.pending[1:]
+	return delivery, true
+}
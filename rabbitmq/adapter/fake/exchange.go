@@ -0,0 +1,157 @@
+package fake
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/streadway/amqp"
+)
+
+type exchange struct {
+	name      string
+	kind      string
+	arguments amqp.Table
+	bindings  []binding
+}
+type binding struct {
+	queue     string
+	key       string
+	arguments amqp.Table
+}
+
+func newExchange(name, kind string, arguments amqp.Table) *exchange {
+	return &exchange{name: name, kind: kind, arguments: arguments}
+}
+
+func (this *exchange) equivalent(kind string, arguments amqp.Table) bool {
+	return this.kind == kind && equivalentArguments(this.arguments, arguments)
+}
+func (this *exchange) bind(value binding) {
+	for _, item := range this.bindings {
+		if item.queue == value.queue && item.key == value.key && equivalentArguments(item.arguments, value.arguments) {
+			return // bindings are idempotent
+		}
+	}
+	this.bindings = append(this.bindings, value)
+}
+func (this *exchange) unbind(value binding) {
+	bindings := this.bindings[0:0]
+	for _, item := range this.bindings {
+		if item.queue != value.queue || item.key != value.key || !equivalentArguments(item.arguments, value.arguments) {
+			bindings = append(bindings, item)
+		}
+	}
+	this.bindings = bindings
+}
+func (this *exchange) unbindQueue(queue string) {
+	bindings := this.bindings[0:0]
+	for _, item := range this.bindings {
+		if item.queue != queue {
+			bindings = append(bindings, item)
+		}
+	}
+	this.bindings = bindings
+}
+
+func (this *exchange) matches(binding binding, key string, headers amqp.Table) bool {
+	switch this.kind {
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeTopic:
+		return matchTopic(strings.Split(binding.key, "."), strings.Split(key, "."))
+	case amqp.ExchangeHeaders:
+		return matchHeaders(binding.arguments, headers)
+	default:
+		return binding.key == key
+	}
+}
+
+// matchTopic compares dot-separated words where "*" matches exactly one word and "#" matches zero or more words.
+func matchTopic(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchTopic(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchTopic(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchTopic(pattern[1:], words[1:])
+	}
+}
+
+// matchHeaders compares the binding arguments to the message headers using x-match "all" (the default) or "any".
+func matchHeaders(arguments, headers amqp.Table) bool {
+	matchAny := arguments["x-match"] == "any"
+
+	for key, expected := range arguments {
+		if strings.HasPrefix(key, "x-") {
+			continue
+		}
+
+		actual, contains := headers[key]
+		matched := contains && reflect.DeepEqual(actual, expected)
+		if matchAny && matched {
+			return true
+		} else if !matchAny && !matched {
+			return false
+		}
+	}
+
+	return !matchAny
+}
+
+// equivalentArguments compares arguments the way the broker does, where integers of different widths are equivalent.
+func equivalentArguments(left, right amqp.Table) bool {
+	if len(left) != len(right) {
+		return false
+	}
+
+	for key, value := range left {
+		other, contains := right[key]
+		if !contains {
+			return false
+		}
+
+		if leftInteger, ok := integer(value); ok {
+			if rightInteger, ok := integer(other); !ok || leftInteger != rightInteger {
+				return false
+			}
+		} else if !reflect.DeepEqual(value, other) {
+			return false
+		}
+	}
+
+	return true
+}
+func integer(value interface{}) (int64, bool) {
+	switch typed := value.(type) {
+	case int:
+		return int64(typed), true
+	case int8:
+		return int64(typed), true
+	case int16:
+		return int64(typed), true
+	case int32:
+		return int64(typed), true
+	case int64:
+		return typed, true
+	case uint8:
+		return int64(typed), true
+	case uint16:
+		return int64(typed), true
+	case uint32:
+		return int64(typed), true
+	case uint64:
+		return int64(typed), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,186 @@
+package fake
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+type queue struct {
+	name       string
+	arguments  amqp.Table
+	owner      *connection // the connection to which an exclusive queue belongs
+	autoDelete bool        // removed once its last consumer is cancelled
+
+	messages  []*message
+	consumers []*consumer
+	next      int // round-robin position among eligible consumers
+}
+type message struct {
+	exchange    string
+	key         string
+	publishing  amqp.Publishing
+	redelivered bool
+	deliveries  uint64 // the number of previous delivery attempts
+	expires     time.Time
+}
+
+func newQueue(name string, arguments amqp.Table, owner *connection) *queue {
+	return &queue{name: name, arguments: arguments, owner: owner, autoDelete: owner != nil}
+}
+func newMessage(exchange, key string, publishing amqp.Publishing) *message {
+	return &message{exchange: exchange, key: key, publishing: publishing}
+}
+
+func (this *queue) lockedBy(connection *connection) bool {
+	return this.owner != nil && this.owner != connection
+}
+
+// messageTTL returns the shorter of the per-message expiration (in milliseconds) and the queue's message TTL.
+func (this *queue) messageTTL(expiration string) (ttl time.Duration, ok bool) {
+	if value, found := integer(this.arguments[messageTTLArgument]); found && value >= 0 {
+		ttl, ok = time.Duration(value)*time.Millisecond, true
+	}
+
+	if value, err := strconv.ParseInt(expiration, 10, 64); err == nil && value >= 0 {
+		if messageTTL := time.Duration(value) * time.Millisecond; !ok || messageTTL < ttl {
+			ttl, ok = messageTTL, true
+		}
+	}
+
+	return ttl, ok
+}
+
+// enqueue appends the message, ahead of those with a lower priority when the queue honors priorities.
+func (this *queue) enqueue(message *message) {
+	maximum, prioritized := integer(this.arguments[maxPriorityArgument])
+	if !prioritized || maximum <= 0 {
+		this.messages = append(this.messages, message)
+		return
+	}
+
+	priority := this.priority(message, maximum)
+	index := len(this.messages)
+	for index > 0 && this.priority(this.messages[index-1], maximum) < priority {
+		index--
+	}
+
+	this.messages = append(this.messages, nil)
+	copy(this.messages[index+1:], this.messages[index:])
+	this.messages[index] = message
+}
+func (this *queue) priority(message *message, maximum int64) int64 {
+	if priority := int64(message.publishing.Priority); priority < maximum {
+		return priority
+	}
+	return maximum
+}
+
+// requeue returns a previously delivered message to the front of the queue, much like the broker does.
+func (this *queue) requeue(item *message) {
+	item.redelivered = true
+	item.deliveries++
+	this.messages = append([]*message{item}, this.messages...)
+}
+func (this *queue) dequeue() *message {
+	message := this.messages[0]
+	this.messages[0] = nil
+	this.messages = this.messages[1:]
+	return message
+}
+func (this *queue) expired(now time.Time) (expired []*message) {
+	remaining := this.messages[0:0]
+	for _, message := range this.messages {
+		if !message.expires.IsZero() && !now.Before(message.expires) {
+			expired = append(expired, message)
+		} else {
+			remaining = append(remaining, message)
+		}
+	}
+
+	for i := len(remaining); i < len(this.messages); i++ {
+		this.messages[i] = nil
+	}
+	this.messages = remaining
+	return expired
+}
+
+func (this *queue) subscribe(consumer *consumer) {
+	this.consumers = append(this.consumers, consumer)
+}
+func (this *queue) unsubscribe(consumer *consumer) {
+	consumers := this.consumers[0:0]
+	for _, item := range this.consumers {
+		if item != consumer {
+			consumers = append(consumers, item)
+		}
+	}
+	this.consumers = consumers
+}
+
+// nextConsumer selects the consumer which should receive the next message: only the first consumer of a single
+// active consumer queue is eligible, otherwise the consumers with the highest priority and available capacity are
+// selected in turn.
+func (this *queue) nextConsumer() *consumer {
+	if len(this.consumers) == 0 {
+		return nil
+	}
+
+	if active, _ := this.arguments[singleActiveConsumerArgument].(bool); active {
+		if consumer := this.consumers[0]; consumer.available() {
+			return consumer
+		}
+		return nil
+	}
+
+	var highest *consumer
+	for _, consumer := range this.consumers {
+		if consumer.available() && (highest == nil || consumer.priority > highest.priority) {
+			highest = consumer
+		}
+	}
+	if highest == nil {
+		return nil
+	}
+
+	for i := 0; i < len(this.consumers); i++ {
+		this.next = (this.next + 1) % len(this.consumers)
+		if consumer := this.consumers[this.next]; consumer.available() && consumer.priority == highest.priority {
+			return consumer
+		}
+	}
+
+	return highest
+}
+
+func (this *queue) delivery(message *message) amqp.Delivery {
+	headers := message.publishing.Headers
+	if this.arguments[queueTypeArgument] == quorumQueueType && message.deliveries > 0 {
+		headers = amqp.Table{}
+		for key, value := range message.publishing.Headers {
+			headers[key] = value
+		}
+		headers[deliveryCountHeader] = int64(message.deliveries)
+	}
+
+	return amqp.Delivery{
+		Headers:         headers,
+		ContentType:     message.publishing.ContentType,
+		ContentEncoding: message.publishing.ContentEncoding,
+		DeliveryMode:    message.publishing.DeliveryMode,
+		Priority:        message.publishing.Priority,
+		CorrelationId:   message.publishing.CorrelationId,
+		ReplyTo:         message.publishing.ReplyTo,
+		Expiration:      message.publishing.Expiration,
+		MessageId:       message.publishing.MessageId,
+		Timestamp:       message.publishing.Timestamp,
+		Type:            message.publishing.Type,
+		UserId:          message.publishing.UserId,
+		AppId:           message.publishing.AppId,
+		Redelivered:     message.redelivered,
+		Exchange:        message.exchange,
+		RoutingKey:      message.key,
+		Body:            message.publishing.Body,
+	}
+}
@@ -0,0 +1,177 @@
+package rabbitmq
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
+)
+
+// channelPool keeps a bounded number of idle writer channels open on a connection so that each new Writer or
+// CommitWriter doesn't have to open (and possibly place into transactional mode) a brand-new channel. The number of
+// writer channels open at once (idle or in use) is limited as well; once the limit is reached, acquiring a channel
+// waits until another one is released.
+type channelPool struct {
+	inner    adapter.Connection
+	capacity int
+	limit    int
+	logger   logger
+
+	mutex    sync.Mutex
+	idle     map[bool][]*pooledChannel // keyed by transactional mode
+	open     int
+	released chan struct{} // closed (and replaced) whenever a channel is released
+	closed   bool
+}
+
+type pooledChannel struct {
+	adapter.Channel
+	reason        *closeReason
+	transactional bool
+	delayQueues   map[string]time.Time // when each delay queue declared on the channel must be declared again
+}
+
+func newChannelPool(inner adapter.Connection, config configuration) *channelPool {
+	return &channelPool{
+		inner:    inner,
+		capacity: int(config.ChannelPoolCapacity),
+		limit:    int(config.ChannelPoolLimit),
+		logger:   config.Logger,
+		idle:     map[bool][]*pooledChannel{},
+		released: make(chan struct{}),
+	}
+}
+func newPooledChannel(channel adapter.Channel, transactional bool) *pooledChannel {
+	return &pooledChannel{
+		Channel:       channel,
+		reason:        newCloseReason(channel),
+		transactional: transactional,
+		delayQueues:   map[string]time.Time{},
+	}
+}
+
+func (this *channelPool) Acquire(ctx context.Context, transactional bool) (*pooledChannel, error) {
+	for waiting := false; ; waiting = true {
+		channel, released, reserved := this.reserve(transactional)
+		if channel != nil {
+			return channel, nil
+		} else if reserved {
+			return this.openChannel(transactional)
+		}
+
+		if !waiting {
+			this.logger.Printf("[WARN] Unable to open writer channel, all [%d] channels are in use, waiting...", this.limit)
+		}
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// reserve returns an idle channel of the mode requested or otherwise reserves room to open a new one, if neither is
+// possible it returns a channel which is closed once another channel is released.
+func (this *channelPool) reserve(transactional bool) (*pooledChannel, <-chan struct{}, bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if channel := this.pop(transactional); channel != nil {
+		return channel, nil, false
+	}
+
+	if this.limit == 0 || this.open < this.limit {
+		this.open++
+		return nil, nil, true
+	}
+
+	if channel := this.pop(!transactional); channel != nil {
+		_ = channel.Close() // make room by closing an idle channel of the other mode
+		return nil, nil, true
+	}
+
+	return nil, this.released, false
+}
+func (this *channelPool) pop(transactional bool) *pooledChannel {
+	idle := this.idle[transactional]
+	if len(idle) == 0 {
+		return nil
+	}
+
+	channel := idle[len(idle)-1]
+	idle[len(idle)-1] = nil
+	this.idle[transactional] = idle[:len(idle)-1]
+	return channel
+}
+func (this *channelPool) openChannel(transactional bool) (*pooledChannel, error) {
+	channel, err := this.inner.Channel()
+	if err != nil {
+		this.forget()
+		return nil, err
+	}
+
+	if transactional {
+		if err := channel.Tx(); err != nil {
+			_ = channel.Close()
+			this.forget()
+			return nil, err
+		}
+	}
+
+	this.logger.Printf("[INFO] Writer channel established on AMQP connection.")
+	return newPooledChannel(channel, transactional), nil
+}
+
+// Release returns a healthy channel to the pool; channels which have failed, which the broker has closed, or which
+// don't fit into the pool are closed instead.
+func (this *channelPool) Release(channel *pooledChannel, healthy bool) error {
+	if healthy && !channel.reason.closed() && this.push(channel) {
+		return nil
+	}
+
+	err := channel.Close()
+	this.forget()
+	return err
+}
+func (this *channelPool) push(channel *pooledChannel) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.closed || len(this.idle[channel.transactional]) >= this.capacity {
+		return false
+	}
+
+	this.idle[channel.transactional] = append(this.idle[channel.transactional], channel)
+	this.notify()
+	return true
+}
+func (this *channelPool) forget() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.open--
+	this.notify()
+}
+func (this *channelPool) notify() {
+	close(this.released)
+	this.released = make(chan struct{})
+}
+
+func (this *channelPool) Close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.closed = true
+	for transactional, idle := range this.idle {
+		for i := range idle {
+			_ = idle[i].Close()
+			idle[i] = nil
+			this.open--
+		}
+		delete(this.idle, transactional)
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
+	"github.com/streadway/amqp"
+)
+
+func TestChannelPoolFixture(t *testing.T) {
+	gunit.Run(new(ChannelPoolFixture), t)
+}
+
+type ChannelPoolFixture struct {
+	*gunit.Fixture
+
+	ctx      context.Context
+	pool     *channelPool
+	capacity uint16
+	limit    uint16
+
+	opened        []*fakePooledChannel
+	channelError  error
+	closeNotifies []chan *amqp.Error
+}
+
+func (this *ChannelPoolFixture) Setup() {
+	this.ctx = context.Background()
+	this.capacity = 1
+	this.initializePool()
+}
+func (this *ChannelPoolFixture) initializePool() {
+	this.pool = newChannelPool(this, configuration{Logger: nop{}, ChannelPoolCapacity: this.capacity, ChannelPoolLimit: this.limit})
+}
+
+func (this *ChannelPoolFixture) TestWhenAcquiringTransactionalChannel_OpenChannelInTransactionalMode() {
+	channel, err := this.pool.Acquire(this.ctx, true)
+
+	this.So(err, should.BeNil)
+	this.So(channel.transactional, should.BeTrue)
+	this.So(this.opened, should.HaveLength, 1)
+	this.So(this.opened[0].txCalls, should.Equal, 1)
+}
+func (this *ChannelPoolFixture) TestWhenOpeningChannelFails_ReturnError() {
+	this.channelError = errors.New("")
+
+	channel, err := this.pool.Acquire(this.ctx, false)
+
+	this.So(channel, should.BeNil)
+	this.So(err, should.Equal, this.channelError)
+}
+func (this *ChannelPoolFixture) TestWhenTransactionalModeFails_CloseChannelAndReturnError() {
+	txError := errors.New("")
+	this.channelError = nil
+	this.pool.inner = &txFailingConnection{ChannelPoolFixture: this, txError: txError}
+
+	channel, err := this.pool.Acquire(this.ctx, true)
+
+	this.So(channel, should.BeNil)
+	this.So(err, should.Equal, txError)
+	this.So(this.opened[0].closeCalls, should.Equal, 1)
+}
+func (this *ChannelPoolFixture) TestWhenHealthyChannelReleased_ReuseForSameMode() {
+	first, _ := this.pool.Acquire(this.ctx, true)
+	_ = this.pool.Release(first, true)
+
+	second, _ := this.pool.Acquire(this.ctx, true)
+	third, _ := this.pool.Acquire(this.ctx, false)
+
+	this.So(second, should.Equal, first)
+	this.So(third, should.NotEqual, first)
+	this.So(this.opened, should.HaveLength, 2)
+	this.So(this.opened[0].closeCalls, should.Equal, 0)
+}
+func (this *ChannelPoolFixture) TestWhenUnhealthyChannelReleased_CloseChannel() {
+	channel, _ := this.pool.Acquire(this.ctx, false)
+
+	_ = this.pool.Release(channel, false)
+	_, _ = this.pool.Acquire(this.ctx, false)
+
+	this.So(this.opened, should.HaveLength, 2)
+	this.So(this.opened[0].closeCalls, should.Equal, 1)
+}
+func (this *ChannelPoolFixture) TestWhenBrokerClosedReleasedChannel_CloseChannel() {
+	channel, _ := this.pool.Acquire(this.ctx, false)
+	this.closeNotifies[0] <- &amqp.Error{Code: 406}
+
+	_ = this.pool.Release(channel, true)
+
+	this.So(this.opened[0].closeCalls, should.Equal, 1)
+}
+func (this *ChannelPoolFixture) TestWhenPoolAtCapacity_CloseReleasedChannel() {
+	first, _ := this.pool.Acquire(this.ctx, false)
+	second, _ := this.pool.Acquire(this.ctx, false)
+
+	_ = this.pool.Release(first, true)
+	_ = this.pool.Release(second, true)
+
+	this.So(this.opened[0].closeCalls, should.Equal, 0)
+	this.So(this.opened[1].closeCalls, should.Equal, 1)
+}
+func (this *ChannelPoolFixture) TestWhenPoolClosed_CloseIdleAndReleasedChannels() {
+	first, _ := this.pool.Acquire(this.ctx, false)
+	second, _ := this.pool.Acquire(this.ctx, true)
+	_ = this.pool.Release(first, true)
+
+	_ = this.pool.Close()
+	_ = this.pool.Release(second, true)
+
+	this.So(this.opened[0].closeCalls, should.Equal, 1)
+	this.So(this.opened[1].closeCalls, should.Equal, 1)
+}
+func (this *ChannelPoolFixture) TestWhenLimitReached_WaitUntilChannelReleased() {
+	this.limit = 2
+	this.initializePool()
+	first, _ := this.pool.Acquire(this.ctx, false)
+	_, _ = this.pool.Acquire(this.ctx, false)
+	go func() {
+		time.Sleep(time.Millisecond)
+		_ = this.pool.Release(first, true)
+	}()
+
+	third, err := this.pool.Acquire(this.ctx, false)
+
+	this.So(err, should.BeNil)
+	this.So(third, should.Equal, first)
+	this.So(this.opened, should.HaveLength, 2)
+}
+func (this *ChannelPoolFixture) TestWhenLimitReachedAndUnhealthyChannelReleased_OpenAnotherChannel() {
+	this.limit = 1
+	this.initializePool()
+	first, _ := this.pool.Acquire(this.ctx, true)
+	go func() {
+		time.Sleep(time.Millisecond)
+		_ = this.pool.Release(first, false)
+	}()
+
+	second, err := this.pool.Acquire(this.ctx, true)
+
+	this.So(err, should.BeNil)
+	this.So(second, should.NotEqual, first)
+	this.So(this.opened, should.HaveLength, 2)
+	this.So(this.opened[0].closeCalls, should.Equal, 1)
+}
+func (this *ChannelPoolFixture) TestWhenLimitReachedAndContextCancelled_ReturnContextError() {
+	this.limit = 1
+	this.initializePool()
+	_, _ = this.pool.Acquire(this.ctx, false)
+	ctx, cancel := context.WithTimeout(this.ctx, time.Millisecond)
+	defer cancel()
+
+	channel, err := this.pool.Acquire(ctx, false)
+
+	this.So(channel, should.BeNil)
+	this.So(err, should.Resemble, context.DeadlineExceeded)
+	this.So(this.opened, should.HaveLength, 1)
+}
+func (this *ChannelPoolFixture) TestWhenLimitReachedByIdleChannelOfOtherMode_CloseItToMakeRoom() {
+	this.limit = 1
+	this.initializePool()
+	first, _ := this.pool.Acquire(this.ctx, false)
+	_ = this.pool.Release(first, true)
+
+	second, err := this.pool.Acquire(this.ctx, true)
+
+	this.So(err, should.BeNil)
+	this.So(second.transactional, should.BeTrue)
+	this.So(this.opened, should.HaveLength, 2)
+	this.So(this.opened[0].closeCalls, should.Equal, 1)
+}
+func (this *ChannelPoolFixture) TestWhenOpeningChannelFails_ItDoesNotCountTowardsLimit() {
+	this.limit = 1
+	this.initializePool()
+	this.channelError = errors.New("")
+	_, _ = this.pool.Acquire(this.ctx, false)
+	this.channelError = nil
+
+	channel, err := this.pool.Acquire(this.ctx, false)
+
+	this.So(err, should.BeNil)
+	this.So(channel, should.NotBeNil)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *ChannelPoolFixture) Channel() (adapter.Channel, error) {
+	if this.channelError != nil {
+		return nil, this.channelError
+	}
+
+	channel := &fakePooledChannel{fixture: this}
+	this.opened = append(this.opened, channel)
+	return channel, nil
+}
+func (this *ChannelPoolFixture) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	panic("nop")
+}
+func (this *ChannelPoolFixture) NotifyBlocked(receiver chan amqp.Blocking) chan amqp.Blocking {
+	panic("nop")
+}
+func (this *ChannelPoolFixture) Close() error { panic("nop") }
+
+type txFailingConnection struct {
+	*ChannelPoolFixture
+	txError error
+}
+
+func (this *txFailingConnection) Channel() (adapter.Channel, error) {
+	channel, err := this.ChannelPoolFixture.Channel()
+	channel.(*fakePooledChannel).txError = this.txError
+	return channel, err
+}
+
+type fakePooledChannel struct {
+	adapter.Channel // unimplemented methods panic
+	fixture         *ChannelPoolFixture
+	txCalls         int
+	txError         error
+	closeCalls      int
+}
+
+func (this *fakePooledChannel) Tx() error    { this.txCalls++; return this.txError }
+func (this *fakePooledChannel) Close() error { this.closeCalls++; return nil }
+func (this *fakePooledChannel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	this.fixture.closeNotifies = append(this.fixture.closeNotifies, receiver)
+	return receiver
+}
@@ -0,0 +1,50 @@
+package rabbitmq
+
+import (
+	"sync"
+
+	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
+	"github.com/streadway/amqp"
+)
+
+// closeReason remembers why the broker closed a channel (or its connection) so that the code and reason can be
+// attached to the otherwise opaque errors returned once the channel is no longer usable.
+type closeReason struct {
+	closes <-chan *amqp.Error
+	mutex  sync.Mutex
+	cause  *amqp.Error
+}
+
+func newCloseReason(channel adapter.Channel) *closeReason {
+	// NOTE: the underlying library publishes the close error before it closes any consumer channels, so a buffered
+	// receiver is guaranteed to hold the cause by the time a consumer observes its deliveries channel closing.
+	return &closeReason{closes: channel.NotifyClose(make(chan *amqp.Error, 1))}
+}
+
+func (this *closeReason) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if cause := this.receive(); cause != nil {
+		return &BrokerError{Code: cause.Code, Reason: cause.Reason, Err: err}
+	}
+
+	return err
+}
+func (this *closeReason) closed() bool {
+	return this.receive() != nil
+}
+func (this *closeReason) receive() *amqp.Error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.cause == nil {
+		select {
+		case this.cause = <-this.closes:
+		default:
+		}
+	}
+
+	return this.cause
+}
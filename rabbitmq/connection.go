@@ -10,6 +10,8 @@ import (
 
 type defaultConnection struct {
 	inner   adapter.Connection
+	state   *connectionState
+	pool    *channelPool
 	config  configuration
 	logger  logger
 	monitor monitor
@@ -20,44 +22,43 @@ func newConnection(inner adapter.Connection, config configuration) messaging.Con
 	// NOTE: using pointer type to allow for pointer equality check
 	config.Monitor.ConnectionOpened(nil)
 	config.Logger.Printf("[INFO] Connection established to AMQP broker [%s].", config.Address.Host)
-	return &defaultConnection{inner: inner, config: config, logger: config.Logger, monitor: config.Monitor}
+	return &defaultConnection{
+		inner:   inner,
+		state:   newConnectionState(inner, config),
+		pool:    newChannelPool(inner, config),
+		config:  config,
+		logger:  config.Logger,
+		monitor: config.Monitor,
+	}
 }
 func (this *defaultConnection) Reader(_ context.Context) (messaging.Reader, error) {
 	if channel, err := this.inner.Channel(); err != nil {
 		this.logger.Printf("[WARN] Unable able open read channel [%s].", err)
 		return nil, err
 	} else {
-		return newReader(channel, this.config), nil
+		return newReader(channel, this.inner, this.config), nil
 	}
 }
 
-func (this *defaultConnection) Writer(_ context.Context) (messaging.Writer, error) {
-	return this.writer(false)
+func (this *defaultConnection) Writer(ctx context.Context) (messaging.Writer, error) {
+	return this.writer(ctx, false)
 }
-func (this *defaultConnection) CommitWriter(_ context.Context) (messaging.CommitWriter, error) {
-	return this.writer(true)
+func (this *defaultConnection) CommitWriter(ctx context.Context) (messaging.CommitWriter, error) {
+	return this.writer(ctx, true)
 }
-func (this *defaultConnection) writer(transactional bool) (messaging.CommitWriter, error) {
-	channel, err := this.inner.Channel()
+func (this *defaultConnection) writer(ctx context.Context, transactional bool) (messaging.CommitWriter, error) {
+	channel, err := this.pool.Acquire(ctx, transactional)
 	if err != nil {
 		this.logger.Printf("[WARN] Unable able open write channel [%s].", err)
 		return nil, err
 	}
 
-	if !transactional {
-		return newWriter(channel, this.config), nil
-	}
-
-	if err := channel.Tx(); err != nil {
-		_ = channel.Close()
-		return nil, err
-	}
-
-	return newWriter(channel, this.config), nil
+	return newWriter(channel, this.pool, this.state, this.config), nil
 }
 
 func (this *defaultConnection) Close() (err error) {
 	this.closer.Do(func() {
+		_ = this.pool.Close()
 		err = this.inner.Close()
 		this.monitor.ConnectionClosed()
 	})
@@ -0,0 +1,75 @@
+package rabbitmq
+
+import (
+	"sync"
+
+	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
+	"github.com/streadway/amqp"
+)
+
+type connectionState struct {
+	mutex   sync.RWMutex
+	blocked bool
+	logger  logger
+	monitor blockingMonitor
+}
+
+func newConnectionState(inner adapter.Connection, config configuration) *connectionState {
+	this := &connectionState{logger: config.Logger, monitor: newBlockingMonitor(config.Monitor)}
+	closes := inner.NotifyClose(make(chan *amqp.Error, 1))
+	blocks := inner.NotifyBlocked(make(chan amqp.Blocking, 1))
+	go this.listen(closes, blocks)
+	return this
+}
+
+func newBlockingMonitor(value monitor) blockingMonitor {
+	if monitor, ok := value.(blockingMonitor); ok {
+		return monitor
+	}
+	return nop{} // blocking notifications are still logged
+}
+
+func (this *connectionState) listen(closes <-chan *amqp.Error, blocks <-chan amqp.Blocking) {
+	for closes != nil || blocks != nil {
+		select {
+		case err, open := <-closes:
+			if !open {
+				closes = nil
+			} else {
+				this.closed(err)
+			}
+		case blocking, open := <-blocks:
+			if !open {
+				blocks = nil
+			} else {
+				this.block(blocking)
+			}
+		}
+	}
+}
+func (this *connectionState) closed(err *amqp.Error) {
+	this.logger.Printf("[WARN] Connection closed by broker [%d: %s].", err.Code, err.Reason)
+	this.setBlocked(false)
+}
+func (this *connectionState) block(blocking amqp.Blocking) {
+	this.setBlocked(blocking.Active)
+
+	if blocking.Active {
+		this.logger.Printf("[WARN] Connection blocked by broker [%s].", blocking.Reason)
+		this.monitor.ConnectionBlocked(blocking.Reason)
+	} else {
+		this.logger.Printf("[INFO] Connection unblocked by broker.")
+		this.monitor.ConnectionUnblocked()
+	}
+}
+func (this *connectionState) setBlocked(value bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.blocked = value
+}
+
+func (this *connectionState) Blocked() bool {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	return this.blocked
+}
@@ -0,0 +1,99 @@
+package rabbitmq
+
+import (
+	"testing"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/streadway/amqp"
+)
+
+func TestConnectionStateFixture(t *testing.T) {
+	gunit.Run(new(ConnectionStateFixture), t)
+}
+
+type ConnectionStateFixture struct {
+	*gunit.Fixture
+
+	state  *connectionState
+	closes chan *amqp.Error
+	blocks chan amqp.Blocking
+
+	blockedReasons []string
+	unblocked      int
+}
+
+func (this *ConnectionStateFixture) Setup() {
+	this.closes = make(chan *amqp.Error, 4)
+	this.blocks = make(chan amqp.Blocking, 4)
+	this.state = &connectionState{logger: nop{}, monitor: this}
+}
+
+func (this *ConnectionStateFixture) TestWhenBrokerBlocksConnection_MarkBlockedAndNotifyMonitor() {
+	this.blocks <- amqp.Blocking{Active: true, Reason: "low on memory"}
+	this.closeNotifications()
+
+	this.state.listen(this.closes, this.blocks)
+
+	this.So(this.state.Blocked(), should.BeTrue)
+	this.So(this.blockedReasons, should.Resemble, []string{"low on memory"})
+	this.So(this.unblocked, should.Equal, 0)
+}
+func (this *ConnectionStateFixture) TestWhenBrokerUnblocksConnection_MarkUnblockedAndNotifyMonitor() {
+	this.blocks <- amqp.Blocking{Active: true, Reason: "low on disk"}
+	this.blocks <- amqp.Blocking{Active: false}
+	this.closeNotifications()
+
+	this.state.listen(this.closes, this.blocks)
+
+	this.So(this.state.Blocked(), should.BeFalse)
+	this.So(this.blockedReasons, should.Resemble, []string{"low on disk"})
+	this.So(this.unblocked, should.Equal, 1)
+}
+func (this *ConnectionStateFixture) TestWhenBrokerClosesBlockedConnection_NoLongerBlocked() {
+	this.blocks <- amqp.Blocking{Active: true, Reason: "low on disk"}
+	this.closeNotifications()
+	this.state.listen(nil, this.blocks)
+
+	this.closes = make(chan *amqp.Error, 1)
+	this.closes <- &amqp.Error{Code: 320, Reason: "CONNECTION_FORCED"}
+	close(this.closes)
+	this.state.listen(this.closes, nil)
+
+	this.So(this.state.Blocked(), should.BeFalse)
+}
+
+func (this *ConnectionStateFixture) TestWhenMonitorDoesNotObserveBlocking_NotificationsAreOnlyLogged() {
+	this.So(newBlockingMonitor(this), should.Equal, this)
+	this.So(newBlockingMonitor(baseMonitor{}), should.Resemble, nop{})
+}
+
+func (this *ConnectionStateFixture) closeNotifications() {
+	close(this.closes)
+	close(this.blocks)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *ConnectionStateFixture) ConnectionBlocked(reason string) {
+	this.blockedReasons = append(this.blockedReasons, reason)
+}
+func (this *ConnectionStateFixture) ConnectionUnblocked() { this.unblocked++ }
+
+func (this *ConnectionStateFixture) ConnectionOpened(error)             {}
+func (this *ConnectionStateFixture) ConnectionClosed()                  {}
+func (this *ConnectionStateFixture) DispatchPublished()                 {}
+func (this *ConnectionStateFixture) DeliveryReceived()                  {}
+func (this *ConnectionStateFixture) DeliveryAcknowledged(uint16, error) {}
+func (this *ConnectionStateFixture) TransactionCommitted(error)         {}
+func (this *ConnectionStateFixture) TransactionRolledBack(error)        {}
+
+type baseMonitor struct{} // observes only the events every monitor must
+
+func (baseMonitor) ConnectionOpened(error)             {}
+func (baseMonitor) ConnectionClosed()                  {}
+func (baseMonitor) DispatchPublished()                 {}
+func (baseMonitor) DeliveryReceived()                  {}
+func (baseMonitor) DeliveryAcknowledged(uint16, error) {}
+func (baseMonitor) TransactionCommitted(error)         {}
+func (baseMonitor) TransactionRolledBack(error)        {}
@@ -25,10 +25,12 @@ type ConnectionFixture struct {
 	channelError error
 	closeError   error
 	txCalls      int
+	channelCalls int
+	closeCalls   int
 }
 
 func (this *ConnectionFixture) Setup() {
-	this.connection = newConnection(this, configuration{Monitor: nop{}, Logger: nop{}})
+	this.connection = newConnection(this, configuration{Monitor: nop{}, Logger: nop{}, ChannelPoolCapacity: 1})
 }
 
 func (this *ConnectionFixture) TestWhenOpeningReader_OpenAChannelAndReturnReader() {
@@ -49,7 +51,7 @@ func (this *ConnectionFixture) TestWhenOpeningReaderFails_ReturnUnderlyingError(
 func (this *ConnectionFixture) TestWhenOpeningCommitWriter_OpenATransactionalChannelAndReturnWriter() {
 	writer, err := this.connection.CommitWriter(context.Background())
 
-	this.So(writer, should.HaveSameTypeAs, defaultWriter{})
+	this.So(writer, should.HaveSameTypeAs, &defaultWriter{})
 	this.So(err, should.BeNil)
 	this.So(this.txCalls, should.Equal, 1)
 }
@@ -75,7 +77,7 @@ func (this *ConnectionFixture) TestWhenMarkingChannelAsTransactionalFails_Return
 func (this *ConnectionFixture) TestWhenOpeningWriter_OpenATransactionalChannelAndReturnWriter() {
 	writer, err := this.connection.Writer(context.Background())
 
-	this.So(writer, should.HaveSameTypeAs, defaultWriter{})
+	this.So(writer, should.HaveSameTypeAs, &defaultWriter{})
 	this.So(err, should.BeNil)
 }
 func (this *ConnectionFixture) TestWhenOpeningChannelForWriterFails_ReturnUnderlyingError() {
@@ -87,6 +89,27 @@ func (this *ConnectionFixture) TestWhenOpeningChannelForWriterFails_ReturnUnderl
 	this.So(err, should.Equal, this.channelError)
 }
 
+func (this *ConnectionFixture) TestWhenOpeningCommitWriterAfterClosingAnother_ReuseTransactionalChannel() {
+	writer, _ := this.connection.CommitWriter(context.Background())
+	_ = writer.Close()
+
+	writer, err := this.connection.CommitWriter(context.Background())
+
+	this.So(writer, should.NotBeNil)
+	this.So(err, should.BeNil)
+	this.So(this.channelCalls, should.Equal, 1)
+	this.So(this.txCalls, should.Equal, 1)
+}
+func (this *ConnectionFixture) TestWhenOpeningWriterAfterClosingCommitWriter_OpenSeparatePlainChannel() {
+	writer, _ := this.connection.CommitWriter(context.Background())
+	_ = writer.Close()
+
+	_, _ = this.connection.Writer(context.Background())
+
+	this.So(this.channelCalls, should.Equal, 2)
+	this.So(this.txCalls, should.Equal, 1)
+}
+
 func (this *ConnectionFixture) TestWhenClosing_InvokeUnderlyingConnection() {
 	this.closeError = errors.New("")
 
@@ -97,31 +120,61 @@ func (this *ConnectionFixture) TestWhenClosing_InvokeUnderlyingConnection() {
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func (this *ConnectionFixture) Channel() (adapter.Channel, error) { return this, this.channelError }
-func (this *ConnectionFixture) Close() error                      { return this.closeError }
+func (this *ConnectionFixture) Channel() (adapter.Channel, error) {
+	this.channelCalls++
+	return this, this.channelError
+}
+func (this *ConnectionFixture) Close() error { this.closeCalls++; return this.closeError }
+func (this *ConnectionFixture) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	return receiver
+}
+func (this *ConnectionFixture) NotifyBlocked(receiver chan amqp.Blocking) chan amqp.Blocking {
+	return receiver
+}
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 func (this *ConnectionFixture) Tx() error { this.txCalls++; return this.txError }
 
-func (this *ConnectionFixture) DeclareQueue(name string) error {
+func (this *ConnectionFixture) DeclareQueue(name string, arguments amqp.Table) error {
 	panic("nop")
 }
-func (this *ConnectionFixture) DeclareExchange(name string) error {
+func (this *ConnectionFixture) DeclareTemporaryQueue() (string, error) {
 	panic("nop")
 }
-func (this *ConnectionFixture) BindQueue(queue, exchange string) error {
+func (this *ConnectionFixture) DeclareExchange(name, kind string, arguments amqp.Table) error {
+	panic("nop")
+}
+func (this *ConnectionFixture) BindQueue(queue, exchange, key string, arguments amqp.Table) error {
+	panic("nop")
+}
+func (this *ConnectionFixture) UnbindQueue(queue, exchange, key string, arguments amqp.Table) error {
+	panic("nop")
+}
+func (this *ConnectionFixture) DeleteQueue(name string, _, _ bool) error {
+	panic("nop")
+}
+func (this *ConnectionFixture) DeleteExchange(name string) error {
+	panic("nop")
+}
+func (this *ConnectionFixture) VerifyQueue(name string) error {
+	panic("nop")
+}
+func (this *ConnectionFixture) VerifyExchange(name string) error {
 	panic("nop")
 }
 func (this *ConnectionFixture) BufferCapacity(value uint16) error {
 	panic("nop")
 }
-func (this *ConnectionFixture) Consume(consumerID, queue string) (<-chan amqp.Delivery, error) {
+func (this *ConnectionFixture) Consume(consumerID, queue string, arguments amqp.Table) (<-chan amqp.Delivery, error) {
 	panic("nop")
 }
 func (this *ConnectionFixture) Ack(deliveryTag uint64, multiple bool) error {
 	panic("nop")
 }
+func (this *ConnectionFixture) Reject(deliveryTag uint64, requeue bool) error {
+	panic("nop")
+}
 func (this *ConnectionFixture) CancelConsumer(consumerID string) error {
 	panic("nop")
 }
@@ -21,7 +21,7 @@ type defaultConnector struct {
 	mutex  sync.Mutex
 }
 
-func newConnector(config configuration) messaging.Connector {
+func newConnector(config configuration) *defaultConnector {
 	return &defaultConnector{
 		inner:   config.Connector,
 		dialer:  config.Dialer,
@@ -33,7 +33,24 @@ func newConnector(config configuration) messaging.Connector {
 }
 
 func (this *defaultConnector) Connect(ctx context.Context) (messaging.Connection, error) {
-	hostAddress, config := this.configuration()
+	amqpConnection, err := this.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.active = append(this.active, newConnection(amqpConnection, this.config))
+	return this.active[len(this.active)-1], nil
+}
+func (this *defaultConnector) dial(ctx context.Context) (adapter.Connection, error) {
+	hostAddress, config, err := this.configuration(ctx)
+	if err != nil {
+		this.logger.Printf("[WARN] Unable to obtain connection credentials [%s].", err)
+		this.monitor.ConnectionOpened(err)
+		return nil, err
+	}
+
 	socket, err := this.dialer.DialContext(ctx, "tcp", hostAddress)
 	if err != nil {
 		this.logger.Printf("[WARN] Unable to connect [%s].", err)
@@ -48,18 +65,33 @@ func (this *defaultConnector) Connect(ctx context.Context) (messaging.Connection
 		return nil, err
 	}
 
-	this.mutex.Lock()
-	defer this.mutex.Unlock()
-	this.active = append(this.active, newConnection(amqpConnection, this.config))
-	return this.active[len(this.active)-1], nil
+	return amqpConnection, nil
 }
-func (this *defaultConnector) configuration() (string, adapter.Config) {
-	username, password := parseAuthentication(this.broker.Address.User)
+func (this *defaultConnector) configuration(ctx context.Context) (string, adapter.Config, error) {
+	username, password, err := this.credentials(ctx)
+	if err != nil {
+		return "", adapter.Config{}, err
+	}
+
 	return this.broker.Address.Host, adapter.Config{
-		Username:    username,
-		Password:    password,
-		VirtualHost: this.broker.Address.Path,
+		Username:       username,
+		Password:       password,
+		ExternalAuth:   this.config.ExternalAuth,
+		VirtualHost:    this.broker.Address.Path,
+		ConnectionName: this.config.ConnectionName,
+		Properties:     this.config.ClientProperties,
+		Heartbeat:      this.config.Heartbeat,
+		ChannelMax:     this.config.ChannelMax,
+		FrameSize:      this.config.FrameSize,
+		Locale:         this.config.Locale,
+	}, nil
+}
+func (this *defaultConnector) credentials(ctx context.Context) (string, string, error) {
+	if this.config.ExternalAuth {
+		return "", "", nil // the broker derives the identity from the TLS client certificate
 	}
+
+	return this.config.Credentials(ctx)
 }
 func parseAuthentication(info *url.Userinfo) (string, string) {
 	if info == nil {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net"
+	"strconv"
 	"testing"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/smartystreets/gunit"
 	"github.com/smartystreets/messaging/v3"
 	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
+	"github.com/streadway/amqp"
 )
 
 func TestConnectorFixture(t *testing.T) {
@@ -63,9 +65,11 @@ func (this *ConnectorFixture) TestWhenConnectingToBroker_UseDialedNetworkConnect
 	this.So(this.connectContext, should.Equal, this.ctx)
 	this.So(this.connectSocket, should.Equal, this)
 	this.So(this.connectConfig, should.Resemble, adapter.Config{
-		Username:    "my-username",
-		Password:    "my-password",
-		VirtualHost: "/my-vhost",
+		Username:       "my-username",
+		Password:       "my-password",
+		VirtualHost:    "/my-vhost",
+		ConnectionName: processName(),
+		Locale:         "en_US",
 	})
 }
 func (this *ConnectorFixture) TestWhenNoCredentialsFound_ConnectUsingDefaultCredentials() {
@@ -84,11 +88,92 @@ func (this *ConnectorFixture) TestWhenNoCredentialsFound_ConnectUsingDefaultCred
 	this.So(this.connectContext, should.Equal, this.ctx)
 	this.So(this.connectSocket, should.Equal, this)
 	this.So(this.connectConfig, should.Resemble, adapter.Config{
-		Username:    "guest",
-		Password:    "guest",
-		VirtualHost: "/another-vhost",
+		Username:       "guest",
+		Password:       "guest",
+		VirtualHost:    "/another-vhost",
+		ConnectionName: processName(),
+		Locale:         "en_US",
 	})
 }
+func (this *ConnectorFixture) TestWhenClientPropertiesAndTuningConfigured_PassToUnderlyingConnector() {
+	this.connector = New(
+		Options.Address(this.brokerAddress),
+		Options.Connector(this),
+		Options.Dialer(this),
+		Options.ConnectionName("my-service"),
+		Options.ClientProperty("team", "infrastructure"),
+		Options.Heartbeat(time.Second*15),
+		Options.ChannelMax(32),
+		Options.FrameSize(131072),
+		Options.Locale("en_GB"),
+	)
+
+	_, err := this.connector.Connect(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(this.connectConfig, should.Resemble, adapter.Config{
+		Username:       "my-username",
+		Password:       "my-password",
+		VirtualHost:    "/my-vhost",
+		ConnectionName: "my-service",
+		Properties:     map[string]interface{}{"team": "infrastructure"},
+		Heartbeat:      time.Second * 15,
+		ChannelMax:     32,
+		FrameSize:      131072,
+		Locale:         "en_GB",
+	})
+}
+
+func (this *ConnectorFixture) TestWhenCredentialsProviderConfigured_ConsultProviderOnEachConnect() {
+	var calls int
+	this.connector = New(
+		Options.Address(this.brokerAddress),
+		Options.Connector(this),
+		Options.Dialer(this),
+		Options.Credentials(func(context.Context) (string, string, error) {
+			calls++
+			return "rotated-username", "rotated-password-" + strconv.Itoa(calls), nil
+		}),
+	)
+
+	_, _ = this.connector.Connect(this.ctx)
+	_, err := this.connector.Connect(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(calls, should.Equal, 2)
+	this.So(this.connectConfig.Username, should.Equal, "rotated-username")
+	this.So(this.connectConfig.Password, should.Equal, "rotated-password-2")
+}
+func (this *ConnectorFixture) TestWhenCredentialsProviderFails_ReturnErrorWithoutDialing() {
+	credentialsError := errors.New("")
+	this.connector = New(
+		Options.Address(this.brokerAddress),
+		Options.Connector(this),
+		Options.Dialer(this),
+		Options.Credentials(func(context.Context) (string, string, error) { return "", "", credentialsError }),
+	)
+
+	connection, err := this.connector.Connect(this.ctx)
+
+	this.So(connection, should.BeNil)
+	this.So(err, should.Equal, credentialsError)
+	this.So(this.dialAddress, should.BeEmpty)
+}
+func (this *ConnectorFixture) TestWhenExternalAuthenticationConfigured_ConnectWithoutCredentials() {
+	this.connector = New(
+		Options.Address(this.brokerAddress),
+		Options.Connector(this),
+		Options.Dialer(this),
+		Options.ExternalAuthentication(true),
+	)
+
+	_, err := this.connector.Connect(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(this.connectConfig.ExternalAuth, should.BeTrue)
+	this.So(this.connectConfig.Username, should.BeEmpty)
+	this.So(this.connectConfig.Password, should.BeEmpty)
+}
 
 func (this *ConnectorFixture) TestWhenDialingFails_ReturnUnderlyingError() {
 	this.dialError = errors.New("")
@@ -132,6 +217,12 @@ func (this *ConnectorFixture) Connect(ctx context.Context, socket net.Conn, conf
 
 func (this *ConnectorFixture) Close() error                      { this.callsToClose++; return nil }
 func (this *ConnectorFixture) Channel() (adapter.Channel, error) { panic("nop") }
+func (this *ConnectorFixture) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	return receiver
+}
+func (this *ConnectorFixture) NotifyBlocked(receiver chan amqp.Blocking) chan amqp.Blocking {
+	return receiver
+}
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
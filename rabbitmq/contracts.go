@@ -1,9 +1,15 @@
 package rabbitmq
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net/url"
+
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
+	"github.com/streadway/amqp"
 )
 
 type brokerEndpoint struct {
@@ -11,6 +17,8 @@ type brokerEndpoint struct {
 	TLSConfig *tls.Config
 }
 
+// monitor receives connection, publishing and delivery events. A monitor may also implement any of the optional
+// interfaces below to observe additional events.
 type monitor interface {
 	ConnectionOpened(error)
 	ConnectionClosed()
@@ -20,11 +28,121 @@ type monitor interface {
 	TransactionCommitted(error)
 	TransactionRolledBack(error)
 }
+
+// blockingMonitor is optionally implemented by a monitor to observe the broker blocking and unblocking publishers
+// (e.g. because of a memory or disk alarm).
+type blockingMonitor interface {
+	ConnectionBlocked(reason string)
+	ConnectionUnblocked()
+}
+
+// consumerMonitor is optionally implemented by a monitor to observe a single-active consumer being activated by (or
+// falling back to standby on) the broker. AMQP doesn't announce activation, so it's inferred: a consumer is considered
+// active once it receives its first delivery, and inactive once its channel is closed or replaced during recovery.
+// A standby activated while the queue is empty is therefore only reported once a message arrives.
+type consumerMonitor interface {
+	ConsumerActivated(queue string, active bool)
+}
+
+// expirationMonitor is optionally implemented by a monitor to observe deliveries which are discarded because they
+// expired before they were received.
+type expirationMonitor interface {
+	DeliveryExpired()
+}
+
+// NamedStream is implemented by every stream opened by the Reader, Name returns the name of the underlying queue,
+// which the broker generates for a TemporaryStream.
+type NamedStream interface {
+	messaging.Stream
+	Name() string
+}
+
+// credentialsFunc supplies the username and password used each time a new connection is established, which allows
+// short-lived credentials to be refreshed on reconnect.
+type credentialsFunc func(ctx context.Context) (username, password string, err error)
+
+// offsetStore records the offset of the latest acknowledged delivery of each replayable stream (e.g. a RabbitMQ
+// stream queue) so that consumption resumes from that point after a reconnect or restart.
+type offsetStore interface {
+	Load(ctx context.Context, stream string) (offset uint64, found bool, err error)
+	Store(ctx context.Context, stream string, offset uint64) error
+}
+
+type channelReleaser interface {
+	Release(channel *pooledChannel, healthy bool) error
+}
+type channelOpener interface {
+	Channel() (adapter.Channel, error)
+}
+type consumerOpener interface {
+	reopen(ctx context.Context, failed adapter.Channel, streamID string, settings messaging.StreamConfig) (adapter.Channel, <-chan amqp.Delivery, error)
+}
+type connectionDialer interface {
+	dial(ctx context.Context) (adapter.Connection, error)
+}
+
 type logger interface {
 	Printf(format string, args ...interface{})
 }
 
+const (
+	deliveryCountHeader          = "x-delivery-count"
+	singleActiveConsumerArgument = "x-single-active-consumer"
+	consumerPriorityArgument     = "x-priority"
+	queueTypeArgument            = "x-queue-type"
+	maxPriorityArgument          = "x-max-priority"
+	streamOffsetArgument         = "x-stream-offset" // also the header which carries the offset of each delivery
+	streamQueueType              = "stream"
+	messageTTLArgument           = "x-message-ttl"
+	deadLetterExchangeArgument   = "x-dead-letter-exchange"
+	deadLetterRoutingKeyArgument = "x-dead-letter-routing-key"
+	queueExpiresArgument         = "x-expires"
+	expiresAtHeader              = "x-expires-at" // absolute expiration, in Unix milliseconds
+)
+
 var (
 	ErrAlreadyExclusive = errors.New("unable to open additional stream, an exclusive stream already exists")
 	ErrMultipleStreams  = errors.New("unable to open exclusive stream, another stream already exists")
+	ErrBlocked          = errors.New("unable to write, the broker has blocked publishing on this connection")
+
+	ErrTopologyExchangeName = errors.New("topology exchanges must be named")
+	ErrTopologyQueueName    = errors.New("topology queues must be named")
+	ErrTopologyBinding      = errors.New("topology bindings must name both a queue and an exchange")
 )
+
+// BrokerError carries the code and reason given by the broker when it closed the underlying channel or connection
+// along with the error that would otherwise have been returned (e.g. io.EOF from a stream).
+type BrokerError struct {
+	Code   int
+	Reason string
+	Err    error
+}
+
+func (this *BrokerError) Error() string {
+	return fmt.Sprintf("broker closed the channel [%d: %s]: %s", this.Code, this.Reason, this.Err)
+}
+func (this *BrokerError) Unwrap() error { return this.Err }
+
+// TopologyEntity identifies a queue or exchange which failed passive verification along with the reason given by
+// the broker.
+type TopologyEntity struct {
+	Kind   string
+	Name   string
+	Code   int
+	Reason string
+}
+
+func (this TopologyEntity) String() string {
+	return fmt.Sprintf("%s [%s] (%d: %s)", this.Kind, this.Name, this.Code, this.Reason)
+}
+
+// TopologyError lists every entity which is missing (404) or which exists but can't be used as declared (e.g. 405
+// when an exclusive queue is held by another connection or 406 when the broker rejects the declaration).
+type TopologyError struct {
+	Missing    []TopologyEntity
+	Mismatched []TopologyEntity
+}
+
+func (this *TopologyError) Error() string {
+	return fmt.Sprintf("topology verification failed, missing: %v, mismatched: %v", this.Missing, this.Mismatched)
+}
@@ -0,0 +1,30 @@
+package rabbitmq
+
+import (
+	"context"
+	"sync"
+)
+
+// memoryOffsetStore retains stream offsets for the lifetime of the process, which allows a consumer to resume after
+// reconnecting; durable storage should be supplied (Options.OffsetStore) to resume after the process restarts.
+type memoryOffsetStore struct {
+	mutex   sync.Mutex
+	offsets map[string]uint64
+}
+
+func newMemoryOffsetStore() offsetStore {
+	return &memoryOffsetStore{offsets: map[string]uint64{}}
+}
+
+func (this *memoryOffsetStore) Load(_ context.Context, stream string) (uint64, bool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	offset, found := this.offsets[stream]
+	return offset, found, nil
+}
+func (this *memoryOffsetStore) Store(_ context.Context, stream string, offset uint64) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.offsets[stream] = offset
+	return nil
+}
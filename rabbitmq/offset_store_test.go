@@ -0,0 +1,42 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+)
+
+func TestMemoryOffsetStoreFixture(t *testing.T) {
+	gunit.Run(new(MemoryOffsetStoreFixture), t)
+}
+
+type MemoryOffsetStoreFixture struct {
+	*gunit.Fixture
+
+	store offsetStore
+}
+
+func (this *MemoryOffsetStoreFixture) Setup() {
+	this.store = newMemoryOffsetStore()
+}
+
+func (this *MemoryOffsetStoreFixture) TestWhenNothingStored_NotFound() {
+	offset, found, err := this.store.Load(context.Background(), "stream")
+
+	this.So(offset, should.Equal, 0)
+	this.So(found, should.BeFalse)
+	this.So(err, should.BeNil)
+}
+func (this *MemoryOffsetStoreFixture) TestWhenStored_LoadLatestOffsetForStream() {
+	_ = this.store.Store(context.Background(), "stream", 1)
+	_ = this.store.Store(context.Background(), "stream", 2)
+	_ = this.store.Store(context.Background(), "other", 3)
+
+	offset, found, err := this.store.Load(context.Background(), "stream")
+
+	this.So(offset, should.Equal, 2)
+	this.So(found, should.BeTrue)
+	this.So(err, should.BeNil)
+}
@@ -13,20 +13,23 @@ import (
 )
 
 type defaultReader struct {
-	streams []io.Closer
-	inner   adapter.Channel
-	config  configuration
-	mutex   sync.Mutex
-	counter uint64
-	logger  logger
+	streams  []io.Closer
+	channels []adapter.Channel // opened to recover streams from channel-level errors
+	closed   bool
+	inner    adapter.Channel
+	opener   channelOpener
+	config   configuration
+	mutex    sync.Mutex
+	counter  uint64
+	logger   logger
 
 	hasExclusiveStream bool
 }
 
-func newReader(inner adapter.Channel, config configuration) messaging.Reader {
-	return &defaultReader{inner: inner, config: config, logger: config.Logger}
+func newReader(inner adapter.Channel, opener channelOpener, config configuration) messaging.Reader {
+	return &defaultReader{inner: inner, opener: opener, config: config, logger: config.Logger}
 }
-func (this *defaultReader) Stream(_ context.Context, settings messaging.StreamConfig) (messaging.Stream, error) {
+func (this *defaultReader) Stream(ctx context.Context, settings messaging.StreamConfig) (messaging.Stream, error) {
 	this.mutex.Lock()
 	defer this.mutex.Unlock()
 
@@ -37,48 +40,125 @@ func (this *defaultReader) Stream(_ context.Context, settings messaging.StreamCo
 		return nil, ErrMultipleStreams
 	}
 
-	if err := this.establishTopology(settings); err != nil {
+	if err := this.establishTopology(&settings); err != nil {
 		_ = this.inner.Close()
 		return nil, this.tryPanic(err)
 	}
 
-	if err := this.inner.BufferCapacity(settings.BufferCapacity); err != nil {
-		this.logger.Printf("[WARN] Unable to set channel buffer size [%s].", err)
-		_ = this.inner.Close()
-		return nil, err
+	if settings.Replayable && settings.BufferCapacity == 0 {
+		settings.BufferCapacity = defaultReplayableBufferCapacity // stream queues reject an unlimited prefetch
 	}
 
 	streamID := strconv.FormatUint(this.counter, 10)
-	deliveries, err := this.inner.Consume(streamID, settings.StreamName)
+	deliveries, err := this.consume(ctx, this.inner, streamID, settings)
 	if err != nil {
-		this.logger.Printf("[WARN] Unable to open consumer on channel [%s].", err)
 		_ = this.inner.Close()
 		return nil, err
 	}
 
 	this.logger.Printf("[INFO] Consumer opened for queue [%s], awaiting messages...", settings.StreamName)
-	stream := newStream(this.inner, deliveries, streamID, settings.ExclusiveStream, this.config)
+	stream := newStream(this.inner, deliveries, streamID, settings, this, this.config)
 	this.counter++
 	this.streams = append(this.streams, stream)
 	this.hasExclusiveStream = this.hasExclusiveStream || settings.ExclusiveStream
 	return stream, nil
 }
-func (this *defaultReader) establishTopology(config messaging.StreamConfig) error {
+func (this *defaultReader) consume(ctx context.Context, channel adapter.Channel, streamID string, settings messaging.StreamConfig) (<-chan amqp.Delivery, error) {
+	if err := channel.BufferCapacity(settings.BufferCapacity); err != nil {
+		this.logger.Printf("[WARN] Unable to set channel buffer size [%s].", err)
+		return nil, err
+	}
+
+	arguments, err := this.consumerArguments(ctx, settings)
+	if err != nil {
+		this.logger.Printf("[WARN] Unable to load stored offset for stream [%s].", err)
+		return nil, err
+	}
+
+	deliveries, err := channel.Consume(streamID, settings.StreamName, arguments)
+	if err != nil {
+		this.logger.Printf("[WARN] Unable to open consumer on channel [%s].", err)
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// reopen opens the consumer of a stream on a new channel after the broker closed the failed channel; the new channel
+// is closed along with the reader.
+func (this *defaultReader) reopen(ctx context.Context, failed adapter.Channel, streamID string, settings messaging.StreamConfig) (adapter.Channel, <-chan amqp.Delivery, error) {
+	channel, err := this.opener.Channel()
+	if err != nil {
+		this.logger.Printf("[WARN] Unable to open channel to recover consumer [%s].", err)
+		return nil, nil, err
+	}
+
+	deliveries, err := this.consume(ctx, channel, streamID, settings)
+	if err != nil {
+		_ = channel.Close()
+		return nil, nil, err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.closed {
+		_ = channel.Close()
+		return nil, nil, amqp.ErrClosed
+	}
+
+	channels := this.channels[0:0]
+	for _, item := range this.channels {
+		if item != failed {
+			channels = append(channels, item)
+		}
+	}
+	this.channels = append(channels, channel)
+	return channel, deliveries, nil
+}
+
+func (this *defaultReader) establishTopology(config *messaging.StreamConfig) error {
+	if config.TemporaryStream {
+		return this.establishTemporaryTopology(config)
+	}
+
+	if config.VerifyTopology && !config.EstablishTopology {
+		return this.verifyTopology(*config)
+	}
+
 	if !config.EstablishTopology {
 		return nil
 	}
 
-	if err := this.inner.DeclareQueue(config.StreamName); err != nil {
+	if err := this.inner.DeclareQueue(config.StreamName, queueArguments(*config)); err != nil {
 		this.logger.Printf("[WARN] Unable to establish topology, queue declaration failed [%s].", err)
 		return err
 	}
 
+	return this.bindTopics(config)
+}
+func (this *defaultReader) establishTemporaryTopology(config *messaging.StreamConfig) error {
+	// temporary queues disappear with their connection, so they're always declared and bound regardless of whether the
+	// rest of the topology (the exchanges) should be established.
+	name, err := this.inner.DeclareTemporaryQueue()
+	if err != nil {
+		this.logger.Printf("[WARN] Unable to establish topology, temporary queue declaration failed [%s].", err)
+		return err
+	}
+
+	this.logger.Printf("[INFO] Temporary queue [%s] declared.", name)
+	config.StreamName = name
+	return this.bindTopics(config)
+}
+func (this *defaultReader) bindTopics(config *messaging.StreamConfig) error {
 	for _, topic := range config.Topics {
-		if err := this.inner.DeclareExchange(topic); err != nil {
-			this.logger.Printf("[WARN] Unable to establish topology, exchange declaration failed [%s].", err)
-			return err
+		if config.EstablishTopology {
+			if err := this.inner.DeclareExchange(topic, amqp.ExchangeFanout, amqp.Table{}); err != nil {
+				this.logger.Printf("[WARN] Unable to establish topology, exchange declaration failed [%s].", err)
+				return err
+			}
 		}
-		if err := this.inner.BindQueue(config.StreamName, topic); err != nil {
+		if err := this.inner.BindQueue(config.StreamName, topic, "", amqp.Table{}); err != nil {
 			this.logger.Printf("[WARN] Unable to establish topology, queue binding failed [%s].", err)
 			return err
 		}
@@ -87,6 +167,104 @@ func (this *defaultReader) establishTopology(config messaging.StreamConfig) erro
 	return nil
 }
 
+func (this *defaultReader) verifyTopology(config messaging.StreamConfig) error {
+	failure := &TopologyError{}
+
+	if err := this.verify(failure, "queue", config.StreamName, func(channel adapter.Channel) error {
+		return channel.VerifyQueue(config.StreamName)
+	}); err != nil {
+		return err
+	}
+
+	for _, topic := range config.Topics {
+		topic := topic
+		if err := this.verify(failure, "exchange", topic, func(channel adapter.Channel) error {
+			return channel.VerifyExchange(topic)
+		}); err != nil {
+			return err
+		}
+	}
+
+	if len(failure.Missing) == 0 && len(failure.Mismatched) == 0 {
+		return nil
+	}
+
+	this.logger.Printf("[WARN] Unable to verify topology [%s].", failure)
+	return failure
+}
+func (this *defaultReader) verify(failure *TopologyError, kind, name string, check func(adapter.Channel) error) error {
+	// a failed passive declaration closes the channel on which it was issued, so each entity is checked on its own
+	// short-lived channel which allows every failure to be reported rather than only the first.
+	channel, err := this.opener.Channel()
+	if err != nil {
+		this.logger.Printf("[WARN] Unable to open topology verification channel [%s].", err)
+		return err
+	}
+	defer func() { _ = channel.Close() }()
+
+	err = check(channel)
+	if err == nil {
+		return nil
+	}
+
+	brokerError, ok := err.(*amqp.Error)
+	if !ok {
+		this.logger.Printf("[WARN] Unable to verify %s [%s] [%s].", kind, name, err)
+		return err
+	}
+
+	entity := TopologyEntity{Kind: kind, Name: name, Code: brokerError.Code, Reason: brokerError.Reason}
+	if brokerError.Code == http.StatusNotFound {
+		failure.Missing = append(failure.Missing, entity)
+	} else {
+		failure.Mismatched = append(failure.Mismatched, entity)
+	}
+
+	return nil
+}
+
+const defaultReplayableBufferCapacity = 64
+
+func queueArguments(config messaging.StreamConfig) amqp.Table {
+	arguments := amqp.Table{}
+	if config.SingleActiveConsumer {
+		arguments[singleActiveConsumerArgument] = true
+	}
+	if config.Replayable {
+		arguments[queueTypeArgument] = streamQueueType
+	}
+	if config.MaxPriority > 0 {
+		arguments[maxPriorityArgument] = int32(config.MaxPriority) // a byte would be encoded as a signed value
+	}
+	return arguments
+}
+func (this *defaultReader) consumerArguments(ctx context.Context, config messaging.StreamConfig) (amqp.Table, error) {
+	arguments := amqp.Table{}
+	if config.ConsumerPriority != 0 {
+		arguments[consumerPriorityArgument] = config.ConsumerPriority
+	}
+
+	if !config.Replayable {
+		return arguments, nil
+	}
+
+	if !config.ExclusiveStream {
+		this.logger.Printf("[WARN] Offsets are only recorded for exclusive streams, queue [%s] will not resume where it left off.", config.StreamName)
+	}
+
+	offset, found, err := this.config.OffsetStore.Load(ctx, config.StreamName)
+	if err != nil {
+		return nil, err
+	} else if found {
+		offset++ // resume after the latest acknowledged delivery
+	} else {
+		offset = config.Sequence
+	}
+
+	arguments[streamOffsetArgument] = int64(offset)
+	return arguments, nil
+}
+
 func (this *defaultReader) tryPanic(err error) error {
 	if err == nil || !this.config.TopologyFailurePanic {
 		return err
@@ -96,6 +274,10 @@ func (this *defaultReader) tryPanic(err error) error {
 		panic(err)
 	}
 
+	if _, ok := err.(*TopologyError); ok {
+		panic(err)
+	}
+
 	return err
 }
 
@@ -109,5 +291,13 @@ func (this *defaultReader) Close() error {
 	}
 
 	this.streams = this.streams[0:0]
+
+	for i, channel := range this.channels {
+		this.channels[i] = nil
+		_ = channel.Close()
+	}
+
+	this.channels = this.channels[0:0]
+	this.closed = true
 	return this.inner.Close()
 }
@@ -3,11 +3,13 @@ package rabbitmq
 import (
 	"context"
 	"errors"
+	"net/http"
 	"testing"
 
 	"github.com/smartystreets/assertions/should"
 	"github.com/smartystreets/gunit"
 	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
 	"github.com/streadway/amqp"
 )
 
@@ -23,30 +25,49 @@ type ReaderFixture struct {
 	reader messaging.Reader
 
 	declareQueueName       string
+	declareQueueArguments  amqp.Table
 	declareQueueError      error
+	temporaryQueueName     string
+	temporaryQueueError    error
+	temporaryQueueCalls    int
 	declareExchangeNames   []string
 	declareExchangeError   error
 	bindQueueQueueNames    []string
 	bindQueueExchangeNames []string
 	bindQueueError         error
+	channelError           error
+	callsToChannel         int
+	verifiedQueues         []string
+	verifiedExchanges      []string
+	verifyErrors           map[string]error
 	bufferCapacityValue    uint16
 	bufferCapacityError    error
 	consumeConsumerID      string
 	consumeQueue           string
+	consumeArguments       amqp.Table
 	consumeChannel         chan amqp.Delivery
 	consumeError           error
 	callsToClose           int
 	cancelledConsumers     []string
+
+	loadStream string
+	loadOffset uint64
+	loadFound  bool
+	loadError  error
 }
 
 func (this *ReaderFixture) Setup() {
 	this.consumeChannel = make(chan amqp.Delivery, 4)
+	this.verifyErrors = make(map[string]error)
 	this.initializeReader()
 }
 func (this *ReaderFixture) initializeReader() {
 	config := configuration{}
-	Options.apply(Options.PanicOnTopologyError(this.configPanicOnTopologyFailure))(&config)
-	this.reader = newReader(this, config)
+	Options.apply(
+		Options.PanicOnTopologyError(this.configPanicOnTopologyFailure),
+		Options.OffsetStore(this),
+	)(&config)
+	this.reader = newReader(this, this, config)
 }
 
 func (this *ReaderFixture) TestWhenEstablishingAStream_StartConsumerOnFromUnderlyingChannel() {
@@ -68,6 +89,121 @@ func (this *ReaderFixture) TestWhenEstablishingAStream_StartConsumerOnFromUnderl
 	this.So(this.bufferCapacityValue, should.Equal, 2)
 	this.So(this.consumeConsumerID, should.Equal, "0")
 	this.So(this.consumeQueue, should.Equal, "queue")
+	this.So(this.declareQueueArguments, should.BeEmpty)
+	this.So(this.consumeArguments, should.BeEmpty)
+}
+func (this *ReaderFixture) TestWhenEstablishingSingleActiveConsumerStreamWithPriority_PassQueueAndConsumerArguments() {
+	_, err := this.reader.Stream(context.Background(), messaging.StreamConfig{
+		EstablishTopology:    true,
+		StreamName:           "queue",
+		SingleActiveConsumer: true,
+		ConsumerPriority:     10,
+	})
+
+	this.So(err, should.BeNil)
+	this.So(this.declareQueueArguments, should.Resemble, amqp.Table{"x-single-active-consumer": true})
+	this.So(this.consumeArguments, should.Resemble, amqp.Table{"x-priority": int32(10)})
+}
+func (this *ReaderFixture) TestWhenEstablishingPrioritizedStream_DeclareQueueWithMaxPriority() {
+	_, err := this.reader.Stream(context.Background(), messaging.StreamConfig{
+		EstablishTopology: true,
+		StreamName:        "queue",
+		MaxPriority:       200,
+	})
+
+	this.So(err, should.BeNil)
+	this.So(this.declareQueueArguments, should.Resemble, amqp.Table{"x-max-priority": int32(200)})
+}
+func (this *ReaderFixture) TestWhenEstablishingATemporaryStream_DeclareServerNamedQueueAndBindToTopics() {
+	this.temporaryQueueName = "amq.gen-123"
+
+	stream, err := this.reader.Stream(context.Background(), messaging.StreamConfig{
+		EstablishTopology: true,
+		TemporaryStream:   true,
+		StreamName:        "ignored",
+		Topics:            []string{"topic1", "topic2"},
+	})
+
+	this.So(err, should.BeNil)
+	this.So(stream, should.Implement, (*NamedStream)(nil))
+	this.So(stream.(NamedStream).Name(), should.Equal, "amq.gen-123")
+	this.So(this.temporaryQueueCalls, should.Equal, 1)
+	this.So(this.declareQueueName, should.BeEmpty)
+	this.So(this.declareExchangeNames, should.Resemble, []string{"topic1", "topic2"})
+	this.So(this.bindQueueQueueNames, should.Resemble, []string{"amq.gen-123", "amq.gen-123"})
+	this.So(this.bindQueueExchangeNames, should.Resemble, []string{"topic1", "topic2"})
+	this.So(this.consumeQueue, should.Equal, "amq.gen-123")
+}
+func (this *ReaderFixture) TestWhenEstablishingATemporaryStreamWithoutTopology_BindToExistingTopics() {
+	this.temporaryQueueName = "amq.gen-123"
+
+	_, err := this.reader.Stream(context.Background(), messaging.StreamConfig{
+		TemporaryStream: true,
+		Topics:          []string{"topic1"},
+	})
+
+	this.So(err, should.BeNil)
+	this.So(this.declareExchangeNames, should.BeEmpty)
+	this.So(this.bindQueueQueueNames, should.Resemble, []string{"amq.gen-123"})
+	this.So(this.bindQueueExchangeNames, should.Resemble, []string{"topic1"})
+}
+func (this *ReaderFixture) TestWhenDeclaringTemporaryQueueFails_CloseChannelAndReturnError() {
+	this.temporaryQueueError = errors.New("")
+
+	stream, err := this.reader.Stream(context.Background(), messaging.StreamConfig{TemporaryStream: true})
+
+	this.So(stream, should.BeNil)
+	this.So(err, should.Equal, this.temporaryQueueError)
+	this.So(this.callsToClose, should.Equal, 1)
+}
+func (this *ReaderFixture) TestWhenEstablishingReplayableStream_DeclareStreamQueueAndConsumeFromSequence() {
+	_, err := this.reader.Stream(context.Background(), messaging.StreamConfig{
+		EstablishTopology: true,
+		ExclusiveStream:   true,
+		StreamName:        "queue",
+		Replayable:        true,
+		Sequence:          42,
+	})
+
+	this.So(err, should.BeNil)
+	this.So(this.declareQueueArguments, should.Resemble, amqp.Table{"x-queue-type": "stream"})
+	this.So(this.loadStream, should.Equal, "queue")
+	this.So(this.consumeArguments, should.Resemble, amqp.Table{"x-stream-offset": int64(42)})
+	this.So(this.bufferCapacityValue, should.Equal, defaultReplayableBufferCapacity)
+}
+func (this *ReaderFixture) TestWhenReplayableStreamHasBufferCapacity_UseIt() {
+	_, err := this.reader.Stream(context.Background(), messaging.StreamConfig{
+		StreamName:     "queue",
+		Replayable:     true,
+		BufferCapacity: 7,
+	})
+
+	this.So(err, should.BeNil)
+	this.So(this.bufferCapacityValue, should.Equal, 7)
+}
+func (this *ReaderFixture) TestWhenOffsetPreviouslyStored_ResumeAfterStoredOffset() {
+	this.loadFound = true
+	this.loadOffset = 100
+
+	_, err := this.reader.Stream(context.Background(), messaging.StreamConfig{
+		ExclusiveStream: true,
+		StreamName:      "queue",
+		Replayable:      true,
+		Sequence:        42,
+	})
+
+	this.So(err, should.BeNil)
+	this.So(this.consumeArguments, should.Resemble, amqp.Table{"x-stream-offset": int64(101)})
+}
+func (this *ReaderFixture) TestWhenLoadingStoredOffsetFails_CloseChannelAndReturnError() {
+	this.loadError = errors.New("")
+
+	stream, err := this.reader.Stream(context.Background(), messaging.StreamConfig{Replayable: true})
+
+	this.So(stream, should.BeNil)
+	this.So(err, should.Equal, this.loadError)
+	this.So(this.callsToClose, should.Equal, 1)
+	this.So(this.consumeQueue, should.BeEmpty)
 }
 func (this *ReaderFixture) TestWhenEstablishingAnExclusiveStreamWithExisting_ReturnError() {
 	_, _ = this.reader.Stream(context.Background(), messaging.StreamConfig{}) // stream already exists
@@ -172,35 +308,179 @@ func (this *ReaderFixture) TestWhenClosing_ShutDownAllStreamsAndUnderlyingChanne
 	this.So(this.callsToClose, should.Equal, 1)
 }
 
+func (this *ReaderFixture) TestWhenReopeningConsumer_ConsumeOnNewChannelClosedAlongWithReader() {
+	settings := messaging.StreamConfig{StreamName: "queue", BufferCapacity: 3}
+
+	channel, deliveries, err := this.reader.(*defaultReader).reopen(context.Background(), this, "7", settings)
+
+	this.So(err, should.BeNil)
+	this.So(channel, should.Equal, this)
+	this.So(deliveries, should.Equal, (<-chan amqp.Delivery)(this.consumeChannel))
+	this.So(this.callsToChannel, should.Equal, 1)
+	this.So(this.bufferCapacityValue, should.Equal, 3)
+	this.So(this.consumeConsumerID, should.Equal, "7")
+	this.So(this.consumeQueue, should.Equal, "queue")
+
+	_ = this.reader.Close()
+	this.So(this.callsToClose, should.Equal, 2) // the reopened channel and the original
+}
+func (this *ReaderFixture) TestWhenReopeningConsumerFails_CloseNewChannelAndReturnError() {
+	this.consumeError = errors.New("")
+
+	_, _, err := this.reader.(*defaultReader).reopen(context.Background(), this, "0", messaging.StreamConfig{})
+
+	this.So(err, should.Equal, this.consumeError)
+	this.So(this.callsToClose, should.Equal, 1)
+}
+func (this *ReaderFixture) TestWhenReopeningConsumerAfterReaderClosed_CloseNewChannelAndReturnError() {
+	_ = this.reader.Close()
+
+	_, _, err := this.reader.(*defaultReader).reopen(context.Background(), this, "0", messaging.StreamConfig{})
+
+	this.So(err, should.Equal, amqp.ErrClosed)
+	this.So(this.callsToClose, should.Equal, 2)
+}
+
+func (this *ReaderFixture) TestWhenVerifyingTopology_PassivelyCheckQueueAndExchangesWithoutDeclaring() {
+	stream, err := this.reader.Stream(context.Background(), messaging.StreamConfig{
+		VerifyTopology: true,
+		StreamName:     "queue",
+		Topics:         []string{"topic1", "topic2"},
+	})
+
+	this.So(err, should.BeNil)
+	this.So(stream, should.NotBeNil)
+	this.So(this.verifiedQueues, should.Resemble, []string{"queue"})
+	this.So(this.verifiedExchanges, should.Resemble, []string{"topic1", "topic2"})
+	this.So(this.callsToChannel, should.Equal, 3)
+	this.So(this.callsToClose, should.Equal, 3)
+	this.So(this.declareQueueName, should.BeEmpty)
+	this.So(this.declareExchangeNames, should.BeEmpty)
+	this.So(this.bindQueueQueueNames, should.BeEmpty)
+}
+func (this *ReaderFixture) TestWhenVerifyingTopologyFails_ReportEveryMissingAndMismatchedEntity() {
+	this.verifyErrors["queue"] = &amqp.Error{Code: http.StatusNotFound, Reason: "NOT_FOUND - no queue"}
+	this.verifyErrors["topic1"] = &amqp.Error{Code: http.StatusMethodNotAllowed, Reason: "RESOURCE_LOCKED"}
+	this.verifyErrors["topic2"] = &amqp.Error{Code: http.StatusNotFound, Reason: "NOT_FOUND - no exchange"}
+
+	stream, err := this.reader.Stream(context.Background(), messaging.StreamConfig{
+		VerifyTopology: true,
+		StreamName:     "queue",
+		Topics:         []string{"topic1", "topic2"},
+	})
+
+	this.So(stream, should.BeNil)
+	this.So(err, should.Resemble, &TopologyError{
+		Missing: []TopologyEntity{
+			{Kind: "queue", Name: "queue", Code: http.StatusNotFound, Reason: "NOT_FOUND - no queue"},
+			{Kind: "exchange", Name: "topic2", Code: http.StatusNotFound, Reason: "NOT_FOUND - no exchange"},
+		},
+		Mismatched: []TopologyEntity{
+			{Kind: "exchange", Name: "topic1", Code: http.StatusMethodNotAllowed, Reason: "RESOURCE_LOCKED"},
+		},
+	})
+	this.So(this.consumeQueue, should.BeEmpty)
+}
+func (this *ReaderFixture) TestWhenVerifyingTopologyFailsAndConfiguredToPanic_Panic() {
+	this.configPanicOnTopologyFailure = true
+	this.initializeReader()
+	this.verifyErrors["queue"] = &amqp.Error{Code: http.StatusNotFound}
+
+	config := messaging.StreamConfig{VerifyTopology: true, StreamName: "queue"}
+
+	this.So(func() { _, _ = this.reader.Stream(context.Background(), config) }, should.Panic)
+}
+func (this *ReaderFixture) TestWhenVerificationChannelCannotBeOpened_ReturnUnderlyingError() {
+	this.configPanicOnTopologyFailure = true
+	this.initializeReader()
+	this.channelError = errors.New("")
+
+	_, err := this.reader.Stream(context.Background(), messaging.StreamConfig{VerifyTopology: true, StreamName: "queue"})
+
+	this.So(err, should.Equal, this.channelError)
+	this.So(this.verifiedQueues, should.BeEmpty)
+}
+func (this *ReaderFixture) TestWhenEstablishingTopology_VerificationIsUnnecessary() {
+	_, err := this.reader.Stream(context.Background(), messaging.StreamConfig{
+		EstablishTopology: true,
+		VerifyTopology:    true,
+		StreamName:        "queue",
+	})
+
+	this.So(err, should.BeNil)
+	this.So(this.declareQueueName, should.Equal, "queue")
+	this.So(this.callsToChannel, should.Equal, 0)
+}
+
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func (this *ReaderFixture) DeclareQueue(name string) error {
+func (this *ReaderFixture) DeclareQueue(name string, arguments amqp.Table) error {
 	this.declareQueueName = name
+	this.declareQueueArguments = arguments
 	return this.declareQueueError
 }
-func (this *ReaderFixture) DeclareExchange(name string) error {
+func (this *ReaderFixture) DeclareTemporaryQueue() (string, error) {
+	this.temporaryQueueCalls++
+	return this.temporaryQueueName, this.temporaryQueueError
+}
+func (this *ReaderFixture) DeclareExchange(name, _ string, _ amqp.Table) error {
 	this.declareExchangeNames = append(this.declareExchangeNames, name)
 	return this.declareExchangeError
 }
-func (this *ReaderFixture) BindQueue(queue, exchange string) error {
+func (this *ReaderFixture) BindQueue(queue, exchange, _ string, _ amqp.Table) error {
 	this.bindQueueQueueNames = append(this.bindQueueQueueNames, queue)
 	this.bindQueueExchangeNames = append(this.bindQueueExchangeNames, exchange)
 	return this.bindQueueError
 }
+func (this *ReaderFixture) UnbindQueue(_, _, _ string, _ amqp.Table) error {
+	panic("nop")
+}
+func (this *ReaderFixture) DeleteQueue(_ string, _, _ bool) error {
+	panic("nop")
+}
+func (this *ReaderFixture) DeleteExchange(_ string) error {
+	panic("nop")
+}
+func (this *ReaderFixture) VerifyQueue(name string) error {
+	this.verifiedQueues = append(this.verifiedQueues, name)
+	return this.verifyErrors[name]
+}
+func (this *ReaderFixture) VerifyExchange(name string) error {
+	this.verifiedExchanges = append(this.verifiedExchanges, name)
+	return this.verifyErrors[name]
+}
 func (this *ReaderFixture) BufferCapacity(value uint16) error {
 	this.bufferCapacityValue = value
 	return this.bufferCapacityError
 }
-func (this *ReaderFixture) Consume(consumerID, queue string) (<-chan amqp.Delivery, error) {
+func (this *ReaderFixture) Consume(consumerID, queue string, arguments amqp.Table) (<-chan amqp.Delivery, error) {
 	this.consumeConsumerID = consumerID
 	this.consumeQueue = queue
+	this.consumeArguments = arguments
 	return this.consumeChannel, this.consumeError
 }
 func (this *ReaderFixture) Close() error { this.callsToClose++; return nil }
 
+func (this *ReaderFixture) Channel() (adapter.Channel, error) {
+	this.callsToChannel++
+	if this.channelError != nil {
+		return nil, this.channelError
+	}
+	return this, nil
+}
+
+func (this *ReaderFixture) Load(_ context.Context, stream string) (uint64, bool, error) {
+	this.loadStream = stream
+	return this.loadOffset, this.loadFound, this.loadError
+}
+func (this *ReaderFixture) Store(_ context.Context, _ string, _ uint64) error { panic("nop") }
+
 func (this *ReaderFixture) Ack(deliveryTag uint64, multiple bool) error {
 	panic("nop")
 }
+func (this *ReaderFixture) Reject(deliveryTag uint64, requeue bool) error {
+	panic("nop")
+}
 func (this *ReaderFixture) CancelConsumer(consumerID string) error {
 	this.cancelledConsumers = append(this.cancelledConsumers, consumerID)
 	return nil
@@ -217,3 +497,6 @@ func (this *ReaderFixture) TxCommit() error {
 func (this *ReaderFixture) TxRollback() error {
 	panic("nop")
 }
+func (this *ReaderFixture) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	return receiver
+}
@@ -2,9 +2,11 @@ package rabbitmq
 
 import (
 	"context"
+	"errors"
 	"io"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/smartystreets/messaging/v3"
 	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
@@ -12,45 +14,119 @@ import (
 )
 
 type defaultStream struct {
+	consumer *streamConsumer
+	opener   consumerOpener
+	settings messaging.StreamConfig
+	recovery sync.Mutex // serializes recovery such that concurrent readers only reopen the failed consumer once
+	closed   bool
+	streamID string
+	name     string
+	batchAck bool
+	closer   sync.Once
+	now      func() time.Time
+	logger   logger
+	monitor  monitor
+	expiries expirationMonitor
+
+	consumers    consumerMonitor
+	singleActive bool // consumers wait on standby until the broker selects them as the single active consumer
+	active       bool
+	mutex        sync.Mutex
+
+	offsets       offsetStore
+	recordOffsets bool // only exclusive streams acknowledge (and record) deliveries strictly in order
+}
+
+// streamConsumer is the broker consumer from which the stream reads; it's replaced whenever the stream recovers from
+// a channel-level error on a new channel.
+type streamConsumer struct {
 	channel    adapter.Channel
 	deliveries <-chan amqp.Delivery
-	streamID   string
-	batchAck   bool
-	closer     sync.Once
-	logger     logger
-	monitor    monitor
+	reason     *closeReason
+	generation uint16
 }
 
-func newStream(channel adapter.Channel, deliveries <-chan amqp.Delivery, id string, exclusive bool, config configuration) messaging.Stream {
+func newStream(channel adapter.Channel, deliveries <-chan amqp.Delivery, id string, settings messaging.StreamConfig, opener consumerOpener, config configuration) messaging.Stream {
 	return &defaultStream{
+		consumer:     newStreamConsumer(channel, deliveries, 0),
+		opener:       opener,
+		settings:     settings,
+		streamID:     id,
+		name:         settings.StreamName,
+		batchAck:     settings.ExclusiveStream,
+		now:          config.Now,
+		logger:       config.Logger,
+		monitor:      config.Monitor,
+		expiries:     newExpirationMonitor(config.Monitor),
+		consumers:    newConsumerMonitor(config.Monitor),
+		singleActive: settings.SingleActiveConsumer,
+
+		offsets:       config.OffsetStore,
+		recordOffsets: settings.Replayable && settings.ExclusiveStream,
+	}
+}
+func newConsumerMonitor(value monitor) consumerMonitor {
+	if monitor, ok := value.(consumerMonitor); ok {
+		return monitor
+	}
+	return nop{} // activation is still logged
+}
+func newExpirationMonitor(value monitor) expirationMonitor {
+	if monitor, ok := value.(expirationMonitor); ok {
+		return monitor
+	}
+	return nop{}
+}
+func newStreamConsumer(channel adapter.Channel, deliveries <-chan amqp.Delivery, generation uint16) *streamConsumer {
+	return &streamConsumer{
 		channel:    channel,
 		deliveries: deliveries,
-		streamID:   id,
-		batchAck:   exclusive,
-		logger:     config.Logger,
-		monitor:    config.Monitor,
+		reason:     newCloseReason(channel),
+		generation: generation,
 	}
 }
 
 func (this *defaultStream) Read(ctx context.Context, target *messaging.Delivery) error {
-	select {
-	case source, open := <-this.deliveries:
-		return this.processDelivery(source, target, open)
-	case <-ctx.Done():
-		return ctx.Err()
+	for {
+		consumer := this.current()
+
+		select {
+		case source, open := <-consumer.deliveries:
+			if !open && this.recover(ctx, consumer) {
+				continue
+			}
+			if err := this.processDelivery(consumer, source, target, open); err != errDeliveryExpired {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 }
-func (this *defaultStream) processDelivery(source amqp.Delivery, target *messaging.Delivery, deliveryChannelOpen bool) error {
+func (this *defaultStream) processDelivery(consumer *streamConsumer, source amqp.Delivery, target *messaging.Delivery, deliveryChannelOpen bool) error {
 	if !deliveryChannelOpen {
-		return io.EOF
+		this.activate(false)
+		return consumer.reason.wrap(io.EOF)
 	}
 
-	target.DeliveryID = source.DeliveryTag
+	this.activate(true)
+
+	if this.expired(source) {
+		return this.discard(consumer, source)
+	}
+
+	target.DeliveryID = consumer.deliveryID(source.DeliveryTag)
+	target.DeliveryCount = computeDeliveryCount(source)
+	target.Sequence, _ = parseHeaderUint64(source.Headers[streamOffsetArgument])
+	target.Redelivered = source.Redelivered
 	target.SourceID = parseUint64(source.AppId)
 	target.MessageID = parseUint64(source.MessageId)
 	target.CorrelationID = parseUint64(source.CorrelationId)
 	target.Timestamp = source.Timestamp
 	target.Durable = source.DeliveryMode == amqp.Persistent
+	target.Priority = source.Priority
+	target.Topic = source.Exchange
+	target.Partition = parseUint64(source.RoutingKey)
 	target.MessageType = source.Type
 	target.ContentType = source.ContentType
 	target.ContentEncoding = source.ContentEncoding
@@ -60,6 +136,129 @@ func (this *defaultStream) processDelivery(source amqp.Delivery, target *messagi
 	this.monitor.DeliveryReceived()
 	return nil
 }
+func (this *defaultStream) expired(source amqp.Delivery) bool {
+	expiresAt, ok := parseHeaderUint64(source.Headers[expiresAtHeader])
+	if !ok {
+		return false
+	}
+
+	return this.now().UnixNano()/int64(time.Millisecond) >= int64(expiresAt)
+}
+func (this *defaultStream) discard(consumer *streamConsumer, source amqp.Delivery) error {
+	// rejecting without requeue dead-letters the delivery when the queue has a dead-letter exchange, otherwise the
+	// broker drops it.
+	if err := consumer.channel.Reject(source.DeliveryTag, false); err != nil {
+		this.logger.Printf("[WARN] Unable to reject expired delivery against underlying channel [%s].", err)
+		return consumer.reason.wrap(err)
+	}
+
+	this.expiries.DeliveryExpired()
+	return errDeliveryExpired
+}
+func (this *defaultStream) current() *streamConsumer {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.consumer
+}
+
+// recover resumes the stream on a new channel after the broker closed the channel of the failed consumer due to a
+// channel-level (soft) error, e.g. acknowledging an unknown delivery tag, such that the connection and every other
+// stream on it remain intact. Deliveries received from the failed consumer have been requeued by the broker and are
+// no longer acknowledged. It reports whether reading may continue.
+func (this *defaultStream) recover(ctx context.Context, failed *streamConsumer) bool {
+	this.recovery.Lock()
+	defer this.recovery.Unlock()
+
+	if this.current() != failed {
+		return true // already recovered by a concurrent reader
+	}
+
+	cause := failed.reason.receive()
+	if cause == nil || !cause.Recover || this.settings.TemporaryStream || this.isClosed() {
+		return false // temporary queues are removed by the broker along with their consumer
+	}
+
+	this.logger.Printf("[WARN] Channel of queue [%s] closed by the broker [%d: %s], recovering consumer...", this.name, cause.Code, cause.Reason)
+	this.activate(false) // the broker registers the new consumer anew, it's on standby until it receives a delivery
+	channel, deliveries, err := this.opener.reopen(ctx, failed.channel, this.streamID, this.settings)
+	if err != nil {
+		this.logger.Printf("[WARN] Unable to recover consumer of queue [%s] [%s].", this.name, err)
+		return false
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.closed {
+		_ = channel.Close() // closed while recovering
+		return false
+	}
+
+	this.consumer = newStreamConsumer(channel, deliveries, failed.generation+1)
+	this.logger.Printf("[INFO] Consumer of queue [%s] recovered, awaiting messages...", this.name)
+	return true
+}
+func (this *defaultStream) isClosed() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.closed
+}
+
+func (this *defaultStream) activate(active bool) {
+	if !this.singleActive {
+		return
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.active == active {
+		return
+	}
+
+	this.active = active
+	if active {
+		this.logger.Printf("[INFO] Consumer activated as the single active consumer of queue [%s].", this.name)
+	} else {
+		this.logger.Printf("[INFO] Consumer of queue [%s] is no longer the single active consumer.", this.name)
+	}
+	this.consumers.ConsumerActivated(this.name, active)
+}
+
+func computeDeliveryCount(source amqp.Delivery) uint64 {
+	// quorum queues report the number of previous (failed) delivery attempts, other queues only flag a redelivery
+	if previous, ok := parseHeaderUint64(source.Headers[deliveryCountHeader]); ok {
+		return previous + 1
+	} else if source.Redelivered {
+		return 2
+	} else {
+		return 1
+	}
+}
+func parseHeaderUint64(value interface{}) (uint64, bool) {
+	switch typed := value.(type) {
+	case int64:
+		return uint64(typed), typed >= 0
+	case int32:
+		return uint64(typed), typed >= 0
+	case int16:
+		return uint64(typed), typed >= 0
+	case int8:
+		return uint64(typed), typed >= 0
+	case int:
+		return uint64(typed), typed >= 0
+	case uint64:
+		return typed, true
+	case uint32:
+		return uint64(typed), true
+	case uint16:
+		return uint64(typed), true
+	case uint8:
+		return uint64(typed), true
+	default:
+		return 0, false
+	}
+}
 func parseUint64(value string) uint64 {
 	parsed, _ := strconv.ParseUint(value, 10, 64)
 	return parsed
@@ -77,8 +276,19 @@ func (this *defaultStream) Acknowledge(ctx context.Context, deliveries ...messag
 		deliveries = deliveries[length-1:] // only ack the last one
 	}
 
+	consumer := this.current()
 	for _, delivery := range deliveries {
-		if err := this.channel.Ack(delivery.DeliveryID, this.batchAck); err != nil {
+		deliveryTag, current := consumer.deliveryTag(delivery.DeliveryID)
+		if !current {
+			continue // received prior to recovery, the broker has already requeued it
+		}
+
+		if err := consumer.channel.Ack(deliveryTag, this.batchAck); err != nil {
+			if this.recover(ctx, consumer) {
+				this.logger.Printf("[WARN] Unable to acknowledge delivery before the channel was recovered [%s], it will be redelivered.", err)
+				return nil
+			}
+
 			this.logger.Printf("[WARN] Unable to acknowledge delivery against underlying channel [%s].", err)
 			this.monitor.DeliveryAcknowledged(uint16(length), err)
 			return err
@@ -86,12 +296,50 @@ func (this *defaultStream) Acknowledge(ctx context.Context, deliveries ...messag
 	}
 
 	this.monitor.DeliveryAcknowledged(uint16(length), nil)
+	return this.recordOffset(ctx, consumer, deliveries)
+}
+func (this *defaultStream) recordOffset(ctx context.Context, consumer *streamConsumer, deliveries []messaging.Delivery) error {
+	if !this.recordOffsets || len(deliveries) == 0 {
+		return nil
+	}
+
+	latest := deliveries[len(deliveries)-1]
+	if _, current := consumer.deliveryTag(latest.DeliveryID); !current {
+		return nil // once recovered, the consumer resumes after the latest offset which was recorded
+	}
+
+	if err := this.offsets.Store(ctx, this.name, latest.Sequence); err != nil {
+		this.logger.Printf("[WARN] Unable to record stream offset [%s].", err)
+		return err
+	}
+
 	return nil
 }
 
+// deliveryID qualifies the delivery tag, which is only unique to the channel, with the generation of the consumer
+// such that acknowledgements of deliveries received prior to recovery are never applied to the new channel.
+func (this *streamConsumer) deliveryID(deliveryTag uint64) uint64 {
+	return uint64(this.generation)<<deliveryTagBits | deliveryTag
+}
+func (this *streamConsumer) deliveryTag(deliveryID uint64) (uint64, bool) {
+	return deliveryID & (1<<deliveryTagBits - 1), uint16(deliveryID>>deliveryTagBits) == this.generation
+}
+
+// Name returns the name of the underlying queue, which is generated by the broker for temporary streams.
+func (this *defaultStream) Name() string { return this.name }
+
 func (this *defaultStream) Close() (err error) {
 	this.closer.Do(func() {
-		err = this.channel.CancelConsumer(this.streamID)
+		this.mutex.Lock()
+		this.closed = true
+		consumer := this.consumer
+		this.mutex.Unlock()
+
+		err = consumer.channel.CancelConsumer(this.streamID)
 	})
 	return err
 }
+
+const deliveryTagBits = 48 // the remaining (upper) bits of a delivery ID hold the generation of the consumer
+
+var errDeliveryExpired = errors.New("the delivery expired before it was received")
@@ -10,6 +10,7 @@ import (
 	"github.com/smartystreets/assertions/should"
 	"github.com/smartystreets/gunit"
 	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
 	"github.com/streadway/amqp"
 )
 
@@ -26,22 +27,56 @@ type StreamFixture struct {
 	exclusiveStream bool
 	now             time.Time
 
+	singleActiveConsumer bool
+	activations          []bool
+
+	replayable    bool
+	storedStreams []string
+	storedOffsets []uint64
+	storeError    error
+
 	cancellations         []string
 	acknowledgedTags      []uint64
 	acknowledgedMultiples []bool
 	acknowledgeError      error
+	rejectedTags          []uint64
+	rejectedRequeues      []bool
+	rejectError           error
+	expirations           int
+	closeNotifications    chan *amqp.Error
+
+	temporaryStream bool
+	reopened        chan amqp.Delivery
+	reopenedStreams []string
+	reopenError     error
 }
 
 func (this *StreamFixture) Setup() {
 	this.now = time.Now().UTC()
 	this.deliveries = make(chan amqp.Delivery, 16)
+	this.reopened = make(chan amqp.Delivery, 16)
 	this.initializeStream()
 }
 func (this *StreamFixture) initializeStream() {
-	this.stream = newStream(this, this.deliveries, this.streamID, this.exclusiveStream,
-		configuration{Logger: nop{}, Monitor: nop{}})
+	settings := messaging.StreamConfig{
+		StreamName:           "queue",
+		ExclusiveStream:      this.exclusiveStream,
+		SingleActiveConsumer: this.singleActiveConsumer,
+		Replayable:           this.replayable,
+		TemporaryStream:      this.temporaryStream,
+	}
+	config := configuration{Logger: nop{}, Monitor: this, OffsetStore: this, Now: func() time.Time { return this.now }}
+	this.stream = newStream(this, this.deliveries, this.streamID, settings, this, config)
 }
 
+func (this *StreamFixture) TestWhenMonitorDoesNotObserveActivation_ItIsOnlyLogged() {
+	this.So(newConsumerMonitor(this), should.Equal, this)
+	this.So(newConsumerMonitor(baseMonitor{}), should.Resemble, nop{})
+}
+func (this *StreamFixture) TestWhenMonitorDoesNotObserveExpiration_NothingIsReported() {
+	this.So(newExpirationMonitor(this), should.Equal, this)
+	this.So(newExpirationMonitor(baseMonitor{}), should.Resemble, nop{})
+}
 func (this *StreamFixture) TestWhenCloseInvokedMultipleTimes_OnlyCancelConsumerOnce() {
 	_ = this.stream.Close()
 
@@ -55,6 +90,7 @@ func (this *StreamFixture) TestWhenReading_ReadFromConsumerBufferChannel() {
 		ContentType:     "content-type",
 		ContentEncoding: "content-encoding",
 		DeliveryMode:    amqp.Persistent,
+		Priority:        8,
 		CorrelationId:   "1",
 		Expiration:      "2",
 		MessageId:       "3",
@@ -64,6 +100,8 @@ func (this *StreamFixture) TestWhenReading_ReadFromConsumerBufferChannel() {
 		AppId:           "5",
 		DeliveryTag:     6,
 		Redelivered:     false,
+		Exchange:        "exchange",
+		RoutingKey:      "7",
 		Body:            []byte("payload"),
 		Headers: map[string]interface{}{
 			"header10": "value10",
@@ -78,11 +116,16 @@ func (this *StreamFixture) TestWhenReading_ReadFromConsumerBufferChannel() {
 	this.So(err, should.BeNil)
 	this.So(delivery, should.Resemble, messaging.Delivery{
 		DeliveryID:      6,
+		DeliveryCount:   1,
+		Redelivered:     false,
 		SourceID:        5,
 		MessageID:       3,
 		CorrelationID:   1,
 		Timestamp:       this.now,
 		Durable:         true,
+		Priority:        8,
+		Topic:           "exchange",
+		Partition:       7,
 		MessageType:     "message-type",
 		ContentType:     "content-type",
 		ContentEncoding: "content-encoding",
@@ -94,6 +137,97 @@ func (this *StreamFixture) TestWhenReading_ReadFromConsumerBufferChannel() {
 		},
 	})
 }
+func (this *StreamFixture) TestWhenReadingARedelivery_MarkDeliveryAsRedelivered() {
+	this.deliveries <- amqp.Delivery{Redelivered: true}
+
+	var delivery messaging.Delivery
+	err := this.stream.Read(context.Background(), &delivery)
+
+	this.So(err, should.BeNil)
+	this.So(delivery.Redelivered, should.BeTrue)
+	this.So(delivery.DeliveryCount, should.Equal, 2)
+}
+func (this *StreamFixture) TestWhenReadingARedeliveryFromQuorumQueue_UseBrokerDeliveryCount() {
+	this.deliveries <- amqp.Delivery{Redelivered: true, Headers: amqp.Table{"x-delivery-count": int64(3)}}
+	this.deliveries <- amqp.Delivery{Redelivered: true, Headers: amqp.Table{"x-delivery-count": int32(4)}}
+
+	var first, second messaging.Delivery
+	_ = this.stream.Read(context.Background(), &first)
+	_ = this.stream.Read(context.Background(), &second)
+
+	this.So(first.Redelivered, should.BeTrue)
+	this.So(first.DeliveryCount, should.Equal, 4)
+	this.So(second.DeliveryCount, should.Equal, 5)
+}
+func (this *StreamFixture) TestWhenSingleActiveConsumerReceivesFirstDelivery_ReportActivationOnce() {
+	this.singleActiveConsumer = true
+	this.initializeStream()
+	this.deliveries <- amqp.Delivery{}
+	this.deliveries <- amqp.Delivery{}
+	close(this.deliveries)
+
+	var delivery messaging.Delivery
+	_ = this.stream.Read(context.Background(), &delivery)
+	_ = this.stream.Read(context.Background(), &delivery)
+	_ = this.stream.Read(context.Background(), &delivery)
+
+	this.So(this.activations, should.Resemble, []bool{true, false})
+}
+func (this *StreamFixture) TestWhenSingleActiveConsumerRecovers_ReportActivationAgainOnceNewConsumerReceivesDelivery() {
+	this.singleActiveConsumer = true
+	this.initializeStream()
+	this.deliveries <- amqp.Delivery{}
+	var delivery messaging.Delivery
+	_ = this.stream.Read(context.Background(), &delivery)
+
+	this.closeChannel()
+	this.reopened <- amqp.Delivery{DeliveryTag: 1}
+	_ = this.stream.Read(context.Background(), &delivery)
+
+	this.So(this.activations, should.Resemble, []bool{true, false, true})
+}
+func (this *StreamFixture) TestWhenNotSingleActiveConsumer_DoNotReportActivation() {
+	this.deliveries <- amqp.Delivery{}
+
+	var delivery messaging.Delivery
+	_ = this.stream.Read(context.Background(), &delivery)
+
+	this.So(this.activations, should.BeEmpty)
+}
+func (this *StreamFixture) TestWhenReadingFromStreamQueue_ExposeStreamOffset() {
+	this.deliveries <- amqp.Delivery{Headers: amqp.Table{"x-stream-offset": int64(1234)}}
+
+	var delivery messaging.Delivery
+	err := this.stream.Read(context.Background(), &delivery)
+
+	this.So(err, should.BeNil)
+	this.So(delivery.Sequence, should.Equal, 1234)
+}
+func (this *StreamFixture) TestWhenReadingExpiredDeliveries_RejectThemAndReadTheNextDelivery() {
+	expiresAt := this.now.UnixNano() / int64(time.Millisecond)
+	this.deliveries <- amqp.Delivery{DeliveryTag: 1, Headers: amqp.Table{"x-expires-at": expiresAt}}
+	this.deliveries <- amqp.Delivery{DeliveryTag: 2, Headers: amqp.Table{"x-expires-at": expiresAt - 1}}
+	this.deliveries <- amqp.Delivery{DeliveryTag: 3, Headers: amqp.Table{"x-expires-at": expiresAt + 1}}
+
+	var delivery messaging.Delivery
+	err := this.stream.Read(context.Background(), &delivery)
+
+	this.So(err, should.BeNil)
+	this.So(delivery.DeliveryID, should.Equal, 3)
+	this.So(this.rejectedTags, should.Resemble, []uint64{1, 2})
+	this.So(this.rejectedRequeues, should.Resemble, []bool{false, false})
+	this.So(this.expirations, should.Equal, 2)
+}
+func (this *StreamFixture) TestWhenRejectingExpiredDeliveryFails_ReturnUnderlyingError() {
+	this.rejectError = errors.New("")
+	this.deliveries <- amqp.Delivery{DeliveryTag: 1, Headers: amqp.Table{"x-expires-at": int64(1)}}
+
+	var delivery messaging.Delivery
+	err := this.stream.Read(context.Background(), &delivery)
+
+	this.So(err, should.Equal, this.rejectError)
+	this.So(this.expirations, should.Equal, 0)
+}
 func (this *StreamFixture) TestWhenReadingFromAClosedBufferChannel_ReturnEOF() {
 	close(this.deliveries)
 
@@ -103,6 +237,54 @@ func (this *StreamFixture) TestWhenReadingFromAClosedBufferChannel_ReturnEOF() {
 	this.So(err, should.Equal, io.EOF)
 	this.So(delivery, should.Resemble, messaging.Delivery{})
 }
+func (this *StreamFixture) TestWhenBrokerClosesTheChannel_ReturnEOFWithBrokerReason() {
+	this.closeNotifications <- &amqp.Error{Code: 320, Reason: "CONNECTION_FORCED - broker forced connection closure"}
+	close(this.deliveries)
+
+	var delivery messaging.Delivery
+	err := this.stream.Read(context.Background(), &delivery)
+
+	this.So(err, should.Resemble, &BrokerError{
+		Code:   320,
+		Reason: "CONNECTION_FORCED - broker forced connection closure",
+		Err:    io.EOF,
+	})
+	this.So(errors.Is(err, io.EOF), should.BeTrue)
+	this.So(delivery, should.Resemble, messaging.Delivery{})
+}
+func (this *StreamFixture) TestWhenBrokerClosesTheChannelDueToChannelLevelError_ReopenConsumerAndContinueReading() {
+	this.closeChannel()
+	this.reopened <- amqp.Delivery{DeliveryTag: 1}
+
+	var delivery messaging.Delivery
+	err := this.stream.Read(context.Background(), &delivery)
+
+	this.So(err, should.BeNil)
+	this.So(delivery.DeliveryID, should.Equal, 1<<48|1) // qualified by the generation of the recovered consumer
+	this.So(this.reopenedStreams, should.Resemble, []string{this.streamID})
+}
+func (this *StreamFixture) TestWhenRecoveringConsumerFails_ReturnEOFWithBrokerReason() {
+	this.reopenError = errors.New("")
+	this.closeChannel()
+
+	var delivery messaging.Delivery
+	err := this.stream.Read(context.Background(), &delivery)
+
+	this.So(errors.Is(err, io.EOF), should.BeTrue)
+	this.So(err.(*BrokerError).Code, should.Equal, amqp.PreconditionFailed)
+	this.So(this.reopenedStreams, should.Resemble, []string{this.streamID})
+}
+func (this *StreamFixture) TestWhenTemporaryStreamChannelIsClosed_DoNotRecover() {
+	this.temporaryStream = true
+	this.initializeStream()
+	this.closeChannel()
+
+	var delivery messaging.Delivery
+	err := this.stream.Read(context.Background(), &delivery)
+
+	this.So(errors.Is(err, io.EOF), should.BeTrue)
+	this.So(this.reopenedStreams, should.BeEmpty)
+}
 func (this *StreamFixture) TestWhenContextIsCancelled_ReturnCancellationError() {
 	dead, shutdown := context.WithCancel(context.Background())
 	shutdown()
@@ -148,6 +330,39 @@ func (this *StreamFixture) TestWhenAcknowledgingManyDeliveriesOnSharedStream_Eac
 	this.So(this.acknowledgedTags, should.Resemble, []uint64{1, 2, 3})
 	this.So(this.acknowledgedMultiples, should.Resemble, []bool{false, false, false})
 }
+func (this *StreamFixture) TestWhenAcknowledgingReplayableExclusiveStream_RecordLatestOffset() {
+	this.replayable = true
+	this.exclusiveStream = true
+	this.initializeStream()
+
+	err := this.stream.Acknowledge(context.Background(),
+		messaging.Delivery{DeliveryID: 1, Sequence: 10},
+		messaging.Delivery{DeliveryID: 2, Sequence: 11},
+	)
+
+	this.So(err, should.BeNil)
+	this.So(this.storedStreams, should.Resemble, []string{"queue"})
+	this.So(this.storedOffsets, should.Resemble, []uint64{11})
+}
+func (this *StreamFixture) TestWhenRecordingOffsetFails_ReturnUnderlyingError() {
+	this.replayable = true
+	this.exclusiveStream = true
+	this.initializeStream()
+	this.storeError = errors.New("")
+
+	err := this.stream.Acknowledge(context.Background(), messaging.Delivery{DeliveryID: 1, Sequence: 10})
+
+	this.So(err, should.Equal, this.storeError)
+	this.So(this.acknowledgedTags, should.Resemble, []uint64{1})
+}
+func (this *StreamFixture) TestWhenAcknowledgingReplayableSharedStream_DoNotRecordOffset() {
+	this.replayable = true
+	this.initializeStream()
+
+	_ = this.stream.Acknowledge(context.Background(), messaging.Delivery{DeliveryID: 1, Sequence: 10})
+
+	this.So(this.storedOffsets, should.BeEmpty)
+}
 func (this *StreamFixture) TestWhenAcknowledgingFails_ReturnUnderlyingError() {
 	this.acknowledgeError = errors.New("")
 
@@ -158,8 +373,68 @@ func (this *StreamFixture) TestWhenAcknowledgingFails_ReturnUnderlyingError() {
 	this.So(this.acknowledgedMultiples, should.Resemble, []bool{false})
 }
 
+func (this *StreamFixture) TestWhenAcknowledgingDeliveriesReceivedBeforeRecovery_SkipThem() {
+	this.closeChannel()
+	this.reopened <- amqp.Delivery{DeliveryTag: 1}
+	var delivery messaging.Delivery
+	_ = this.stream.Read(context.Background(), &delivery)
+
+	err := this.stream.Acknowledge(context.Background(), messaging.Delivery{DeliveryID: 5}, delivery)
+
+	this.So(err, should.BeNil)
+	this.So(this.acknowledgedTags, should.Resemble, []uint64{1})
+}
+func (this *StreamFixture) TestWhenAcknowledgingFailsDueToChannelLevelError_RecoverConsumer() {
+	this.acknowledgeError = errors.New("")
+	this.closeNotifications <- &amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED", Recover: true}
+
+	err := this.stream.Acknowledge(context.Background(), messaging.Delivery{DeliveryID: 1})
+
+	this.So(err, should.BeNil)
+	this.So(this.reopenedStreams, should.Resemble, []string{this.streamID})
+}
+
+func (this *StreamFixture) closeChannel() {
+	this.closeNotifications <- &amqp.Error{
+		Code:    amqp.PreconditionFailed,
+		Reason:  "PRECONDITION_FAILED - unknown delivery tag 1",
+		Server:  true,
+		Recover: true,
+	}
+	close(this.deliveries)
+}
+
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
+func (this *StreamFixture) reopen(_ context.Context, _ adapter.Channel, streamID string, _ messaging.StreamConfig) (adapter.Channel, <-chan amqp.Delivery, error) {
+	this.reopenedStreams = append(this.reopenedStreams, streamID)
+	if this.reopenError != nil {
+		return nil, nil, this.reopenError
+	}
+	return this, this.reopened, nil
+}
+
+func (this *StreamFixture) Load(context.Context, string) (uint64, bool, error) { panic("nop") }
+func (this *StreamFixture) Store(_ context.Context, stream string, offset uint64) error {
+	this.storedStreams = append(this.storedStreams, stream)
+	this.storedOffsets = append(this.storedOffsets, offset)
+	return this.storeError
+}
+
+func (this *StreamFixture) ConsumerActivated(queue string, active bool) {
+	this.activations = append(this.activations, active)
+}
+func (this *StreamFixture) ConnectionOpened(error)             {}
+func (this *StreamFixture) ConnectionClosed()                  {}
+func (this *StreamFixture) ConnectionBlocked(string)           {}
+func (this *StreamFixture) ConnectionUnblocked()               {}
+func (this *StreamFixture) DispatchPublished()                 {}
+func (this *StreamFixture) DeliveryReceived()                  {}
+func (this *StreamFixture) DeliveryExpired()                   { this.expirations++ }
+func (this *StreamFixture) DeliveryAcknowledged(uint16, error) {}
+func (this *StreamFixture) TransactionCommitted(error)         {}
+func (this *StreamFixture) TransactionRolledBack(error)        {}
+
 func (this *StreamFixture) CancelConsumer(consumerID string) error {
 	this.cancellations = append(this.cancellations, consumerID)
 	return nil
@@ -169,12 +444,29 @@ func (this *StreamFixture) Ack(deliveryTag uint64, multiple bool) error {
 	this.acknowledgedMultiples = append(this.acknowledgedMultiples, multiple)
 	return this.acknowledgeError
 }
+func (this *StreamFixture) Reject(deliveryTag uint64, requeue bool) error {
+	this.rejectedTags = append(this.rejectedTags, deliveryTag)
+	this.rejectedRequeues = append(this.rejectedRequeues, requeue)
+	return this.rejectError
+}
 
-func (this *StreamFixture) DeclareQueue(name string) error         { panic("nop") }
-func (this *StreamFixture) DeclareExchange(name string) error      { panic("nop") }
-func (this *StreamFixture) BindQueue(queue, exchange string) error { panic("nop") }
-func (this *StreamFixture) BufferCapacity(value uint16) error      { panic("nop") }
-func (this *StreamFixture) Consume(consumerID, queue string) (<-chan amqp.Delivery, error) {
+func (this *StreamFixture) DeclareQueue(name string, arguments amqp.Table) error { panic("nop") }
+func (this *StreamFixture) DeclareTemporaryQueue() (string, error)               { panic("nop") }
+func (this *StreamFixture) DeclareExchange(name, kind string, arguments amqp.Table) error {
+	panic("nop")
+}
+func (this *StreamFixture) BindQueue(queue, exchange, key string, arguments amqp.Table) error {
+	panic("nop")
+}
+func (this *StreamFixture) UnbindQueue(queue, exchange, key string, arguments amqp.Table) error {
+	panic("nop")
+}
+func (this *StreamFixture) DeleteQueue(name string, ifUnused, ifEmpty bool) error { panic("nop") }
+func (this *StreamFixture) DeleteExchange(name string) error                      { panic("nop") }
+func (this *StreamFixture) VerifyQueue(name string) error                         { panic("nop") }
+func (this *StreamFixture) VerifyExchange(name string) error                      { panic("nop") }
+func (this *StreamFixture) BufferCapacity(value uint16) error                     { panic("nop") }
+func (this *StreamFixture) Consume(consumerID, queue string, arguments amqp.Table) (<-chan amqp.Delivery, error) {
 	panic("nop")
 }
 func (this *StreamFixture) Publish(exchange, key string, envelope amqp.Publishing) error {
@@ -184,3 +476,7 @@ func (this *StreamFixture) Tx() error         { panic("nop") }
 func (this *StreamFixture) TxCommit() error   { panic("nop") }
 func (this *StreamFixture) TxRollback() error { panic("nop") }
 func (this *StreamFixture) Close() error      { panic("nop") }
+func (this *StreamFixture) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	this.closeNotifications = receiver
+	return receiver
+}
@@ -0,0 +1,128 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/streadway/amqp"
+)
+
+// Topology is a declarative description of the exchanges, queues, and bindings which should exist on the broker. It
+// allows topology to be established ahead of time (e.g. before publishers start) rather than only as a side effect of
+// opening a stream.
+type Topology struct {
+	Exchanges []Exchange `json:"exchanges"`
+	Queues    []Queue    `json:"queues"`
+	Bindings  []Binding  `json:"bindings"`
+}
+type Exchange struct {
+	Name      string                 `json:"name"`
+	Kind      string                 `json:"kind"` // fanout, direct, topic, headers, or a plugin type (defaults to fanout)
+	Arguments map[string]interface{} `json:"arguments,omitempty"`
+}
+type Queue struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments,omitempty"` // e.g. x-queue-type, x-max-priority
+}
+type Binding struct {
+	Queue      string                 `json:"queue"`
+	Exchange   string                 `json:"exchange"`
+	RoutingKey string                 `json:"routing_key,omitempty"`
+	Arguments  map[string]interface{} `json:"arguments,omitempty"`
+}
+
+func (this Binding) String() string {
+	return fmt.Sprintf("%s -> %s (%s)", this.Exchange, this.Queue, this.RoutingKey)
+}
+
+// ParseTopology reads a JSON topology description and validates it.
+func ParseTopology(reader io.Reader) (Topology, error) {
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber() // preserve integer arguments (e.g. x-max-priority), the broker rejects floating point values
+	decoder.DisallowUnknownFields()
+
+	var topology Topology
+	if err := decoder.Decode(&topology); err != nil {
+		return Topology{}, err
+	}
+
+	return topology, topology.validate()
+}
+func (this Topology) validate() error {
+	for _, exchange := range this.Exchanges {
+		if len(exchange.Name) == 0 {
+			return ErrTopologyExchangeName
+		}
+	}
+	for _, queue := range this.Queues {
+		if len(queue.Name) == 0 {
+			return ErrTopologyQueueName
+		}
+	}
+	for _, binding := range this.Bindings {
+		if len(binding.Queue) == 0 || len(binding.Exchange) == 0 {
+			return ErrTopologyBinding
+		}
+	}
+	return nil
+}
+
+func (this Exchange) kind() string {
+	if len(this.Kind) == 0 {
+		return amqp.ExchangeFanout
+	}
+	return this.Kind
+}
+func (this Topology) hasExchange(name string) bool {
+	for _, item := range this.Exchanges {
+		if item.Name == name {
+			return true
+		}
+	}
+	return false
+}
+func (this Topology) hasQueue(name string) bool {
+	for _, item := range this.Queues {
+		if item.Name == name {
+			return true
+		}
+	}
+	return false
+}
+func (this Topology) hasBinding(binding Binding) bool {
+	for _, item := range this.Bindings {
+		if item.Queue == binding.Queue && item.Exchange == binding.Exchange && item.RoutingKey == binding.RoutingKey {
+			return true
+		}
+	}
+	return false
+}
+
+func tableArguments(values map[string]interface{}) amqp.Table {
+	table := amqp.Table{}
+	for key, value := range values {
+		table[key] = tableValue(value)
+	}
+	return table
+}
+func tableValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case json.Number:
+		if integer, err := typed.Int64(); err == nil {
+			return integer
+		}
+		float, _ := typed.Float64()
+		return float
+	case map[string]interface{}:
+		return tableArguments(typed)
+	case []interface{}:
+		values := make([]interface{}, 0, len(typed))
+		for _, item := range typed {
+			values = append(values, tableValue(item))
+		}
+		return values
+	default:
+		return value
+	}
+}
@@ -0,0 +1,318 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
+	"github.com/streadway/amqp"
+)
+
+// TopologyManager declares a Topology on the broker independent of any reader or writer. Entities found in the
+// previous topology but not in the desired topology are deleted once deletion is enabled (see Options.DeleteTopology)
+// and are otherwise only reported; an empty previous topology deletes nothing.
+type TopologyManager interface {
+	// Plan reports the changes that Apply would make without making any of them. Only passive declarations are used,
+	// so existing entities whose type or arguments differ from their declaration are reported as unchanged here but
+	// as conflicts by Apply.
+	Plan(ctx context.Context, desired, previous Topology) ([]TopologyChange, error)
+	// Apply idempotently declares the desired topology and (when enabled) deletes anything which is no longer
+	// declared. Nothing is changed when any existing entity conflicts with its declaration.
+	Apply(ctx context.Context, desired, previous Topology) ([]TopologyChange, error)
+}
+
+func NewTopologyManager(options ...option) TopologyManager {
+	var config configuration
+	Options.apply(options...)(&config)
+	return newTopologyManager(newConnector(config), config)
+}
+
+type TopologyAction string
+
+const (
+	TopologyCreate    TopologyAction = "+"
+	TopologyUnchanged TopologyAction = "="
+	TopologyConflict  TopologyAction = "!"
+	TopologyDelete    TopologyAction = "-"
+)
+
+type TopologyChange struct {
+	Action TopologyAction
+	Kind   string
+	Name   string
+	Reason string
+
+	code    int
+	execute func(adapter.Channel) error
+}
+
+func (this TopologyChange) String() string {
+	if len(this.Reason) == 0 {
+		return fmt.Sprintf("%s %s [%s]", this.Action, this.Kind, this.Name)
+	}
+	return fmt.Sprintf("%s %s [%s] (%s)", this.Action, this.Kind, this.Name, this.Reason)
+}
+
+type defaultTopologyManager struct {
+	dialer connectionDialer
+	delete bool
+	force  bool
+	logger logger
+}
+
+func newTopologyManager(dialer connectionDialer, config configuration) TopologyManager {
+	return &defaultTopologyManager{
+		dialer: dialer,
+		delete: config.DeleteTopology,
+		force:  config.ForceDeletion,
+		logger: config.Logger,
+	}
+}
+
+func (this *defaultTopologyManager) Plan(ctx context.Context, desired, previous Topology) ([]TopologyChange, error) {
+	connection, err := this.dialer.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = connection.Close() }()
+
+	return this.plan(connection, desired, previous, false)
+}
+func (this *defaultTopologyManager) Apply(ctx context.Context, desired, previous Topology) ([]TopologyChange, error) {
+	connection, err := this.dialer.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = connection.Close() }()
+
+	changes, err := this.plan(connection, desired, previous, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conflicts(changes); err != nil {
+		this.logger.Printf("[WARN] Unable to apply topology [%s].", err)
+		return changes, err
+	}
+
+	channel, err := connection.Channel()
+	if err != nil {
+		return changes, err
+	}
+	defer func() { _ = channel.Close() }()
+
+	for _, change := range changes {
+		if change.execute == nil {
+			continue
+		}
+
+		if err := change.execute(channel); err != nil {
+			this.logger.Printf("[WARN] Unable to apply topology change %s [%s].", change, err)
+			return changes, err
+		}
+
+		this.logger.Printf("[INFO] Applied topology change %s.", change)
+	}
+
+	return changes, nil
+}
+
+func (this *defaultTopologyManager) plan(connection channelOpener, desired, previous Topology, redeclare bool) ([]TopologyChange, error) {
+	var changes []TopologyChange
+	appendChange := func(change TopologyChange, err error) error {
+		if err == nil {
+			changes = append(changes, change)
+		}
+		return err
+	}
+
+	for _, item := range desired.Exchanges {
+		if err := appendChange(this.planExchange(connection, item, redeclare)); err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range desired.Queues {
+		if err := appendChange(this.planQueue(connection, item, redeclare)); err != nil {
+			return nil, err
+		}
+	}
+	created := createdEntities(changes)
+	for _, item := range desired.Bindings {
+		unchanged := wasBound(previous, item) && !created["queue:"+item.Queue] && !created["exchange:"+item.Exchange]
+		changes = append(changes, planBinding(item, unchanged))
+	}
+
+	for _, item := range previous.Bindings {
+		if desired.hasBinding(item) || removed(desired, previous, item) {
+			continue
+		}
+		changes = append(changes, this.withheld(planUnbinding(item)))
+	}
+	for _, item := range previous.Queues {
+		if desired.hasQueue(item.Name) {
+			continue
+		}
+		if err := appendChange(this.planDeletion(connection, "queue", item.Name)); err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range previous.Exchanges {
+		if desired.hasExchange(item.Name) {
+			continue
+		}
+		if err := appendChange(this.planDeletion(connection, "exchange", item.Name)); err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
+func (this *defaultTopologyManager) planExchange(connection channelOpener, item Exchange, redeclare bool) (TopologyChange, error) {
+	declare := func(channel adapter.Channel) error {
+		return channel.DeclareExchange(item.Name, item.kind(), tableArguments(item.Arguments))
+	}
+	verify := func(channel adapter.Channel) error { return channel.VerifyExchange(item.Name) }
+	return this.planDeclaration(connection, "exchange", item.Name, verify, declare, redeclare)
+}
+func (this *defaultTopologyManager) planQueue(connection channelOpener, item Queue, redeclare bool) (TopologyChange, error) {
+	declare := func(channel adapter.Channel) error {
+		return channel.DeclareQueue(item.Name, tableArguments(item.Arguments))
+	}
+	verify := func(channel adapter.Channel) error { return channel.VerifyQueue(item.Name) }
+	return this.planDeclaration(connection, "queue", item.Name, verify, declare, redeclare)
+}
+func (this *defaultTopologyManager) planDeclaration(connection channelOpener, kind, name string, verify, declare func(adapter.Channel) error, redeclare bool) (TopologyChange, error) {
+	change := TopologyChange{Kind: kind, Name: name}
+
+	if code, reason, err := inspect(connection, verify); err != nil {
+		return change, err
+	} else if code == http.StatusNotFound {
+		change.Action, change.execute = TopologyCreate, declare
+		return change, nil
+	} else if code != 0 {
+		change.Action, change.Reason, change.code = TopologyConflict, reason, code
+		return change, nil
+	}
+
+	if !redeclare {
+		change.Action = TopologyUnchanged
+		return change, nil
+	}
+
+	// the entity exists; redeclaring it is a no-op unless its type or arguments differ, in which case the broker
+	// rejects the declaration (closing only the inspection channel) and nothing is changed.
+	if code, reason, err := inspect(connection, declare); err != nil {
+		return change, err
+	} else if code != 0 {
+		change.Action, change.Reason, change.code = TopologyConflict, reason, code
+		return change, nil
+	}
+
+	change.Action = TopologyUnchanged
+	return change, nil
+}
+func (this *defaultTopologyManager) planDeletion(connection channelOpener, kind, name string) (TopologyChange, error) {
+	change := TopologyChange{Action: TopologyDelete, Kind: kind, Name: name}
+
+	verify := func(channel adapter.Channel) error { return channel.VerifyExchange(name) }
+	change.execute = func(channel adapter.Channel) error { return channel.DeleteExchange(name) }
+	if kind == "queue" {
+		// unless forced, the broker refuses to delete a queue which is still consumed or holds messages
+		verify = func(channel adapter.Channel) error { return channel.VerifyQueue(name) }
+		change.execute = func(channel adapter.Channel) error { return channel.DeleteQueue(name, !this.force, !this.force) }
+	}
+
+	if code, _, err := inspect(connection, verify); err != nil {
+		return change, err
+	} else if code == http.StatusNotFound {
+		change.Action, change.execute = TopologyUnchanged, nil // already gone
+		return change, nil
+	}
+
+	return this.withheld(change), nil
+}
+
+// withheld reports the deletion without making it unless deletion is enabled.
+func (this *defaultTopologyManager) withheld(change TopologyChange) TopologyChange {
+	if !this.delete {
+		change.Reason, change.execute = "deletion not enabled", nil
+	}
+	return change
+}
+func planBinding(item Binding, unchanged bool) TopologyChange {
+	// bindings can't be inspected over AMQP, so a binding is only considered unchanged when it was previously applied
+	// and neither of its ends is (re)created; otherwise it's applied, binding is idempotent.
+	if unchanged {
+		return TopologyChange{Action: TopologyUnchanged, Kind: "binding", Name: item.String()}
+	}
+
+	return TopologyChange{Action: TopologyCreate, Kind: "binding", Name: item.String(), execute: func(channel adapter.Channel) error {
+		return channel.BindQueue(item.Queue, item.Exchange, item.RoutingKey, tableArguments(item.Arguments))
+	}}
+}
+func planUnbinding(item Binding) TopologyChange {
+	return TopologyChange{Action: TopologyDelete, Kind: "binding", Name: item.String(), execute: func(channel adapter.Channel) error {
+		return channel.UnbindQueue(item.Queue, item.Exchange, item.RoutingKey, tableArguments(item.Arguments))
+	}}
+}
+
+func createdEntities(changes []TopologyChange) map[string]bool {
+	created := map[string]bool{}
+	for _, change := range changes {
+		if change.Action == TopologyCreate {
+			created[change.Kind+":"+change.Name] = true
+		}
+	}
+	return created
+}
+
+func wasBound(previous Topology, binding Binding) bool {
+	for _, item := range previous.Bindings {
+		if item.String() == binding.String() && reflect.DeepEqual(item.Arguments, binding.Arguments) {
+			return true
+		}
+	}
+	return false
+}
+
+// removed indicates whether either end of the binding is being deleted (or is already gone), which removes the binding.
+func removed(desired, previous Topology, item Binding) bool {
+	return (previous.hasQueue(item.Queue) && !desired.hasQueue(item.Queue)) ||
+		(previous.hasExchange(item.Exchange) && !desired.hasExchange(item.Exchange))
+}
+
+func inspect(connection channelOpener, check func(adapter.Channel) error) (int, string, error) {
+	// a failed (passive) declaration closes the channel on which it was issued, so each inspection uses its own channel.
+	channel, err := connection.Channel()
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() { _ = channel.Close() }()
+
+	err = check(channel)
+	if err == nil {
+		return 0, "", nil
+	}
+
+	if brokerError, ok := err.(*amqp.Error); ok {
+		return brokerError.Code, brokerError.Reason, nil
+	}
+
+	return 0, "", err
+}
+func conflicts(changes []TopologyChange) error {
+	failure := &TopologyError{}
+	for _, change := range changes {
+		if change.Action == TopologyConflict {
+			failure.Mismatched = append(failure.Mismatched, TopologyEntity{Kind: change.Kind, Name: change.Name, Code: change.code, Reason: change.Reason})
+		}
+	}
+
+	if len(failure.Mismatched) == 0 {
+		return nil
+	}
+
+	return failure
+}
@@ -0,0 +1,321 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
+	"github.com/streadway/amqp"
+)
+
+func TestTopologyManagerFixture(t *testing.T) {
+	gunit.Run(new(TopologyManagerFixture), t)
+}
+
+type TopologyManagerFixture struct {
+	*gunit.Fixture
+
+	manager TopologyManager
+
+	dialError    error
+	channelCalls int
+	closeCalls   int
+	exchanges    map[string]bool
+	queues       map[string]bool
+	conflicts    map[string]*amqp.Error
+	executeError error
+	operations   []string
+	redeclared   []string
+
+	desired  Topology
+	previous Topology
+}
+
+func (this *TopologyManagerFixture) Setup() {
+	this.exchanges = map[string]bool{"existing-exchange": true, "conflicting-exchange": true, "old-exchange": true}
+	this.queues = map[string]bool{"existing-queue": true, "old-queue": true}
+	this.conflicts = map[string]*amqp.Error{
+		"conflicting-exchange": {Code: http.StatusNotAcceptable, Reason: "PRECONDITION_FAILED - inequivalent arg 'type'"},
+	}
+	this.manager = newTopologyManager(this, configuration{Logger: nop{}, DeleteTopology: true})
+
+	this.desired = Topology{
+		Exchanges: []Exchange{{Name: "new-exchange", Kind: "topic"}, {Name: "existing-exchange"}},
+		Queues:    []Queue{{Name: "new-queue"}, {Name: "existing-queue"}},
+		Bindings:  []Binding{{Queue: "new-queue", Exchange: "new-exchange", RoutingKey: "a.#"}},
+	}
+	this.previous = Topology{
+		Exchanges: []Exchange{{Name: "existing-exchange"}, {Name: "old-exchange"}, {Name: "gone-exchange"}},
+		Queues:    []Queue{{Name: "existing-queue"}, {Name: "old-queue"}},
+		Bindings: []Binding{
+			{Queue: "existing-queue", Exchange: "existing-exchange"},
+			{Queue: "old-queue", Exchange: "existing-exchange"},
+		},
+	}
+}
+
+func (this *TopologyManagerFixture) TestPlan_ReportChangesWithoutMakingThem() {
+	changes, err := this.manager.Plan(context.Background(), this.desired, this.previous)
+
+	this.So(err, should.BeNil)
+	this.So(describe(changes), should.Resemble, []string{
+		"+ exchange [new-exchange]",
+		"= exchange [existing-exchange]",
+		"+ queue [new-queue]",
+		"= queue [existing-queue]",
+		"+ binding [new-exchange -> new-queue (a.#)]",
+		"- binding [existing-exchange -> existing-queue ()]",
+		"- queue [old-queue]",
+		"- exchange [old-exchange]",
+		"= exchange [gone-exchange]",
+	})
+	this.So(this.operations, should.BeEmpty)
+	this.So(this.redeclared, should.BeEmpty)
+	this.So(this.closeCalls, should.Equal, this.channelCalls+1) // every channel plus the connection
+}
+func (this *TopologyManagerFixture) TestPlanWithoutPreviousTopology_NothingIsDeleted() {
+	changes, err := this.manager.Plan(context.Background(), this.desired, Topology{})
+
+	this.So(err, should.BeNil)
+	this.So(describe(changes), should.NotContain, "- queue [old-queue]")
+	this.So(len(changes), should.Equal, 5)
+}
+func (this *TopologyManagerFixture) TestPlanConflictingDeclaration_ExistingEntitiesAreOnlyVerifiedPassively() {
+	this.desired.Exchanges = append(this.desired.Exchanges, Exchange{Name: "conflicting-exchange", Kind: "direct"})
+
+	changes, err := this.manager.Plan(context.Background(), this.desired, Topology{})
+
+	this.So(err, should.BeNil)
+	this.So(describe(changes), should.Contain, "= exchange [conflicting-exchange]")
+	this.So(this.redeclared, should.BeEmpty)
+}
+func (this *TopologyManagerFixture) TestPlanPreviouslyAppliedBinding_ReportUnchanged() {
+	this.desired.Bindings = append(this.desired.Bindings, Binding{Queue: "existing-queue", Exchange: "existing-exchange"})
+
+	changes, err := this.manager.Plan(context.Background(), this.desired, this.previous)
+
+	this.So(err, should.BeNil)
+	this.So(describe(changes), should.Contain, "= binding [existing-exchange -> existing-queue ()]")
+	this.So(describe(changes), should.NotContain, "- binding [existing-exchange -> existing-queue ()]")
+}
+func (this *TopologyManagerFixture) TestPlanPreviouslyAppliedBindingWithChangedArguments_ReportAddition() {
+	binding := Binding{Queue: "existing-queue", Exchange: "existing-exchange", Arguments: map[string]interface{}{"x-match": "any"}}
+	this.desired.Bindings = append(this.desired.Bindings, binding)
+
+	changes, _ := this.manager.Plan(context.Background(), this.desired, this.previous)
+
+	this.So(describe(changes), should.Contain, "+ binding [existing-exchange -> existing-queue ()]")
+}
+func (this *TopologyManagerFixture) TestPlanPreviouslyAppliedBindingToRecreatedQueue_ReportAddition() {
+	delete(this.queues, "existing-queue")
+	this.desired.Bindings = append(this.desired.Bindings, Binding{Queue: "existing-queue", Exchange: "existing-exchange"})
+
+	changes, _ := this.manager.Plan(context.Background(), this.desired, this.previous)
+
+	this.So(describe(changes), should.Contain, "+ queue [existing-queue]")
+	this.So(describe(changes), should.Contain, "+ binding [existing-exchange -> existing-queue ()]")
+}
+
+func (this *TopologyManagerFixture) TestApply_CreateMissingEntitiesAndDeleteThoseNoLongerDeclared() {
+	_, err := this.manager.Apply(context.Background(), this.desired, this.previous)
+
+	this.So(err, should.BeNil)
+	this.So(this.operations, should.Resemble, []string{
+		"declare exchange new-exchange topic",
+		"declare queue new-queue",
+		"bind new-queue new-exchange a.#",
+		"unbind existing-queue existing-exchange ",
+		"delete queue old-queue (if unused: true, if empty: true)",
+		"delete exchange old-exchange",
+	})
+}
+func (this *TopologyManagerFixture) TestApplyWithoutDeletionEnabled_ReportDeletionsWithoutMakingThem() {
+	this.manager = newTopologyManager(this, configuration{Logger: nop{}})
+
+	changes, err := this.manager.Apply(context.Background(), this.desired, this.previous)
+
+	this.So(err, should.BeNil)
+	this.So(describe(changes), should.Contain, "- binding [existing-exchange -> existing-queue ()] (deletion not enabled)")
+	this.So(describe(changes), should.Contain, "- queue [old-queue] (deletion not enabled)")
+	this.So(describe(changes), should.Contain, "- exchange [old-exchange] (deletion not enabled)")
+	this.So(describe(changes), should.Contain, "= exchange [gone-exchange]")
+	this.So(this.operations, should.Resemble, []string{
+		"declare exchange new-exchange topic",
+		"declare queue new-queue",
+		"bind new-queue new-exchange a.#",
+	})
+}
+func (this *TopologyManagerFixture) TestApplyWithDeletionForced_DeleteQueuesEvenWhenConsumedOrHoldingMessages() {
+	this.manager = newTopologyManager(this, configuration{Logger: nop{}, DeleteTopology: true, ForceDeletion: true})
+
+	_, err := this.manager.Apply(context.Background(), this.desired, this.previous)
+
+	this.So(err, should.BeNil)
+	this.So(this.operations, should.Contain, "delete queue old-queue (if unused: false, if empty: false)")
+}
+func (this *TopologyManagerFixture) TestApplyTwice_SecondApplicationOnlyRebinds() {
+	_, _ = this.manager.Apply(context.Background(), this.desired, this.previous)
+	this.operations = nil
+
+	_, err := this.manager.Apply(context.Background(), this.desired, this.previous)
+
+	this.So(err, should.BeNil)
+	this.So(this.operations, should.Resemble, []string{
+		"bind new-queue new-exchange a.#",
+		"unbind existing-queue existing-exchange ",
+	})
+}
+func (this *TopologyManagerFixture) TestApplyWithUnchangedBinding_DoNotRebind() {
+	this.desired.Bindings = []Binding{{Queue: "existing-queue", Exchange: "existing-exchange"}}
+
+	_, err := this.manager.Apply(context.Background(), this.desired, this.previous)
+
+	this.So(err, should.BeNil)
+	this.So(this.operations, should.NotContain, "bind existing-queue existing-exchange ")
+	this.So(this.redeclared, should.Resemble, []string{"existing-exchange", "existing-queue"})
+}
+func (this *TopologyManagerFixture) TestApplyWithConflict_NothingIsChanged() {
+	this.desired.Exchanges = append(this.desired.Exchanges, Exchange{Name: "conflicting-exchange", Kind: "direct"})
+
+	_, err := this.manager.Apply(context.Background(), this.desired, this.previous)
+
+	this.So(err, should.Resemble, &TopologyError{Mismatched: []TopologyEntity{{
+		Kind:   "exchange",
+		Name:   "conflicting-exchange",
+		Code:   http.StatusNotAcceptable,
+		Reason: "PRECONDITION_FAILED - inequivalent arg 'type'",
+	}}})
+	this.So(this.operations, should.BeEmpty)
+}
+func (this *TopologyManagerFixture) TestApplyFails_StopAndReturnUnderlyingError() {
+	this.executeError = errors.New("")
+
+	_, err := this.manager.Apply(context.Background(), this.desired, this.previous)
+
+	this.So(err, should.Equal, this.executeError)
+	this.So(this.operations, should.HaveLength, 1)
+}
+func (this *TopologyManagerFixture) TestDialFails_ReturnUnderlyingError() {
+	this.dialError = errors.New("")
+
+	changes, err := this.manager.Apply(context.Background(), this.desired, this.previous)
+
+	this.So(changes, should.BeNil)
+	this.So(err, should.Equal, this.dialError)
+}
+
+func describe(changes []TopologyChange) (values []string) {
+	for _, change := range changes {
+		values = append(values, change.String())
+	}
+	return values
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *TopologyManagerFixture) dial(_ context.Context) (adapter.Connection, error) {
+	if this.dialError != nil {
+		return nil, this.dialError
+	}
+	return this, nil
+}
+func (this *TopologyManagerFixture) Channel() (adapter.Channel, error) {
+	this.channelCalls++
+	return this, nil
+}
+func (this *TopologyManagerFixture) Close() error { this.closeCalls++; return nil }
+func (this *TopologyManagerFixture) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	return receiver
+}
+func (this *TopologyManagerFixture) NotifyBlocked(receiver chan amqp.Blocking) chan amqp.Blocking {
+	return receiver
+}
+
+func (this *TopologyManagerFixture) DeclareQueue(name string, _ amqp.Table) error {
+	if this.queues[name] {
+		this.redeclared = append(this.redeclared, name)
+		return this.conflictOf(name)
+	}
+	this.queues[name] = true
+	return this.execute("declare queue " + name)
+}
+func (this *TopologyManagerFixture) DeclareExchange(name, kind string, _ amqp.Table) error {
+	if this.exchanges[name] {
+		this.redeclared = append(this.redeclared, name)
+		return this.conflictOf(name)
+	}
+	this.exchanges[name] = true
+	return this.execute("declare exchange " + name + " " + kind)
+}
+func (this *TopologyManagerFixture) BindQueue(queue, exchange, key string, _ amqp.Table) error {
+	return this.execute("bind " + queue + " " + exchange + " " + key)
+}
+func (this *TopologyManagerFixture) UnbindQueue(queue, exchange, key string, _ amqp.Table) error {
+	return this.execute("unbind " + queue + " " + exchange + " " + key)
+}
+func (this *TopologyManagerFixture) DeleteQueue(name string, ifUnused, ifEmpty bool) error {
+	delete(this.queues, name)
+	return this.execute(fmt.Sprintf("delete queue %s (if unused: %t, if empty: %t)", name, ifUnused, ifEmpty))
+}
+func (this *TopologyManagerFixture) DeleteExchange(name string) error {
+	delete(this.exchanges, name)
+	return this.execute("delete exchange " + name)
+}
+func (this *TopologyManagerFixture) VerifyQueue(name string) error {
+	return this.existence(this.queues[name])
+}
+func (this *TopologyManagerFixture) VerifyExchange(name string) error {
+	return this.existence(this.exchanges[name])
+}
+func (this *TopologyManagerFixture) existence(exists bool) error {
+	if exists {
+		return nil
+	}
+	return &amqp.Error{Code: http.StatusNotFound, Reason: "NOT_FOUND"}
+}
+func (this *TopologyManagerFixture) conflictOf(name string) error {
+	if conflict, contains := this.conflicts[name]; contains {
+		return conflict
+	}
+	return nil
+}
+func (this *TopologyManagerFixture) execute(operation string) error {
+	this.operations = append(this.operations, operation)
+	return this.executeError
+}
+
+func (this *TopologyManagerFixture) DeclareTemporaryQueue() (string, error) {
+	panic("nop")
+}
+func (this *TopologyManagerFixture) BufferCapacity(value uint16) error {
+	panic("nop")
+}
+func (this *TopologyManagerFixture) Consume(consumerID, queue string, arguments amqp.Table) (<-chan amqp.Delivery, error) {
+	panic("nop")
+}
+func (this *TopologyManagerFixture) Ack(deliveryTag uint64, multiple bool) error {
+	panic("nop")
+}
+func (this *TopologyManagerFixture) Reject(deliveryTag uint64, requeue bool) error {
+	panic("nop")
+}
+func (this *TopologyManagerFixture) CancelConsumer(consumerID string) error {
+	panic("nop")
+}
+func (this *TopologyManagerFixture) Publish(exchange, key string, envelope amqp.Publishing) error {
+	panic("nop")
+}
+func (this *TopologyManagerFixture) Tx() error {
+	panic("nop")
+}
+func (this *TopologyManagerFixture) TxCommit() error {
+	panic("nop")
+}
+func (this *TopologyManagerFixture) TxRollback() error {
+	panic("nop")
+}
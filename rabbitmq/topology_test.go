@@ -0,0 +1,59 @@
+package rabbitmq
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/streadway/amqp"
+)
+
+func TestTopologyFixture(t *testing.T) {
+	gunit.Run(new(TopologyFixture), t)
+}
+
+type TopologyFixture struct {
+	*gunit.Fixture
+}
+
+func (this *TopologyFixture) TestParseDescription() {
+	topology, err := ParseTopology(strings.NewReader(`{
+		"exchanges": [{"name": "events", "kind": "topic"}, {"name": "commands"}],
+		"queues": [{"name": "orders", "arguments": {"x-max-priority": 10, "x-message-ttl": 1.5}}],
+		"bindings": [{"queue": "orders", "exchange": "events", "routing_key": "order.#"}]
+	}`))
+
+	this.So(err, should.BeNil)
+	this.So(topology.Exchanges, should.HaveLength, 2)
+	this.So(topology.Exchanges[0].kind(), should.Equal, amqp.ExchangeTopic)
+	this.So(topology.Exchanges[1].kind(), should.Equal, amqp.ExchangeFanout)
+	this.So(topology.Bindings, should.Resemble, []Binding{{Queue: "orders", Exchange: "events", RoutingKey: "order.#"}})
+	this.So(tableArguments(topology.Queues[0].Arguments), should.Resemble, amqp.Table{
+		"x-max-priority": int64(10),
+		"x-message-ttl":  1.5,
+	})
+}
+func (this *TopologyFixture) TestNestedArgumentsAreConverted() {
+	arguments := tableArguments(map[string]interface{}{
+		"nested": map[string]interface{}{"value": "1"},
+		"list":   []interface{}{"a"},
+	})
+
+	this.So(arguments, should.Resemble, amqp.Table{"nested": amqp.Table{"value": "1"}, "list": []interface{}{"a"}})
+}
+func (this *TopologyFixture) TestUnknownFieldsAreRejected() {
+	_, err := ParseTopology(strings.NewReader(`{"exchange": []}`))
+
+	this.So(err, should.NotBeNil)
+}
+func (this *TopologyFixture) TestIncompleteEntitiesAreRejected() {
+	_, err := ParseTopology(strings.NewReader(`{"exchanges": [{"kind": "topic"}]}`))
+	this.So(err, should.Equal, ErrTopologyExchangeName)
+
+	_, err = ParseTopology(strings.NewReader(`{"queues": [{}]}`))
+	this.So(err, should.Equal, ErrTopologyQueueName)
+
+	_, err = ParseTopology(strings.NewReader(`{"bindings": [{"queue": "orders"}]}`))
+	this.So(err, should.Equal, ErrTopologyBinding)
+}
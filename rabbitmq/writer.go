@@ -2,51 +2,138 @@ package rabbitmq
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/smartystreets/messaging/v3"
-	"github.com/smartystreets/messaging/v3/rabbitmq/adapter"
 	"github.com/streadway/amqp"
 )
 
 type defaultWriter struct {
-	inner         adapter.Channel
-	topologyPanic bool
-	now           func() time.Time
-	logger        logger
-	monitor       monitor
+	inner           *pooledChannel
+	pool            channelReleaser
+	state           *connectionState
+	topologyPanic   bool
+	failWhenBlocked bool
+	expiryHeader    bool
+	now             func() time.Time
+	logger          logger
+	monitor         monitor
+
+	failed  bool // the channel returned an error and can no longer be trusted
+	pending bool // transactional writes have neither been committed nor rolled back
+	closer  sync.Once
 }
 
-func newWriter(inner adapter.Channel, config configuration) messaging.CommitWriter {
-	config.Logger.Printf("[INFO] Writer channel established on AMQP connection.")
-	return defaultWriter{
-		inner:         inner,
-		topologyPanic: config.TopologyFailurePanic,
-		now:           config.Now,
-		logger:        config.Logger,
-		monitor:       config.Monitor,
+func newWriter(inner *pooledChannel, pool channelReleaser, state *connectionState, config configuration) messaging.CommitWriter {
+	return &defaultWriter{
+		inner:           inner,
+		pool:            pool,
+		state:           state,
+		topologyPanic:   config.TopologyFailurePanic,
+		failWhenBlocked: config.FailWhenBlocked,
+		expiryHeader:    config.ExpirationHeader,
+		now:             config.Now,
+		logger:          config.Logger,
+		monitor:         config.Monitor,
 	}
 }
 
-func (this defaultWriter) Write(_ context.Context, messages ...messaging.Dispatch) (count int, err error) {
+func (this *defaultWriter) Write(_ context.Context, messages ...messaging.Dispatch) (count int, err error) {
+	if this.failWhenBlocked && this.state.Blocked() {
+		this.logger.Printf("[WARN] Unable to write dispatch, the broker has blocked the connection.")
+		return 0, ErrBlocked
+	}
+
 	now := this.now().UTC()
 
 	for _, message := range messages {
 		count++
 		converted := toAMQPDispatch(message, now)
-		partition := strconv.FormatUint(message.Partition, 10)
-		if err := this.inner.Publish(message.Topic, partition, converted); err != nil {
+		exchange, key := message.Topic, strconv.FormatUint(message.Partition, 10)
+		delay := message.DeliverAt.Sub(now)
+		delayed := !message.DeliverAt.IsZero() && delay > 0
+
+		if this.expiryHeader || delayed {
+			// a scheduled message only begins to age once it's delivered
+			converted.Headers = appendExpiresAt(converted.Headers, latest(converted.Timestamp, message.DeliverAt), message.Expiration)
+		}
+
+		if delayed {
+			if exchange, key, err = this.delay(exchange, key, delay, now); err != nil {
+				this.logger.Printf("[WARN] Unable to declare delay queue for dispatch [%s].", err)
+				this.failed = true
+				return count - 1, this.inner.reason.wrap(err)
+			}
+
+			// RabbitMQ dead-letters expired messages, so a per-message expiration would deliver the message early;
+			// the absolute expiration header is used instead.
+			converted.Expiration = ""
+		}
+
+		if err := this.inner.Publish(exchange, key, converted); err != nil {
 			this.logger.Printf("[WARN] Unable to write dispatch to underlying channel [%s].", err)
-			return count - 1, err // writes are async, only channel unavailability causes errors here
+			this.failed = true
+			return count - 1, this.inner.reason.wrap(err) // writes are async, only channel unavailability causes errors here
 		}
 
+		this.pending = this.inner.transactional
+
 		this.monitor.DispatchPublished()
 	}
 
 	return count, nil
 }
+
+// delay routes the message through a queue which holds each message for the (rounded up) delay before dead-lettering
+// it into the target exchange using the original partition as the routing key. Delay queues are shared by all
+// messages with the same target and delay and are removed by the broker once they've sat unused for a while.
+func (this *defaultWriter) delay(topic, partition string, delay time.Duration, now time.Time) (string, string, error) {
+	seconds := (delay + time.Second - 1) / time.Second
+	name := fmt.Sprintf("%s.delay.%s.%ds", topic, partition, seconds)
+	ttl := seconds * time.Second
+
+	// publishing doesn't keep a queue from expiring, so it's redeclared (extending its expiration) once the messages
+	// published since it was last declared might outlive it.
+	if lapses, found := this.inner.delayQueues[name]; found && now.Before(lapses) {
+		return "", name, nil
+	}
+
+	milliseconds := int64(ttl / time.Millisecond)
+	arguments := amqp.Table{
+		messageTTLArgument:           milliseconds,
+		deadLetterExchangeArgument:   topic,
+		deadLetterRoutingKeyArgument: partition,
+		queueExpiresArgument:         milliseconds*2 + int64(time.Minute/time.Millisecond),
+	}
+
+	if err := this.inner.DeclareQueue(name, arguments); err != nil {
+		return "", "", err
+	}
+
+	this.forgetDelayQueues(now)
+	this.inner.delayQueues[name] = now.Add(ttl)
+	return "", name, nil // the default exchange routes directly to the named queue
+}
+
+// forgetDelayQueues removes the delay queues which would have to be redeclared anyway, such that the channel only
+// remembers those (of the many combinations of target and delay) which were used recently.
+func (this *defaultWriter) forgetDelayQueues(now time.Time) {
+	for name, lapses := range this.inner.delayQueues {
+		if !now.Before(lapses) {
+			delete(this.inner.delayQueues, name)
+		}
+	}
+}
+func latest(timestamp, deliverAt time.Time) time.Time {
+	if deliverAt.After(timestamp) {
+		return deliverAt
+	}
+	return timestamp
+}
 func toAMQPDispatch(dispatch messaging.Dispatch, now time.Time) amqp.Publishing {
 	if dispatch.Timestamp.IsZero() {
 		dispatch.Timestamp = now
@@ -62,19 +149,34 @@ func toAMQPDispatch(dispatch messaging.Dispatch, now time.Time) amqp.Publishing
 		Timestamp:       dispatch.Timestamp,
 		Expiration:      computeExpiration(dispatch.Expiration),
 		DeliveryMode:    computePersistence(dispatch.Durable),
+		Priority:        dispatch.Priority,
 		Headers:         dispatch.Headers,
 		Body:            dispatch.Payload,
 	}
 }
 func computeExpiration(expiration time.Duration) string {
+	// the AMQP expiration property is a TTL in milliseconds
 	if expiration == 0 {
 		return ""
-	} else if seconds := int(expiration.Seconds()); seconds <= 0 {
+	} else if milliseconds := expiration.Milliseconds(); milliseconds <= 0 {
 		return "1"
 	} else {
-		return strconv.FormatUint(uint64(seconds), 10)
+		return strconv.FormatInt(milliseconds, 10)
 	}
 }
+func appendExpiresAt(headers amqp.Table, timestamp time.Time, expiration time.Duration) amqp.Table {
+	if expiration <= 0 {
+		return headers
+	}
+
+	copied := make(amqp.Table, len(headers)+1) // don't modify the caller's headers
+	for key, value := range headers {
+		copied[key] = value
+	}
+
+	copied[expiresAtHeader] = timestamp.Add(expiration).UnixNano() / int64(time.Millisecond)
+	return copied
+}
 func computePersistence(durable bool) uint8 {
 	if durable {
 		return amqp.Persistent
@@ -83,27 +185,31 @@ func computePersistence(durable bool) uint8 {
 	return amqp.Transient
 }
 
-func (this defaultWriter) Commit() error {
+func (this *defaultWriter) Commit() error {
 	if err := this.inner.TxCommit(); err == nil {
+		this.pending = false
 		this.monitor.TransactionCommitted(nil)
 		return nil
 	} else {
 		this.logger.Printf("[WARN] Unable to commit channel transaction [%s].", err)
+		this.failed = true
 		this.monitor.TransactionCommitted(err)
-		return this.tryPanic(err)
+		return this.inner.reason.wrap(this.tryPanic(err))
 	}
 }
-func (this defaultWriter) Rollback() error {
+func (this *defaultWriter) Rollback() error {
 	if err := this.inner.TxRollback(); err == nil {
+		this.pending = false
 		this.monitor.TransactionRolledBack(nil)
 		return nil
 	} else {
 		this.logger.Printf("[WARN] Unable to rollback channel transaction [%s].", err)
+		this.failed = true
 		this.monitor.TransactionRolledBack(err)
-		return this.tryPanic(err)
+		return this.inner.reason.wrap(this.tryPanic(err))
 	}
 }
-func (this defaultWriter) tryPanic(err error) error {
+func (this *defaultWriter) tryPanic(err error) error {
 	if !this.topologyPanic {
 		return err
 	}
@@ -115,6 +221,9 @@ func (this defaultWriter) tryPanic(err error) error {
 	return err
 }
 
-func (this defaultWriter) Close() error {
-	return this.inner.Close()
+func (this *defaultWriter) Close() (err error) {
+	this.closer.Do(func() {
+		err = this.pool.Release(this.inner, !this.failed && !this.pending)
+	})
+	return err
 }
@@ -22,6 +22,10 @@ type WriterFixture struct {
 	writer                 messaging.CommitWriter
 	now                    time.Time
 	panicOnTopologyFailure bool
+	failWhenBlocked        bool
+	expirationHeader       bool
+	transactional          bool
+	state                  *connectionState
 
 	closeError              error
 	commitError             error
@@ -32,9 +36,20 @@ type WriterFixture struct {
 	publishExchanges []string
 	publishKeys      []string
 	publishMessages  []amqp.Publishing
+
+	declareQueueNames     []string
+	declareQueueArguments []amqp.Table
+	declareQueueError     error
+
+	closeNotifications chan *amqp.Error
+
+	releaseCount   int
+	releaseHealthy bool
+	releaseError   error
 }
 
 func (this *WriterFixture) Setup() {
+	this.state = &connectionState{}
 	this.initializeWriter()
 }
 func (this *WriterFixture) initializeWriter() {
@@ -42,17 +57,62 @@ func (this *WriterFixture) initializeWriter() {
 	Options.apply(
 		Options.Now(func() time.Time { return this.now }),
 		Options.PanicOnTopologyError(this.panicOnTopologyFailure),
+		Options.FailWhenBlocked(this.failWhenBlocked),
+		Options.ExpirationHeader(this.expirationHeader),
 	)(&config)
 
-	this.writer = newWriter(this, config)
+	this.writer = newWriter(newPooledChannel(this, this.transactional), this, this.state, config)
 }
 
-func (this *WriterFixture) TestWhenCloseInvoked_UnderlyingChannelClosed() {
-	this.closeError = errors.New("")
+func (this *WriterFixture) TestWhenCloseInvoked_ReleaseHealthyChannelToPool() {
+	this.releaseError = errors.New("")
 
 	err := this.writer.Close()
 
-	this.So(err, should.Equal, this.closeError)
+	this.So(err, should.Equal, this.releaseError)
+	this.So(this.releaseCount, should.Equal, 1)
+	this.So(this.releaseHealthy, should.BeTrue)
+}
+func (this *WriterFixture) TestWhenCloseInvokedMultipleTimes_OnlyReleaseChannelOnce() {
+	_ = this.writer.Close()
+	_ = this.writer.Close()
+
+	this.So(this.releaseCount, should.Equal, 1)
+}
+func (this *WriterFixture) TestWhenCloseInvokedAfterPublishFailure_ReleaseUnhealthyChannel() {
+	this.publishError = errors.New("")
+	_, _ = this.writer.Write(context.Background(), messaging.Dispatch{})
+
+	_ = this.writer.Close()
+
+	this.So(this.releaseHealthy, should.BeFalse)
+}
+func (this *WriterFixture) TestWhenCloseInvokedAfterCommitFailure_ReleaseUnhealthyChannel() {
+	this.commitError = errors.New("")
+	_ = this.writer.Commit()
+
+	_ = this.writer.Close()
+
+	this.So(this.releaseHealthy, should.BeFalse)
+}
+func (this *WriterFixture) TestWhenClosingTransactionalWriterWithUncommittedWrites_ReleaseUnhealthyChannel() {
+	this.transactional = true
+	this.initializeWriter()
+	_, _ = this.writer.Write(context.Background(), messaging.Dispatch{})
+
+	_ = this.writer.Close()
+
+	this.So(this.releaseHealthy, should.BeFalse)
+}
+func (this *WriterFixture) TestWhenClosingTransactionalWriterAfterCommit_ReleaseHealthyChannel() {
+	this.transactional = true
+	this.initializeWriter()
+	_, _ = this.writer.Write(context.Background(), messaging.Dispatch{})
+	_ = this.writer.Commit()
+
+	_ = this.writer.Close()
+
+	this.So(this.releaseHealthy, should.BeTrue)
 }
 func (this *WriterFixture) TestWhenUnderlyingRollbackFails_ReturnError() {
 	this.rollbackError = errors.New("")
@@ -78,6 +138,15 @@ func (this *WriterFixture) TestWhenUnderlyingCommitSucceeds_ReturnNil() {
 
 	this.So(err, should.BeNil)
 }
+func (this *WriterFixture) TestWhenUnderlyingCommitFailsBecauseBrokerClosedChannel_ReturnErrorWithBrokerReason() {
+	this.commitError = amqp.ErrClosed
+	this.closeNotifications <- &amqp.Error{Code: 406, Reason: "PRECONDITION_FAILED"}
+
+	err := this.writer.Commit()
+
+	this.So(err, should.Resemble, &BrokerError{Code: 406, Reason: "PRECONDITION_FAILED", Err: amqp.ErrClosed})
+	this.So(errors.Is(err, amqp.ErrClosed), should.BeTrue)
+}
 func (this *WriterFixture) TestWhenTopologyNotEstablished_PanicOnTopologyErrors() {
 	this.panicOnTopologyFailure = true
 	this.initializeWriter()
@@ -105,6 +174,7 @@ func (this *WriterFixture) TestWhenWrite_PublishToUnderlyingChannel() {
 		Timestamp:       time.Time{},
 		Expiration:      time.Minute,
 		Durable:         true,
+		Priority:        9,
 		Topic:           "topic",
 		Partition:       5,
 		MessageType:     "message-type",
@@ -128,10 +198,10 @@ func (this *WriterFixture) TestWhenWrite_PublishToUnderlyingChannel() {
 			ContentType:     "content-type",
 			ContentEncoding: "content-encoding",
 			DeliveryMode:    amqp.Persistent,
-			Priority:        0,
+			Priority:        9,
 			CorrelationId:   "3",
 			ReplyTo:         "",
-			Expiration:      "60",
+			Expiration:      "60000",
 			MessageId:       "2",
 			Timestamp:       time.Time{},
 			Type:            "message-type",
@@ -167,9 +237,15 @@ func (this *WriterFixture) TestWhenWriteTransientMessage_PublishTransientMessage
 		},
 	})
 }
-func (this *WriterFixture) TestWhenWriteExpirationLessThanOneSecond_UseOneSecondExpiration() {
+func (this *WriterFixture) TestWhenWriteExpirationIncludesMilliseconds_PreserveMillisecondPrecision() {
+	_, err := this.writer.Write(context.Background(), messaging.Dispatch{Expiration: time.Millisecond * 1500})
+
+	this.So(err, should.BeNil)
+	this.So(this.publishMessages[0].Expiration, should.Equal, "1500")
+}
+func (this *WriterFixture) TestWhenWriteExpirationLessThanOneMillisecond_UseOneMillisecondExpiration() {
 	count, err := this.writer.Write(context.Background(), messaging.Dispatch{
-		Expiration: time.Second - 1,
+		Expiration: time.Millisecond - 1,
 	})
 
 	this.So(err, should.BeNil)
@@ -187,6 +263,119 @@ func (this *WriterFixture) TestWhenWriteExpirationLessThanOneSecond_UseOneSecond
 		},
 	})
 }
+func (this *WriterFixture) TestWhenWriteScheduledMessage_PublishThroughDelayQueueWhichDeadLettersToTarget() {
+	this.now = time.Now().UTC()
+
+	_, err := this.writer.Write(context.Background(), messaging.Dispatch{
+		Topic:      "topic",
+		Partition:  7,
+		Expiration: time.Hour,
+		DeliverAt:  this.now.Add(time.Minute + time.Millisecond),
+	})
+
+	this.So(err, should.BeNil)
+	this.So(this.declareQueueNames, should.Resemble, []string{"topic.delay.7.61s"})
+	this.So(this.declareQueueArguments, should.Resemble, []amqp.Table{{
+		"x-message-ttl":             int64(61000),
+		"x-dead-letter-exchange":    "topic",
+		"x-dead-letter-routing-key": "7",
+		"x-expires":                 int64(182000),
+	}})
+	this.So(this.publishExchanges, should.Resemble, []string{""})
+	this.So(this.publishKeys, should.Resemble, []string{"topic.delay.7.61s"})
+	this.So(this.publishMessages[0].Expiration, should.BeEmpty)
+	this.So(this.publishMessages[0].Headers, should.Resemble, amqp.Table{
+		"x-expires-at": this.now.Add(time.Hour+time.Minute+time.Millisecond).UnixNano() / int64(time.Millisecond),
+	})
+}
+func (this *WriterFixture) TestWhenDelayExceedsExpiration_MessageExpiresRelativeToDeliveryTime() {
+	this.now = time.Now().UTC()
+	deliverAt := this.now.Add(time.Hour * 2)
+
+	_, err := this.writer.Write(context.Background(), messaging.Dispatch{
+		Topic:      "topic",
+		Timestamp:  this.now,
+		Expiration: time.Hour,
+		DeliverAt:  deliverAt,
+	})
+
+	this.So(err, should.BeNil)
+	this.So(this.publishMessages[0].Headers, should.Resemble, amqp.Table{
+		"x-expires-at": deliverAt.Add(time.Hour).UnixNano() / int64(time.Millisecond),
+	})
+}
+func (this *WriterFixture) TestWhenWritingSeveralMessagesWithSameDelay_DeclareDelayQueueOnceUntilItMightExpire() {
+	this.now = time.Now().UTC()
+	dispatch := messaging.Dispatch{Topic: "topic", DeliverAt: this.now.Add(time.Second * 10)}
+
+	_, _ = this.writer.Write(context.Background(), dispatch, dispatch)
+	this.So(this.declareQueueNames, should.Resemble, []string{"topic.delay.0.10s"})
+
+	this.now = this.now.Add(time.Second * 9)
+	dispatch.DeliverAt = this.now.Add(time.Second * 10)
+	_, _ = this.writer.Write(context.Background(), dispatch)
+	this.So(this.declareQueueNames, should.HaveLength, 1)
+
+	this.now = this.now.Add(time.Second)
+	dispatch.DeliverAt = this.now.Add(time.Second * 10)
+	_, _ = this.writer.Write(context.Background(), dispatch)
+	this.So(this.declareQueueNames, should.HaveLength, 2)
+	this.So(this.publishKeys, should.Resemble, []string{"topic.delay.0.10s", "topic.delay.0.10s", "topic.delay.0.10s", "topic.delay.0.10s"})
+}
+func (this *WriterFixture) TestWhenDelayQueuesLapse_ChannelForgetsThemOnceAnotherIsDeclared() {
+	this.now = time.Now().UTC()
+	_, _ = this.writer.Write(context.Background(),
+		messaging.Dispatch{Topic: "topic", Partition: 1, DeliverAt: this.now.Add(time.Second * 10)},
+		messaging.Dispatch{Topic: "topic", Partition: 2, DeliverAt: this.now.Add(time.Second * 20)})
+
+	this.now = this.now.Add(time.Second * 15)
+	_, _ = this.writer.Write(context.Background(), messaging.Dispatch{Topic: "topic", DeliverAt: this.now.Add(time.Second)})
+
+	delayQueues := this.writer.(*defaultWriter).inner.delayQueues
+	this.So(delayQueues, should.HaveLength, 2)
+	this.So(delayQueues, should.ContainKey, "topic.delay.2.20s")
+	this.So(delayQueues, should.ContainKey, "topic.delay.0.1s")
+}
+func (this *WriterFixture) TestWhenConfiguredForExpirationHeader_AddAbsoluteExpirationWithoutModifyingCallerHeaders() {
+	this.expirationHeader = true
+	this.initializeWriter()
+	timestamp := time.Date(2020, 1, 2, 14, 0, 0, 0, time.UTC)
+	headers := map[string]interface{}{"header": "value"}
+
+	_, err := this.writer.Write(context.Background(),
+		messaging.Dispatch{Timestamp: timestamp, Expiration: time.Second, Headers: headers},
+		messaging.Dispatch{Timestamp: timestamp, Headers: headers},
+	)
+
+	this.So(err, should.BeNil)
+	this.So(this.publishMessages[0].Expiration, should.Equal, "1000")
+	this.So(this.publishMessages[0].Headers, should.Resemble, amqp.Table{
+		"header":       "value",
+		"x-expires-at": timestamp.Add(time.Second).UnixNano() / int64(time.Millisecond),
+	})
+	this.So(this.publishMessages[1].Headers, should.Resemble, amqp.Table(headers))
+	this.So(headers, should.Resemble, map[string]interface{}{"header": "value"})
+}
+func (this *WriterFixture) TestWhenWriteMessageScheduledInThePast_PublishImmediately() {
+	this.now = time.Now().UTC()
+
+	_, err := this.writer.Write(context.Background(), messaging.Dispatch{Topic: "topic", DeliverAt: this.now.Add(-time.Second)})
+
+	this.So(err, should.BeNil)
+	this.So(this.declareQueueNames, should.BeEmpty)
+	this.So(this.publishExchanges, should.Resemble, []string{"topic"})
+}
+func (this *WriterFixture) TestWhenDelayQueueDeclarationFails_ReturnErrorWithoutPublishing() {
+	this.now = time.Now().UTC()
+	this.declareQueueError = errors.New("")
+	dispatch := messaging.Dispatch{Topic: "topic", DeliverAt: this.now.Add(time.Second)}
+
+	count, err := this.writer.Write(context.Background(), dispatch, dispatch)
+
+	this.So(count, should.Equal, 0)
+	this.So(err, should.Equal, this.declareQueueError)
+	this.So(this.publishMessages, should.BeEmpty)
+}
 func (this *WriterFixture) TestWhenWriterFailsMidwayThrough_ReturnNumberOfWritesThusFarAndError() {
 	this.publishError = errors.New("")
 	this.publishCallsBeforeError = 3
@@ -197,6 +386,36 @@ func (this *WriterFixture) TestWhenWriterFailsMidwayThrough_ReturnNumberOfWrites
 	this.So(err, should.Equal, this.publishError)
 }
 
+func (this *WriterFixture) TestWhenPublishFailsBecauseBrokerClosedConnection_ReturnErrorWithBrokerReason() {
+	this.publishError = amqp.ErrClosed
+	this.closeNotifications <- &amqp.Error{Code: 320, Reason: "CONNECTION_FORCED"}
+
+	count, err := this.writer.Write(context.Background(), messaging.Dispatch{})
+
+	this.So(count, should.Equal, 0)
+	this.So(err, should.Resemble, &BrokerError{Code: 320, Reason: "CONNECTION_FORCED", Err: amqp.ErrClosed})
+}
+func (this *WriterFixture) TestWhenConnectionBlockedAndConfiguredToFailFast_ReturnErrorWithoutPublishing() {
+	this.failWhenBlocked = true
+	this.initializeWriter()
+	this.state.setBlocked(true)
+
+	count, err := this.writer.Write(context.Background(), messaging.Dispatch{})
+
+	this.So(count, should.Equal, 0)
+	this.So(err, should.Equal, ErrBlocked)
+	this.So(this.publishMessages, should.BeEmpty)
+}
+func (this *WriterFixture) TestWhenConnectionBlockedAndNotConfiguredToFailFast_Publish() {
+	this.state.setBlocked(true)
+
+	count, err := this.writer.Write(context.Background(), messaging.Dispatch{})
+
+	this.So(count, should.Equal, 1)
+	this.So(err, should.BeNil)
+	this.So(this.publishMessages, should.HaveLength, 1)
+}
+
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 func (this *WriterFixture) Close() error      { return this.closeError }
@@ -214,27 +433,60 @@ func (this *WriterFixture) Publish(exchange, key string, envelope amqp.Publishin
 	return nil
 }
 
-func (this *WriterFixture) DeclareQueue(name string) error {
+func (this *WriterFixture) DeclareQueue(name string, arguments amqp.Table) error {
+	this.declareQueueNames = append(this.declareQueueNames, name)
+	this.declareQueueArguments = append(this.declareQueueArguments, arguments)
+	return this.declareQueueError
+}
+func (this *WriterFixture) DeclareTemporaryQueue() (string, error) {
 	panic("nop")
 }
-func (this *WriterFixture) DeclareExchange(name string) error {
+func (this *WriterFixture) DeclareExchange(name, kind string, arguments amqp.Table) error {
 	panic("nop")
 }
-func (this *WriterFixture) BindQueue(queue, exchange string) error {
+func (this *WriterFixture) BindQueue(queue, exchange, key string, arguments amqp.Table) error {
+	panic("nop")
+}
+func (this *WriterFixture) UnbindQueue(queue, exchange, key string, arguments amqp.Table) error {
+	panic("nop")
+}
+func (this *WriterFixture) DeleteQueue(name string, _, _ bool) error {
+	panic("nop")
+}
+func (this *WriterFixture) DeleteExchange(name string) error {
+	panic("nop")
+}
+func (this *WriterFixture) VerifyQueue(name string) error {
+	panic("nop")
+}
+func (this *WriterFixture) VerifyExchange(name string) error {
 	panic("nop")
 }
 func (this *WriterFixture) BufferCapacity(value uint16) error {
 	panic("nop")
 }
-func (this *WriterFixture) Consume(consumerID, queue string) (<-chan amqp.Delivery, error) {
+func (this *WriterFixture) Consume(consumerID, queue string, arguments amqp.Table) (<-chan amqp.Delivery, error) {
 	panic("nop")
 }
 func (this *WriterFixture) Ack(deliveryTag uint64, multiple bool) error {
 	panic("nop")
 }
+func (this *WriterFixture) Reject(deliveryTag uint64, requeue bool) error {
+	panic("nop")
+}
 func (this *WriterFixture) CancelConsumer(consumerID string) error {
 	panic("nop")
 }
 func (this *WriterFixture) Tx() error {
 	panic("nop")
 }
+func (this *WriterFixture) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	this.closeNotifications = receiver
+	return receiver
+}
+
+func (this *WriterFixture) Release(channel *pooledChannel, healthy bool) error {
+	this.releaseCount++
+	this.releaseHealthy = healthy
+	return this.releaseError
+}
@@ -1,9 +1,41 @@
-CREATE TABLE Messages (
-	id bigint unsigned AUTO_INCREMENT NOT NULL,
-	dispatched datetime(3) NULL,
-	type varchar(256) NOT NULL,
-	payload mediumblob NOT NULL,
-	PRIMARY KEY (id)
-);
+-- The statements applied by the schema manager (see cmd/schema) to create the table from scratch, a table
+-- created using this script is recognized by its columns and adopted by the manager.
 
+-- 1: create table
+CREATE TABLE Messages (id bigint unsigned AUTO_INCREMENT NOT NULL PRIMARY KEY, dispatched datetime(3) NULL, type varchar(256) NOT NULL, payload mediumblob NOT NULL);
 CREATE UNIQUE INDEX ix_messages_dispatched ON Messages (dispatched, id);
+
+-- 2: add scheduled delivery
+ALTER TABLE Messages ADD COLUMN deliver_at datetime(3) NULL;
+
+-- 3: add priority
+ALTER TABLE Messages ADD COLUMN priority tinyint unsigned NOT NULL DEFAULT 0;
+
+-- 4: add dispatch envelope
+ALTER TABLE Messages ADD COLUMN source_id bigint NOT NULL DEFAULT 0;
+ALTER TABLE Messages ADD COLUMN correlation_id bigint NOT NULL DEFAULT 0;
+ALTER TABLE Messages ADD COLUMN created datetime(3) NULL;
+ALTER TABLE Messages ADD COLUMN expiration bigint NOT NULL DEFAULT 0;
+ALTER TABLE Messages ADD COLUMN durable tinyint unsigned NOT NULL DEFAULT 0;
+ALTER TABLE Messages ADD COLUMN topic varchar(256) NOT NULL DEFAULT '';
+ALTER TABLE Messages ADD COLUMN partition_id bigint NOT NULL DEFAULT 0;
+ALTER TABLE Messages ADD COLUMN content_type varchar(256) NOT NULL DEFAULT '';
+ALTER TABLE Messages ADD COLUMN content_encoding varchar(256) NOT NULL DEFAULT '';
+ALTER TABLE Messages ADD COLUMN headers mediumblob NULL;
+UPDATE Messages SET durable = 1, topic = type, content_type = 'application/json';
+
+-- 5: add lease
+ALTER TABLE Messages ADD COLUMN claimed_by varchar(64) NULL;
+ALTER TABLE Messages ADD COLUMN claimed_until datetime(3) NULL;
+
+-- 6: add queue
+CREATE TABLE Messages_queue (id bigint unsigned AUTO_INCREMENT NOT NULL PRIMARY KEY, queue varchar(256) NOT NULL, visible_at datetime(3) NOT NULL, delivery_count bigint NOT NULL DEFAULT 0, claimed_by varchar(64) NULL, message_id bigint NOT NULL DEFAULT 0, type varchar(256) NOT NULL, payload mediumblob NOT NULL, deliver_at datetime(3) NULL, priority tinyint unsigned NOT NULL DEFAULT 0, source_id bigint NOT NULL DEFAULT 0, correlation_id bigint NOT NULL DEFAULT 0, created datetime(3) NULL, expiration bigint NOT NULL DEFAULT 0, durable tinyint unsigned NOT NULL DEFAULT 0, topic varchar(256) NOT NULL DEFAULT '', partition_id bigint NOT NULL DEFAULT 0, content_type varchar(256) NOT NULL DEFAULT '', content_encoding varchar(256) NOT NULL DEFAULT '', headers mediumblob NULL);
+CREATE INDEX ix_messages_queue_visible ON Messages_queue (queue, visible_at, id);
+CREATE TABLE Messages_bindings (queue varchar(256) NOT NULL, topic varchar(256) NOT NULL, PRIMARY KEY (queue, topic));
+
+-- 7: add insertion time
+ALTER TABLE Messages ADD COLUMN inserted datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3);
+
+-- 8: add claim token
+ALTER TABLE Messages ADD COLUMN claim_token varchar(32) NULL;
+CREATE INDEX ix_messages_claimed ON Messages (claimed_by, claimed_until);
@@ -45,6 +45,17 @@ type ReadWriter interface {
 	Writer
 }
 
+// Preparer is implemented by a handle which prepares statements, each of which is executed (repeatedly) with the
+// arguments provided until it's closed.
+type Preparer interface {
+	PrepareContext(ctx context.Context, statement string) (Statement, error)
+}
+type Statement interface {
+	QueryContext(ctx context.Context, args ...interface{}) (QueryResult, error)
+	ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error)
+	io.Closer
+}
+
 type Transaction interface {
 	ReadWriter
 	Transactional
@@ -21,8 +21,22 @@ func (this sqlDB) BeginTx(ctx context.Context, options *sql.TxOptions) (Transact
 	}
 }
 
+func (this sqlDB) PrepareContext(ctx context.Context, statement string) (Statement, error) {
+	if stmt, err := this.DB.PrepareContext(ctx, statement); err != nil {
+		return nil, err
+	} else {
+		return sqlStmt{Stmt: stmt}, nil
+	}
+}
+
 func (this sqlDB) DBHandle() *sql.DB { return this.DB }
 
+type sqlStmt struct{ *sql.Stmt }
+
+func (this sqlStmt) QueryContext(ctx context.Context, args ...interface{}) (QueryResult, error) {
+	return this.Stmt.QueryContext(ctx, args...)
+}
+
 type sqlTx struct{ *sql.Tx }
 
 func (this sqlTx) QueryContext(ctx context.Context, statement string, args ...interface{}) (QueryResult, error) {
@@ -2,7 +2,9 @@ package sqlmq
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"time"
 
 	"github.com/smartystreets/messaging/v3"
@@ -16,21 +18,50 @@ type configuration struct {
 	DriverName    string
 	DataSource    string
 	StorageHandle adapter.Handle
+	Dialect       Dialect
+	TableName     string
+	MigrateSchema bool
 	Channel       chan messaging.Dispatch
+	InFlight      *inflightSet
 	SQLTxOptions  sql.TxOptions
 	Now           func() time.Time
 	Sleep         time.Duration
+	PageSize      int
 	Logger        logger
 	Monitor       monitor
 
-	MessageStore messageStore
-	Sender       messaging.Writer
+	RetentionPeriod  time.Duration
+	ArchiveTableName string
+	PurgeBatchSize   int
+	PurgeInterval    time.Duration
+
+	LeaseDuration time.Duration
+	InstanceID    string
+
+	SweepInterval    time.Duration
+	SweepBatchSize   int
+	SweepGracePeriod time.Duration
+
+	VisibilityTimeout time.Duration
+	PollInterval      time.Duration
+
+	GaugeInterval time.Duration
+
+	MessageStore   messageStore
+	MessageClaimer messageClaimer
+	SchemaManager  SchemaManager
+	Retention      messaging.ListenCloser
+	Gauges         messaging.ListenCloser
+	Sender         messaging.Writer
 }
 
 func New(transport messaging.Connector, options ...option) (messaging.Connector, messaging.ListenCloser) {
 	var config configuration
 	options = append(options, Options.TransportConnector(transport))
 	Options.apply(options...)(&config)
+	if config.MessageClaimer != nil && config.SweepInterval > 0 {
+		config.Logger.Printf("[WARN] The sweep interval is ignored while pending rows are leased, rows whose lease has expired are claimed instead.")
+	}
 	return newConnector(config), newDispatchProcessor(config)
 }
 
@@ -51,6 +82,63 @@ func (singleton) DataSource(driver, dataSource string) option {
 func (singleton) StorageHandle(value *sql.DB) option {
 	return func(this *configuration) { this.StorageHandle = adapter.New(value) }
 }
+func (singleton) Dialect(value Dialect) option {
+	return func(this *configuration) { this.Dialect = value }
+}
+func (singleton) TableName(value string) option {
+	if !isValidTableName(value) {
+		panic(errInvalidTableName)
+	}
+	return func(this *configuration) { this.TableName = value }
+}
+func (singleton) MigrateSchema(value bool) option {
+	return func(this *configuration) { this.MigrateSchema = value }
+}
+func (singleton) RetentionPeriod(value time.Duration) option {
+	return func(this *configuration) { this.RetentionPeriod = value }
+}
+func (singleton) ArchiveTableName(value string) option {
+	if !isValidTableName(value) {
+		panic(errInvalidTableName)
+	}
+	return func(this *configuration) { this.ArchiveTableName = value }
+}
+func (singleton) PurgeBatchSize(value int) option {
+	return func(this *configuration) { this.PurgeBatchSize = value }
+}
+func (singleton) PurgeInterval(value time.Duration) option {
+	return func(this *configuration) { this.PurgeInterval = value }
+}
+func (singleton) PageSize(value int) option {
+	if value <= 0 {
+		panic(errInvalidPageSize)
+	}
+	return func(this *configuration) { this.PageSize = value }
+}
+func (singleton) SweepInterval(value time.Duration) option {
+	return func(this *configuration) { this.SweepInterval = value }
+}
+func (singleton) SweepBatchSize(value int) option {
+	return func(this *configuration) { this.SweepBatchSize = value }
+}
+func (singleton) SweepGracePeriod(value time.Duration) option {
+	return func(this *configuration) { this.SweepGracePeriod = value }
+}
+func (singleton) VisibilityTimeout(value time.Duration) option {
+	return func(this *configuration) { this.VisibilityTimeout = value }
+}
+func (singleton) PollInterval(value time.Duration) option {
+	return func(this *configuration) { this.PollInterval = value }
+}
+func (singleton) GaugeInterval(value time.Duration) option {
+	return func(this *configuration) { this.GaugeInterval = value }
+}
+func (singleton) LeaseDuration(value time.Duration) option {
+	return func(this *configuration) { this.LeaseDuration = value }
+}
+func (singleton) InstanceID(value string) option {
+	return func(this *configuration) { this.InstanceID = value }
+}
 func (singleton) Channel(value chan messaging.Dispatch) option {
 	return func(this *configuration) { this.Channel = value }
 }
@@ -69,6 +157,9 @@ func (singleton) RetryTimeout(value time.Duration) option {
 func (singleton) MessageStore(value messageStore) option {
 	return func(this *configuration) { this.MessageStore = value }
 }
+func (singleton) SchemaManager(value SchemaManager) option {
+	return func(this *configuration) { this.SchemaManager = value }
+}
 func (singleton) MessageSender(value messaging.Writer) option {
 	return func(this *configuration) { this.Sender = value }
 }
@@ -89,11 +180,32 @@ func (singleton) apply(options ...option) option {
 			this.StorageHandle = adapter.Open(this.DriverName, this.DataSource)
 		}
 
-		if this.MessageStore == nil {
-			this.MessageStore = newMessageStore(this.StorageHandle, this.Now)
+		if this.InFlight == nil {
+			this.InFlight = newInflightSet()
+		}
+
+		if this.MessageStore == nil && this.LeaseDuration > 0 {
+			store := newLeasingStore(this.StorageHandle, *this)
+			this.MessageStore, this.MessageClaimer = store, store
+		} else if this.MessageStore == nil {
+			this.MessageStore = newMessageStore(this.StorageHandle, this.Dialect, this.TableName, this.PageSize, this.Now)
 		}
 
-		if this.Sender == nil {
+		if this.SchemaManager == nil {
+			this.SchemaManager = newSchemaManager(*this)
+		}
+
+		if this.Retention == nil && this.RetentionPeriod > 0 {
+			this.Retention = newRetentionWorker(*this)
+		}
+
+		if this.Gauges == nil && this.GaugeInterval > 0 {
+			this.Gauges = newGaugeWorker(*this)
+		}
+
+		if this.Sender == nil && this.Target == nil {
+			this.Sender = newQueueWriter(*this) // without a broker, dispatches are written to the queue in the database
+		} else if this.Sender == nil {
 			this.Sender = batch.NewWriter(this.Target)
 		}
 	}
@@ -102,21 +214,45 @@ func (singleton) defaults(options ...option) []option {
 	var defaultContext = context.Background()
 	var defaultLogger = nop{}
 	var defaultMonitor = nop{}
+	const defaultTableName = "Messages"
 	const defaultChannelBufferCapacity = 1024
 	const defaultIsolationLevel = sql.LevelReadCommitted
 	const defaultRetryTimeout = time.Second * 5
+	const defaultPageSize = 1000
+	const defaultPurgeBatchSize = 1000
+	const defaultPurgeInterval = time.Minute
+	const defaultSweepBatchSize = 1000
+	const defaultSweepGracePeriod = time.Minute
+	const defaultVisibilityTimeout = time.Second * 30
+	const defaultPollInterval = time.Millisecond * 250
 
 	return append([]option{
 		Options.Context(defaultContext),
+		Options.Dialect(MySQL),
+		Options.TableName(defaultTableName),
 		Options.ChannelBufferCapacity(defaultChannelBufferCapacity),
 		Options.IsolationLevel(defaultIsolationLevel),
 		Options.Now(time.Now),
 		Options.RetryTimeout(defaultRetryTimeout),
+		Options.PageSize(defaultPageSize),
+		Options.PurgeBatchSize(defaultPurgeBatchSize),
+		Options.PurgeInterval(defaultPurgeInterval),
+		Options.SweepBatchSize(defaultSweepBatchSize),
+		Options.SweepGracePeriod(defaultSweepGracePeriod),
+		Options.VisibilityTimeout(defaultVisibilityTimeout),
+		Options.PollInterval(defaultPollInterval),
+		Options.InstanceID(newInstanceID()),
 		Options.Logger(defaultLogger),
 		Options.Monitor(defaultMonitor),
 	}, options...)
 }
 
+func newInstanceID() string {
+	var value [8]byte
+	_, _ = rand.Read(value[:])
+	return hex.EncodeToString(value[:])
+}
+
 type nop struct{}
 
 func (nop) Printf(_ string, _ ...interface{}) {}
@@ -125,3 +261,8 @@ func (nop) MessageReceived(_ int)  {}
 func (nop) MessageStored(_ int)    {}
 func (nop) MessagePublished(_ int) {}
 func (nop) MessageConfirmed(_ int) {}
+func (nop) MessagePurged(_ int)    {}
+
+func (nop) PendingMessages(_ int, _ time.Duration) {}
+func (nop) ChannelDepth(_ int)                     {}
+func (nop) ConfirmLatency(_ time.Duration)         {}
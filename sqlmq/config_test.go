@@ -1,7 +1,10 @@
 package sqlmq
 
 import (
+	"database/sql"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/smartystreets/assertions/should"
 	"github.com/smartystreets/gunit"
@@ -13,6 +16,8 @@ func TestConfigFixture(t *testing.T) {
 
 type ConfigFixture struct {
 	*gunit.Fixture
+
+	logged []string
 }
 
 func (this *ConfigFixture) TestPanicOnInvalidDriver() {
@@ -21,3 +26,39 @@ func (this *ConfigFixture) TestPanicOnInvalidDriver() {
 		Options.apply(Options.DataSource("", ""))(&config)
 	}, should.Panic)
 }
+func (this *ConfigFixture) TestPanicOnInvalidTableName() {
+	this.So(func() { Options.TableName("Messages; DROP TABLE Messages") }, should.Panic)
+	this.So(func() { Options.TableName("") }, should.Panic)
+	this.So(func() { Options.TableName("outbox.Messages") }, should.NotPanic)
+}
+func (this *ConfigFixture) TestPanicOnInvalidPageSize() {
+	this.So(func() { Options.PageSize(0) }, should.Panic)
+	this.So(func() { Options.PageSize(1) }, should.NotPanic)
+}
+func (this *ConfigFixture) TestSchemaManagerCreatedWhetherOrNotSchemaMigrationIsRequested() {
+	config := configuration{}
+	Options.apply(Options.StorageHandle(&sql.DB{}))(&config)
+	this.So(config.SchemaManager, should.NotBeNil) // verifies the schema instead
+	this.So(config.MigrateSchema, should.BeFalse)
+
+	config = configuration{}
+	Options.apply(Options.StorageHandle(&sql.DB{}), Options.MigrateSchema(true))(&config)
+	this.So(config.SchemaManager, should.NotBeNil)
+	this.So(config.MigrateSchema, should.BeTrue)
+}
+func (this *ConfigFixture) TestWhenLeasingAndSweepingAreBothConfigured_WarnThatSweepIntervalIsIgnored() {
+	New(nil, Options.StorageHandle(&sql.DB{}), Options.Logger(this),
+		Options.LeaseDuration(time.Minute), Options.SweepInterval(time.Minute))
+	this.So(this.logged, should.HaveLength, 1)
+
+	this.logged = nil
+	New(nil, Options.StorageHandle(&sql.DB{}), Options.Logger(this), Options.LeaseDuration(time.Minute))
+	New(nil, Options.StorageHandle(&sql.DB{}), Options.Logger(this), Options.SweepInterval(time.Minute))
+	this.So(this.logged, should.BeEmpty)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *ConfigFixture) Printf(format string, args ...interface{}) {
+	this.logged = append(this.logged, fmt.Sprintf(format, args...))
+}
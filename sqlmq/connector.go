@@ -2,6 +2,7 @@ package sqlmq
 
 import (
 	"context"
+	"io"
 
 	"github.com/smartystreets/messaging/v3"
 )
@@ -12,19 +13,22 @@ func newConnector(config configuration) messaging.Connector {
 	return defaultConnector{config: config}
 }
 func (this defaultConnector) Connect(_ context.Context) (messaging.Connection, error) {
-	return defaultConnection{config: this.config}, nil
+	return &defaultConnection{config: this.config}, nil // comparable, e.g. by connection pools
 }
 func (this defaultConnector) Close() error {
+	if closer, ok := this.config.MessageStore.(io.Closer); ok {
+		_ = closer.Close() // releases any prepared statements before the handle they were prepared on
+	}
 	return this.config.StorageHandle.Close()
 }
 
 type defaultConnection struct{ config configuration }
 
 func (this defaultConnection) Reader(_ context.Context) (messaging.Reader, error) {
-	panic("not supported")
+	return newQueueReader(this.config), nil
 }
 func (this defaultConnection) Writer(_ context.Context) (messaging.Writer, error) {
-	panic("not supported")
+	return newQueueWriter(this.config), nil
 }
 func (this defaultConnection) CommitWriter(ctx context.Context) (messaging.CommitWriter, error) {
 	tx, err := this.config.StorageHandle.BeginTx(ctx, &this.config.SQLTxOptions)
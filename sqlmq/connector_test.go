@@ -19,6 +19,7 @@ func TestConnectorFixture(t *testing.T) {
 type ConnectorFixture struct {
 	*gunit.Fixture
 
+	config    configuration
 	connector messaging.Connector
 
 	ctx        context.Context
@@ -26,6 +27,7 @@ type ConnectorFixture struct {
 	sqlTx      *sql.Tx
 
 	closeError   error
+	closed       []string
 	beginContext context.Context
 	beginOptions sql.TxOptions
 	beginError   error
@@ -42,6 +44,7 @@ func (this *ConnectorFixture) Setup() {
 	this.sqlOptions = config.SQLTxOptions
 	this.sqlTx = &sql.Tx{}
 	config.StorageHandle = this
+	this.config = config
 	this.connector = newConnector(config)
 }
 
@@ -52,6 +55,15 @@ func (this *ConnectorFixture) TestWhenClosing_ItShouldCloseUnderlyingHandle() {
 
 	this.So(err, should.Equal, this.closeError)
 }
+func (this *ConnectorFixture) TestWhenClosing_ItShouldCloseMessageStoreBeforeUnderlyingHandle() {
+	this.config.MessageStore = &closingStore{messageStore: this.config.MessageStore, fixture: this}
+	this.connector = newConnector(this.config)
+
+	err := this.connector.Close()
+
+	this.So(err, should.BeNil)
+	this.So(this.closed, should.Resemble, []string{"store", "handle"})
+}
 func (this *ConnectorFixture) TestWhenClosingCreatedConnection_Nop() {
 	this.closeError = errors.New("")
 
@@ -61,19 +73,21 @@ func (this *ConnectorFixture) TestWhenClosingCreatedConnection_Nop() {
 	this.So(err, should.BeNil)
 }
 
-func (this *ConnectorFixture) TestWhenOpeningAReader_ItShouldPanic() {
-	connection, err := this.connector.Connect(this.ctx)
+func (this *ConnectorFixture) TestWhenOpeningAReader_ItShouldReturnQueueReader() {
+	connection, _ := this.connector.Connect(this.ctx)
+
+	reader, err := connection.Reader(this.ctx)
 
-	this.So(connection, should.NotBeNil)
+	this.So(reader, should.HaveSameTypeAs, queueReader{})
 	this.So(err, should.BeNil)
-	this.So(func() { _, _ = connection.Reader(this.ctx) }, should.Panic)
 }
-func (this *ConnectorFixture) TestWhenOpeningARegularWriter_ItShouldPanic() {
-	connection, err := this.connector.Connect(this.ctx)
+func (this *ConnectorFixture) TestWhenOpeningARegularWriter_ItShouldReturnQueueWriter() {
+	connection, _ := this.connector.Connect(this.ctx)
+
+	writer, err := connection.Writer(this.ctx)
 
-	this.So(connection, should.NotBeNil)
+	this.So(writer, should.HaveSameTypeAs, queueWriter{})
 	this.So(err, should.BeNil)
-	this.So(func() { _, _ = connection.Writer(this.ctx) }, should.Panic)
 }
 
 func (this *ConnectorFixture) TestWhenOpeningCommitWriterAndNewTxFails_ItShouldReturnError() {
@@ -106,7 +120,10 @@ func (this *ConnectorFixture) TestWhenOpeningCommitWriterWithTxStateOnCallingCon
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func (this *ConnectorFixture) Close() error { return this.closeError }
+func (this *ConnectorFixture) Close() error {
+	this.closed = append(this.closed, "handle")
+	return this.closeError
+}
 
 func (this *ConnectorFixture) QueryContext(ctx context.Context, statement string, args ...interface{}) (adapter.QueryResult, error) {
 	panic("nop")
@@ -139,3 +156,13 @@ type transactionalContextFake struct {
 }
 
 func (this *transactionalContextFake) Store(tx *sql.Tx) { this.tx = tx }
+
+type closingStore struct {
+	messageStore
+	fixture *ConnectorFixture
+}
+
+func (this *closingStore) Close() error {
+	this.fixture.closed = append(this.fixture.closed, "store")
+	return nil
+}
@@ -3,22 +3,32 @@ package sqlmq
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/smartystreets/messaging/v3"
 	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
 )
 
 type messageStore interface {
-	Store(ctx context.Context, writer adapter.Writer, dispatches []messaging.Dispatch) error
-	Load(ctx context.Context, id uint64) ([]messaging.Dispatch, error)
+	Store(ctx context.Context, writer adapter.ReadWriter, dispatches []messaging.Dispatch) error
+	Load(ctx context.Context, id uint64, due time.Time) ([]messaging.Dispatch, error)
+	LoadDue(ctx context.Context, id uint64, after, due time.Time) ([]messaging.Dispatch, error)
+	Sweep(ctx context.Context, id uint64, before time.Time, limit int) ([]messaging.Dispatch, error)
 	Confirm(ctx context.Context, dispatches []messaging.Dispatch) error
 }
 
+type messageClaimer interface {
+	Claim(ctx context.Context) ([]messaging.Dispatch, error)
+	Renew(ctx context.Context) error
+}
+
 type transactionalContext interface {
 	context.Context
 	Store(tx *sql.Tx) // used by transactional handler
 }
 
+// monitor receives events as messages pass through the outbox. A monitor may also implement any of the optional
+// interfaces below to observe additional events.
 type monitor interface {
 	MessageReceived(count int)
 	MessageStored(count int)
@@ -26,6 +36,18 @@ type monitor interface {
 	MessageConfirmed(count int)
 }
 
+// purgeMonitor is optionally implemented by a monitor to observe rows removed by the retention worker.
+type purgeMonitor interface {
+	MessagePurged(count int)
+}
+
+// lagMonitor is optionally implemented by a monitor to observe how far the outbox has fallen behind.
+type lagMonitor interface {
+	PendingMessages(count int, oldest time.Duration) // rows due but not dispatched and how long the oldest has been due
+	ChannelDepth(count int)                          // dispatches waiting in the channel to be written to the sender
+	ConfirmLatency(latency time.Duration)            // from writing to the sender until confirmed in durable storage
+}
+
 type logger interface {
 	Printf(format string, args ...interface{})
 }
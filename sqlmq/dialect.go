@@ -0,0 +1,125 @@
+package sqlmq
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect adapts the statements issued against the Messages table to the SQL syntax and driver behavior of a
+// particular database.
+type Dialect interface {
+	// Placeholder returns the marker of the statement parameter at the (one-based) position provided.
+	Placeholder(position int) string
+
+	// Timestamp converts the time into the value written to a datetime column.
+	Timestamp(value time.Time) interface{}
+
+	// Returning is the clause appended to an INSERT statement such that it yields the identity of each inserted row
+	// (in order). When empty, the identities are derived from the LastInsertId of the result instead.
+	Returning() string
+
+	// FirstInsertID derives the identity of the first of the rows inserted by a single multi-row statement from the
+	// value reported by LastInsertId.
+	FirstInsertID(lastInsertID, rows int64) int64
+
+	// Column returns the SQL type (including any constraints inherent to the kind) of a column created or added by a
+	// schema migration.
+	Column(kind ColumnType) string
+}
+
+// ColumnType identifies the kinds of columns whose declaration differs between databases.
+type ColumnType int
+
+const (
+	IdentityColumn  ColumnType = iota // the auto-incrementing primary key
+	TimestampColumn                   // a point in time with (at least) millisecond precision
+	BinaryColumn                      // a payload of arbitrary bytes
+	SmallIntColumn                    // a small, non-negative integer
+	IntegerColumn                     // a signed, 64-bit integer
+	InsertedColumn                    // a point in time assigned by the database as each row is inserted
+)
+
+// insertionTrigger is implemented by a dialect whose database can't add a column defaulting to the current time to an
+// existing table, the trigger returned assigns the time as each row is inserted instead.
+type insertionTrigger interface {
+	triggerStatement(table string) string
+}
+
+// databaseClock is implemented by a dialect whose statements can refer to the current (UTC) time of the database, the
+// expression returned is that time offset by the duration provided. Leases are written and compared against it such
+// that the clocks of processors sharing a table needn't agree.
+type databaseClock interface {
+	currentTime(offset time.Duration) string
+}
+
+var (
+	// MySQL uses "?" placeholders and reports the identity of the first row inserted by a multi-row statement.
+	MySQL Dialect = mysqlDialect{}
+
+	// PostgreSQL uses numbered ("$1") placeholders and retrieves identities using INSERT ... RETURNING id.
+	PostgreSQL Dialect = postgresDialect{}
+
+	// SQLite uses "?" placeholders, stores times as (sortable) UTC text, and reports the identity of the last row
+	// inserted by a multi-row statement.
+	SQLite Dialect = sqliteDialect{}
+)
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Placeholder(int) string                    { return "?" }
+func (mysqlDialect) Timestamp(value time.Time) interface{}     { return value.UTC() }
+func (mysqlDialect) Returning() string                         { return "" }
+func (mysqlDialect) FirstInsertID(lastInsertID, _ int64) int64 { return lastInsertID }
+func (mysqlDialect) currentTime(offset time.Duration) string {
+	return fmt.Sprintf("TIMESTAMPADD(MICROSECOND, %d, UTC_TIMESTAMP(3))", offset.Microseconds())
+}
+func (mysqlDialect) Column(kind ColumnType) string {
+	return [...]string{"bigint unsigned AUTO_INCREMENT NOT NULL PRIMARY KEY", "datetime(3)", "mediumblob", "tinyint unsigned", "bigint",
+		"datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3)"}[kind]
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Placeholder(position int) string           { return "$" + strconv.Itoa(position) }
+func (postgresDialect) Timestamp(value time.Time) interface{}     { return value.UTC() }
+func (postgresDialect) Returning() string                         { return "RETURNING id" }
+func (postgresDialect) FirstInsertID(lastInsertID, _ int64) int64 { return lastInsertID }
+func (postgresDialect) currentTime(offset time.Duration) string {
+	return fmt.Sprintf("((now() AT TIME ZONE 'utc') + interval '%d microseconds')", offset.Microseconds())
+}
+func (postgresDialect) Column(kind ColumnType) string {
+	return [...]string{"bigserial NOT NULL PRIMARY KEY", "timestamp(3)", "bytea", "smallint", "bigint",
+		"timestamp(3) NOT NULL DEFAULT (now() AT TIME ZONE 'utc')"}[kind] // the columns hold UTC without a time zone
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Placeholder(int) string { return "?" }
+func (sqliteDialect) Timestamp(value time.Time) interface{} {
+	// drivers differ in how (and whether) they convert time values, text is stored and compared consistently
+	return value.UTC().Format(storageTimeLayout)
+}
+func (sqliteDialect) Returning() string { return "" }
+func (sqliteDialect) FirstInsertID(lastInsertID, rows int64) int64 {
+	return lastInsertID - rows + 1 // writes are serialized, so the rows of a single statement are numbered consecutively
+}
+func (sqliteDialect) currentTime(offset time.Duration) string {
+	return fmt.Sprintf("strftime('%%Y-%%m-%%d %%H:%%M:%%f', 'now', '%+.3f seconds')", offset.Seconds())
+}
+func (sqliteDialect) Column(kind ColumnType) string {
+	return [...]string{"integer NOT NULL PRIMARY KEY AUTOINCREMENT", "datetime", "blob", "tinyint", "bigint",
+		"datetime NULL"}[kind]
+}
+func (sqliteDialect) triggerStatement(table string) string {
+	// triggers are created in the schema of their table which must then be referred to by its unqualified name
+	schema, name := "", table
+	if index := strings.LastIndex(table, "."); index >= 0 {
+		schema, name = table[:index+1], table[index+1:]
+	}
+
+	return fmt.Sprintf("CREATE TRIGGER %str_%s_inserted AFTER INSERT ON %s FOR EACH ROW WHEN NEW.inserted IS NULL "+
+		"BEGIN UPDATE %s SET inserted = strftime('%%Y-%%m-%%d %%H:%%M:%%f', 'now') WHERE id = NEW.id; END;",
+		schema, strings.ToLower(name), name, name)
+}
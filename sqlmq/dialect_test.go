@@ -0,0 +1,52 @@
+package sqlmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+)
+
+func TestDialectFixture(t *testing.T) {
+	gunit.Run(new(DialectFixture), t)
+}
+
+type DialectFixture struct {
+	*gunit.Fixture
+}
+
+func (this *DialectFixture) TestMySQL() {
+	local := time.Date(2020, 1, 2, 15, 0, 0, 0, time.FixedZone("", 3600))
+
+	this.So(MySQL.Placeholder(3), should.Equal, "?")
+	this.So(MySQL.Timestamp(local), should.Equal, local.UTC())
+	this.So(MySQL.Returning(), should.BeEmpty)
+	this.So(MySQL.FirstInsertID(42, 3), should.Equal, 42)
+	this.So(MySQL.Column(IdentityColumn), should.Equal, "bigint unsigned AUTO_INCREMENT NOT NULL PRIMARY KEY")
+	this.So(MySQL.Column(TimestampColumn), should.Equal, "datetime(3)")
+	this.So(MySQL.(databaseClock).currentTime(time.Minute), should.Equal, "TIMESTAMPADD(MICROSECOND, 60000000, UTC_TIMESTAMP(3))")
+}
+func (this *DialectFixture) TestPostgreSQL() {
+	local := time.Date(2020, 1, 2, 15, 0, 0, 0, time.FixedZone("", 3600))
+
+	this.So(PostgreSQL.Placeholder(3), should.Equal, "$3")
+	this.So(PostgreSQL.Timestamp(local), should.Equal, local.UTC())
+	this.So(PostgreSQL.Returning(), should.Equal, "RETURNING id")
+	this.So(PostgreSQL.Column(IdentityColumn), should.Equal, "bigserial NOT NULL PRIMARY KEY")
+	this.So(PostgreSQL.Column(BinaryColumn), should.Equal, "bytea")
+	this.So(PostgreSQL.(databaseClock).currentTime(time.Minute), should.Equal,
+		"((now() AT TIME ZONE 'utc') + interval '60000000 microseconds')")
+}
+func (this *DialectFixture) TestSQLite() {
+	local := time.Date(2020, 1, 2, 15, 0, 0, 123000000, time.FixedZone("", 3600))
+
+	this.So(SQLite.Placeholder(3), should.Equal, "?")
+	this.So(SQLite.Timestamp(local), should.Equal, "2020-01-02 14:00:00.123")
+	this.So(SQLite.Returning(), should.BeEmpty)
+	this.So(SQLite.FirstInsertID(44, 3), should.Equal, 42)
+	this.So(SQLite.Column(IdentityColumn), should.Equal, "integer NOT NULL PRIMARY KEY AUTOINCREMENT")
+	this.So(SQLite.Column(SmallIntColumn), should.Equal, "tinyint")
+	this.So(SQLite.(databaseClock).currentTime(-time.Millisecond*1500), should.Equal,
+		"strftime('%Y-%m-%d %H:%M:%f', 'now', '-1.500 seconds')")
+}
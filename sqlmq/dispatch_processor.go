@@ -12,15 +12,31 @@ type dispatchProcessor struct {
 	ctx       context.Context
 	shutdown  context.CancelFunc
 	channel   chan messaging.Dispatch
+	sending   *inflightSet
 	retryWait time.Duration
+	pageSize  int
 	store     messageStore
+	claimer   messageClaimer
+	lease     time.Duration
+	sweep     time.Duration
+	sweepSize int
+	grace     time.Duration
+	poll      time.Duration
+	schema    SchemaManager
+	migrate   bool
+	retention messaging.ListenCloser
+	gauges    messaging.ListenCloser
 	sender    messaging.Writer
+	now       func() time.Time
 	logger    logger
 	monitor   monitor
+	lag       lagMonitor
 
-	buffer   []messaging.Dispatch
-	latestID uint64
-	sent     bool
+	buffer    []messaging.Dispatch
+	latestID  uint64
+	due       time.Time // scheduled rows due up until this time have been loaded
+	sent      bool
+	published time.Time
 }
 
 func newDispatchProcessor(config configuration) messaging.ListenCloser {
@@ -29,11 +45,25 @@ func newDispatchProcessor(config configuration) messaging.ListenCloser {
 		ctx:       ctx,
 		shutdown:  shutdown,
 		channel:   config.Channel,
+		sending:   config.InFlight,
 		retryWait: config.Sleep,
+		pageSize:  config.PageSize,
 		store:     config.MessageStore,
+		claimer:   config.MessageClaimer,
+		lease:     config.LeaseDuration,
+		sweep:     config.SweepInterval,
+		sweepSize: config.SweepBatchSize,
+		grace:     config.SweepGracePeriod,
+		poll:      config.PollInterval,
+		schema:    config.SchemaManager,
+		migrate:   config.MigrateSchema,
+		retention: config.Retention,
+		gauges:    config.Gauges,
 		sender:    config.Sender,
+		now:       config.Now,
 		logger:    config.Logger,
 		monitor:   config.Monitor,
+		lag:       newLagMonitor(config.Monitor),
 	}
 }
 
@@ -46,12 +76,84 @@ func (this *dispatchProcessor) Listen() {
 	waiter.Add(2)
 	go this.listenInitialize(&waiter)
 	go this.listenProcess(&waiter)
+
+	if this.retention != nil {
+		waiter.Add(1)
+		go this.listenRetention(&waiter)
+	}
+
+	if this.gauges != nil {
+		waiter.Add(1)
+		go this.listenGauges(&waiter)
+	}
 }
 func (this *dispatchProcessor) listenInitialize(waiter *sync.WaitGroup) {
 	defer waiter.Done()
+	for this.isAlive() && !this.migrateSchema() {
+		this.sleep()
+	}
+
+	if this.claimer != nil {
+		waiter.Add(1)
+		go this.listenRenew(waiter)
+		this.listenClaim()
+		return
+	}
+
+	this.due = this.now()
 	for this.isAlive() && !this.readPending() {
 		this.sleep()
 	}
+
+	if this.sweep > 0 {
+		waiter.Add(1)
+		go this.listenSweep(waiter)
+	}
+
+	this.listenScheduled()
+}
+func (this *dispatchProcessor) listenSweep(waiter *sync.WaitGroup) {
+	defer waiter.Done()
+	for this.isAlive() {
+		this.wait(this.sweep)
+		this.sweepPending()
+	}
+}
+func (this *dispatchProcessor) listenScheduled() {
+	for this.isAlive() {
+		this.wait(this.poll)
+		this.readScheduled()
+	}
+}
+func (this *dispatchProcessor) listenClaim() {
+	for this.isAlive() {
+		if this.claimPending() {
+			this.wait(this.lease / 3)
+		} else {
+			this.sleep()
+		}
+	}
+}
+
+// listenRenew extends the lease of the rows held by this processor well before it expires, independent of claiming
+// such that it's renewed even while claimed rows are waiting for the channel to be drained.
+func (this *dispatchProcessor) listenRenew(waiter *sync.WaitGroup) {
+	defer waiter.Done()
+	for this.isAlive() {
+		if this.renewLease() {
+			this.wait(this.lease / 3)
+		} else {
+			this.sleep()
+		}
+	}
+}
+func (this *dispatchProcessor) renewLease() bool {
+	if err := this.claimer.Renew(this.ctx); err != nil {
+		this.logger.Printf("[WARN] Unable to renew the lease of messages in durable storage [%s].", err)
+		return false
+	}
+
+	return true
 }
 func (this *dispatchProcessor) listenProcess(waiter *sync.WaitGroup) {
 	defer waiter.Done()
@@ -60,19 +162,151 @@ func (this *dispatchProcessor) listenProcess(waiter *sync.WaitGroup) {
 	}
 }
 
+func (this *dispatchProcessor) listenRetention(waiter *sync.WaitGroup) {
+	defer waiter.Done()
+	this.retention.Listen()
+}
+func (this *dispatchProcessor) listenGauges(waiter *sync.WaitGroup) {
+	defer waiter.Done()
+	this.gauges.Listen()
+}
+
+func (this *dispatchProcessor) migrateSchema() bool {
+	if this.schema == nil {
+		return true
+	} else if !this.migrate {
+		return this.verifySchema()
+	}
+
+	if _, err := this.schema.Migrate(this.ctx); err != nil {
+		this.logger.Printf("[WARN] Unable to migrate the schema of durable storage [%s].", err)
+		return false
+	}
+
+	return true
+}
+
+// verifySchema holds off processing until the schema has been migrated (elsewhere) rather than failing to read or write
+// the columns introduced by the pending migrations.
+func (this *dispatchProcessor) verifySchema() bool {
+	pending, err := this.schema.Plan(this.ctx)
+	if err != nil {
+		this.logger.Printf("[WARN] Unable to verify the schema of durable storage [%s].", err)
+		return false
+	} else if len(pending) == 0 {
+		return true
+	}
+
+	this.logger.Printf("[WARN] Unable to process messages, schema version [%d] < required [%d], the schema of durable "+
+		"storage must be migrated (e.g. using the MigrateSchema option).", pending[0].Version-1, pending[len(pending)-1].Version)
+	return false
+}
 func (this *dispatchProcessor) readPending() bool {
-	dispatches, err := this.store.Load(this.ctx, this.latestID)
+	for {
+		dispatches, err := this.store.Load(this.ctx, this.latestID, this.due)
+		if err != nil {
+			this.logger.Printf("[WARN] Unable to load persisted messages from durable storage [%s].", err)
+			return false
+		}
+
+		// the next page is loaded only once this one fits into the channel, bounding what's held in memory
+		for _, dispatch := range dispatches {
+			if !this.enqueue(dispatch) {
+				return false
+			}
+			this.latestID = dispatch.MessageID
+		}
+
+		if len(dispatches) < this.pageSize {
+			return true
+		}
+	}
+}
+
+// readScheduled publishes the rows whose delivery time has arrived since they were last read. Should reading fail, the
+// same rows are read again the next time.
+func (this *dispatchProcessor) readScheduled() bool {
+	due := this.now()
+
+	for latestID := uint64(0); ; {
+		dispatches, err := this.store.LoadDue(this.ctx, latestID, this.due, due)
+		if err != nil {
+			this.logger.Printf("[WARN] Unable to load scheduled messages from durable storage [%s].", err)
+			return false
+		}
+
+		for _, dispatch := range dispatches {
+			latestID = dispatch.MessageID
+			if !this.enqueue(dispatch) {
+				return false
+			}
+		}
 
+		if len(dispatches) < this.pageSize {
+			break
+		}
+	}
+
+	this.due = due
+	return true
+}
+
+// sweepPending publishes rows which weren't committed through this process (or whose confirmation failed). Only rows
+// inserted before the grace period are swept such that those committed moments ago are first sent by the process which
+// committed them, rows still on their way to the sender aren't sent again.
+func (this *dispatchProcessor) sweepPending() bool {
+	before := this.now().Add(-this.grace)
+
+	for latestID := uint64(0); ; {
+		dispatches, err := this.store.Sweep(this.ctx, latestID, before, this.sweepSize)
+		if err != nil {
+			this.logger.Printf("[WARN] Unable to sweep persisted messages from durable storage [%s].", err)
+			return false
+		}
+
+		for _, dispatch := range dispatches {
+			latestID = dispatch.MessageID
+			if !this.enqueue(dispatch) {
+				return false
+			}
+		}
+
+		if len(dispatches) < this.sweepSize {
+			return true
+		}
+	}
+}
+
+func (this *dispatchProcessor) claimPending() bool {
+	dispatches, err := this.claimer.Claim(this.ctx)
 	for _, dispatch := range dispatches {
-		this.latestID = dispatch.MessageID
-		this.channel <- dispatch
+		if !this.enqueue(dispatch) {
+			return false
+		}
 	}
 
 	if err != nil {
-		this.logger.Printf("[WARN] Unable to load persisted messages from durable storage [%s].", err)
+		this.logger.Printf("[WARN] Unable to claim persisted messages from durable storage [%s].", err)
+		return false
+	}
+
+	return true
+}
+
+// enqueue sends the dispatch to the channel unless it's already on its way to the sender, e.g. when it's swept again
+// before being confirmed.
+func (this *dispatchProcessor) enqueue(dispatch messaging.Dispatch) bool {
+	if !this.sending.Add(dispatch.MessageID) {
+		return true
 	}
 
-	return err == nil
+	select {
+	case this.channel <- dispatch:
+		return true
+	case <-this.ctx.Done():
+		this.sending.Remove(dispatch)
+		return false
+	}
 }
 
 func (this *dispatchProcessor) write() bool {
@@ -91,6 +325,8 @@ func (this *dispatchProcessor) write() bool {
 		}
 
 		this.monitor.MessageConfirmed(len(this.buffer))
+		this.lag.ConfirmLatency(this.now().Sub(this.published))
+		this.sending.Remove(this.buffer...)
 		this.clearBuffer()
 	}
 }
@@ -99,18 +335,30 @@ func (this *dispatchProcessor) fillEmptyBuffer() bool {
 		return true // buffer hasn't yet been flushed, it's not empty and needs to be handled
 	}
 
-	select {
-	case dispatch := <-this.channel:
-		this.buffer = append(this.buffer, dispatch)
+	for len(this.buffer) == 0 {
+		select {
+		case dispatch := <-this.channel:
+			this.schedule(dispatch)
 
-		length := len(this.channel)
-		for i := 0; i < length; i++ {
-			this.buffer = append(this.buffer, <-this.channel)
+			length := len(this.channel)
+			for i := 0; i < length; i++ {
+				this.schedule(<-this.channel)
+			}
+		case <-this.ctx.Done():
+			return false
 		}
+	}
 
-		return true
-	case <-this.ctx.Done():
-		return false
+	return true
+}
+
+// schedule buffers the dispatch unless it isn't yet due, in which case it remains in durable storage from which it's
+// read once it's due.
+func (this *dispatchProcessor) schedule(dispatch messaging.Dispatch) {
+	if dispatch.DeliverAt.After(this.now()) {
+		this.sending.Remove(dispatch)
+	} else {
+		this.buffer = append(this.buffer, dispatch)
 	}
 }
 func (this *dispatchProcessor) writeBufferToSender() bool {
@@ -123,6 +371,7 @@ func (this *dispatchProcessor) writeBufferToSender() bool {
 	}
 
 	this.monitor.MessagePublished(len(this.buffer))
+	this.published = this.now()
 	this.sent = true
 	return true
 }
@@ -144,6 +393,15 @@ func (this *dispatchProcessor) isAlive() bool {
 		return true
 	}
 }
+func (this *dispatchProcessor) wait(duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-this.ctx.Done():
+	}
+}
 func (this *dispatchProcessor) sleep() {
 	ctx, _ := context.WithTimeout(this.ctx, this.retryWait)
 	<-ctx.Done()
@@ -158,5 +416,47 @@ func (this *dispatchProcessor) cleanup() {
 
 func (this *dispatchProcessor) Close() error {
 	this.shutdown()
+	if this.retention != nil {
+		_ = this.retention.Close()
+	}
+	if this.gauges != nil {
+		_ = this.gauges.Close()
+	}
 	return nil
 }
+
+// inflightSet holds the identities of the rows on their way to the sender, from being sent to the channel until they're
+// confirmed, such that rows swept or loaded again in the meantime aren't sent twice.
+type inflightSet struct {
+	mutex sync.Mutex
+	ids   map[uint64]struct{}
+}
+
+func newInflightSet() *inflightSet {
+	return &inflightSet{ids: make(map[uint64]struct{})}
+}
+
+// Add reports whether the row wasn't already on its way to the sender, in which case it now is.
+func (this *inflightSet) Add(id uint64) bool {
+	if id == 0 {
+		return true // not persisted
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if _, found := this.ids[id]; found {
+		return false
+	}
+
+	this.ids[id] = struct{}{}
+	return true
+}
+func (this *inflightSet) Remove(dispatches ...messaging.Dispatch) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for _, dispatch := range dispatches {
+		delete(this.ids, dispatch.MessageID)
+	}
+}
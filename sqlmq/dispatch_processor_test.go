@@ -4,6 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"math"
+	"sync"
 	"testing"
 	"time"
 
@@ -19,10 +22,13 @@ func TestDispatchProcessorFixture(t *testing.T) {
 
 type DispatchProcessorFixture struct {
 	*gunit.Fixture
+	nopMonitor
 
 	ctx          context.Context
 	channel      chan messaging.Dispatch
 	sleepTimeout time.Duration
+	now          time.Time
+	clock        sync.Mutex // the time is read by the processor while it's advanced by Confirm
 	listener     messaging.ListenCloser
 
 	writeCount        int
@@ -36,20 +42,54 @@ type DispatchProcessorFixture struct {
 	confirmContext      context.Context
 	confirmDispatches   []messaging.Dispatch
 	confirmError        error
+	confirmDelay        time.Duration
+	confirmLatencies    []time.Duration
 
 	loadCount         int
 	loadMaxResultsPer int
 	loadContext       context.Context
 	loadID            uint64
+	loadDue           time.Time
 	loadResult        []messaging.Dispatch
 	loadError         error
 
+	dueCount  int
+	dueIDs    []uint64
+	dueAfter  time.Time
+	dueBefore time.Time
+	dueResult []messaging.Dispatch
+	dueError  error
+
+	sweepCount  int
+	sweepIDs    []uint64
+	sweepBefore time.Time
+	sweepLimit  int
+	sweepResult []messaging.Dispatch
+	sweepError  error
+
+	claimCount  int
+	claimResult []messaging.Dispatch
+	claimError  error
+	renewCount  int
+	renewError  error
+
+	logs   sync.Mutex // the processor logs from several goroutines
+	logged []string
+
+	planCount      int
+	planResult     []Migration
+	planError      error
+	migrateCount   int
+	migrateContext context.Context
+	migrateError   error
+
 	closeCount int
 }
 
 func (this *DispatchProcessorFixture) Setup() {
 	this.ctx = context.Background()
 	this.sleepTimeout = time.Microsecond * 100
+	this.now = time.Now().UTC()
 	this.channel = make(chan messaging.Dispatch, 4)
 	this.initializeDispatchProcessor()
 }
@@ -60,7 +100,11 @@ func (this *DispatchProcessorFixture) initializeDispatchProcessor() {
 		Options.Context(this.ctx),
 		Options.Channel(this.channel),
 		Options.RetryTimeout(this.sleepTimeout),
+		Options.Now(this.Now),
 		Options.StorageHandle(&sql.DB{}),
+		Options.SchemaManager(this),
+		Options.Logger(this),
+		Options.Monitor(this),
 	)
 }
 func (this *DispatchProcessorFixture) listen(sleep time.Duration) {
@@ -80,6 +124,285 @@ func (this *DispatchProcessorFixture) TestWhenClose_ShutDownChannelAndAllowListe
 	this.So(this.closeCount, should.Equal, 1)
 	this.So(open, should.BeFalse)
 }
+func (this *DispatchProcessorFixture) TestWhenDispatchesAreScheduled_LeaveThemInStorageUntilTheyAreDue() {
+	processor := this.listener.(*dispatchProcessor)
+	this.channel <- messaging.Dispatch{MessageID: 1}
+	this.channel <- messaging.Dispatch{MessageID: 2, DeliverAt: this.now.Add(time.Hour)}
+	this.channel <- messaging.Dispatch{MessageID: 3, DeliverAt: this.now}
+
+	this.So(processor.fillEmptyBuffer(), should.BeTrue)
+	this.So(processor.buffer, should.Resemble, []messaging.Dispatch{{MessageID: 1}, {MessageID: 3, DeliverAt: this.now}})
+}
+func (this *DispatchProcessorFixture) TestWhenOnlyScheduledDispatchesAreWaiting_KeepWaitingForDueDispatches() {
+	processor := this.listener.(*dispatchProcessor)
+	this.channel <- messaging.Dispatch{MessageID: 1, DeliverAt: this.now.Add(time.Hour)}
+	go func() {
+		time.Sleep(time.Millisecond)
+		this.channel <- messaging.Dispatch{MessageID: 2}
+	}()
+
+	this.So(processor.fillEmptyBuffer(), should.BeTrue)
+	this.So(processor.buffer, should.Resemble, []messaging.Dispatch{{MessageID: 2}})
+}
+func (this *DispatchProcessorFixture) TestWhenReadingScheduled_LoadPagesDueSinceLastReadAndAdvance() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.pageSize = 2
+	processor.due = this.now.Add(-time.Second)
+	this.dueResult = []messaging.Dispatch{{MessageID: 1}, {MessageID: 2}, {MessageID: 3}}
+
+	this.So(processor.readScheduled(), should.BeTrue)
+	this.So(this.dueIDs, should.Resemble, []uint64{0, 2})
+	this.So(this.dueAfter, should.Equal, this.now.Add(-time.Second))
+	this.So(this.dueBefore, should.Equal, this.now)
+	this.So(processor.due, should.Equal, this.now)
+	this.So(this.channel, should.HaveLength, 3)
+}
+func (this *DispatchProcessorFixture) TestWhenReadingScheduledFails_ReadSameRowsAgainNextTime() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.due = this.now.Add(-time.Second)
+	this.dueError = errors.New("")
+
+	this.So(processor.readScheduled(), should.BeFalse)
+	this.So(processor.due, should.Equal, this.now.Add(-time.Second))
+}
+func (this *DispatchProcessorFixture) TestWhenListening_LoadPendingAsOfStartAndThenPollScheduled() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.poll = time.Millisecond
+
+	this.listen(time.Millisecond * 20)
+
+	this.So(this.loadCount, should.Equal, 1)
+	this.So(this.loadDue, should.Equal, this.now)
+	this.So(this.dueCount, should.BeGreaterThan, 1)
+	this.So(this.dueAfter, should.Equal, this.now)
+}
+func (this *DispatchProcessorFixture) TestWhenRetentionIsConfigured_ItListensUntilTheProcessorIsClosed() {
+	retention := &retentionFake{closed: make(chan struct{})}
+	this.listener.(*dispatchProcessor).retention = retention
+
+	this.listen(time.Millisecond)
+
+	this.So(retention.listenCount, should.Equal, 1)
+	this.So(retention.closeCount, should.Equal, 1)
+}
+func (this *DispatchProcessorFixture) TestWhenGaugesAreConfigured_TheyListenUntilTheProcessorIsClosed() {
+	gauges := &retentionFake{closed: make(chan struct{})}
+	this.listener.(*dispatchProcessor).gauges = gauges
+
+	this.listen(time.Millisecond)
+
+	this.So(gauges.listenCount, should.Equal, 1)
+	this.So(gauges.closeCount, should.Equal, 1)
+}
+func (this *DispatchProcessorFixture) TestWhenConfirmed_ReportLatencySincePublished() {
+	this.confirmDelay = time.Second
+	this.channel <- messaging.Dispatch{MessageID: 1}
+
+	this.listen(time.Millisecond * 5)
+
+	this.So(this.confirmCount, should.Equal, 1)
+	this.So(this.confirmLatencies, should.Resemble, []time.Duration{time.Second})
+}
+func (this *DispatchProcessorFixture) TestWhenNoSchemaManagerIsConfigured_NothingToMigrate() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.schema = nil
+
+	this.So(processor.migrateSchema(), should.BeTrue)
+	this.So(this.migrateCount, should.Equal, 0)
+	this.So(this.planCount, should.Equal, 0)
+}
+func (this *DispatchProcessorFixture) TestWhenSchemaMigrationIsNotRequested_VerifySchemaIsCurrent() {
+	processor := this.listener.(*dispatchProcessor)
+
+	this.So(processor.migrateSchema(), should.BeTrue)
+	this.So(this.planCount, should.Equal, 1)
+	this.So(this.migrateCount, should.Equal, 0)
+	this.So(this.logged, should.BeEmpty)
+}
+func (this *DispatchProcessorFixture) TestWhenSchemaIsOutdated_ReportRequiredVersionAndHoldOffProcessing() {
+	processor := this.listener.(*dispatchProcessor)
+	this.planResult = []Migration{{Version: 6}, {Version: 7}}
+
+	this.So(processor.migrateSchema(), should.BeFalse)
+	this.So(this.migrateCount, should.Equal, 0)
+	this.So(this.logged, should.HaveLength, 1)
+	this.So(this.logged[0], should.StartWith, "[WARN] Unable to process messages, schema version [5] < required [7]")
+}
+func (this *DispatchProcessorFixture) TestWhenSchemaCannotBeVerified_ReportFailureSoThatItIsRetried() {
+	processor := this.listener.(*dispatchProcessor)
+	this.planError = errors.New("")
+
+	this.So(processor.migrateSchema(), should.BeFalse)
+	this.So(this.logged, should.HaveLength, 1)
+	this.So(this.logged[0], should.StartWith, "[WARN] Unable to verify the schema of durable storage")
+}
+func (this *DispatchProcessorFixture) TestWhenSchemaMigrationIsRequested_MigrateSchema() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.migrate = true
+
+	this.So(processor.migrateSchema(), should.BeTrue)
+	this.So(this.migrateCount, should.Equal, 1)
+	this.So(this.migrateContext, should.Equal, processor.ctx)
+}
+func (this *DispatchProcessorFixture) TestWhenSchemaMigrationFails_ReportFailureSoThatItIsRetried() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.migrate = true
+	this.migrateError = errors.New("")
+
+	this.So(processor.migrateSchema(), should.BeFalse)
+	this.So(this.migrateCount, should.Equal, 1)
+}
+func (this *DispatchProcessorFixture) TestWhenReadingPending_LoadPagesUntilBacklogIsExhausted() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.pageSize = 2
+	processor.channel = make(chan messaging.Dispatch, 8)
+	this.loadResult = []messaging.Dispatch{{MessageID: 1}, {MessageID: 2}, {MessageID: 3}, {MessageID: 4}, {MessageID: 5}}
+	this.loadMaxResultsPer = 2
+
+	this.So(processor.readPending(), should.BeTrue)
+	this.So(this.loadCount, should.Equal, 3)
+	this.So(this.loadID, should.Equal, 4)
+	this.So(processor.latestID, should.Equal, 5)
+	this.So(processor.channel, should.HaveLength, 5)
+}
+func (this *DispatchProcessorFixture) TestWhenLoadingFails_ResumeAfterLatestDispatchLoaded() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.latestID = 3
+	this.loadResult = []messaging.Dispatch{{MessageID: 1}, {MessageID: 2}, {MessageID: 3}, {MessageID: 4}}
+	this.loadError = errors.New("")
+
+	this.So(processor.readPending(), should.BeFalse)
+	this.So(this.loadID, should.Equal, 3)
+	this.So(processor.latestID, should.Equal, 3)
+}
+func (this *DispatchProcessorFixture) TestWhenSweeping_PushRowsOlderThanGracePeriodToChannelInBatches() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.grace = time.Minute
+	processor.sweepSize = 2
+	this.sweepResult = []messaging.Dispatch{{MessageID: 1}, {MessageID: 2}, {MessageID: 3}}
+
+	this.So(processor.sweepPending(), should.BeTrue)
+	this.So(this.sweepIDs, should.Resemble, []uint64{0, 2})
+	this.So(this.sweepBefore, should.Equal, this.now.Add(-time.Minute))
+	this.So(this.sweepLimit, should.Equal, 2)
+	this.So(<-this.channel, should.Resemble, messaging.Dispatch{MessageID: 1})
+	this.So(<-this.channel, should.Resemble, messaging.Dispatch{MessageID: 2})
+	this.So(<-this.channel, should.Resemble, messaging.Dispatch{MessageID: 3})
+}
+func (this *DispatchProcessorFixture) TestWhenSweepingFails_ReportFailure() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.sweepSize = 2
+	this.sweepError = errors.New("")
+
+	this.So(processor.sweepPending(), should.BeFalse)
+	this.So(this.channel, should.BeEmpty)
+}
+func (this *DispatchProcessorFixture) TestWhenSweepIntervalIsConfigured_SweepRepeatedlyAfterLoading() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.sweep = time.Millisecond
+	processor.sweepSize = 2
+
+	this.listen(time.Millisecond * 20)
+
+	this.So(this.loadCount, should.Equal, 1)
+	this.So(this.sweepCount, should.BeGreaterThan, 1)
+}
+func (this *DispatchProcessorFixture) TestWhenSweepingAgainBeforeConfirmed_EachRowIsPublishedOnce() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.sweep = time.Millisecond
+	processor.sweepSize = 4
+	this.sweepResult = []messaging.Dispatch{{MessageID: 1}, {MessageID: 2}}
+	this.confirmError = errors.New("")
+	this.confirmFailureUntil = math.MaxInt32
+
+	this.listen(time.Millisecond * 20)
+
+	this.So(this.sweepCount, should.BeGreaterThan, 1)
+	this.So(this.writeDispatches, should.Resemble, this.sweepResult)
+}
+func (this *DispatchProcessorFixture) TestWhenConfirmed_RowsAreNoLongerInFlight() {
+	processor := this.listener.(*dispatchProcessor)
+	this.sweepResult = []messaging.Dispatch{{MessageID: 1}}
+	processor.sweepSize = 4
+	processor.sweepPending()
+	processor.sweepPending()
+	this.So(this.channel, should.HaveLength, 1)
+
+	this.listen(time.Millisecond * 5)
+
+	this.So(this.confirmDispatches, should.Resemble, this.sweepResult)
+	this.So(processor.sending.Add(1), should.BeTrue)
+}
+func (this *DispatchProcessorFixture) TestWhenScheduledDispatchIsLeftInStorage_ItIsNoLongerInFlight() {
+	processor := this.listener.(*dispatchProcessor)
+	this.dueResult = []messaging.Dispatch{{MessageID: 1, DeliverAt: this.now.Add(time.Hour)}, {MessageID: 2}}
+	processor.readScheduled()
+
+	this.So(processor.fillEmptyBuffer(), should.BeTrue)
+
+	this.So(processor.buffer, should.Resemble, []messaging.Dispatch{{MessageID: 2}})
+	this.So(processor.sending.Add(1), should.BeTrue)
+	this.So(processor.sending.Add(2), should.BeFalse)
+}
+func (this *DispatchProcessorFixture) TestWhenSweepIntervalIsNotConfigured_OnlyLoadOnce() {
+	this.listen(time.Millisecond * 5)
+
+	this.So(this.loadCount, should.Equal, 1)
+	this.So(this.sweepCount, should.Equal, 0)
+}
+func (this *DispatchProcessorFixture) TestWhenClaiming_PushClaimedDispatchesToChannel() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.claimer = this
+	this.claimResult = []messaging.Dispatch{{MessageID: 1}, {MessageID: 2}}
+
+	this.So(processor.claimPending(), should.BeTrue)
+	this.So(this.claimCount, should.Equal, 1)
+	this.So(<-this.channel, should.Resemble, messaging.Dispatch{MessageID: 1})
+	this.So(<-this.channel, should.Resemble, messaging.Dispatch{MessageID: 2})
+}
+func (this *DispatchProcessorFixture) TestWhenRenewingFails_ReportFailureSoThatItIsRetried() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.claimer = this
+	this.renewError = errors.New("")
+
+	this.So(processor.renewLease(), should.BeFalse)
+	this.So(this.renewCount, should.Equal, 1)
+	this.So(this.logged, should.HaveLength, 1)
+}
+func (this *DispatchProcessorFixture) TestWhenClaimingFails_ReportFailureSoThatItIsRetried() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.claimer = this
+	this.claimError = errors.New("")
+
+	this.So(processor.claimPending(), should.BeFalse)
+	this.So(this.channel, should.BeEmpty)
+}
+func (this *DispatchProcessorFixture) TestWhenClaimerIsConfigured_ClaimRepeatedlyInsteadOfLoading() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.claimer = this
+	processor.lease = time.Millisecond * 3
+
+	this.listen(time.Millisecond * 20)
+
+	this.So(this.loadCount, should.Equal, 0)
+	this.So(this.claimCount, should.BeGreaterThan, 1)
+	this.So(this.renewCount, should.BeGreaterThan, 1)
+}
+func (this *DispatchProcessorFixture) TestWhenChannelIsFullOfClaimedDispatches_LeaseIsStillRenewed() {
+	processor := this.listener.(*dispatchProcessor)
+	processor.claimer = this
+	processor.lease = time.Millisecond * 3
+	processor.channel = make(chan messaging.Dispatch) // nothing drains it, claimed dispatches wait until closed
+	this.claimResult = []messaging.Dispatch{{MessageID: 1}}
+	var waiter sync.WaitGroup
+	waiter.Add(1)
+	go processor.listenRenew(&waiter)
+	time.AfterFunc(time.Millisecond*20, processor.shutdown)
+
+	this.So(processor.claimPending(), should.BeFalse)
+	waiter.Wait()
+	this.So(this.renewCount, should.BeGreaterThan, 1)
+}
 func (this *DispatchProcessorFixture) SkipTestWhenDispatchesArePending_ItShouldPublishThemAndConfirmDispatch() {
 	expected := []messaging.Dispatch{{MessageID: 1}, {MessageID: 2}, {MessageID: 3}}
 	for _, item := range expected {
@@ -196,10 +519,11 @@ func (this *DispatchProcessorFixture) Close() error {
 	return nil
 }
 
-func (this *DispatchProcessorFixture) Load(ctx context.Context, id uint64) ([]messaging.Dispatch, error) {
+func (this *DispatchProcessorFixture) Load(ctx context.Context, id uint64, due time.Time) ([]messaging.Dispatch, error) {
 	this.loadCount++
 	this.loadContext = ctx
 	this.loadID = id
+	this.loadDue = due
 
 	if id >= uint64(len(this.loadResult)) {
 		return nil, nil
@@ -215,16 +539,99 @@ func (this *DispatchProcessorFixture) Load(ctx context.Context, id uint64) ([]me
 
 	return result[0:this.loadMaxResultsPer], this.loadError
 }
+func (this *DispatchProcessorFixture) LoadDue(_ context.Context, id uint64, after, due time.Time) ([]messaging.Dispatch, error) {
+	this.dueCount++
+	this.dueIDs = append(this.dueIDs, id)
+	this.dueAfter = after
+	this.dueBefore = due
+
+	var results []messaging.Dispatch
+	for _, dispatch := range this.dueResult {
+		if dispatch.MessageID > id && len(results) < this.listener.(*dispatchProcessor).pageSize {
+			results = append(results, dispatch)
+		}
+	}
+	return results, this.dueError
+}
+func (this *DispatchProcessorFixture) Sweep(ctx context.Context, id uint64, before time.Time, limit int) ([]messaging.Dispatch, error) {
+	this.sweepCount++
+	this.sweepIDs = append(this.sweepIDs, id)
+	this.sweepBefore = before
+	this.sweepLimit = limit
+
+	var results []messaging.Dispatch
+	for _, dispatch := range this.sweepResult {
+		if dispatch.MessageID > id && len(results) < limit {
+			results = append(results, dispatch)
+		}
+	}
+	return results, this.sweepError
+}
 func (this *DispatchProcessorFixture) Confirm(ctx context.Context, dispatches []messaging.Dispatch) error {
 	this.confirmCount++
 	this.confirmContext = ctx
 	this.confirmDispatches = append(this.confirmDispatches, dispatches...)
+	this.clock.Lock()
+	this.now = this.now.Add(this.confirmDelay)
+	this.clock.Unlock()
 	if this.confirmFailureUntil > this.confirmCount-1 {
 		return this.confirmError
 	} else {
 		return nil
 	}
 }
-func (this *DispatchProcessorFixture) Store(ctx context.Context, writer adapter.Writer, dispatches []messaging.Dispatch) error {
+func (this *DispatchProcessorFixture) Now() time.Time {
+	this.clock.Lock()
+	defer this.clock.Unlock()
+	return this.now
+}
+func (this *DispatchProcessorFixture) Store(ctx context.Context, writer adapter.ReadWriter, dispatches []messaging.Dispatch) error {
 	panic("nop")
 }
+
+func (this *DispatchProcessorFixture) Claim(_ context.Context) ([]messaging.Dispatch, error) {
+	this.claimCount++
+	return this.claimResult, this.claimError
+}
+func (this *DispatchProcessorFixture) Renew(_ context.Context) error {
+	this.renewCount++
+	return this.renewError
+}
+
+func (this *DispatchProcessorFixture) Printf(format string, args ...interface{}) {
+	this.logs.Lock()
+	defer this.logs.Unlock()
+	this.logged = append(this.logged, fmt.Sprintf(format, args...))
+}
+
+func (this *DispatchProcessorFixture) Plan(_ context.Context) ([]Migration, error) {
+	this.planCount++
+	return this.planResult, this.planError
+}
+func (this *DispatchProcessorFixture) Migrate(ctx context.Context) ([]Migration, error) {
+	this.migrateCount++
+	this.migrateContext = ctx
+	return nil, this.migrateError
+}
+
+func (this *DispatchProcessorFixture) PendingMessages(_ int, _ time.Duration) {}
+func (this *DispatchProcessorFixture) ChannelDepth(_ int)                     {}
+func (this *DispatchProcessorFixture) ConfirmLatency(value time.Duration) {
+	this.confirmLatencies = append(this.confirmLatencies, value)
+}
+
+type retentionFake struct {
+	listenCount int
+	closeCount  int
+	closed      chan struct{}
+}
+
+func (this *retentionFake) Listen() {
+	this.listenCount++
+	<-this.closed
+}
+func (this *retentionFake) Close() error {
+	this.closeCount++
+	close(this.closed)
+	return nil
+}
@@ -12,6 +12,7 @@ type dispatchReceiver struct {
 	ctx     context.Context
 	tx      adapter.Transaction
 	output  chan messaging.Dispatch
+	sending *inflightSet
 	store   messageStore
 	logger  logger
 	monitor monitor
@@ -24,6 +25,7 @@ func newDispatchReceiver(ctx context.Context, tx adapter.Transaction, config con
 		ctx:     ctx,
 		tx:      tx,
 		output:  config.Channel,
+		sending: config.InFlight,
 		store:   config.MessageStore,
 		logger:  config.Logger,
 		monitor: config.Monitor,
@@ -52,10 +54,36 @@ func (this *dispatchReceiver) Commit() error {
 	}
 
 	this.monitor.MessageStored(len(this.buffer))
+	return this.send()
+}
+
+// send marks each row as on its way to the sender before sending it. Should the context be cancelled while waiting,
+// rows not yet sent are unmarked such that they're swept (or loaded) and sent again.
+func (this *dispatchReceiver) send() error {
+	pending := make([]messaging.Dispatch, 0, len(this.buffer))
 	for _, dispatch := range this.buffer {
+		if this.sending.Add(dispatch.MessageID) {
+			pending = append(pending, dispatch) // otherwise already swept and on its way to the sender
+		}
+	}
+
+	blocked := false
+	for i, dispatch := range pending {
+		select {
+		case this.output <- dispatch:
+			continue
+		default:
+		}
+
+		if !blocked {
+			blocked = true // warn only once per commit
+			this.logger.Printf("[WARN] Unable to send committed messages, channel is full (capacity [%d]), waiting...", cap(this.output))
+		}
+
 		select {
 		case this.output <- dispatch:
 		case <-this.ctx.Done():
+			this.sending.Remove(pending[i:]...)
 			return this.ctx.Err()
 		}
 	}
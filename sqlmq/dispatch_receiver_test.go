@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -23,6 +24,7 @@ type DispatchReceiverFixture struct {
 	ctx         context.Context
 	ctxShutdown context.CancelFunc
 	channel     chan messaging.Dispatch
+	config      configuration
 	writer      messaging.CommitWriter
 
 	commitCalls   int
@@ -33,6 +35,12 @@ type DispatchReceiverFixture struct {
 	storeContext context.Context
 	storeWrites  []messaging.Dispatch
 	storeError   error
+
+	storeIdentities bool
+
+	sweepResult []messaging.Dispatch
+
+	logged []string
 }
 
 func (this *DispatchReceiverFixture) Setup() {
@@ -41,14 +49,15 @@ func (this *DispatchReceiverFixture) Setup() {
 	this.initializeDispatchWriter()
 }
 func (this *DispatchReceiverFixture) initializeDispatchWriter() {
-	config := configuration{}
+	this.config = configuration{}
 	Options.apply(
 		Options.Context(this.ctx),
 		Options.StorageHandle(&sql.DB{}),
 		Options.Channel(this.channel),
-	)(&config)
-	config.MessageStore = this
-	this.writer = newDispatchReceiver(this.ctx, this, config)
+		Options.Logger(this),
+	)(&this.config)
+	this.config.MessageStore = this
+	this.writer = newDispatchReceiver(this.ctx, this, this.config)
 }
 
 func (this *DispatchReceiverFixture) TestWhenWritingDispatches_ReturnNumberOfWritesNewlyBuffered() {
@@ -74,6 +83,18 @@ func (this *DispatchReceiverFixture) TestWhenCommitting_FlushBufferToStorageThen
 	this.So(this.commitCalls, should.Equal, 1)
 	this.So(len(this.channel), should.Equal, len(writes))
 }
+func (this *DispatchReceiverFixture) TestWhenCommittedDispatchIsAlreadyInFlight_DoNotSendItAgain() {
+	receiver := this.writer.(*dispatchReceiver)
+	receiver.sending.Add(2) // e.g. swept while the commit was slow
+	_, _ = this.writer.Write(nil, messaging.Dispatch{MessageID: 1}, messaging.Dispatch{MessageID: 2})
+
+	err := this.writer.Commit()
+
+	this.So(err, should.BeNil)
+	this.So(this.channel, should.HaveLength, 1)
+	this.So(<-this.channel, should.Resemble, messaging.Dispatch{MessageID: 1})
+	this.So(receiver.sending.Add(1), should.BeFalse)
+}
 func (this *DispatchReceiverFixture) TestWhenUnderlyingStoreOperationsFails_ReturnErrorDoNotCommitOrSendToOutputChannel() {
 	this.storeError = errors.New("")
 	_, _ = this.writer.Write(nil, messaging.Dispatch{MessageType: "1", Payload: []byte("a")})
@@ -114,6 +135,57 @@ func (this *DispatchReceiverFixture) TestWhenTransactionContextCancelled_DoNotBl
 	this.So(err, should.Equal, context.Canceled)
 	this.So(len(this.channel), should.Equal, cap(this.channel))
 }
+func (this *DispatchReceiverFixture) TestWhenContextCancelledWhileChannelIsFull_UnsentDispatchesAreSweptAgain() {
+	for i := 0; i < cap(this.channel); i++ {
+		this.channel <- messaging.Dispatch{}
+	}
+	_, _ = this.writer.Write(nil, messaging.Dispatch{MessageType: "1"}, messaging.Dispatch{MessageType: "2"})
+	this.storeIdentities = true // as the store does
+	time.AfterFunc(time.Millisecond, this.ctxShutdown)
+
+	err := this.writer.Commit()
+
+	this.So(err, should.Equal, context.Canceled)
+	for len(this.channel) > 0 {
+		<-this.channel
+	}
+
+	this.config.Context = context.Background()
+	this.sweepResult = this.storeWrites
+	processor := newDispatchProcessor(this.config).(*dispatchProcessor)
+	this.So(processor.sweepPending(), should.BeTrue)
+	close(this.channel)
+	var swept []messaging.Dispatch
+	for dispatch := range this.channel {
+		swept = append(swept, dispatch)
+	}
+	this.So(swept, should.Resemble, this.storeWrites)
+}
+func (this *DispatchReceiverFixture) TestWhenOutputChannelIsFull_WarnOnceWhileBlocked() {
+	for i := 0; i < cap(this.channel); i++ {
+		this.channel <- messaging.Dispatch{}
+	}
+	_, _ = this.writer.Write(nil, messaging.Dispatch{MessageType: "1"}, messaging.Dispatch{MessageType: "2"})
+	go func() {
+		for i := 0; i < cap(this.channel)+2; i++ {
+			time.Sleep(time.Microsecond * 100)
+			<-this.channel
+		}
+	}()
+
+	err := this.writer.Commit()
+
+	this.So(err, should.BeNil)
+	this.So(this.logged, should.HaveLength, 1)
+	this.So(this.logged[0], should.StartWith, "[WARN] Unable to send committed messages, channel is full (capacity [16])")
+}
+func (this *DispatchReceiverFixture) TestWhenOutputChannelHasCapacity_NoWarning() {
+	_, _ = this.writer.Write(nil, messaging.Dispatch{MessageType: "1"})
+
+	_ = this.writer.Commit()
+
+	this.So(this.logged, should.BeEmpty)
+}
 
 func (this *DispatchReceiverFixture) TestWhenCommittingWithoutAnyDispatches_CommitShouldStillBeInvoked() {
 	// there may be other storage operations using the same SQL transaction, so we still need to allow commit to be called
@@ -149,19 +221,35 @@ func (this *DispatchReceiverFixture) TestWhenClosing_Nop() {
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
+func (this *DispatchReceiverFixture) Printf(format string, args ...interface{}) {
+	this.logged = append(this.logged, fmt.Sprintf(format, args...))
+}
+
 func (this *DispatchReceiverFixture) Commit() error   { this.commitCalls++; return this.commitError }
 func (this *DispatchReceiverFixture) Rollback() error { return this.rollbackError }
 
-func (this *DispatchReceiverFixture) Store(ctx context.Context, writer adapter.Writer, writes []messaging.Dispatch) error {
+func (this *DispatchReceiverFixture) Store(ctx context.Context, writer adapter.ReadWriter, writes []messaging.Dispatch) error {
 	this.So(writer, should.Equal, this)
 
+	if this.storeIdentities {
+		for i := range writes {
+			writes[i].MessageID = uint64(len(this.storeWrites) + i + 1)
+		}
+	}
+
 	this.storeContext = ctx
 	this.storeWrites = append(this.storeWrites, writes...)
 	return this.storeError
 }
-func (this *DispatchReceiverFixture) Load(ctx context.Context, id uint64) ([]messaging.Dispatch, error) {
+func (this *DispatchReceiverFixture) Load(ctx context.Context, id uint64, due time.Time) ([]messaging.Dispatch, error) {
+	panic("nop")
+}
+func (this *DispatchReceiverFixture) LoadDue(ctx context.Context, id uint64, after, due time.Time) ([]messaging.Dispatch, error) {
 	panic("nop")
 }
+func (this *DispatchReceiverFixture) Sweep(ctx context.Context, id uint64, before time.Time, limit int) ([]messaging.Dispatch, error) {
+	return this.sweepResult, nil
+}
 func (this *DispatchReceiverFixture) Confirm(ctx context.Context, dispatches []messaging.Dispatch) error {
 	panic("nop")
 }
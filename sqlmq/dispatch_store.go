@@ -2,10 +2,14 @@ package sqlmq
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/smartystreets/messaging/v3"
@@ -14,22 +18,54 @@ import (
 
 type dispatchStore struct {
 	db               adapter.ReadWriter
+	dialect          Dialect
+	table            string
+	pageSize         int
 	now              func() time.Time
-	confirmStatement *strings.Builder
+	prepared         *preparedStatements
+	loadStatement    string
+	dueStatement     string
+	confirmStatement string // for a full page, the statement for any remainder is built when needed
 }
 
-func newMessageStore(db adapter.ReadWriter, now func() time.Time) messageStore {
-	return dispatchStore{db: db, now: now, confirmStatement: &strings.Builder{}}
+func newMessageStore(db adapter.ReadWriter, dialect Dialect, table string, pageSize int, now func() time.Time) dispatchStore {
+	const loadFormat = "SELECT id, %s FROM %s WHERE dispatched IS NULL AND id > %s " +
+		"AND (deliver_at IS NULL OR deliver_at <= %s) ORDER BY id LIMIT %d;"
+	const dueFormat = "SELECT id, %s FROM %s WHERE dispatched IS NULL AND id > %s " +
+		"AND deliver_at > %s AND deliver_at <= %s ORDER BY id LIMIT %d;"
+	this := dispatchStore{db: db, dialect: dialect, table: table, pageSize: pageSize, now: now}
+	this.prepared = newPreparedStatements(db)
+	this.loadStatement = fmt.Sprintf(loadFormat, storedColumns, table,
+		dialect.Placeholder(1), dialect.Placeholder(2), pageSize)
+	this.dueStatement = fmt.Sprintf(dueFormat, storedColumns, table,
+		dialect.Placeholder(1), dialect.Placeholder(2), dialect.Placeholder(3), pageSize)
+	this.confirmStatement = this.buildConfirmStatement(pageSize)
+	return this
 }
 
-func (this dispatchStore) Store(ctx context.Context, writer adapter.Writer, dispatches []messaging.Dispatch) error {
+func (this dispatchStore) Store(ctx context.Context, writer adapter.ReadWriter, dispatches []messaging.Dispatch) error {
 	length := uint64(len(dispatches))
 	if length == 0 {
 		return nil
 	}
 
-	statement, args := this.buildExecArgs(dispatches)
-	result, err := writer.ExecContext(ctx, statement, args...)
+	now := this.now().UTC().Truncate(time.Millisecond) // the precision of the datetime columns
+	for i := range dispatches {
+		if dispatches[i].Timestamp.IsZero() {
+			dispatches[i].Timestamp = now // such that messages sent immediately and those recovered later are identical
+		}
+	}
+
+	statement, args, err := this.buildExecArgs(dispatches)
+	if err != nil {
+		return err
+	}
+
+	if returning := this.dialect.Returning(); len(returning) > 0 {
+		return this.storeReturning(ctx, writer, statement+" "+returning+";", args, dispatches)
+	}
+
+	result, err := writer.ExecContext(ctx, statement+";", args...)
 	if err != nil {
 		return err
 	}
@@ -40,6 +76,7 @@ func (this dispatchStore) Store(ctx context.Context, writer adapter.Writer, disp
 	}
 
 	identity, _ := result.LastInsertId()
+	identity = this.dialect.FirstInsertID(identity, int64(length))
 	if identity <= 0 {
 		return errIdentityFailure
 	}
@@ -50,26 +87,114 @@ func (this dispatchStore) Store(ctx context.Context, writer adapter.Writer, disp
 
 	return nil
 }
-func (this dispatchStore) buildExecArgs(dispatches []messaging.Dispatch) (string, []interface{}) {
+func (this dispatchStore) storeReturning(ctx context.Context, reader adapter.Reader, statement string, args []interface{}, dispatches []messaging.Dispatch) error {
+	rows, err := reader.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return err
+	}
+	defer closeResource(rows)
+
+	identities := make([]uint64, 0, len(dispatches))
+	for rows.Next() {
+		var identity uint64
+		if err := rows.Scan(&identity); err != nil {
+			return err
+		} else if identity == 0 {
+			return errIdentityFailure
+		}
+		identities = append(identities, identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	} else if len(identities) != len(dispatches) {
+		return errRowsAffected
+	}
+
+	for i, identity := range identities {
+		dispatches[i].MessageID = identity
+	}
+
+	return nil
+}
+func (this dispatchStore) buildExecArgs(dispatches []messaging.Dispatch) (string, []interface{}, error) {
 	builder := &strings.Builder{}
-	args := make([]interface{}, 0, len(dispatches)*2)
+	args := make([]interface{}, 0, len(dispatches)*storedColumnCount)
 
-	_, _ = builder.WriteString("INSERT INTO Messages (type, payload) VALUES ")
+	_, _ = fmt.Fprintf(builder, "INSERT INTO %s (%s) VALUES ", this.table, storedColumns)
 	for i, dispatch := range dispatches {
-		args = append(args, dispatch.MessageType, dispatch.Payload)
-		if i == len(dispatches)-1 {
-			_, _ = builder.WriteString("(?,?);")
-		} else {
-			_, _ = builder.WriteString("(?,?),")
+		if i > 0 {
+			_, _ = builder.WriteString(",")
+		}
+
+		values, err := this.storedValues(dispatch)
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, values...)
+
+		_, _ = builder.WriteString("(")
+		for position := len(args) - storedColumnCount + 1; position <= len(args); position++ {
+			if position > len(args)-storedColumnCount+1 {
+				_, _ = builder.WriteString(",")
+			}
+			_, _ = builder.WriteString(this.dialect.Placeholder(position))
 		}
+		_, _ = builder.WriteString(")")
+	}
+
+	return builder.String(), args, nil
+}
+func (this dispatchStore) storedValues(dispatch messaging.Dispatch) ([]interface{}, error) {
+	headers, err := encodeHeaders(dispatch.Headers)
+	if err != nil {
+		return nil, err
 	}
 
-	return builder.String(), args
+	return []interface{}{
+		dispatch.MessageType,
+		dispatch.Payload,
+		this.nullTime(dispatch.DeliverAt),
+		dispatch.Priority,
+		int64(dispatch.SourceID), // unsigned values are stored using the bits of a signed value
+		int64(dispatch.CorrelationID),
+		this.nullTime(dispatch.Timestamp),
+		int64(dispatch.Expiration),
+		durability(dispatch.Durable),
+		dispatch.Topic,
+		int64(dispatch.Partition),
+		dispatch.ContentType,
+		dispatch.ContentEncoding,
+		headers,
+	}, nil
+}
+
+// Load returns the next page of pending dispatches after the id specified which are due for delivery at the time
+// specified.
+func (this dispatchStore) Load(ctx context.Context, id uint64, due time.Time) ([]messaging.Dispatch, error) {
+	return this.scan(this.prepared.QueryContext(ctx, this.loadStatement, int64(id), this.dialect.Timestamp(due)))
+}
+
+// LoadDue returns the next page of pending dispatches after the id specified whose delivery was scheduled after the
+// first time specified and up to (and including) the second.
+func (this dispatchStore) LoadDue(ctx context.Context, id uint64, after, due time.Time) ([]messaging.Dispatch, error) {
+	return this.scan(this.prepared.QueryContext(ctx, this.dueStatement, int64(id),
+		this.dialect.Timestamp(after), this.dialect.Timestamp(due)))
 }
 
-func (this dispatchStore) Load(ctx context.Context, id uint64) (results []messaging.Dispatch, err error) {
-	statement := fmt.Sprintf("SELECT id, type, payload FROM Messages WHERE dispatched IS NULL AND id > %d;", id)
-	rows, err := this.db.QueryContext(ctx, statement)
+// Sweep returns at most limit pending dispatches after the id specified which were inserted (as recorded by the
+// database) before the time specified and which are already due for delivery.
+func (this dispatchStore) Sweep(ctx context.Context, id uint64, before time.Time, limit int) ([]messaging.Dispatch, error) {
+	const statementFormat = "SELECT id, %s FROM %s WHERE dispatched IS NULL AND id > %s " +
+		"AND (inserted IS NULL OR inserted < %s) AND (deliver_at IS NULL OR deliver_at <= %s) ORDER BY id LIMIT %d;"
+	statement := fmt.Sprintf(statementFormat, storedColumns, this.table,
+		this.dialect.Placeholder(1), this.dialect.Placeholder(2), this.dialect.Placeholder(3), limit)
+	return this.query(ctx, statement, int64(id), this.dialect.Timestamp(before), this.dialect.Timestamp(this.now()))
+}
+func (this dispatchStore) query(ctx context.Context, statement string, args ...interface{}) ([]messaging.Dispatch, error) {
+	return this.scan(this.db.QueryContext(ctx, statement, args...))
+}
+func (this dispatchStore) scan(rows adapter.QueryResult, err error) (results []messaging.Dispatch, _ error) {
 	if err != nil {
 		return nil, err
 	}
@@ -77,40 +202,356 @@ func (this dispatchStore) Load(ctx context.Context, id uint64) (results []messag
 
 	now := this.now().UTC()
 	for rows.Next() {
-		dispatch := messaging.Dispatch{Timestamp: now}
-		if err := rows.Scan(&dispatch.MessageID, &dispatch.MessageType, &dispatch.Payload); err != nil {
+		dispatch := messaging.Dispatch{}
+		if err := scanStored(rows, &dispatch, &dispatch.MessageID); err != nil {
 			return nil, err
 		}
 
-		dispatch.Durable = true
-		dispatch.ContentType = "application/json"
-		dispatch.Topic = dispatch.MessageType
+		if dispatch.Timestamp.IsZero() {
+			dispatch.Timestamp = now // stored before timestamps were persisted
+		}
+
 		results = append(results, dispatch)
 	}
 
 	return results, rows.Err()
 }
+
+// Confirm marks the dispatches as dispatched, one page at a time such that the statements remain bounded. Pages already
+// confirmed before a failure are unaffected when the dispatches are confirmed again.
 func (this dispatchStore) Confirm(ctx context.Context, dispatches []messaging.Dispatch) error {
 	if len(dispatches) == 0 {
 		return nil
 	}
 
-	defer this.confirmStatement.Reset()
+	now := this.dialect.Timestamp(this.now())
+	args := make([]interface{}, 0, this.pageSize+1)
 
-	for i, dispatch := range dispatches {
-		var template = "%d, "
-		if i+1 >= len(dispatches) {
-			template = "%d"
+	for len(dispatches) > 0 {
+		page := dispatches
+		if len(page) > this.pageSize {
+			page = page[:this.pageSize]
+		}
+		dispatches = dispatches[len(page):]
+
+		args = append(args[0:0], now)
+		for _, dispatch := range page {
+			args = append(args, int64(dispatch.MessageID))
+		}
+
+		var err error
+		if len(page) == this.pageSize {
+			_, err = this.prepared.ExecContext(ctx, this.confirmStatement, args...)
+		} else {
+			_, err = this.db.ExecContext(ctx, this.buildConfirmStatement(len(page)), args...)
+		}
+		if err != nil {
+			return err
 		}
-		_, _ = fmt.Fprintf(this.confirmStatement, template, dispatch.MessageID)
 	}
 
-	now := this.now().UTC().Format("2006-01-02 15:04:05.000000")
-	const statementFormat = "UPDATE Messages SET dispatched = '%s' WHERE dispatched IS NULL AND id IN (%s);"
-	statement := fmt.Sprintf(statementFormat, now, this.confirmStatement.String())
-	_, err := this.db.ExecContext(ctx, statement)
+	return nil
+}
+func (this dispatchStore) buildConfirmStatement(count int) string {
+	return fmt.Sprintf("UPDATE %s SET dispatched = %s WHERE dispatched IS NULL AND id IN (%s);",
+		this.table, this.dialect.Placeholder(1), placeholders(this.dialect, 2, count))
+}
+
+// Close releases the statements prepared by the store.
+func (this dispatchStore) Close() error {
+	return this.prepared.Close()
+}
+
+// preparedStatements prepares each statement once, when it's first executed, and keeps it until closed. Statements are
+// executed as they are when the database handle doesn't prepare statements.
+type preparedStatements struct {
+	db         adapter.ReadWriter
+	mutex      sync.Mutex
+	statements map[string]adapter.Statement
+	closed     bool
+}
+
+func newPreparedStatements(db adapter.ReadWriter) *preparedStatements {
+	return &preparedStatements{db: db, statements: make(map[string]adapter.Statement)}
+}
+
+func (this *preparedStatements) QueryContext(ctx context.Context, statement string, args ...interface{}) (adapter.QueryResult, error) {
+	if prepared, err := this.prepare(ctx, statement); err != nil {
+		return nil, err
+	} else if prepared != nil {
+		return prepared.QueryContext(ctx, args...)
+	}
+
+	return this.db.QueryContext(ctx, statement, args...)
+}
+func (this *preparedStatements) ExecContext(ctx context.Context, statement string, args ...interface{}) (sql.Result, error) {
+	if prepared, err := this.prepare(ctx, statement); err != nil {
+		return nil, err
+	} else if prepared != nil {
+		return prepared.ExecContext(ctx, args...)
+	}
+
+	return this.db.ExecContext(ctx, statement, args...)
+}
+func (this *preparedStatements) prepare(ctx context.Context, statement string) (adapter.Statement, error) {
+	preparer, ok := this.db.(adapter.Preparer)
+	if !ok {
+		return nil, nil
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.closed {
+		return nil, nil
+	} else if prepared, found := this.statements[statement]; found {
+		return prepared, nil
+	}
+
+	prepared, err := preparer.PrepareContext(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	this.statements[statement] = prepared
+	return prepared, nil
+}
+func (this *preparedStatements) Close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.closed = true
+	for statement, prepared := range this.statements {
+		_ = prepared.Close()
+		delete(this.statements, statement)
+	}
+
+	return nil
+}
+
+// placeholders returns the comma-separated markers of count statement parameters beginning at the position specified.
+func placeholders(dialect Dialect, position, count int) string {
+	markers := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		markers = append(markers, dialect.Placeholder(position+i))
+	}
+	return strings.Join(markers, ", ")
+}
+
+// identityPages splits the identities into pages of at most size, bounding the number of parameters of each statement.
+func identityPages(identities []interface{}, size int) (pages [][]interface{}) {
+	for len(identities) > size {
+		pages = append(pages, identities[:size:size])
+		identities = identities[size:]
+	}
+	if len(identities) > 0 {
+		pages = append(pages, identities)
+	}
+	return pages
+}
+
+// scanStored scans the leading columns specified followed by the stored columns of the dispatch.
+func scanStored(scanner adapter.RowScanner, dispatch *messaging.Dispatch, leading ...interface{}) (err error) {
+	var deliverAt, timestamp nullableTime
+	var sourceID, correlationID, expiration, partition int64
+	var headers []byte
+	if err = scanner.Scan(append(leading, &dispatch.MessageType, &dispatch.Payload, &deliverAt, &dispatch.Priority,
+		&sourceID, &correlationID, &timestamp, &expiration, &dispatch.Durable, &dispatch.Topic, &partition,
+		&dispatch.ContentType, &dispatch.ContentEncoding, &headers)...); err != nil {
+		return err
+	}
+
+	if dispatch.Headers, err = decodeHeaders(headers); err != nil {
+		return err
+	}
+
+	dispatch.DeliverAt = deliverAt.Time
+	dispatch.SourceID = uint64(sourceID)
+	dispatch.CorrelationID = uint64(correlationID)
+	dispatch.Timestamp = timestamp.Time
+	dispatch.Expiration = time.Duration(expiration)
+	dispatch.Partition = uint64(partition)
+	return nil
+}
+
+func (this dispatchStore) nullTime(value time.Time) interface{} {
+	if value.IsZero() {
+		return nil
+	}
+	return this.dialect.Timestamp(value)
+}
+
+// nullableTime scans a nullable datetime column whether the driver yields a time.Time (e.g. MySQL with parseTime) or
+// the textual value of the column.
+type nullableTime struct{ Time time.Time }
+
+func (this *nullableTime) Scan(value interface{}) (err error) {
+	switch typed := value.(type) {
+	case nil:
+		this.Time = time.Time{}
+	case time.Time:
+		this.Time = typed.UTC()
+	case []byte:
+		this.Time, err = parseStorageTime(string(typed))
+	case string:
+		this.Time, err = parseStorageTime(typed)
+	default:
+		err = fmt.Errorf("unable to scan [%T] as time", value)
+	}
 	return err
 }
+func parseStorageTime(value string) (time.Time, error) {
+	for _, layout := range []string{storageTimeLayout, time.RFC3339Nano} {
+		if parsed, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse [%s] as time", value)
+}
+
+const storageTimeLayout = "2006-01-02 15:04:05.999999999"
+
+const (
+	storedColumns = "type, payload, deliver_at, priority, source_id, correlation_id, created, expiration, durable, " +
+		"topic, partition_id, content_type, content_encoding, headers"
+	storedColumnCount = 14
+)
+
+// encodeHeaders writes the headers as JSON, tagging each value with its type such that it's restored as it was (JSON
+// alone doesn't distinguish between numeric types, times, and byte slices).
+func encodeHeaders(headers map[string]interface{}) (interface{}, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+
+	tagged, err := tagHeaders(headers)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tagged)
+}
+func decodeHeaders(encoded []byte) (map[string]interface{}, error) {
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+
+	var tagged map[string]taggedHeader
+	if err := json.Unmarshal(encoded, &tagged); err != nil {
+		return nil, err
+	}
+	return untagHeaders(tagged)
+}
+
+type taggedHeader struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func tagHeaders(headers map[string]interface{}) (map[string]taggedHeader, error) {
+	tagged := make(map[string]taggedHeader, len(headers))
+	for key, value := range headers {
+		item, err := tagHeader(value)
+		if err != nil {
+			return nil, err
+		}
+		tagged[key] = item
+	}
+	return tagged, nil
+}
+func tagHeader(value interface{}) (tagged taggedHeader, err error) {
+	switch typed := value.(type) {
+	case nil:
+		tagged.Type = "nil"
+		return tagged, nil
+	case map[string]interface{}:
+		items, err := tagHeaders(typed)
+		if err != nil {
+			return tagged, err
+		}
+		tagged.Type = "table"
+		tagged.Value, err = json.Marshal(items)
+		return tagged, err
+	case []interface{}:
+		items := make([]taggedHeader, len(typed))
+		for i, item := range typed {
+			if items[i], err = tagHeader(item); err != nil {
+				return tagged, err
+			}
+		}
+		tagged.Type = "array"
+		tagged.Value, err = json.Marshal(items)
+		return tagged, err
+	}
+
+	tagged.Type = reflect.TypeOf(value).String()
+	if _, ok := headerTypes[tagged.Type]; !ok {
+		return tagged, fmt.Errorf("%w [%s]", errUnsupportedHeader, tagged.Type)
+	}
+	tagged.Value, err = json.Marshal(value)
+	return tagged, err
+}
+func untagHeaders(tagged map[string]taggedHeader) (map[string]interface{}, error) {
+	headers := make(map[string]interface{}, len(tagged))
+	for key, item := range tagged {
+		value, err := untagHeader(item)
+		if err != nil {
+			return nil, err
+		}
+		headers[key] = value
+	}
+	return headers, nil
+}
+func untagHeader(tagged taggedHeader) (interface{}, error) {
+	switch tagged.Type {
+	case "nil":
+		return nil, nil
+	case "table":
+		var items map[string]taggedHeader
+		if err := json.Unmarshal(tagged.Value, &items); err != nil {
+			return nil, err
+		}
+		return untagHeaders(items)
+	case "array":
+		var items []taggedHeader
+		if err := json.Unmarshal(tagged.Value, &items); err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(items))
+		for i, item := range items {
+			value, err := untagHeader(item)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	}
+
+	kind, ok := headerTypes[tagged.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w [%s]", errUnsupportedHeader, tagged.Type)
+	}
+	value := reflect.New(kind)
+	err := json.Unmarshal(tagged.Value, value.Interface())
+	return value.Elem().Interface(), err
+}
+
+// headerTypes are those of the values found in AMQP tables (besides tables and arrays), keyed by the name of each.
+var headerTypes = func(values ...interface{}) map[string]reflect.Type {
+	types := make(map[string]reflect.Type, len(values))
+	for _, value := range values {
+		types[reflect.TypeOf(value).String()] = reflect.TypeOf(value)
+	}
+	return types
+}(false, int(0), int8(0), int16(0), int32(0), int64(0), uint8(0), uint16(0), uint32(0), uint64(0), float32(0),
+	float64(0), "", []byte(nil), time.Time{})
+
+func durability(durable bool) uint8 {
+	if durable {
+		return 1
+	}
+	return 0
+}
 
 func closeResource(resource io.Closer) {
 	if resource != nil {
@@ -119,6 +560,8 @@ func closeResource(resource io.Closer) {
 }
 
 var (
-	errRowsAffected    = errors.New("the number of modified rows was not expected compared to the number of writes performed")
-	errIdentityFailure = errors.New("unable to determine the identity of the inserted row(s)")
+	errRowsAffected      = errors.New("the number of modified rows was not expected compared to the number of writes performed")
+	errIdentityFailure   = errors.New("unable to determine the identity of the inserted row(s)")
+	errUnsupportedHeader = errors.New("the type of the header value is not supported")
+	errInvalidPageSize   = errors.New("the page size must be greater than zero")
 )
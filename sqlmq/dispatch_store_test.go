@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"io"
+	"math"
 	"testing"
 	"time"
 
@@ -43,7 +45,7 @@ type DispatchStoreFixture struct {
 func (this *DispatchStoreFixture) Setup() {
 	this.now = time.Now().UTC()
 	this.ctx = context.Background()
-	this.store = newMessageStore(this, func() time.Time { return this.now })
+	this.store = newMessageStore(this, MySQL, "Messages", 2, func() time.Time { return this.now })
 }
 
 func (this *DispatchStoreFixture) TestWhenNoDispatchesToWrite_DoNotPerformWriteOperation() {
@@ -69,18 +71,116 @@ func (this *DispatchStoreFixture) TestWhenStoring_WriteToUnderlyingStoreAndMarkD
 	this.So(err, should.BeNil)
 
 	this.So(this.execContext, should.Equal, this.ctx)
-	this.So(this.execStatement, should.Equal, "INSERT INTO Messages (type, payload) VALUES (?,?),(?,?),(?,?);")
+}
+func (this *DispatchStoreFixture) TestWhenStoring_InsertEachDispatchAsRowOfSingleStatementAndNumberConsecutively() {
+	this.rowsAffectedValue = 3
+	this.lastInsertID = 42
+	writes := []messaging.Dispatch{
+		{MessageType: "1", Payload: []byte("a")},
+		{MessageType: "2", Payload: []byte("b")},
+		{MessageType: "3", Payload: []byte("c")},
+	}
+
+	err := this.store.Store(this.ctx, this, writes)
+
+	this.So(err, should.BeNil)
+	this.So(this.execCalls, should.Equal, 1)
+	this.So(this.execStatement, should.Equal, "INSERT INTO Messages (type, payload, deliver_at, priority, source_id, "+
+		"correlation_id, created, expiration, durable, topic, partition_id, content_type, content_encoding, headers) VALUES "+
+		"(?,?,?,?,?,?,?,?,?,?,?,?,?,?),(?,?,?,?,?,?,?,?,?,?,?,?,?,?),(?,?,?,?,?,?,?,?,?,?,?,?,?,?);")
+	stamped := this.now.Truncate(time.Millisecond)
 	this.So(this.execArgs, should.Resemble, []interface{}{
-		"1", []byte("a"),
-		"2", []byte("b"),
-		"3", []byte("c"),
+		"1", []byte("a"), nil, uint8(0), int64(0), int64(0), stamped, int64(0), uint8(0), "", int64(0), "", "", nil,
+		"2", []byte("b"), nil, uint8(0), int64(0), int64(0), stamped, int64(0), uint8(0), "", int64(0), "", "", nil,
+		"3", []byte("c"), nil, uint8(0), int64(0), int64(0), stamped, int64(0), uint8(0), "", int64(0), "", "", nil,
 	})
 
 	this.So(writes, should.Resemble, []messaging.Dispatch{
-		{MessageID: 42, MessageType: "1", Payload: []byte("a")},
-		{MessageID: 43, MessageType: "2", Payload: []byte("b")},
-		{MessageID: 44, MessageType: "3", Payload: []byte("c")},
+		{MessageID: 42, MessageType: "1", Payload: []byte("a"), Timestamp: stamped},
+		{MessageID: 43, MessageType: "2", Payload: []byte("b"), Timestamp: stamped},
+		{MessageID: 44, MessageType: "3", Payload: []byte("c"), Timestamp: stamped},
+	})
+}
+func (this *DispatchStoreFixture) TestWhenStoringScheduledDispatch_PersistDeliveryTime() {
+	this.rowsAffectedValue = 1
+	this.lastInsertID = 42
+	deliverAt := time.Date(2020, 1, 2, 14, 0, 0, 0, time.FixedZone("", 3600))
+
+	err := this.store.Store(this.ctx, this, []messaging.Dispatch{{MessageType: "1", Payload: []byte("a"), DeliverAt: deliverAt}})
+
+	this.So(err, should.BeNil)
+	this.So(this.execArgs[0:4], should.Resemble, []interface{}{"1", []byte("a"), deliverAt.UTC(), uint8(0)})
+}
+func (this *DispatchStoreFixture) TestWhenStoringPrioritizedDispatch_PersistPriority() {
+	this.rowsAffectedValue = 1
+	this.lastInsertID = 42
+
+	err := this.store.Store(this.ctx, this, []messaging.Dispatch{{MessageType: "1", Payload: []byte("a"), Priority: 9}})
+
+	this.So(err, should.BeNil)
+	this.So(this.execArgs[0:4], should.Resemble, []interface{}{"1", []byte("a"), nil, uint8(9)})
+}
+func (this *DispatchStoreFixture) TestWhenStoringCompleteDispatch_PersistEntireEnvelope() {
+	this.rowsAffectedValue = 1
+	this.lastInsertID = 42
+	timestamp := time.Date(2020, 1, 2, 14, 0, 0, 0, time.FixedZone("", 3600))
+	headers := map[string]interface{}{"a": int32(1), "b": "2", "c": time.Date(2020, 1, 2, 14, 0, 0, 0, time.UTC)}
+
+	err := this.store.Store(this.ctx, this, []messaging.Dispatch{{
+		SourceID:        math.MaxUint64,
+		CorrelationID:   2,
+		Timestamp:       timestamp,
+		Expiration:      time.Second,
+		Durable:         true,
+		Topic:           "topic",
+		Partition:       3,
+		MessageType:     "type",
+		ContentType:     "content-type",
+		ContentEncoding: "content-encoding",
+		Payload:         []byte("a"),
+		Headers:         headers,
+	}})
+
+	this.So(err, should.BeNil)
+	this.So(this.execArgs[0:13], should.Resemble, []interface{}{
+		"type", []byte("a"), nil, uint8(0), int64(-1), int64(2), timestamp.UTC(), int64(time.Second), uint8(1), "topic",
+		int64(3), "content-type", "content-encoding",
 	})
+	decoded, err := decodeHeaders(this.execArgs[13].([]byte))
+	this.So(err, should.BeNil)
+	this.So(decoded, should.Resemble, headers)
+}
+func (this *DispatchStoreFixture) TestWhenStoringHeadersWhichCannotBeEncoded_ReturnError() {
+	err := this.store.Store(this.ctx, this, []messaging.Dispatch{{Headers: map[string]interface{}{"a": struct{}{}}}})
+
+	this.So(errors.Is(err, errUnsupportedHeader), should.BeTrue)
+	this.So(this.execCalls, should.BeZeroValue)
+}
+func (this *DispatchStoreFixture) TestHeadersEncodedAsJSONWithTypeOfEachValue() {
+	headers := map[string]interface{}{
+		"a": int32(1),
+		"b": map[string]interface{}{"c": []interface{}{"d", 2.5, nil}},
+		"e": []byte("f"),
+		"g": time.Date(2020, 1, 2, 14, 0, 0, 0, time.UTC),
+	}
+
+	encoded, encodeErr := encodeHeaders(headers)
+	decoded, decodeErr := decodeHeaders(encoded.([]byte))
+
+	this.So(encodeErr, should.BeNil)
+	this.So(string(encoded.([]byte)), should.Equal, `{"a":{"type":"int32","value":1},`+
+		`"b":{"type":"table","value":{"c":{"type":"array","value":[{"type":"string","value":"d"},`+
+		`{"type":"float64","value":2.5},{"type":"nil"}]}}},"e":{"type":"[]uint8","value":"Zg=="},`+
+		`"g":{"type":"time.Time","value":"2020-01-02T14:00:00Z"}}`)
+	this.So(decodeErr, should.BeNil)
+	this.So(decoded, should.Resemble, headers)
+}
+func (this *DispatchStoreFixture) TestWhenDecodingHeadersOfUnknownType_ReturnError() {
+	_, unknownErr := decodeHeaders([]byte(`{"a":{"type":"complex128","value":1}}`))
+	_, malformedErr := decodeHeaders([]byte(`{"a":`))
+
+	this.So(errors.Is(unknownErr, errUnsupportedHeader), should.BeTrue)
+	this.So(malformedErr, should.NotBeNil)
 }
 func (this *DispatchStoreFixture) TestWhenStoreWriteFails_ReturnErrorDoNotCommitOrSendToOutputChannel() {
 	this.execError = errors.New("")
@@ -107,29 +207,50 @@ func (this *DispatchStoreFixture) TestWhenLastInsertIDCannotBeDetermined_ReturnE
 }
 
 func (this *DispatchStoreFixture) TestWhenLoading_ItShouldQueryUnderlingStorage() {
+	timestamp := time.Date(2020, 1, 2, 13, 0, 0, 0, time.UTC)
 	expected := []messaging.Dispatch{
-		{MessageID: 42, MessageType: "message-type1", Payload: []byte{4}},
-		{MessageID: 43, MessageType: "message-type2", Payload: []byte{5}},
-		{MessageID: 44, MessageType: "message-type3", Payload: []byte{6}},
+		{MessageID: 42, MessageType: "message-type1", Payload: []byte{4}, Timestamp: timestamp},
+		{MessageID: 43, MessageType: "message-type2", Payload: []byte{5}, Timestamp: timestamp, Priority: 9},
+		{MessageID: 44, MessageType: "message-type3", Payload: []byte{6}, Timestamp: timestamp, DeliverAt: time.Date(2020, 1, 2, 14, 0, 0, 0, time.UTC)},
+		{
+			MessageID:       45,
+			SourceID:        math.MaxUint64,
+			CorrelationID:   2,
+			Timestamp:       timestamp,
+			Expiration:      time.Second,
+			Durable:         true,
+			Topic:           "topic",
+			Partition:       3,
+			MessageType:     "message-type4",
+			ContentType:     "content-type",
+			ContentEncoding: "content-encoding",
+			Payload:         []byte{7},
+			Headers:         map[string]interface{}{"a": int32(1), "b": []interface{}{"2", 3.0}},
+		},
 	}
 	this.queryResult = &storageQueryResult{items: expected}
 
-	results, err := this.store.Load(this.ctx, 42)
+	results, err := this.store.Load(this.ctx, 42, this.now)
 
-	this.So(results, should.Resemble, []messaging.Dispatch{
-		{MessageID: 42, MessageType: "message-type1", Topic: "message-type1", Payload: []byte{4}, Timestamp: this.now, Durable: true, ContentType: "application/json"},
-		{MessageID: 43, MessageType: "message-type2", Topic: "message-type2", Payload: []byte{5}, Timestamp: this.now, Durable: true, ContentType: "application/json"},
-		{MessageID: 44, MessageType: "message-type3", Topic: "message-type3", Payload: []byte{6}, Timestamp: this.now, Durable: true, ContentType: "application/json"},
-	})
+	this.So(results, should.Resemble, expected)
 	this.So(err, should.BeNil)
-	this.So(this.queryStatement, should.Equal, "SELECT id, type, payload FROM Messages WHERE dispatched IS NULL AND id > 42;")
-	this.So(this.queryArgs, should.BeEmpty)
+	this.So(this.queryStatement, should.Equal, "SELECT id, type, payload, deliver_at, priority, source_id, correlation_id, "+
+		"created, expiration, durable, topic, partition_id, content_type, content_encoding, headers "+
+		"FROM Messages WHERE dispatched IS NULL AND id > ? AND (deliver_at IS NULL OR deliver_at <= ?) ORDER BY id LIMIT 2;")
+	this.So(this.queryArgs, should.Resemble, []interface{}{int64(42), this.now})
 	this.So(this.queryResult.closeCount, should.Equal, 1)
 }
+func (this *DispatchStoreFixture) TestWhenLoadingRowStoredWithoutTimestamp_AssignCurrentTime() {
+	this.queryResult = &storageQueryResult{items: []messaging.Dispatch{{MessageID: 42}}}
+
+	results, _ := this.store.Load(this.ctx, 0, this.now)
+
+	this.So(results, should.Resemble, []messaging.Dispatch{{MessageID: 42, Timestamp: this.now}})
+}
 func (this *DispatchStoreFixture) TestWhenLoadingQueryFails_ItShouldReturnError() {
 	this.queryError = errors.New("")
 
-	results, err := this.store.Load(this.ctx, 42)
+	results, err := this.store.Load(this.ctx, 42, this.now)
 
 	this.So(results, should.BeEmpty)
 	this.So(err, should.Equal, this.queryError)
@@ -140,7 +261,7 @@ func (this *DispatchStoreFixture) TestWhenLoadingQueryScanFails_ItShouldReturnEr
 		scanError: errors.New(""),
 	}
 
-	results, err := this.store.Load(this.ctx, 42)
+	results, err := this.store.Load(this.ctx, 42, this.now)
 
 	this.So(results, should.BeEmpty)
 	this.So(err, should.Equal, this.queryResult.scanError)
@@ -149,13 +270,59 @@ func (this *DispatchStoreFixture) TestWhenLoadingQueryScanFails_ItShouldReturnEr
 func (this *DispatchStoreFixture) TestWhenLoadingQueryRowIterationsFails_ItShouldReturnError() {
 	this.queryResult = &storageQueryResult{errError: errors.New("")}
 
-	results, err := this.store.Load(this.ctx, 42)
+	results, err := this.store.Load(this.ctx, 42, this.now)
 
 	this.So(results, should.BeEmpty)
 	this.So(err, should.Equal, this.queryResult.errError)
 	this.So(this.queryResult.closeCount, should.Equal, 1)
 }
+func (this *DispatchStoreFixture) TestWhenSweeping_QueryBoundedBatchOfRowsInsertedBeforeTheGivenTime() {
+	before := time.Date(2020, 1, 2, 13, 0, 0, 0, time.UTC)
+	expected := []messaging.Dispatch{{MessageID: 43, MessageType: "message-type", Payload: []byte{1}, Timestamp: before}}
+	this.queryResult = &storageQueryResult{items: expected}
+
+	results, err := this.store.Sweep(this.ctx, 42, before, 10)
+
+	this.So(results, should.Resemble, expected)
+	this.So(err, should.BeNil)
+	this.So(this.queryStatement, should.Equal, "SELECT id, "+storedColumns+" FROM Messages WHERE dispatched IS NULL "+
+		"AND id > ? AND (inserted IS NULL OR inserted < ?) AND (deliver_at IS NULL OR deliver_at <= ?) ORDER BY id LIMIT 10;")
+	this.So(this.queryArgs, should.Resemble, []interface{}{int64(42), before, this.now})
+	this.So(this.queryResult.closeCount, should.Equal, 1)
+}
+func (this *DispatchStoreFixture) TestWhenLoadingDue_QueryPageOfRowsScheduledWithinTheGivenTimes() {
+	after := time.Date(2020, 1, 2, 13, 0, 0, 0, time.UTC)
+	expected := []messaging.Dispatch{{MessageID: 43, MessageType: "message-type", Payload: []byte{1}, Timestamp: after}}
+	this.queryResult = &storageQueryResult{items: expected}
+
+	results, err := this.store.LoadDue(this.ctx, 42, after, this.now)
+
+	this.So(results, should.Resemble, expected)
+	this.So(err, should.BeNil)
+	this.So(this.queryStatement, should.Equal, "SELECT id, "+storedColumns+" FROM Messages WHERE dispatched IS NULL "+
+		"AND id > ? AND deliver_at > ? AND deliver_at <= ? ORDER BY id LIMIT 2;")
+	this.So(this.queryArgs, should.Resemble, []interface{}{int64(42), after, this.now})
+	this.So(this.queryResult.closeCount, should.Equal, 1)
+}
 
+func (this *DispatchStoreFixture) TestScanningStorageTimes() {
+	var value nullableTime
+
+	this.So(value.Scan(nil), should.BeNil)
+	this.So(value.Time, should.BeZeroValue)
+
+	this.So(value.Scan([]byte("2020-01-02 14:00:00.123")), should.BeNil)
+	this.So(value.Time, should.Equal, time.Date(2020, 1, 2, 14, 0, 0, 123000000, time.UTC))
+
+	this.So(value.Scan("2020-01-02T14:00:00Z"), should.BeNil)
+	this.So(value.Time, should.Equal, time.Date(2020, 1, 2, 14, 0, 0, 0, time.UTC))
+
+	this.So(value.Scan(time.Date(2020, 1, 2, 15, 0, 0, 0, time.FixedZone("", 3600))), should.BeNil)
+	this.So(value.Time, should.Equal, time.Date(2020, 1, 2, 14, 0, 0, 0, time.UTC))
+
+	this.So(value.Scan("garbage"), should.NotBeNil)
+	this.So(value.Scan(42), should.NotBeNil)
+}
 func (this *DispatchStoreFixture) TestConfirmNothing_NoOperationsPerformed() {
 	err := this.store.Confirm(this.ctx, nil)
 
@@ -171,9 +338,137 @@ func (this *DispatchStoreFixture) TestConfirmedDispatches_WrittenToUnderlyingSto
 
 	this.So(err, should.Equal, this.execError)
 	this.So(this.execContext, should.Equal, this.ctx)
-	this.So(this.execArgs, should.BeEmpty)
+}
+func (this *DispatchStoreFixture) TestWhenConfirmFails_ReturnErrorWithoutConfirmingRemainingPages() {
+	this.now = time.Date(2020, 01, 02, 12, 30, 15, 37, time.UTC)
+	this.execError = errors.New("")
+	writes := []messaging.Dispatch{{MessageID: 1}, {MessageID: 2}, {MessageID: 3}}
+
+	err := this.store.Confirm(this.ctx, writes)
+
+	this.So(err, should.Equal, this.execError)
+	this.So(this.execCalls, should.Equal, 1)
+	this.So(this.execArgs, should.Resemble, []interface{}{this.now, int64(1), int64(2)})
+	this.So(this.execStatement, should.Equal,
+		"UPDATE Messages SET dispatched = ? WHERE dispatched IS NULL AND id IN (?, ?);")
+}
+func (this *DispatchStoreFixture) TestWhenConfirmingMoreThanPage_ConfirmEachPageSeparately() {
+	writes := []messaging.Dispatch{{MessageID: 1}, {MessageID: 2}, {MessageID: 3}}
+
+	err := this.store.Confirm(this.ctx, writes)
+
+	this.So(err, should.BeNil)
+	this.So(this.execCalls, should.Equal, 2)
+	this.So(this.execArgs, should.Resemble, []interface{}{this.now, int64(3)})
 	this.So(this.execStatement, should.Equal,
-		"UPDATE Messages SET dispatched = '2020-01-02 12:30:15.000000' WHERE dispatched IS NULL AND id IN (1, 2, 3);")
+		"UPDATE Messages SET dispatched = ? WHERE dispatched IS NULL AND id IN (?);")
+}
+func (this *DispatchStoreFixture) TestWhenHandlePreparesStatements_LoadAndConfirmArePreparedOnceAndClosedWithStore() {
+	handle := &preparingHandle{DispatchStoreFixture: this}
+	this.store = newMessageStore(handle, MySQL, "Messages", 2, func() time.Time { return this.now })
+	this.queryResult = &storageQueryResult{}
+	full := []messaging.Dispatch{{MessageID: 1}, {MessageID: 2}}
+
+	_, _ = this.store.Load(this.ctx, 0, this.now)
+	_, _ = this.store.Load(this.ctx, 1, this.now)
+	_ = this.store.Confirm(this.ctx, full)
+	_ = this.store.Confirm(this.ctx, full)
+	_ = this.store.Confirm(this.ctx, []messaging.Dispatch{{MessageID: 3}}) // a partial page isn't worth preparing
+
+	this.So(handle.prepared, should.HaveLength, 2)
+	this.So(handle.prepared[0].statement, should.StartWith, "SELECT id, ")
+	this.So(handle.prepared[0].queryCalls, should.Equal, 2)
+	this.So(handle.prepared[1].statement, should.Equal,
+		"UPDATE Messages SET dispatched = ? WHERE dispatched IS NULL AND id IN (?, ?);")
+	this.So(handle.prepared[1].execCalls, should.Equal, 2)
+	this.So(handle.prepared[1].execArgs, should.Resemble, []interface{}{this.now, int64(1), int64(2)})
+	this.So(this.execCalls, should.Equal, 1)
+	this.So(this.execArgs, should.Resemble, []interface{}{this.now, int64(3)})
+
+	this.So(this.store.(io.Closer).Close(), should.BeNil)
+
+	this.So(handle.prepared[0].closeCalls, should.Equal, 1)
+	this.So(handle.prepared[1].closeCalls, should.Equal, 1)
+	_ = this.store.Confirm(this.ctx, full)
+	this.So(handle.prepared, should.HaveLength, 2)
+	this.So(this.execCalls, should.Equal, 2)
+}
+func (this *DispatchStoreFixture) TestWhenPreparingFails_ReturnError() {
+	handle := &preparingHandle{DispatchStoreFixture: this, prepareError: errors.New("")}
+	this.store = newMessageStore(handle, MySQL, "Messages", 2, func() time.Time { return this.now })
+
+	_, err := this.store.Load(this.ctx, 0, this.now)
+
+	this.So(err, should.Equal, handle.prepareError)
+	this.So(this.queryStatement, should.BeEmpty)
+}
+
+func (this *DispatchStoreFixture) TestWhenStoringWithPostgreSQL_NumberPlaceholdersAndReturnIdentities() {
+	this.store = newMessageStore(this, PostgreSQL, "Messages", 2, func() time.Time { return this.now })
+	this.queryResult = &storageQueryResult{items: []messaging.Dispatch{{MessageID: 7}, {MessageID: 8}}}
+	writes := []messaging.Dispatch{{MessageType: "1", Payload: []byte("a")}, {MessageType: "2", Payload: []byte("b")}}
+
+	err := this.store.Store(this.ctx, this, writes)
+
+	this.So(err, should.BeNil)
+	this.So(this.execCalls, should.BeZeroValue)
+	this.So(this.queryStatement, should.Equal,
+		"INSERT INTO Messages ("+storedColumns+") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14),"+
+			"($15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27,$28) RETURNING id;")
+	this.So(this.queryArgs, should.HaveLength, 28)
+	this.So(this.queryArgs[14], should.Equal, "2")
+	this.So(writes[0].MessageID, should.Equal, 7)
+	this.So(writes[1].MessageID, should.Equal, 8)
+	this.So(this.queryResult.closeCount, should.Equal, 1)
+}
+func (this *DispatchStoreFixture) TestWhenPostgreSQLReturnsFewerIdentitiesThanWrites_ReturnError() {
+	this.store = newMessageStore(this, PostgreSQL, "Messages", 2, func() time.Time { return this.now })
+	this.queryResult = &storageQueryResult{items: []messaging.Dispatch{{MessageID: 7}}}
+	writes := []messaging.Dispatch{{MessageType: "1"}, {MessageType: "2"}}
+
+	err := this.store.Store(this.ctx, this, writes)
+
+	this.So(err, should.Equal, errRowsAffected)
+	this.So(writes[0].MessageID, should.BeZeroValue)
+}
+func (this *DispatchStoreFixture) TestWhenPostgreSQLReturnsInvalidIdentity_ReturnError() {
+	this.store = newMessageStore(this, PostgreSQL, "Messages", 2, func() time.Time { return this.now })
+	this.queryResult = &storageQueryResult{items: []messaging.Dispatch{{MessageID: 0}}}
+
+	err := this.store.Store(this.ctx, this, []messaging.Dispatch{{MessageType: "1"}})
+
+	this.So(err, should.Equal, errIdentityFailure)
+}
+func (this *DispatchStoreFixture) TestWhenPostgreSQLInsertFails_ReturnError() {
+	this.store = newMessageStore(this, PostgreSQL, "Messages", 2, func() time.Time { return this.now })
+	this.queryError = errors.New("")
+
+	err := this.store.Store(this.ctx, this, []messaging.Dispatch{{MessageType: "1"}})
+
+	this.So(err, should.Equal, this.queryError)
+}
+func (this *DispatchStoreFixture) TestWhenConfirmingWithPostgreSQL_NumberPlaceholder() {
+	this.store = newMessageStore(this, PostgreSQL, "Messages", 2, func() time.Time { return this.now })
+
+	_ = this.store.Confirm(this.ctx, []messaging.Dispatch{{MessageID: 1}, {MessageID: 2}})
+
+	this.So(this.execStatement, should.Equal, "UPDATE Messages SET dispatched = $1 WHERE dispatched IS NULL AND id IN ($2, $3);")
+	this.So(this.execArgs, should.Resemble, []interface{}{this.now, int64(1), int64(2)})
+}
+func (this *DispatchStoreFixture) TestWhenUsingCustomTableName_StatementsReferToIt() {
+	this.store = newMessageStore(this, MySQL, "outbox.Messages", 2, func() time.Time { return this.now })
+	this.rowsAffectedValue = 1
+	this.lastInsertID = 1
+	this.queryResult = &storageQueryResult{}
+
+	_ = this.store.Store(this.ctx, this, []messaging.Dispatch{{MessageType: "a"}})
+	this.So(this.execStatement, should.StartWith, "INSERT INTO outbox.Messages (type, payload, ")
+
+	_, _ = this.store.Load(this.ctx, 0, this.now)
+	this.So(this.queryStatement, should.ContainSubstring, " FROM outbox.Messages ")
+
+	_ = this.store.Confirm(this.ctx, []messaging.Dispatch{{MessageID: 1}})
+	this.So(this.execStatement, should.StartWith, "UPDATE outbox.Messages SET dispatched = ? ")
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -203,6 +498,44 @@ func (this *DispatchStoreFixture) QueryRowContext(ctx context.Context, statement
 	panic("nop")
 }
 
+type preparingHandle struct {
+	*DispatchStoreFixture
+	prepared     []*preparedStatement
+	prepareError error
+}
+
+func (this *preparingHandle) PrepareContext(_ context.Context, statement string) (adapter.Statement, error) {
+	if this.prepareError != nil {
+		return nil, this.prepareError
+	}
+	prepared := &preparedStatement{statement: statement, fixture: this.DispatchStoreFixture}
+	this.prepared = append(this.prepared, prepared)
+	return prepared, nil
+}
+
+type preparedStatement struct {
+	fixture    *DispatchStoreFixture
+	statement  string
+	queryCalls int
+	execCalls  int
+	execArgs   []interface{}
+	closeCalls int
+}
+
+func (this *preparedStatement) QueryContext(_ context.Context, _ ...interface{}) (adapter.QueryResult, error) {
+	this.queryCalls++
+	return this.fixture.queryResult, nil
+}
+func (this *preparedStatement) ExecContext(_ context.Context, args ...interface{}) (sql.Result, error) {
+	this.execCalls++
+	this.execArgs = args
+	return this.fixture, nil
+}
+func (this *preparedStatement) Close() error {
+	this.closeCalls++
+	return nil
+}
+
 type storageQueryResult struct {
 	scanError  error
 	errError   error
@@ -221,12 +554,43 @@ func (this *storageQueryResult) Scan(fields ...interface{}) error {
 			*(fields[i].(*string)) = item.MessageType
 		case 2:
 			*(fields[i].(*[]byte)) = item.Payload
+		case 3:
+			_ = fields[i].(sql.Scanner).Scan(storageTime(item.DeliverAt))
+		case 4:
+			*(fields[i].(*uint8)) = item.Priority
+		case 5:
+			*(fields[i].(*int64)) = int64(item.SourceID)
+		case 6:
+			*(fields[i].(*int64)) = int64(item.CorrelationID)
+		case 7:
+			_ = fields[i].(sql.Scanner).Scan(storageTime(item.Timestamp))
+		case 8:
+			*(fields[i].(*int64)) = int64(item.Expiration)
+		case 9:
+			*(fields[i].(*bool)) = item.Durable
+		case 10:
+			*(fields[i].(*string)) = item.Topic
+		case 11:
+			*(fields[i].(*int64)) = int64(item.Partition)
+		case 12:
+			*(fields[i].(*string)) = item.ContentType
+		case 13:
+			*(fields[i].(*string)) = item.ContentEncoding
+		case 14:
+			encoded, _ := encodeHeaders(item.Headers)
+			*(fields[i].(*[]byte)), _ = encoded.([]byte)
 		default:
 			panic("bad scan")
 		}
 	}
 	return this.scanError
 }
+func storageTime(value time.Time) interface{} {
+	if value.IsZero() {
+		return nil
+	}
+	return []byte(value.Format("2006-01-02 15:04:05.000")) // e.g. MySQL without parseTime
+}
 func (this *storageQueryResult) Next() bool {
 	this.index++
 	return this.index <= len(this.items)
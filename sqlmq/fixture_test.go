@@ -0,0 +1,9 @@
+package sqlmq
+
+// nopMonitor observes only the events every monitor must, and ignores them. Fixtures embed it and observe the rest.
+type nopMonitor struct{}
+
+func (nopMonitor) MessageReceived(int)  {}
+func (nopMonitor) MessageStored(int)    {}
+func (nopMonitor) MessagePublished(int) {}
+func (nopMonitor) MessageConfirmed(int) {}
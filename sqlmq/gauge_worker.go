@@ -0,0 +1,104 @@
+package sqlmq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
+)
+
+// gaugeWorker periodically reports how far the outbox has fallen behind: the number of rows due but not yet dispatched,
+// how long the oldest of them has been due, and the number of dispatches waiting in the channel to be written to the
+// sender. Rows scheduled for later delivery aren't behind until they're due.
+type gaugeWorker struct {
+	ctx      context.Context
+	shutdown context.CancelFunc
+	db       adapter.Handle
+	dialect  Dialect
+	table    string
+	channel  chan messaging.Dispatch
+	interval time.Duration
+	now      func() time.Time
+	logger   logger
+	monitor  lagMonitor
+}
+
+func newGaugeWorker(config configuration) messaging.ListenCloser {
+	ctx, shutdown := context.WithCancel(config.Context)
+	return &gaugeWorker{
+		ctx:      ctx,
+		shutdown: shutdown,
+		db:       config.StorageHandle,
+		dialect:  config.Dialect,
+		table:    config.TableName,
+		channel:  config.Channel,
+		interval: config.GaugeInterval,
+		now:      config.Now,
+		logger:   config.Logger,
+		monitor:  newLagMonitor(config.Monitor),
+	}
+}
+func newLagMonitor(value monitor) lagMonitor {
+	if monitor, ok := value.(lagMonitor); ok {
+		return monitor
+	}
+	return nop{}
+}
+
+func (this *gaugeWorker) Listen() {
+	for this.isAlive() {
+		this.measure()
+		this.sleep()
+	}
+}
+func (this *gaugeWorker) measure() {
+	this.monitor.ChannelDepth(len(this.channel))
+
+	count, oldest, err := this.pending()
+	if err != nil {
+		this.logger.Printf("[WARN] Unable to count undispatched messages in durable storage [%s].", err)
+		return
+	}
+
+	var age time.Duration
+	if !oldest.IsZero() {
+		age = this.now().Sub(oldest)
+	}
+
+	this.monitor.PendingMessages(count, age)
+}
+func (this *gaugeWorker) pending() (count int, oldest time.Time, err error) {
+	// a row is due once it's inserted or, when scheduled, once its delivery time arrives (whichever is later)
+	const statementFormat = "SELECT COUNT(*), MIN(CASE WHEN deliver_at > inserted THEN deliver_at ELSE inserted END) " +
+		"FROM %s WHERE dispatched IS NULL AND (deliver_at IS NULL OR deliver_at <= %s);"
+	statement := fmt.Sprintf(statementFormat, this.table, this.dialect.Placeholder(1))
+
+	var due nullableTime // rows inserted before the column was introduced may have no insertion time
+	err = this.db.QueryRowContext(this.ctx, statement, this.dialect.Timestamp(this.now())).Scan(&count, &due)
+	return count, due.Time, err
+}
+
+func (this *gaugeWorker) isAlive() bool {
+	select {
+	case <-this.ctx.Done():
+		return false
+	default:
+		return true
+	}
+}
+func (this *gaugeWorker) sleep() {
+	timer := time.NewTimer(this.interval)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-this.ctx.Done():
+	}
+}
+
+func (this *gaugeWorker) Close() error {
+	this.shutdown()
+	return nil
+}
@@ -0,0 +1,156 @@
+package sqlmq
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
+)
+
+func TestGaugeWorkerFixture(t *testing.T) {
+	gunit.Run(new(GaugeWorkerFixture), t)
+}
+
+type GaugeWorkerFixture struct {
+	*gunit.Fixture
+	nopMonitor
+
+	now     time.Time
+	channel chan messaging.Dispatch
+	worker  *gaugeWorker
+
+	queryStatement string
+	queryArgs      []interface{}
+	queryCount     int
+	queryDue       interface{}
+	queryError     error
+
+	pending []int
+	ages    []time.Duration
+	depths  []int
+	logged  []string
+}
+
+func (this *GaugeWorkerFixture) Setup() {
+	this.now = time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	this.channel = make(chan messaging.Dispatch, 8)
+
+	config := configuration{}
+	Options.apply(
+		Options.StorageHandle(&sql.DB{}),
+		Options.Dialect(SQLite),
+		Options.Channel(this.channel),
+		Options.GaugeInterval(time.Millisecond),
+		Options.Now(func() time.Time { return this.now }),
+		Options.Logger(this),
+		Options.Monitor(this),
+	)(&config)
+	config.StorageHandle = this
+	this.worker = newGaugeWorker(config).(*gaugeWorker)
+}
+
+func (this *GaugeWorkerFixture) TestReportUndispatchedRowsAndAgeOfOldest() {
+	this.queryCount, this.queryDue = 2, SQLite.Timestamp(this.now.Add(-time.Minute))
+	this.channel <- messaging.Dispatch{}
+	this.channel <- messaging.Dispatch{}
+
+	this.worker.measure()
+
+	this.So(this.queryStatement, should.Equal, "SELECT COUNT(*), MIN(CASE WHEN deliver_at > inserted THEN deliver_at "+
+		"ELSE inserted END) FROM Messages WHERE dispatched IS NULL AND (deliver_at IS NULL OR deliver_at <= ?);")
+	this.So(this.queryArgs, should.Resemble, []interface{}{SQLite.Timestamp(this.now)})
+	this.So(this.pending, should.Resemble, []int{2})
+	this.So(this.ages, should.Resemble, []time.Duration{time.Minute})
+	this.So(this.depths, should.Resemble, []int{2})
+	this.So(this.logged, should.BeEmpty)
+}
+func (this *GaugeWorkerFixture) TestWhenOldestRowHasNoInsertionTime_ReportNoAge() {
+	this.queryCount = 1 // e.g. inserted before the column was introduced, or when nothing is undispatched
+
+	this.worker.measure()
+
+	this.So(this.pending, should.Resemble, []int{1})
+	this.So(this.ages, should.Resemble, []time.Duration{0})
+	this.So(this.depths, should.Resemble, []int{0})
+}
+func (this *GaugeWorkerFixture) TestWhenQueryFails_LogAndReportOnlyChannelDepth() {
+	this.queryError = errors.New("")
+
+	this.worker.measure()
+
+	this.So(this.pending, should.BeEmpty)
+	this.So(this.depths, should.Resemble, []int{0})
+	this.So(this.logged, should.HaveLength, 1)
+	this.So(this.logged[0], should.StartWith, "[WARN] Unable to count undispatched messages")
+}
+func (this *GaugeWorkerFixture) TestWhenListening_MeasureRepeatedlyUntilClosed() {
+	done := make(chan struct{})
+	go func() {
+		this.worker.Listen()
+		close(done)
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	_ = this.worker.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		this.So("Listen should exit once closed", should.BeEmpty)
+	}
+	this.So(len(this.depths), should.BeGreaterThan, 1)
+}
+func (this *GaugeWorkerFixture) TestWhenGaugeIntervalIsNotConfigured_NoWorkerIsCreated() {
+	config := configuration{}
+	Options.apply(Options.StorageHandle(&sql.DB{}))(&config)
+
+	this.So(config.Gauges, should.BeNil)
+}
+
+func (this *GaugeWorkerFixture) TestWhenMonitorDoesNotObserveLag_NothingIsReported() {
+	this.So(newLagMonitor(this), should.Equal, this)
+	this.So(newLagMonitor(nopMonitor{}), should.Resemble, nop{})
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *GaugeWorkerFixture) Printf(format string, args ...interface{}) {
+	this.logged = append(this.logged, fmt.Sprintf(format, args...))
+}
+
+func (this *GaugeWorkerFixture) PendingMessages(count int, oldest time.Duration) {
+	this.pending = append(this.pending, count)
+	this.ages = append(this.ages, oldest)
+}
+func (this *GaugeWorkerFixture) ChannelDepth(count int) {
+	this.depths = append(this.depths, count)
+}
+func (this *GaugeWorkerFixture) ConfirmLatency(_ time.Duration) {}
+
+func (this *GaugeWorkerFixture) QueryRowContext(_ context.Context, statement string, args ...interface{}) adapter.RowScanner {
+	this.queryStatement, this.queryArgs = statement, args
+	return this
+}
+func (this *GaugeWorkerFixture) Scan(fields ...interface{}) error {
+	*fields[0].(*int) = this.queryCount
+	_ = fields[1].(sql.Scanner).Scan(this.queryDue)
+	return this.queryError
+}
+func (this *GaugeWorkerFixture) QueryContext(_ context.Context, _ string, _ ...interface{}) (adapter.QueryResult, error) {
+	panic("nop")
+}
+func (this *GaugeWorkerFixture) ExecContext(_ context.Context, _ string, _ ...interface{}) (sql.Result, error) {
+	panic("nop")
+}
+func (this *GaugeWorkerFixture) BeginTx(_ context.Context, _ *sql.TxOptions) (adapter.Transaction, error) {
+	panic("nop")
+}
+func (this *GaugeWorkerFixture) DBHandle() *sql.DB { panic("nop") }
+func (this *GaugeWorkerFixture) Close() error      { panic("nop") }
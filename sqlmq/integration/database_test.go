@@ -0,0 +1,199 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	_ "github.com/lib/pq"
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3/sqlmq"
+	_ "modernc.org/sqlite"
+)
+
+// Each fixture runs against SQLite, in process, and against PostgreSQL. The PostgreSQL server is the one named by
+// SQLMQ_POSTGRES (a data source name) or otherwise a server downloaded (once) and started by the embedded-postgres
+// package, as an unprivileged user. Every PostgreSQL test starts from an empty schema of the same database, so those
+// tests run sequentially.
+
+const postgresPort = 54329
+
+var postgres struct {
+	once       sync.Once
+	dataSource string
+	server     *embeddedpostgres.EmbeddedPostgres
+	directory  string
+	err        error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	stopPostgreSQL()
+	os.Exit(code)
+}
+
+func requirePostgreSQL(t *testing.T) {
+	postgres.once.Do(startPostgreSQL)
+	if postgres.err != nil {
+		t.Fatalf("Unable to start PostgreSQL: %s", postgres.err)
+	}
+}
+func startPostgreSQL() {
+	if postgres.dataSource = os.Getenv("SQLMQ_POSTGRES"); len(postgres.dataSource) > 0 {
+		return
+	}
+
+	if postgres.directory, postgres.err = ioutil.TempDir("", "sqlmq"); postgres.err != nil {
+		return
+	}
+
+	output := new(bytes.Buffer)
+	postgres.server = embeddedpostgres.NewDatabase(embeddedpostgres.DefaultConfig().
+		Port(postgresPort).
+		RuntimePath(filepath.Join(postgres.directory, "runtime")).
+		CachePath(filepath.Join(os.TempDir(), "sqlmq-postgres")).
+		Logger(output))
+	if err := postgres.server.Start(); err != nil {
+		postgres.server, postgres.err = nil, fmt.Errorf("%s %s", err, output)
+		return
+	}
+
+	postgres.dataSource = fmt.Sprintf(
+		"host=localhost port=%d user=postgres password=postgres dbname=postgres sslmode=disable", postgresPort)
+}
+func stopPostgreSQL() {
+	if postgres.server != nil {
+		_ = postgres.server.Stop()
+	}
+	if len(postgres.directory) > 0 {
+		_ = os.RemoveAll(postgres.directory)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// database is an empty database of the given dialect, statements executed by the fixtures are written using "?" as the
+// placeholder of each argument whatever the dialect.
+type database struct {
+	*sql.DB
+	dialect   sqlmq.Dialect
+	directory string
+}
+
+// openDatabase opens a database to which every migration has been applied.
+func openDatabase(fixture *gunit.Fixture, dialect sqlmq.Dialect) *database {
+	database := openEmptyDatabase(fixture, dialect)
+	manager := sqlmq.NewSchemaManager(sqlmq.Options.StorageHandle(database.DB), sqlmq.Options.Dialect(dialect))
+	_, err := manager.Migrate(context.Background())
+	fixture.So(err, should.BeNil)
+	return database
+}
+func openEmptyDatabase(fixture *gunit.Fixture, dialect sqlmq.Dialect) *database {
+	if dialect == sqlmq.PostgreSQL {
+		db, err := sql.Open("postgres", postgres.dataSource)
+		fixture.So(err, should.BeNil)
+		_, err = db.Exec("DROP SCHEMA IF EXISTS public CASCADE; CREATE SCHEMA public;")
+		fixture.So(err, should.BeNil)
+		return &database{DB: db, dialect: dialect}
+	}
+
+	directory, err := ioutil.TempDir("", "sqlmq")
+	fixture.So(err, should.BeNil)
+
+	db, err := sql.Open("sqlite", filepath.Join(directory, "messages.db")+"?_pragma=busy_timeout(5000)")
+	fixture.So(err, should.BeNil)
+	db.SetMaxOpenConns(1) // SQLite allows a single writer at a time
+
+	return &database{DB: db, dialect: dialect, directory: directory}
+}
+func (this *database) Close() error {
+	if len(this.directory) > 0 {
+		defer func() { _ = os.RemoveAll(this.directory) }()
+	}
+	return this.DB.Close()
+}
+
+func (this *database) exec(statement string, args ...interface{}) error {
+	_, err := this.Exec(this.bind(statement), args...)
+	return err
+}
+func (this *database) count(statement string, args ...interface{}) (count int) {
+	_ = this.QueryRow(this.bind(statement), args...).Scan(&count)
+	return count
+}
+func (this *database) identities(statement string, args ...interface{}) (identities []uint64) {
+	rows, err := this.Query(this.bind(statement), args...)
+	if err != nil {
+		return nil
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var identity uint64
+		_ = rows.Scan(&identity)
+		identities = append(identities, identity)
+	}
+	return identities
+}
+func (this *database) values(statement string, args ...interface{}) (values []string) {
+	rows, err := this.Query(this.bind(statement), args...)
+	if err != nil {
+		return nil
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var value sql.NullString
+		_ = rows.Scan(&value)
+		values = append(values, value.String)
+	}
+	return values
+}
+func (this *database) bind(statement string) string {
+	parts := strings.Split(statement, "?")
+	for i := 1; i < len(parts); i++ {
+		parts[0] += this.dialect.Placeholder(i) + parts[i]
+	}
+	return parts[0]
+}
+
+// timestamp is the value of the given time as an argument of a statement, a zero time being NULL.
+func (this *database) timestamp(value time.Time) interface{} {
+	if value.IsZero() {
+		return nil
+	}
+	return this.dialect.Timestamp(value)
+}
+
+func (this *database) tableExists(name string) bool {
+	if this.dialect == sqlmq.PostgreSQL {
+		return this.count("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = 'public' AND "+
+			"table_name = ?;", strings.ToLower(name)) > 0
+	}
+	return this.count("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?;", name) > 0
+}
+func (this *database) indexExists(name string) bool {
+	if this.dialect == sqlmq.PostgreSQL {
+		return this.count("SELECT COUNT(*) FROM pg_indexes WHERE schemaname = 'public' AND indexname = ?;",
+			strings.ToLower(name)) > 0
+	}
+	return this.count("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = ?;", name) > 0
+}
+
+// nopMonitor observes only the events every monitor must, and ignores them. Fixtures embed it and observe the rest.
+type nopMonitor struct{}
+
+func (nopMonitor) MessageReceived(int)  {}
+func (nopMonitor) MessageStored(int)    {}
+func (nopMonitor) MessagePublished(int) {}
+func (nopMonitor) MessageConfirmed(int) {}
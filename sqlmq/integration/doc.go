@@ -0,0 +1,4 @@
+// Package integration holds the tests which run the sqlmq package against the databases it supports. It is a module
+// of its own such that the database drivers (and the PostgreSQL server) on which the tests rely aren't requirements of
+// the library itself.
+package integration
@@ -0,0 +1,129 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/sqlmq"
+)
+
+func TestGaugeFixtureSQLite(t *testing.T) {
+	gunit.Run(new(SQLiteGaugeFixture), t)
+}
+func TestGaugeFixturePostgreSQL(t *testing.T) {
+	requirePostgreSQL(t)
+	gunit.Run(new(PostgreSQLGaugeFixture), t, gunit.Options.AllSequential())
+}
+
+type SQLiteGaugeFixture struct{ GaugeFixture }
+type PostgreSQLGaugeFixture struct{ GaugeFixture }
+
+func (this *SQLiteGaugeFixture) Setup()     { this.setup(sqlmq.SQLite) }
+func (this *PostgreSQLGaugeFixture) Setup() { this.setup(sqlmq.PostgreSQL) }
+
+type GaugeFixture struct {
+	*gunit.Fixture
+	nopMonitor
+
+	db  *database
+	now time.Time
+
+	measured chan measurement
+}
+
+type measurement struct {
+	count  int
+	oldest time.Duration
+}
+
+func (this *GaugeFixture) setup(dialect sqlmq.Dialect) {
+	this.db = openDatabase(this.Fixture, dialect)
+
+	this.now = time.Now().UTC().Truncate(time.Millisecond)
+	this.measured = make(chan measurement, 16)
+}
+func (this *GaugeFixture) Teardown() {
+	_ = this.db.Close()
+}
+
+// measure runs a processor until the gauges are first reported. Its broker is unreachable, so nothing is dispatched in
+// the meantime.
+func (this *GaugeFixture) measure() measurement {
+	_, processor := sqlmq.New(this,
+		sqlmq.Options.StorageHandle(this.db.DB),
+		sqlmq.Options.Dialect(this.db.dialect),
+		sqlmq.Options.GaugeInterval(time.Millisecond),
+		sqlmq.Options.RetryTimeout(time.Millisecond),
+		sqlmq.Options.Now(func() time.Time { return this.now }),
+		sqlmq.Options.Monitor(this),
+	)
+	listening := make(chan struct{})
+	go func() {
+		defer close(listening)
+		processor.Listen()
+	}()
+	defer func() { _ = processor.Close(); <-listening }()
+
+	select {
+	case measured := <-this.measured:
+		return measured
+	case <-time.After(time.Second * 5):
+		this.So("the gauges should be reported", should.BeEmpty)
+		return measurement{}
+	}
+}
+
+func (this *GaugeFixture) TestReportUndispatchedRowsAndAgeOfOldest() {
+	this.insert(1, this.now.Add(-time.Hour), this.now, time.Time{})
+	this.insert(2, this.now.Add(-time.Minute), time.Time{}, time.Time{})
+	this.insert(3, this.now.Add(-time.Second), time.Time{}, time.Time{})
+
+	this.So(this.measure(), should.Resemble, measurement{count: 2, oldest: time.Minute})
+}
+func (this *GaugeFixture) TestWhenNothingIsUndispatched_ReportNoAge() {
+	this.insert(1, this.now.Add(-time.Hour), this.now, time.Time{})
+
+	this.So(this.measure(), should.Resemble, measurement{})
+}
+func (this *GaugeFixture) TestWhenRowsAreScheduled_ReportOnlyThoseDueSinceTheyBecameDue() {
+	this.insert(1, this.now.Add(-time.Hour), time.Time{}, this.now.Add(time.Hour))
+	this.insert(2, this.now.Add(-time.Hour), time.Time{}, this.now.Add(-time.Second))
+	this.insert(3, this.now.Add(-time.Minute), time.Time{}, this.now.Add(-time.Hour))
+
+	this.So(this.measure(), should.Resemble, measurement{count: 2, oldest: time.Minute})
+}
+func (this *SQLiteGaugeFixture) TestWhenOldestRowHasNoInsertionTime_ReportNoAge() {
+	this.insert(1, this.now, time.Time{}, time.Time{})
+	this.So(this.db.exec("UPDATE Messages SET inserted = NULL;"), should.BeNil) // inserted before the column was added
+
+	this.So(this.measure(), should.Resemble, measurement{count: 1})
+}
+
+func (this *GaugeFixture) insert(id uint64, inserted, dispatched, deliverAt time.Time) {
+	this.So(this.db.exec("INSERT INTO Messages (id, inserted, dispatched, deliver_at, created, type, payload) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?);", id, this.db.timestamp(inserted), this.db.timestamp(dispatched),
+		this.db.timestamp(deliverAt), this.db.timestamp(this.now.Add(-time.Hour*24)), fmt.Sprintf("type-%d", id),
+		[]byte("payload")), should.BeNil)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *GaugeFixture) PendingMessages(count int, oldest time.Duration) {
+	select {
+	case this.measured <- measurement{count: count, oldest: oldest}:
+	default:
+	}
+}
+func (this *GaugeFixture) ChannelDepth(_ int)             {}
+func (this *GaugeFixture) ConfirmLatency(_ time.Duration) {}
+
+func (this *GaugeFixture) Connect(_ context.Context) (messaging.Connection, error) {
+	return nil, errors.New("unreachable")
+}
+func (this *GaugeFixture) Close() error { return nil }
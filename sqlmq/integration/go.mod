@@ -0,0 +1,14 @@
+module github.com/smartystreets/messaging/v3/sqlmq/integration
+
+go 1.13
+
+require (
+	github.com/fergusstrange/embedded-postgres v1.25.0
+	github.com/lib/pq v1.10.4
+	github.com/smartystreets/assertions v1.2.0
+	github.com/smartystreets/gunit v1.4.2
+	github.com/smartystreets/messaging/v3 v3.0.0-00010101000000-000000000000
+	modernc.org/sqlite v1.20.4
+)
+
+replace github.com/smartystreets/messaging/v3 => ../..
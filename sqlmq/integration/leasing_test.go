@@ -0,0 +1,218 @@
+package integration
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/sqlmq"
+)
+
+func TestLeasingFixtureSQLite(t *testing.T) {
+	gunit.Run(new(SQLiteLeasingFixture), t)
+}
+func TestLeasingFixturePostgreSQL(t *testing.T) {
+	requirePostgreSQL(t)
+	gunit.Run(new(PostgreSQLLeasingFixture), t, gunit.Options.AllSequential())
+}
+
+type SQLiteLeasingFixture struct{ LeasingFixture }
+type PostgreSQLLeasingFixture struct{ LeasingFixture }
+
+func (this *SQLiteLeasingFixture) Setup()     { this.setup(sqlmq.SQLite) }
+func (this *PostgreSQLLeasingFixture) Setup() { this.setup(sqlmq.PostgreSQL) }
+
+type LeasingFixture struct {
+	*gunit.Fixture
+
+	ctx        context.Context
+	db         *database
+	processors []messaging.ListenCloser
+	listening  chan struct{}
+	listeners  int
+	duration   time.Duration
+
+	published publisher
+}
+
+func (this *LeasingFixture) setup(dialect sqlmq.Dialect) {
+	this.ctx = context.Background()
+
+	this.db = openDatabase(this.Fixture, dialect)
+
+	this.duration = time.Minute
+	this.listening = make(chan struct{}, 8)
+	this.published = make(publisher, 64)
+}
+func (this *LeasingFixture) Teardown() {
+	for _, processor := range this.processors {
+		_ = processor.Close()
+	}
+	for ; this.listeners > 0; this.listeners-- {
+		<-this.listening
+	}
+	_ = this.db.Close()
+}
+
+// newProcessor creates (but doesn't start) a processor with the given identity whose clock is offset from that of
+// the database.
+func (this *LeasingFixture) newProcessor(owner string, offset time.Duration) (messaging.Connector, messaging.ListenCloser) {
+	connector, processor := sqlmq.New(this.published,
+		sqlmq.Options.StorageHandle(this.db.DB),
+		sqlmq.Options.Dialect(this.db.dialect),
+		sqlmq.Options.InstanceID(owner),
+		sqlmq.Options.LeaseDuration(this.duration),
+		sqlmq.Options.PollInterval(time.Millisecond),
+		sqlmq.Options.RetryTimeout(time.Millisecond*10),
+		sqlmq.Options.Now(func() time.Time { return time.Now().Add(offset) }),
+	)
+	this.processors = append(this.processors, processor)
+	return connector, processor
+}
+func (this *LeasingFixture) listen(owner string, offset time.Duration) {
+	_, processor := this.newProcessor(owner, offset)
+	this.listeners++
+	go func() {
+		defer func() { this.listening <- struct{}{} }()
+		processor.Listen()
+	}()
+}
+
+func (this *LeasingFixture) TestWhenStoring_StoringProcessorHoldsLease() {
+	this.store("a", 2)
+
+	this.So(this.leases(), should.Resemble, []string{"a", "a"})
+
+	this.listen("b", 0)
+	this.So(this.nothingReceived(), should.BeTrue)
+}
+func (this *LeasingFixture) TestWhenLeaseExpires_AnotherProcessorTakesOver() {
+	this.store("a", 2)
+	this.lease("a", time.Now().Add(-time.Second))
+
+	this.listen("b", 0)
+
+	this.So(this.receive(2), should.Resemble, []uint64{1, 2})
+	this.So(this.awaitUndispatched(0), should.BeTrue)
+	this.So(this.leases(), should.Resemble, []string{"b", "b"})
+}
+func (this *LeasingFixture) TestWhenClaimingConcurrently_EachRowIsPublishedByOneProcessorOnly() {
+	for i := 0; i < 8; i++ {
+		this.So(this.db.exec("INSERT INTO Messages (type, payload) VALUES ('type', 'payload');"), should.BeNil)
+	}
+
+	this.listen("a", 0)
+	this.listen("b", 0)
+
+	this.So(this.receive(8), should.Resemble, []uint64{1, 2, 3, 4, 5, 6, 7, 8})
+	this.So(this.nothingReceived(), should.BeTrue)
+	this.So(this.awaitUndispatched(0), should.BeTrue)
+}
+func (this *LeasingFixture) TestLeasesAreMeasuredUsingClockOfDatabase() {
+	this.So(this.db.exec("INSERT INTO Messages (type, payload, claimed_by, claimed_until) VALUES "+
+		"('type', 'held', 'a', ?), ('type', 'lapsed', 'a', ?);",
+		this.db.timestamp(time.Now().Add(time.Minute)), this.db.timestamp(time.Now().Add(-time.Second))), should.BeNil)
+
+	this.listen("b", time.Hour*24*365) // the clock of the processor is a year ahead of that of the database
+
+	this.So(this.receive(1), should.Resemble, []uint64{2})
+	this.So(this.nothingReceived(), should.BeTrue)
+}
+func (this *LeasingFixture) TestWhenStoringScheduledDispatch_ItIsClaimedOnceDue() {
+	this.duration = time.Millisecond * 30 // rows are claimed every third of the lease
+	connector, _ := this.newProcessor("a", 0)
+	deliverAt := time.Now().UTC().Add(time.Millisecond * 100)
+	this.write(connector, messaging.Dispatch{MessageType: "type", Payload: []byte("payload"), DeliverAt: deliverAt})
+
+	this.So(this.leases(), should.Resemble, []string{""})
+
+	this.listen("b", 0)
+
+	this.So(this.receive(1), should.Resemble, []uint64{1})
+	this.So(time.Now().Before(deliverAt), should.BeFalse)
+}
+
+// store writes the given number of dispatches using a processor which isn't started, such that they remain leased.
+func (this *LeasingFixture) store(owner string, count int) {
+	connector, _ := this.newProcessor(owner, 0)
+	dispatches := make([]messaging.Dispatch, count)
+	for i := range dispatches {
+		dispatches[i] = messaging.Dispatch{MessageType: "type", Payload: []byte("payload")}
+	}
+	this.write(connector, dispatches...)
+}
+func (this *LeasingFixture) write(connector messaging.Connector, dispatches ...messaging.Dispatch) {
+	connection, _ := connector.Connect(this.ctx)
+	writer, err := connection.CommitWriter(this.ctx)
+	this.So(err, should.BeNil)
+	_, err = writer.Write(this.ctx, dispatches...)
+	this.So(err, should.BeNil)
+	this.So(writer.Commit(), should.BeNil)
+}
+func (this *LeasingFixture) lease(owner string, until time.Time) {
+	this.So(this.db.exec("UPDATE Messages SET claimed_by = ?, claimed_until = ?;", owner, this.db.timestamp(until)),
+		should.BeNil)
+}
+func (this *LeasingFixture) leases() []string {
+	return this.db.values("SELECT claimed_by FROM Messages ORDER BY id;")
+}
+
+func (this *LeasingFixture) receive(count int) (identities []uint64) {
+	timeout := time.After(time.Second * 5)
+	for len(identities) < count {
+		select {
+		case dispatch := <-this.published:
+			identities = append(identities, dispatch.MessageID)
+		case <-timeout:
+			this.So(len(identities), should.Equal, count)
+			return identities
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i] < identities[j] })
+	return identities
+}
+func (this *LeasingFixture) nothingReceived() bool {
+	select {
+	case <-this.published:
+		return false
+	case <-time.After(time.Millisecond * 50):
+		return true
+	}
+}
+func (this *LeasingFixture) awaitUndispatched(expected int) bool {
+	for deadline := time.Now().Add(time.Second * 5); time.Now().Before(deadline); time.Sleep(time.Millisecond * 10) {
+		if this.db.count("SELECT COUNT(*) FROM Messages WHERE dispatched IS NULL;") == expected {
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// publisher is a transport which publishes each dispatch as it is written.
+type publisher chan messaging.Dispatch
+
+func (this publisher) Connect(_ context.Context) (messaging.Connection, error) { return this, nil }
+func (this publisher) Reader(_ context.Context) (messaging.Reader, error) {
+	panic("not supported")
+}
+func (this publisher) Writer(_ context.Context) (messaging.Writer, error) {
+	panic("not supported")
+}
+func (this publisher) CommitWriter(_ context.Context) (messaging.CommitWriter, error) {
+	return this, nil
+}
+func (this publisher) Write(_ context.Context, dispatches ...messaging.Dispatch) (int, error) {
+	for _, dispatch := range dispatches {
+		this <- dispatch
+	}
+	return len(dispatches), nil
+}
+func (this publisher) Commit() error   { return nil }
+func (this publisher) Rollback() error { return nil }
+func (this publisher) Close() error    { return nil }
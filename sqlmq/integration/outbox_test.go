@@ -0,0 +1,343 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/handlers/transactional"
+	"github.com/smartystreets/messaging/v3/sqlmq"
+	"github.com/smartystreets/messaging/v3/streaming"
+)
+
+func TestOutboxFixtureSQLite(t *testing.T) {
+	gunit.Run(new(SQLiteOutboxFixture), t)
+}
+func TestOutboxFixturePostgreSQL(t *testing.T) {
+	requirePostgreSQL(t)
+	gunit.Run(new(PostgreSQLOutboxFixture), t, gunit.Options.AllSequential())
+}
+
+type SQLiteOutboxFixture struct{ OutboxFixture }
+type PostgreSQLOutboxFixture struct{ OutboxFixture }
+
+func (this *SQLiteOutboxFixture) Setup()     { this.setup(sqlmq.SQLite) }
+func (this *PostgreSQLOutboxFixture) Setup() { this.setup(sqlmq.PostgreSQL) }
+
+type OutboxFixture struct {
+	*gunit.Fixture
+
+	db        *database
+	transport messaging.Connector
+	connector messaging.Connector
+	processor messaging.ListenCloser
+	listening chan struct{}
+
+	pollInterval     time.Duration
+	sweepInterval    time.Duration
+	sweepGracePeriod time.Duration
+
+	pending   []messaging.Dispatch
+	published chan messaging.Dispatch
+}
+
+func (this *OutboxFixture) setup(dialect sqlmq.Dialect) {
+	this.db = openDatabase(this.Fixture, dialect)
+	this.So(this.db.exec("CREATE TABLE Documents (name varchar(256) NOT NULL);"), should.BeNil)
+
+	this.transport = this
+	this.pollInterval = time.Millisecond * 250
+	this.sweepGracePeriod = time.Minute
+	this.published = make(chan messaging.Dispatch, 16)
+}
+func (this *OutboxFixture) Teardown() {
+	this.stop()
+	_ = this.db.Close()
+}
+func (this *OutboxFixture) listen() {
+	this.connector, this.processor = sqlmq.New(this.transport,
+		sqlmq.Options.StorageHandle(this.db.DB),
+		sqlmq.Options.Dialect(this.db.dialect),
+		sqlmq.Options.RetryTimeout(time.Millisecond*10),
+		sqlmq.Options.PollInterval(this.pollInterval),
+		sqlmq.Options.SweepInterval(this.sweepInterval),
+		sqlmq.Options.SweepGracePeriod(this.sweepGracePeriod),
+	)
+
+	this.listening = make(chan struct{})
+	go func() {
+		defer close(this.listening)
+		this.processor.Listen()
+	}()
+}
+func (this *OutboxFixture) stop() {
+	if this.processor != nil {
+		_ = this.processor.Close()
+		<-this.listening
+		this.processor = nil
+	}
+}
+
+func (this *OutboxFixture) TestDispatchesWrittenWithinTransaction_PublishedAndMarkedAsDispatched() {
+	this.listen()
+	handler := transactional.New(this.connector, this.newHandler)
+
+	handler.Handle(context.Background(), "a", "b", "c")
+	handler.Handle(context.Background(), "d", "e")
+
+	published := this.receive(5)
+	this.So(messageIDs(published), should.Resemble, []uint64{1, 2, 3, 4, 5})
+	this.So(messageTypes(published), should.Resemble, []string{"a", "b", "c", "d", "e"})
+	this.So(this.db.count("SELECT COUNT(*) FROM Documents;"), should.Equal, 5)
+	this.So(this.awaitUndispatched(0), should.BeTrue)
+}
+func (this *OutboxFixture) TestDispatchesWrittenWithinFailedTransaction_RolledBackAndNeverPublished() {
+	this.listen()
+	handler := transactional.New(this.connector, this.newHandler)
+
+	this.So(func() { handler.Handle(context.Background(), "a", errors.New("failed")) }, should.Panic)
+	handler.Handle(context.Background(), "b")
+
+	published := this.receive(1)
+	this.So(messageTypes(published), should.Resemble, []string{"b"})
+	this.So(this.db.count("SELECT COUNT(*) FROM Messages;"), should.Equal, 1)
+	this.So(this.db.count("SELECT COUNT(*) FROM Documents;"), should.Equal, 1)
+}
+func (this *OutboxFixture) TestDispatchesRecoveredAfterRestart_IdenticalToThoseFirstPublished() {
+	this.listen()
+	handler := transactional.New(this.connector, this.newHandler)
+	handler.Handle(context.Background(), messaging.Dispatch{
+		SourceID:        math.MaxUint64,
+		CorrelationID:   2,
+		Expiration:      time.Minute,
+		DeliverAt:       time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond),
+		Durable:         true,
+		Priority:        4,
+		Topic:           "topic",
+		Partition:       5,
+		MessageType:     "type",
+		ContentType:     "application/json",
+		ContentEncoding: "gzip",
+		Payload:         []byte("payload"),
+		Headers:         map[string]interface{}{"a": int32(1), "b": "2", "c": time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+	})
+	sent := this.receive(1)
+	this.So(this.awaitUndispatched(0), should.BeTrue)
+
+	this.stop()
+	this.So(this.db.exec("UPDATE Messages SET dispatched = NULL;"), should.BeNil) // as though stopped before confirming
+	this.listen()
+
+	recovered := this.receive(1)
+	this.So(recovered, should.Resemble, sent)
+	this.So(sent[0].Timestamp.IsZero(), should.BeFalse)
+}
+func (this *OutboxFixture) TestPendingDispatchesFromPreviousProcess_LoadedAndPublished() {
+	past := time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond)
+	this.So(this.db.exec("INSERT INTO Messages (type, payload, deliver_at, priority) VALUES (?,?,?,?),(?,?,?,?);",
+		"a", []byte("1"), this.db.timestamp(past), 5,
+		"b", []byte("2"), nil, 0), should.BeNil)
+	this.So(this.db.exec("INSERT INTO Messages (dispatched, type, payload, priority) VALUES (?,?,?,?);",
+		this.db.timestamp(past), "c", []byte("3"), 0), should.BeNil)
+
+	this.listen()
+
+	published := this.receive(2)
+	this.So(messageIDs(published), should.Resemble, []uint64{1, 2})
+	this.So(published[0].Payload, should.Resemble, []byte("1"))
+	this.So(published[0].DeliverAt, should.Equal, past)
+	this.So(published[0].Priority, should.Equal, 5)
+	this.So(published[1].DeliverAt.IsZero(), should.BeTrue)
+	this.So(this.awaitUndispatched(0), should.BeTrue)
+}
+func (this *OutboxFixture) TestScheduledDispatches_PublishedOnceDue() {
+	this.pollInterval = time.Millisecond * 5
+	this.listen()
+	handler := transactional.New(this.connector, this.newHandler)
+	deliverAt := time.Now().UTC().Add(time.Millisecond * 100)
+
+	handler.Handle(context.Background(), messaging.Dispatch{MessageType: "a", Payload: []byte("1"), DeliverAt: deliverAt})
+
+	published := this.receive(1)
+	this.So(time.Now().Before(deliverAt), should.BeFalse)
+	this.So(messageTypes(published), should.Resemble, []string{"a"})
+	this.So(this.awaitUndispatched(0), should.BeTrue)
+}
+func (this *OutboxFixture) TestWhenSweeping_DispatchesWrittenByOtherProcessesArePublished() {
+	this.sweepInterval, this.sweepGracePeriod = time.Millisecond*5, 0
+	this.listen()
+
+	this.So(this.db.exec("INSERT INTO Messages (type, payload) VALUES ('a', '1');"), should.BeNil) // e.g. by a procedure
+
+	published := this.receive(1)
+	this.So(messageTypes(published), should.Resemble, []string{"a"})
+	this.So(this.awaitUndispatched(0), should.BeTrue)
+}
+func (this *OutboxFixture) TestWhenSweeping_RowsInsertedWithinGracePeriodAreNotSweptWhateverTheirTimestamp() {
+	this.So(this.db.exec("INSERT INTO Messages (type, payload) VALUES ('a', '1');"), should.BeNil)
+	this.sweepInterval, this.sweepGracePeriod = time.Millisecond, time.Hour
+	this.listen()
+	this.So(messageTypes(this.receive(1)), should.Resemble, []string{"a"}) // once loaded, only sweeping remains
+
+	past := time.Now().UTC().Add(-time.Hour * 24)
+	this.So(this.db.exec("INSERT INTO Messages (type, payload, created) VALUES ('b', '2', ?);",
+		this.db.timestamp(past)), should.BeNil)
+
+	select {
+	case dispatch := <-this.published:
+		this.So(dispatch, should.BeNil)
+	case <-time.After(time.Millisecond * 50):
+	}
+	this.So(this.db.count("SELECT COUNT(*) FROM Messages WHERE inserted IS NOT NULL;"), should.Equal, 2)
+}
+func (this *OutboxFixture) TestWithoutBroker_DispatchesAreStreamedFromQueueInDatabase() {
+	this.transport, this.pollInterval = nil, time.Millisecond
+	this.listen()
+
+	delivered := make(deliveryHandler, 16)
+	subscriber := streaming.New(this.connector, streaming.Options.Subscriptions(streaming.NewSubscription("queue",
+		streaming.SubscriptionOptions.Topics("topic"),
+		streaming.SubscriptionOptions.FullDeliveryToHandler(true),
+		streaming.SubscriptionOptions.AddWorkers(delivered))))
+	subscribed := make(chan struct{})
+	go func() {
+		defer close(subscribed)
+		subscriber.Listen()
+	}()
+	defer func() { _ = subscriber.Close(); <-subscribed }()
+
+	for deadline := time.Now().Add(time.Second * 5); this.db.count("SELECT COUNT(*) FROM Messages_bindings;") == 0 &&
+		time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+	}
+
+	handler := transactional.New(this.connector, this.newHandler)
+	handler.Handle(context.Background(), messaging.Dispatch{Topic: "topic", MessageType: "a", Payload: []byte("1")})
+
+	select {
+	case delivery := <-delivered:
+		this.So(delivery.MessageType, should.Equal, "a")
+		this.So(delivery.Payload, should.Resemble, []byte("1"))
+		this.So(delivery.MessageID, should.Equal, 1)
+	case <-time.After(time.Second * 5):
+		this.So("the dispatch should be delivered", should.BeEmpty)
+	}
+
+	this.So(this.awaitUndispatched(0), should.BeTrue)
+	for deadline := time.Now().Add(time.Second * 5); this.db.count("SELECT COUNT(*) FROM Messages_queue;") > 0 &&
+		time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+	}
+	this.So(this.db.count("SELECT COUNT(*) FROM Messages_queue;"), should.Equal, 0) // acknowledged
+}
+
+func (this *OutboxFixture) receive(count int) (dispatches []messaging.Dispatch) {
+	received := make(map[uint64]struct{})
+	timeout := time.After(time.Second * 5)
+	for len(dispatches) < count {
+		select {
+		case dispatch := <-this.published:
+			if _, duplicate := received[dispatch.MessageID]; duplicate {
+				continue // committed while the initial load was running, so also loaded (at least once)
+			}
+			received[dispatch.MessageID] = struct{}{}
+			dispatches = append(dispatches, dispatch)
+		case <-timeout:
+			this.So(len(dispatches), should.Equal, count)
+			return dispatches
+		}
+	}
+	return dispatches
+}
+func (this *OutboxFixture) awaitUndispatched(expected int) bool {
+	for deadline := time.Now().Add(time.Second * 5); time.Now().Before(deadline); time.Sleep(time.Millisecond * 10) {
+		if this.db.count("SELECT COUNT(*) FROM Messages WHERE dispatched IS NULL;") == expected {
+			return true
+		}
+	}
+	return false
+}
+
+func messageIDs(dispatches []messaging.Dispatch) (ids []uint64) {
+	for _, dispatch := range dispatches {
+		ids = append(ids, dispatch.MessageID)
+	}
+	return ids
+}
+func messageTypes(dispatches []messaging.Dispatch) (types []string) {
+	for _, dispatch := range dispatches {
+		types = append(types, dispatch.MessageType)
+	}
+	return types
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *OutboxFixture) newHandler(state transactional.State) messaging.Handler {
+	return documentHandler{state: state, statement: this.db.bind("INSERT INTO Documents (name) VALUES (?);")}
+}
+
+type documentHandler struct {
+	state     transactional.State
+	statement string
+}
+
+func (this documentHandler) Handle(ctx context.Context, messages ...interface{}) {
+	for _, message := range messages {
+		if err, ok := message.(error); ok {
+			panic(err)
+		}
+
+		if dispatch, ok := message.(messaging.Dispatch); ok {
+			_, _ = this.state.Writer.Write(ctx, dispatch)
+			continue
+		}
+
+		name := message.(string)
+		if _, err := this.state.Tx.ExecContext(ctx, this.statement, name); err != nil {
+			panic(err)
+		}
+		dispatch := messaging.Dispatch{MessageType: name, Payload: []byte(name)}
+		if _, err := this.state.Writer.Write(ctx, dispatch); err != nil {
+			panic(err)
+		}
+	}
+}
+
+type deliveryHandler chan messaging.Delivery
+
+func (this deliveryHandler) Handle(_ context.Context, messages ...interface{}) {
+	for _, message := range messages {
+		this <- message.(messaging.Delivery)
+	}
+}
+
+func (this *OutboxFixture) Connect(_ context.Context) (messaging.Connection, error) { return this, nil }
+func (this *OutboxFixture) Reader(_ context.Context) (messaging.Reader, error) {
+	panic("not supported")
+}
+func (this *OutboxFixture) Writer(_ context.Context) (messaging.Writer, error) {
+	panic("not supported")
+}
+func (this *OutboxFixture) CommitWriter(_ context.Context) (messaging.CommitWriter, error) {
+	return this, nil
+}
+func (this *OutboxFixture) Write(_ context.Context, dispatches ...messaging.Dispatch) (int, error) {
+	this.pending = append(this.pending, dispatches...)
+	return len(dispatches), nil
+}
+func (this *OutboxFixture) Commit() error {
+	for _, dispatch := range this.pending {
+		this.published <- dispatch
+	}
+	this.pending = this.pending[0:0]
+	return nil
+}
+func (this *OutboxFixture) Rollback() error {
+	this.pending = this.pending[0:0]
+	return nil
+}
+func (this *OutboxFixture) Close() error { return nil }
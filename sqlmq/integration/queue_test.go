@@ -0,0 +1,340 @@
+package integration
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/sqlmq"
+)
+
+func TestQueueFixtureSQLite(t *testing.T) {
+	gunit.Run(new(SQLiteQueueFixture), t)
+}
+func TestQueueFixturePostgreSQL(t *testing.T) {
+	requirePostgreSQL(t)
+	gunit.Run(new(PostgreSQLQueueFixture), t, gunit.Options.AllSequential())
+}
+
+type SQLiteQueueFixture struct{ QueueFixture }
+type PostgreSQLQueueFixture struct{ QueueFixture }
+
+func (this *SQLiteQueueFixture) Setup()     { this.setup(sqlmq.SQLite) }
+func (this *PostgreSQLQueueFixture) Setup() { this.setup(sqlmq.PostgreSQL) }
+
+type QueueFixture struct {
+	*gunit.Fixture
+
+	ctx          context.Context
+	db           *database
+	now          time.Time
+	pageSize     int
+	pollInterval time.Duration
+}
+
+func (this *QueueFixture) setup(dialect sqlmq.Dialect) {
+	this.ctx = context.Background()
+
+	this.db = openDatabase(this.Fixture, dialect)
+
+	this.now = time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	this.pageSize = 1000
+	this.pollInterval = time.Millisecond
+}
+func (this *QueueFixture) Teardown() {
+	_ = this.db.Close()
+}
+func (this *QueueFixture) connect() messaging.Connection {
+	connector, _ := sqlmq.New(nil,
+		sqlmq.Options.StorageHandle(this.db.DB),
+		sqlmq.Options.Dialect(this.db.dialect),
+		sqlmq.Options.PageSize(this.pageSize),
+		sqlmq.Options.VisibilityTimeout(time.Minute),
+		sqlmq.Options.PollInterval(this.pollInterval),
+		sqlmq.Options.RetryTimeout(time.Millisecond),
+		sqlmq.Options.Now(func() time.Time { return this.now }),
+	)
+
+	connection, err := connector.Connect(this.ctx)
+	this.So(err, should.BeNil)
+	return connection
+}
+func (this *QueueFixture) newStream(settings messaging.StreamConfig) messaging.Stream {
+	settings.StreamName = "queue"
+	reader, _ := this.connect().Reader(this.ctx)
+	stream, err := reader.Stream(this.ctx, settings)
+	this.So(err, should.BeNil)
+	return stream
+}
+
+func (this *QueueFixture) TestWhenStreamIsNotNamed_ReturnError() {
+	reader, _ := this.connect().Reader(this.ctx)
+
+	stream, err := reader.Stream(this.ctx, messaging.StreamConfig{TemporaryStream: true})
+
+	this.So(stream, should.BeNil)
+	this.So(err, should.NotBeNil)
+}
+func (this *QueueFixture) TestWhenEstablishingTopology_BindQueueToEachTopicOnce() {
+	settings := messaging.StreamConfig{StreamName: "queue", Topics: []string{"a", "b"}, EstablishTopology: true}
+	reader, _ := this.connect().Reader(this.ctx)
+
+	first, firstErr := reader.Stream(this.ctx, settings)
+	second, secondErr := reader.Stream(this.ctx, settings)
+
+	this.So(firstErr, should.BeNil)
+	this.So(first, should.NotBeNil)
+	this.So(secondErr, should.BeNil)
+	this.So(second, should.NotBeNil)
+	this.So(this.bindings(), should.Resemble, []string{"queue:a", "queue:b"})
+}
+func (this *QueueFixture) TestWhenVerifyingTopology_ReportTopicsToWhichQueueIsNotBound() {
+	this.bind("queue", "a")
+	settings := messaging.StreamConfig{StreamName: "queue", Topics: []string{"a", "b", "c"}, VerifyTopology: true}
+	reader, _ := this.connect().Reader(this.ctx)
+
+	stream, err := reader.Stream(this.ctx, settings)
+
+	this.So(stream, should.BeNil)
+	this.So(err, should.NotBeNil)
+	this.So(err.Error(), should.EndWith, "queue [queue], topics [b, c]")
+	this.So(this.bindings(), should.Resemble, []string{"queue:a"})
+}
+func (this *QueueFixture) TestWhenVerifyingCompleteTopology_OpenStream() {
+	this.bind("queue", "a")
+	settings := messaging.StreamConfig{StreamName: "queue", Topics: []string{"a"}, VerifyTopology: true}
+	reader, _ := this.connect().Reader(this.ctx)
+
+	stream, err := reader.Stream(this.ctx, settings)
+
+	this.So(stream, should.NotBeNil)
+	this.So(err, should.BeNil)
+}
+
+func (this *QueueFixture) TestWhenWritingToTopic_WriteRowToEachBoundQueue() {
+	this.bind("a", "topic1")
+	this.bind("b", "topic1")
+	this.bind("c", "topic2")
+
+	count, err := this.write(messaging.Dispatch{MessageID: 7, Topic: "topic1", MessageType: "type", Payload: []byte("1")})
+
+	this.So(err, should.BeNil)
+	this.So(count, should.Equal, 1)
+	this.So(this.queued(), should.Resemble, []string{"a:7:type", "b:7:type"})
+}
+func (this *QueueFixture) TestWhenWritingToUnboundTopic_DispatchIsDropped() {
+	count, err := this.write(messaging.Dispatch{Topic: "topic", MessageType: "type", Payload: []byte("1")})
+
+	this.So(err, should.BeNil)
+	this.So(count, should.Equal, 1)
+	this.So(this.queued(), should.BeEmpty)
+}
+func (this *QueueFixture) TestWhenWritingScheduledDispatch_RowIsInvisibleUntilDue() {
+	this.bind("a", "topic")
+	deliverAt := this.now.Add(time.Hour)
+
+	_, err := this.write(
+		messaging.Dispatch{Topic: "topic", MessageType: "now", Payload: []byte("1")},
+		messaging.Dispatch{Topic: "topic", MessageType: "later", Payload: []byte("2"), DeliverAt: deliverAt})
+
+	this.So(err, should.BeNil)
+	this.So(this.visibleAt("now", this.now), should.BeTrue)
+	this.So(this.visibleAt("later", deliverAt), should.BeTrue)
+}
+func (this *QueueFixture) TestWhenWritingFails_NothingIsWritten() {
+	this.bind("a", "topic")
+
+	_, err := this.write(
+		messaging.Dispatch{Topic: "topic", MessageType: "type", Payload: []byte("1")},
+		messaging.Dispatch{Topic: "topic", MessageType: "type", Payload: []byte("2"),
+			Headers: map[string]interface{}{"unregistered": struct{}{}}})
+
+	this.So(err, should.NotBeNil)
+	this.So(this.queued(), should.BeEmpty)
+}
+
+func (this *QueueFixture) TestWhenReading_DeliverEntireEnvelopeOfEachRowInOrder() {
+	this.bind("queue", "topic")
+	_, _ = this.write(
+		messaging.Dispatch{
+			SourceID:        1,
+			MessageID:       2,
+			CorrelationID:   3,
+			Timestamp:       this.now.Add(-time.Second),
+			Durable:         true,
+			Topic:           "topic",
+			Partition:       4,
+			MessageType:     "type",
+			ContentType:     "application/json",
+			ContentEncoding: "gzip",
+			Payload:         []byte("1"),
+			Headers:         map[string]interface{}{"a": "b"},
+		},
+		messaging.Dispatch{MessageID: 5, Topic: "topic", MessageType: "type", Payload: []byte("2")},
+	)
+	stream := this.newStream(messaging.StreamConfig{BufferCapacity: 8})
+
+	first, second := this.read(stream), this.read(stream)
+
+	this.So(first, should.Resemble, messaging.Delivery{
+		DeliveryID:      1,
+		DeliveryCount:   1,
+		SourceID:        1,
+		MessageID:       2,
+		CorrelationID:   3,
+		Timestamp:       this.now.Add(-time.Second),
+		Durable:         true,
+		Topic:           "topic",
+		Partition:       4,
+		MessageType:     "type",
+		ContentType:     "application/json",
+		ContentEncoding: "gzip",
+		Payload:         []byte("1"),
+		Headers:         map[string]interface{}{"a": "b"},
+	})
+	this.So(second.DeliveryID, should.Equal, 2)
+	this.So(second.MessageID, should.Equal, 5)
+	this.So(second.Timestamp, should.Equal, this.now)
+}
+func (this *QueueFixture) TestWhenReadingFromSeveralStreams_EachRowIsClaimedByOneStreamOnly() {
+	this.bind("queue", "topic")
+	_, _ = this.write(this.dispatch("1"), this.dispatch("2"), this.dispatch("3"))
+	first := this.newStream(messaging.StreamConfig{BufferCapacity: 2})
+	second := this.newStream(messaging.StreamConfig{BufferCapacity: 2})
+
+	this.So(this.read(first).DeliveryID, should.Equal, 1)
+	this.So(this.read(second).DeliveryID, should.Equal, 3)
+	this.So(this.read(first).DeliveryID, should.Equal, 2)
+	this.So(this.tryRead(second), should.Resemble, context.DeadlineExceeded)
+}
+func (this *QueueFixture) TestWhenNotAcknowledgedWithinVisibilityTimeout_RowIsDeliveredAgain() {
+	this.bind("queue", "topic")
+	_, _ = this.write(this.dispatch("1"))
+	first, second := this.newStream(messaging.StreamConfig{}), this.newStream(messaging.StreamConfig{})
+	delivery := this.read(first)
+
+	this.So(this.tryRead(second), should.Resemble, context.DeadlineExceeded)
+	this.now = this.now.Add(time.Minute)
+	redelivery := this.read(second)
+
+	this.So(redelivery.DeliveryID, should.Equal, delivery.DeliveryID)
+	this.So(redelivery.DeliveryCount, should.Equal, 2)
+	this.So(redelivery.Redelivered, should.BeTrue)
+
+	this.So(first.Acknowledge(this.ctx, delivery), should.BeNil) // too late, the row is claimed by the second stream
+	this.So(this.count(), should.Equal, 1)
+	this.So(second.Acknowledge(this.ctx, redelivery), should.BeNil)
+	this.So(this.count(), should.Equal, 0)
+}
+func (this *QueueFixture) TestWhenAcknowledged_RowsAreRemoved() {
+	this.bind("queue", "topic")
+	_, _ = this.write(this.dispatch("1"), this.dispatch("2"), this.dispatch("3"))
+	stream := this.newStream(messaging.StreamConfig{BufferCapacity: 4})
+	first, second := this.read(stream), this.read(stream)
+
+	this.So(stream.Acknowledge(this.ctx, first, second), should.BeNil)
+	this.So(stream.Acknowledge(this.ctx), should.BeNil)
+
+	this.So(this.count(), should.Equal, 1)
+}
+func (this *QueueFixture) TestWhenRowIsScheduled_DeliverOnceDue() {
+	this.bind("queue", "topic")
+	_, _ = this.write(messaging.Dispatch{Topic: "topic", MessageType: "type", Payload: []byte("1"), DeliverAt: this.now.Add(time.Hour)})
+	stream := this.newStream(messaging.StreamConfig{})
+
+	this.So(this.tryRead(stream), should.Resemble, context.DeadlineExceeded)
+	this.now = this.now.Add(time.Hour)
+	this.So(this.read(stream).DeliveryID, should.Equal, 1)
+}
+func (this *QueueFixture) TestWhenMessageHasExpired_DiscardRatherThanDeliver() {
+	this.bind("queue", "topic")
+	_, _ = this.write(
+		messaging.Dispatch{Topic: "topic", MessageType: "type", Payload: []byte("1"), Expiration: time.Second, Timestamp: this.now.Add(-time.Second)},
+		messaging.Dispatch{Topic: "topic", MessageType: "type", Payload: []byte("2"), Expiration: time.Hour},
+	)
+	stream := this.newStream(messaging.StreamConfig{BufferCapacity: 4})
+
+	this.So(this.read(stream).DeliveryID, should.Equal, 2)
+	this.So(this.count(), should.Equal, 1)
+}
+func (this *QueueFixture) TestWhenStreamIsPrioritized_DeliverHigherPrioritiesFirst() {
+	this.bind("queue", "topic")
+	_, _ = this.write(
+		messaging.Dispatch{Topic: "topic", MessageType: "type", Payload: []byte("1"), Priority: 1},
+		messaging.Dispatch{Topic: "topic", MessageType: "type", Payload: []byte("2"), Priority: 9},
+	)
+	stream := this.newStream(messaging.StreamConfig{BufferCapacity: 4, MaxPriority: 10})
+
+	this.So(this.read(stream).Priority, should.Equal, 9)
+	this.So(this.read(stream).Priority, should.Equal, 1)
+}
+func (this *QueueFixture) TestWhenClosed_ReadingStopsAndUnreadRowsAreReleased() {
+	this.bind("queue", "topic")
+	_, _ = this.write(this.dispatch("1"), this.dispatch("2"))
+	stream := this.newStream(messaging.StreamConfig{BufferCapacity: 4})
+	_ = this.read(stream)
+
+	this.So(stream.Close(), should.BeNil)
+
+	this.So(this.tryRead(stream), should.Equal, io.EOF)
+	this.So(this.read(this.newStream(messaging.StreamConfig{})).DeliveryID, should.Equal, 2)
+}
+func (this *QueueFixture) TestWhenMoreCandidatesThanPage_ClaimEachPageSeparately() {
+	this.bind("queue", "topic")
+	this.pageSize = 2
+	_, _ = this.write(this.dispatch("1"), this.dispatch("2"), this.dispatch("3"), this.dispatch("4"), this.dispatch("5"))
+	stream := this.newStream(messaging.StreamConfig{BufferCapacity: 8})
+
+	for i := uint64(1); i <= 5; i++ {
+		this.So(this.read(stream).DeliveryID, should.Equal, i)
+	}
+	this.So(this.tryRead(stream), should.Resemble, context.DeadlineExceeded)
+}
+func (this *QueueFixture) TestWhenClaimingFails_RetryUntilContextIsDone() {
+	stream := this.newStream(messaging.StreamConfig{})
+	this.So(this.db.exec("DROP TABLE Messages_queue;"), should.BeNil)
+
+	this.So(this.tryRead(stream), should.Resemble, context.DeadlineExceeded)
+}
+
+func (this *QueueFixture) dispatch(payload string) messaging.Dispatch {
+	return messaging.Dispatch{Topic: "topic", MessageType: "type", Payload: []byte(payload)}
+}
+func (this *QueueFixture) bind(queue, topic string) {
+	this.So(this.db.exec("INSERT INTO Messages_bindings (queue, topic) VALUES (?, ?);", queue, topic), should.BeNil)
+}
+func (this *QueueFixture) write(dispatches ...messaging.Dispatch) (int, error) {
+	writer, _ := this.connect().Writer(this.ctx)
+	return writer.Write(this.ctx, dispatches...)
+}
+func (this *QueueFixture) read(stream messaging.Stream) (delivery messaging.Delivery) {
+	ctx, cancel := context.WithTimeout(this.ctx, time.Second)
+	defer cancel()
+
+	this.So(stream.Read(ctx, &delivery), should.BeNil)
+	return delivery
+}
+func (this *QueueFixture) tryRead(stream messaging.Stream) error {
+	ctx, cancel := context.WithTimeout(this.ctx, time.Millisecond*10)
+	defer cancel()
+
+	var delivery messaging.Delivery
+	return stream.Read(ctx, &delivery)
+}
+func (this *QueueFixture) count() int {
+	return this.db.count("SELECT COUNT(*) FROM Messages_queue;")
+}
+func (this *QueueFixture) bindings() []string {
+	return this.db.values("SELECT queue || ':' || topic FROM Messages_bindings ORDER BY queue, topic;")
+}
+func (this *QueueFixture) queued() []string {
+	return this.db.values("SELECT queue || ':' || message_id || ':' || type FROM Messages_queue ORDER BY queue;")
+}
+func (this *QueueFixture) visibleAt(messageType string, value time.Time) bool {
+	return this.db.count("SELECT COUNT(*) FROM Messages_queue WHERE type = ? AND visible_at = ?;",
+		messageType, this.db.timestamp(value)) == 1
+}
@@ -0,0 +1,190 @@
+package integration
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3/sqlmq"
+)
+
+func TestRetentionFixtureSQLite(t *testing.T) {
+	gunit.Run(new(SQLiteRetentionFixture), t)
+}
+func TestRetentionFixturePostgreSQL(t *testing.T) {
+	requirePostgreSQL(t)
+	gunit.Run(new(PostgreSQLRetentionFixture), t, gunit.Options.AllSequential())
+}
+
+type SQLiteRetentionFixture struct{ RetentionFixture }
+type PostgreSQLRetentionFixture struct{ RetentionFixture }
+
+func (this *SQLiteRetentionFixture) Setup()     { this.setup(sqlmq.SQLite) }
+func (this *PostgreSQLRetentionFixture) Setup() { this.setup(sqlmq.PostgreSQL) }
+
+type RetentionFixture struct {
+	*gunit.Fixture
+	nopMonitor
+
+	db        *database
+	now       time.Time
+	batchSize int
+	archive   string
+
+	lock   sync.Mutex
+	purged []int
+	logged []string
+}
+
+func (this *RetentionFixture) setup(dialect sqlmq.Dialect) {
+	this.db = openDatabase(this.Fixture, dialect)
+
+	this.now = time.Now().UTC().Truncate(time.Millisecond)
+	this.batchSize = 1000
+}
+func (this *RetentionFixture) Teardown() {
+	_ = this.db.Close()
+}
+
+// purge runs a processor (without a broker) until done or until waiting longer is pointless.
+func (this *RetentionFixture) purge(done func() bool) {
+	archive := sqlmq.Options.RetentionPeriod(time.Hour)
+	if len(this.archive) > 0 {
+		archive = sqlmq.Options.ArchiveTableName(this.archive)
+	}
+
+	_, processor := sqlmq.New(nil,
+		sqlmq.Options.StorageHandle(this.db.DB),
+		sqlmq.Options.Dialect(this.db.dialect),
+		sqlmq.Options.RetentionPeriod(time.Hour),
+		sqlmq.Options.PurgeInterval(time.Millisecond),
+		sqlmq.Options.PurgeBatchSize(this.batchSize),
+		archive,
+		sqlmq.Options.Now(func() time.Time { return this.now }),
+		sqlmq.Options.Logger(this),
+		sqlmq.Options.Monitor(this),
+	)
+	listening := make(chan struct{})
+	go func() {
+		defer close(listening)
+		processor.Listen()
+	}()
+
+	for deadline := time.Now().Add(time.Second); !done() && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+	}
+	time.Sleep(time.Millisecond * 10) // such that the remaining rows would have been purged were they expired
+
+	_ = processor.Close()
+	<-listening
+}
+
+func (this *RetentionFixture) TestOnlyRowsConfirmedLongerAgoThanRetentionPeriodArePurged() {
+	this.insert(1, this.now.Add(-time.Hour*2))
+	this.insert(2, this.now.Add(-time.Hour-time.Millisecond))
+	this.insert(3, this.now.Add(-time.Minute))
+
+	this.purge(this.remain("Messages", 3))
+
+	this.So(this.remaining("Messages"), should.Resemble, []uint64{3})
+	this.So(this.db.tableExists("Archive"), should.BeFalse)
+	this.So(this.purged, should.Resemble, []int{2})
+}
+func (this *RetentionFixture) TestWhenNothingHasExpired_NothingIsReported() {
+	this.insert(1, this.now)
+
+	this.purge(this.remain("Messages", 1))
+
+	this.So(this.remaining("Messages"), should.Resemble, []uint64{1})
+	this.So(this.purged, should.BeEmpty)
+}
+func (this *RetentionFixture) TestRowsArePurgedInBoundedBatches() {
+	this.batchSize = 2
+	for id := uint64(1); id <= 5; id++ {
+		this.insert(id, this.now.Add(-time.Hour*2))
+	}
+
+	this.purge(this.remain("Messages"))
+
+	this.So(this.remaining("Messages"), should.BeEmpty)
+	this.So(this.purged, should.Resemble, []int{2, 2, 1})
+}
+func (this *RetentionFixture) TestWhenArchiving_ArchiveTableIsCreatedAndPurgedRowsAreMovedToIt() {
+	this.archive = "Archive"
+	this.insert(1, this.now.Add(-time.Hour*2))
+	this.insert(2, this.now)
+	this.So(this.db.exec("UPDATE Messages SET headers = ? WHERE id = 1;",
+		[]byte(`{"a":{"type":"string","value":"b"}}`)), should.BeNil)
+
+	this.purge(this.remain("Messages", 2))
+
+	this.So(this.remaining("Messages"), should.Resemble, []uint64{2})
+	this.So(this.remaining("Archive"), should.Resemble, []uint64{1})
+	this.So(this.db.count("SELECT COUNT(*) FROM Archive WHERE id = 1 AND type = 'type-1' AND dispatched = ?;",
+		this.db.timestamp(this.now.Add(-time.Hour*2))), should.Equal, 1)
+
+	var headers []byte
+	this.So(this.db.QueryRow("SELECT headers FROM Archive WHERE id = 1;").Scan(&headers), should.BeNil)
+	this.So(string(headers), should.Equal, `{"a":{"type":"string","value":"b"}}`)
+}
+func (this *RetentionFixture) TestWhenArchiveTableExists_ItIsUsedAsItIs() {
+	this.archive = "Archive"
+	this.insert(1, this.now.Add(-time.Hour*2))
+	this.purge(this.remain("Archive", 1))
+	this.insert(2, this.now.Add(-time.Hour*2))
+
+	this.purge(this.remain("Archive", 1, 2))
+
+	this.So(this.remaining("Archive"), should.Resemble, []uint64{1, 2})
+	this.So(this.warned(), should.BeFalse)
+}
+func (this *RetentionFixture) TestWhenArchivingFails_NothingIsDeleted() {
+	this.So(this.db.exec("CREATE TABLE Archive (id bigint NOT NULL PRIMARY KEY);"), should.BeNil) // lacks the columns
+	this.archive = "Archive"
+	this.insert(1, this.now.Add(-time.Hour*2))
+
+	this.purge(this.warned)
+
+	this.So(this.remaining("Messages"), should.Resemble, []uint64{1})
+	this.So(this.purged, should.BeEmpty)
+	this.So(this.warned(), should.BeTrue)
+}
+
+func (this *RetentionFixture) insert(id uint64, dispatched time.Time) {
+	this.So(this.db.exec("INSERT INTO Messages (id, dispatched, type, payload) VALUES (?, ?, ?, ?);",
+		id, this.db.timestamp(dispatched), fmt.Sprintf("type-%d", id), []byte("payload")), should.BeNil)
+}
+func (this *RetentionFixture) remaining(table string) []uint64 {
+	return this.db.identities("SELECT id FROM " + table + " ORDER BY id;")
+}
+func (this *RetentionFixture) remain(table string, identities ...uint64) func() bool {
+	return func() bool { return fmt.Sprint(this.remaining(table)) == fmt.Sprint(identities) }
+}
+func (this *RetentionFixture) warned() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for _, message := range this.logged {
+		if strings.HasPrefix(message, "[WARN] Unable to purge dispatched messages") {
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *RetentionFixture) Printf(format string, args ...interface{}) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.logged = append(this.logged, fmt.Sprintf(format, args...))
+}
+
+func (this *RetentionFixture) MessagePurged(count int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.purged = append(this.purged, count)
+}
@@ -0,0 +1,182 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3/sqlmq"
+)
+
+func TestSchemaFixtureSQLite(t *testing.T) {
+	gunit.Run(new(SQLiteSchemaFixture), t)
+}
+func TestSchemaFixturePostgreSQL(t *testing.T) {
+	requirePostgreSQL(t)
+	gunit.Run(new(PostgreSQLSchemaFixture), t, gunit.Options.AllSequential())
+}
+
+type SQLiteSchemaFixture struct{ SchemaFixture }
+type PostgreSQLSchemaFixture struct{ SchemaFixture }
+
+func (this *SQLiteSchemaFixture) Setup()     { this.setup(sqlmq.SQLite) }
+func (this *PostgreSQLSchemaFixture) Setup() { this.setup(sqlmq.PostgreSQL) }
+
+type SchemaFixture struct {
+	*gunit.Fixture
+
+	ctx     context.Context
+	db      *database
+	manager sqlmq.SchemaManager
+}
+
+func (this *SchemaFixture) setup(dialect sqlmq.Dialect) {
+	this.ctx = context.Background()
+
+	this.db = openEmptyDatabase(this.Fixture, dialect)
+
+	this.manager = sqlmq.NewSchemaManager(sqlmq.Options.StorageHandle(this.db.DB), sqlmq.Options.Dialect(dialect))
+}
+func (this *SchemaFixture) Teardown() {
+	_ = this.db.Close()
+}
+
+func (this *SchemaFixture) TestWhenPlanningAgainstEmptyDatabase_EveryMigrationIsPendingAndNothingIsCreated() {
+	planned, err := this.manager.Plan(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(versions(planned), should.Resemble, []int{1, 2, 3, 4, 5, 6, 7, 8})
+	this.So(this.db.tableExists("Messages"), should.BeFalse)
+	this.So(this.db.tableExists("Messages_migrations"), should.BeFalse)
+}
+func (this *SchemaFixture) TestWhenPlanningWithoutConnectivity_ReturnError() {
+	_ = this.db.Close()
+
+	planned, err := this.manager.Plan(this.ctx)
+
+	this.So(err, should.NotBeNil)
+	this.So(planned, should.BeEmpty)
+}
+func (this *SchemaFixture) TestWhenMigratingEmptyDatabase_CreateTablesWithIndexesAndRecordEachVersion() {
+	applied, err := this.manager.Migrate(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(versions(applied), should.Resemble, []int{1, 2, 3, 4, 5, 6, 7, 8})
+	this.So(this.recorded(), should.Resemble, []uint64{1, 2, 3, 4, 5, 6, 7, 8})
+	this.So(this.query("SELECT id, dispatched, inserted, claimed_by, claimed_until, claim_token, "+storedColumns+
+		" FROM Messages;"), should.BeNil)
+	this.So(this.db.indexExists("ix_messages_dispatched"), should.BeTrue)
+	this.So(this.db.indexExists("ix_messages_claimed"), should.BeTrue)
+	this.So(this.query("SELECT id, queue, visible_at, delivery_count, claimed_by, message_id, "+storedColumns+
+		" FROM Messages_queue;"), should.BeNil)
+	this.So(this.db.indexExists("ix_messages_queue_visible"), should.BeTrue)
+	this.So(this.query("SELECT queue, topic FROM Messages_bindings;"), should.BeNil)
+}
+func (this *SchemaFixture) TestWhenQueueTablesExistWithoutHistory_AdoptAtQueueVersion() {
+	_, _ = this.manager.Migrate(this.ctx)
+	this.So(this.db.exec("DROP TABLE Messages_migrations;"), should.BeNil)
+
+	applied, err := this.manager.Migrate(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(applied, should.BeEmpty)
+	this.So(this.recorded(), should.Resemble, []uint64{1, 2, 3, 4, 5, 6, 7, 8})
+}
+func (this *SchemaFixture) TestWhenMigratingAgain_NothingIsApplied() {
+	_, _ = this.manager.Migrate(this.ctx)
+
+	planned, planErr := this.manager.Plan(this.ctx)
+	applied, migrateErr := this.manager.Migrate(this.ctx)
+
+	this.So(planErr, should.BeNil)
+	this.So(planned, should.BeEmpty)
+	this.So(migrateErr, should.BeNil)
+	this.So(applied, should.BeEmpty)
+	this.So(this.recorded(), should.Resemble, []uint64{1, 2, 3, 4, 5, 6, 7, 8})
+}
+func (this *SchemaFixture) TestWhenTablePredatesManager_AdoptAtCorrespondingVersionAndUpgrade() {
+	this.So(this.db.exec(this.table("deliver_at %[2]s NULL")), should.BeNil)
+	this.So(this.db.exec("CREATE UNIQUE INDEX ix_messages_dispatched ON Messages (dispatched, id);"), should.BeNil)
+	this.So(this.db.exec("INSERT INTO Messages (type, payload) VALUES ('a', 'b');"), should.BeNil)
+
+	planned, planErr := this.manager.Plan(this.ctx)
+	applied, migrateErr := this.manager.Migrate(this.ctx)
+
+	this.So(planErr, should.BeNil)
+	this.So(versions(planned), should.Resemble, []int{3, 4, 5, 6, 7, 8})
+	this.So(migrateErr, should.BeNil)
+	this.So(versions(applied), should.Resemble, []int{3, 4, 5, 6, 7, 8})
+	this.So(this.recorded(), should.Resemble, []uint64{1, 2, 3, 4, 5, 6, 7, 8})
+	this.So(this.db.count("SELECT COUNT(*) FROM Messages WHERE priority = 0 AND durable = 1 AND topic = 'a' AND "+
+		"content_type = 'application/json';"), should.Equal, 1)
+}
+func (this *SchemaFixture) TestWhenTablePredatesManagerWithSomeColumnsOfMigration_AdoptOnlyUpToThatMigration() {
+	this.So(this.db.exec(this.table( // e.g. an envelope migration applied only in part
+		"deliver_at %[2]s NULL, priority %[4]s NOT NULL DEFAULT 0, headers %[3]s NULL, inserted %[2]s NULL")), should.BeNil)
+
+	planned, err := this.manager.Plan(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(versions(planned), should.Resemble, []int{4, 5, 6, 7, 8})
+}
+func (this *SchemaFixture) TestWhenMigrationFails_RollBackSuchThatItIsRetriedLater() {
+	this.So(this.db.exec("CREATE VIEW ix_messages_dispatched AS SELECT 1 AS id;"), should.BeNil) // conflicts with the index
+
+	applied, err := this.manager.Migrate(this.ctx)
+
+	this.So(err, should.NotBeNil)
+	this.So(applied, should.BeEmpty)
+	this.So(this.recorded(), should.BeEmpty)
+	this.So(this.db.tableExists("Messages"), should.BeFalse) // the failed migration was rolled back
+
+	this.So(this.db.exec("DROP VIEW ix_messages_dispatched;"), should.BeNil)
+	applied, err = this.manager.Migrate(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(versions(applied), should.Resemble, []int{1, 2, 3, 4, 5, 6, 7, 8})
+}
+func (this *SchemaFixture) TestWhenUsingCustomTableName_MigrateThatTable() {
+	this.manager = sqlmq.NewSchemaManager(sqlmq.Options.StorageHandle(this.db.DB), sqlmq.Options.Dialect(this.db.dialect),
+		sqlmq.Options.TableName("Outbox"))
+
+	applied, err := this.manager.Migrate(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(applied, should.HaveLength, 8)
+	this.So(this.db.tableExists("Outbox"), should.BeTrue)
+	this.So(this.db.tableExists("Outbox_migrations"), should.BeTrue)
+	this.So(this.db.tableExists("Outbox_queue"), should.BeTrue)
+	this.So(this.db.tableExists("Outbox_bindings"), should.BeTrue)
+	this.So(this.db.indexExists("ix_outbox_dispatched"), should.BeTrue)
+	this.So(this.db.tableExists("Messages"), should.BeFalse)
+}
+
+// table is the statement creating the table as it was before the schema manager, with the given columns appended.
+func (this *SchemaFixture) table(columns string) string {
+	dialect := this.db.dialect
+	return fmt.Sprintf("CREATE TABLE Messages (id %[1]s, dispatched %[2]s NULL, type varchar(256) NOT NULL, "+
+		"payload %[3]s NOT NULL, "+columns+");", dialect.Column(sqlmq.IdentityColumn),
+		dialect.Column(sqlmq.TimestampColumn), dialect.Column(sqlmq.BinaryColumn), dialect.Column(sqlmq.SmallIntColumn))
+}
+func (this *SchemaFixture) query(statement string) error {
+	rows, err := this.db.Query(statement)
+	if err == nil {
+		err = rows.Close()
+	}
+	return err
+}
+func (this *SchemaFixture) recorded() []uint64 {
+	return this.db.identities("SELECT version FROM Messages_migrations ORDER BY version;")
+}
+
+const storedColumns = "type, payload, deliver_at, priority, source_id, correlation_id, created, expiration, durable, " +
+	"topic, partition_id, content_type, content_encoding, headers"
+
+func versions(migrations []sqlmq.Migration) (versions []int) {
+	for _, migration := range migrations {
+		versions = append(versions, migration.Version)
+	}
+	return versions
+}
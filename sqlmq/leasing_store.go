@@ -0,0 +1,164 @@
+package sqlmq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
+)
+
+// leasingStore coordinates multiple processors sharing the same table: each pending row is leased by (claimed for) a
+// single processor until the lease expires. The processor storing a row holds its lease from the outset, other
+// processors take over its rows once the lease lapses, e.g. because the processor holding it has stopped. Each claim
+// tags the rows it leases with a token of its own by which they're selected again. Leases are measured using the clock
+// of the database (unless the dialect can't refer to it) and rows are only confirmed by the processor holding them.
+type leasingStore struct {
+	dispatchStore
+	owner    string
+	duration time.Duration
+	limit    int
+	token    func() string
+}
+
+func newLeasingStore(db adapter.ReadWriter, config configuration) leasingStore {
+	return leasingStore{
+		dispatchStore: newMessageStore(db, config.Dialect, config.TableName, config.PageSize, config.Now),
+		owner:         config.InstanceID,
+		duration:      config.LeaseDuration,
+		limit:         cap(config.Channel),
+		token:         newInstanceID,
+	}
+}
+
+func (this leasingStore) Store(ctx context.Context, writer adapter.ReadWriter, dispatches []messaging.Dispatch) error {
+	if err := this.dispatchStore.Store(ctx, writer, dispatches); err != nil {
+		return err
+	}
+
+	// scheduled rows are left unleased such that whichever processor is running once they're due claims them
+	now := this.now()
+	due := make([]interface{}, 0, len(dispatches))
+	for _, dispatch := range dispatches {
+		if !dispatch.DeliverAt.After(now) {
+			due = append(due, int64(dispatch.MessageID))
+		}
+	}
+
+	const statementFormat = "UPDATE %s SET claimed_by = %s, claimed_until = %s WHERE id IN (%s);"
+	expiration, expirationArgs := this.clock(this.duration, 2)
+	for _, page := range identityPages(due, this.pageSize) {
+		statement := fmt.Sprintf(statementFormat, this.table, this.dialect.Placeholder(1), expiration,
+			placeholders(this.dialect, 2+len(expirationArgs), len(page)))
+		args := append(append([]interface{}{this.owner}, expirationArgs...), page...)
+		if _, err := writer.ExecContext(ctx, statement, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Claim leases pending rows which are due and which aren't leased (or whose lease has expired) and returns those
+// leased.
+func (this leasingStore) Claim(ctx context.Context) ([]messaging.Dispatch, error) {
+	candidates, err := this.candidates(ctx)
+	if err != nil || len(candidates) == 0 {
+		return nil, err
+	}
+
+	// rows claimed by earlier pages are returned along with any failure such that they're still sent
+	var claimed []messaging.Dispatch
+	for _, page := range identityPages(candidates, this.pageSize) {
+		dispatches, err := this.claim(ctx, page)
+		claimed = append(claimed, dispatches...)
+		if err != nil {
+			return claimed, err
+		}
+	}
+
+	return claimed, nil
+}
+func (this leasingStore) claim(ctx context.Context, candidates []interface{}) ([]messaging.Dispatch, error) {
+	// another processor may claim any of the candidates in the meantime, only those still available are claimed
+	const updateFormat = "UPDATE %s SET claimed_by = %s, claim_token = %s, claimed_until = %s WHERE dispatched IS NULL " +
+		"AND (claimed_until IS NULL OR claimed_until < %s) AND id IN (%s);"
+	token := this.token()
+	expiration, expirationArgs := this.clock(this.duration, 3)
+	now, nowArgs := this.clock(0, 3+len(expirationArgs))
+	statement := fmt.Sprintf(updateFormat, this.table, this.dialect.Placeholder(1), this.dialect.Placeholder(2),
+		expiration, now, placeholders(this.dialect, 3+len(expirationArgs)+len(nowArgs), len(candidates)))
+	args := append(append(append([]interface{}{this.owner, token}, expirationArgs...), nowArgs...), candidates...)
+	if _, err := this.db.ExecContext(ctx, statement, args...); err != nil {
+		return nil, err
+	}
+
+	const claimedFormat = "SELECT id, %s FROM %s WHERE claim_token = %s AND id IN (%s) ORDER BY id;"
+	statement = fmt.Sprintf(claimedFormat, storedColumns, this.table, this.dialect.Placeholder(1),
+		placeholders(this.dialect, 2, len(candidates)))
+	return this.query(ctx, statement, append([]interface{}{token}, candidates...)...)
+}
+
+// Renew extends the lease of every pending row leased by this processor.
+func (this leasingStore) Renew(ctx context.Context) error {
+	const statementFormat = "UPDATE %s SET claimed_until = %s WHERE claimed_by = %s AND dispatched IS NULL;"
+	expiration, args := this.clock(this.duration, 1)
+	statement := fmt.Sprintf(statementFormat, this.table, expiration, this.dialect.Placeholder(1+len(args)))
+	_, err := this.db.ExecContext(ctx, statement, append(args, this.owner)...)
+	return err
+}
+
+// Confirm marks the dispatches as dispatched unless they're leased by another processor, e.g. because the lease of this
+// processor has lapsed in the meantime, in which case that processor sends and confirms them instead.
+func (this leasingStore) Confirm(ctx context.Context, dispatches []messaging.Dispatch) error {
+	identities := make([]interface{}, 0, len(dispatches))
+	for _, dispatch := range dispatches {
+		identities = append(identities, int64(dispatch.MessageID))
+	}
+
+	const statementFormat = "UPDATE %s SET dispatched = %s WHERE dispatched IS NULL " +
+		"AND (claimed_by IS NULL OR claimed_by = %s) AND id IN (%s);"
+	now := this.dialect.Timestamp(this.now())
+	for _, page := range identityPages(identities, this.pageSize) {
+		statement := fmt.Sprintf(statementFormat, this.table, this.dialect.Placeholder(1), this.dialect.Placeholder(2),
+			placeholders(this.dialect, 3, len(page)))
+		if _, err := this.db.ExecContext(ctx, statement, append([]interface{}{now, this.owner}, page...)...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (this leasingStore) candidates(ctx context.Context) (results []interface{}, err error) {
+	const statementFormat = "SELECT id FROM %s WHERE dispatched IS NULL AND (claimed_until IS NULL OR claimed_until < %s) " +
+		"AND (deliver_at IS NULL OR deliver_at <= %s) ORDER BY id LIMIT %d;"
+	now, args := this.clock(0, 1)
+	statement := fmt.Sprintf(statementFormat, this.table, now, this.dialect.Placeholder(1+len(args)), this.limit)
+	rows, err := this.db.QueryContext(ctx, statement, append(args, this.dialect.Timestamp(this.now()))...)
+	if err != nil {
+		return nil, err
+	}
+	defer closeResource(rows)
+
+	for rows.Next() {
+		var identity int64
+		if err = rows.Scan(&identity); err != nil {
+			return nil, err
+		}
+		results = append(results, identity)
+	}
+
+	return results, rows.Err()
+}
+
+// clock returns the expression of the current time offset by the duration provided. Unless the dialect refers to the
+// clock of the database, the expression is the placeholder at the position provided and the time is its argument.
+func (this leasingStore) clock(offset time.Duration, position int) (string, []interface{}) {
+	if clock, ok := this.dialect.(databaseClock); ok {
+		return clock.currentTime(offset), nil
+	}
+
+	return this.dialect.Placeholder(position), []interface{}{this.dialect.Timestamp(this.now().Add(offset))}
+}
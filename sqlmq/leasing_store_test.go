@@ -0,0 +1,229 @@
+package sqlmq
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
+)
+
+func TestLeasingStoreFixture(t *testing.T) {
+	gunit.Run(new(LeasingStoreFixture), t)
+}
+
+type LeasingStoreFixture struct {
+	*gunit.Fixture
+
+	ctx   context.Context
+	now   time.Time
+	store leasingStore
+
+	execStatements []string
+	execArgs       [][]interface{}
+	execError      error
+	rowsAffected   int64
+
+	queryStatements []string
+	queryArgs       [][]interface{}
+	queryResults    []adapter.QueryResult
+}
+
+func (this *LeasingStoreFixture) Setup() {
+	this.ctx = context.Background()
+	this.now = time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	this.store = this.newStore(MySQL)
+}
+func (this *LeasingStoreFixture) newStore(dialect Dialect) leasingStore {
+	config := configuration{}
+	Options.apply(
+		Options.StorageHandle(&sql.DB{}),
+		Options.Dialect(dialect),
+		Options.InstanceID("a"),
+		Options.LeaseDuration(time.Minute),
+		Options.Channel(make(chan messaging.Dispatch, 8)),
+		Options.Now(func() time.Time { return this.now }),
+	)(&config)
+	store := newLeasingStore(this, config)
+	store.token = func() string { return "token" }
+	return store
+}
+
+func (this *LeasingStoreFixture) TestWhenLeaseDurationIsNotConfigured_NoClaimerIsCreated() {
+	config := configuration{}
+	Options.apply(Options.StorageHandle(&sql.DB{}))(&config)
+
+	this.So(config.MessageClaimer, should.BeNil)
+}
+func (this *LeasingStoreFixture) TestWhenStoring_StoringProcessorHoldsLease() {
+	this.rowsAffected = 2
+	dispatches := []messaging.Dispatch{{MessageType: "1"}, {MessageType: "2"}}
+
+	err := this.store.Store(this.ctx, this, dispatches)
+
+	this.So(err, should.BeNil)
+	this.So(this.execStatements, should.HaveLength, 2)
+	this.So(this.execStatements[1], should.Equal, "UPDATE Messages SET claimed_by = ?, claimed_until = "+
+		"TIMESTAMPADD(MICROSECOND, 60000000, UTC_TIMESTAMP(3)) WHERE id IN (?, ?);")
+	this.So(this.execArgs[1], should.Resemble, []interface{}{"a", int64(1), int64(2)})
+}
+func (this *LeasingStoreFixture) TestWhenStoringScheduledDispatch_ItIsLeftUnleasedUntilDue() {
+	this.rowsAffected = 1
+	dispatches := []messaging.Dispatch{{MessageType: "1", DeliverAt: this.now.Add(time.Hour)}}
+
+	err := this.store.Store(this.ctx, this, dispatches)
+
+	this.So(err, should.BeNil)
+	this.So(this.execStatements, should.HaveLength, 1) // the insert
+}
+func (this *LeasingStoreFixture) TestWhenStoringFails_NothingIsLeased() {
+	this.execError = errors.New("")
+
+	err := this.store.Store(this.ctx, this, []messaging.Dispatch{{MessageType: "1"}})
+
+	this.So(err, should.Equal, this.execError)
+	this.So(this.execStatements, should.HaveLength, 1)
+}
+func (this *LeasingStoreFixture) TestWhenClaiming_LeaseCandidatesUsingTokenOfClaimAndReturnThoseTaggedWithIt() {
+	this.queryResults = []adapter.QueryResult{
+		&identityRows{identities: []int64{1, 2}},
+		&storageQueryResult{items: []messaging.Dispatch{{MessageID: 1, MessageType: "type"}}}, // 2 was taken meanwhile
+	}
+
+	claimed, err := this.store.Claim(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(messageIDs(claimed), should.Resemble, []uint64{1})
+	this.So(this.queryStatements[0], should.Equal, "SELECT id FROM Messages WHERE dispatched IS NULL AND "+
+		"(claimed_until IS NULL OR claimed_until < TIMESTAMPADD(MICROSECOND, 0, UTC_TIMESTAMP(3))) AND "+
+		"(deliver_at IS NULL OR deliver_at <= ?) ORDER BY id LIMIT 8;")
+	this.So(this.queryArgs[0], should.Resemble, []interface{}{this.now})
+	this.So(this.execStatements, should.Resemble, []string{"UPDATE Messages SET claimed_by = ?, claim_token = ?, " +
+		"claimed_until = TIMESTAMPADD(MICROSECOND, 60000000, UTC_TIMESTAMP(3)) WHERE dispatched IS NULL AND " +
+		"(claimed_until IS NULL OR claimed_until < TIMESTAMPADD(MICROSECOND, 0, UTC_TIMESTAMP(3))) AND id IN (?, ?);"})
+	this.So(this.execArgs[0], should.Resemble, []interface{}{"a", "token", int64(1), int64(2)})
+	this.So(this.queryStatements[1], should.Equal, "SELECT id, "+storedColumns+" FROM Messages WHERE claim_token = ? "+
+		"AND id IN (?, ?) ORDER BY id;")
+	this.So(this.queryArgs[1], should.Resemble, []interface{}{"token", int64(1), int64(2)})
+}
+func (this *LeasingStoreFixture) TestWhenNothingIsAvailable_NothingIsLeased() {
+	this.queryResults = []adapter.QueryResult{&identityRows{identities: []int64{}}}
+
+	claimed, err := this.store.Claim(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(claimed, should.BeEmpty)
+	this.So(this.execStatements, should.BeEmpty)
+}
+func (this *LeasingStoreFixture) TestWhenMoreCandidatesThanPage_LeaseAndClaimEachPageSeparately() {
+	this.store.pageSize = 2
+	this.queryResults = []adapter.QueryResult{
+		&identityRows{identities: []int64{1, 2, 3, 4, 5}},
+		&storageQueryResult{items: []messaging.Dispatch{{MessageID: 1}, {MessageID: 2}}},
+		&storageQueryResult{items: []messaging.Dispatch{{MessageID: 3}, {MessageID: 4}}},
+		&storageQueryResult{items: []messaging.Dispatch{{MessageID: 5}}},
+	}
+
+	claimed, err := this.store.Claim(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(messageIDs(claimed), should.Resemble, []uint64{1, 2, 3, 4, 5})
+	this.So(this.execArgs, should.Resemble, [][]interface{}{
+		{"a", "token", int64(1), int64(2)},
+		{"a", "token", int64(3), int64(4)},
+		{"a", "token", int64(5)},
+	})
+}
+func (this *LeasingStoreFixture) TestWhenLeasingPageFails_ReturnRowsClaimedByEarlierPagesAlongWithFailure() {
+	this.store.pageSize = 1
+	this.queryResults = []adapter.QueryResult{
+		&identityRows{identities: []int64{1, 2}},
+		&storageQueryResult{items: []messaging.Dispatch{{MessageID: 1}}},
+	}
+	this.store.token = func() string {
+		if len(this.execStatements) > 0 {
+			this.execError = errors.New("")
+		}
+		return "token"
+	}
+
+	claimed, err := this.store.Claim(this.ctx)
+
+	this.So(err, should.NotBeNil)
+	this.So(messageIDs(claimed), should.Resemble, []uint64{1})
+}
+func (this *LeasingStoreFixture) TestWhenRenewing_ExtendLeaseOfEveryPendingRowHeld() {
+	err := this.store.Renew(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(this.execStatements, should.Resemble, []string{"UPDATE Messages SET claimed_until = " +
+		"TIMESTAMPADD(MICROSECOND, 60000000, UTC_TIMESTAMP(3)) WHERE claimed_by = ? AND dispatched IS NULL;"})
+	this.So(this.execArgs[0], should.Resemble, []interface{}{"a"})
+}
+func (this *LeasingStoreFixture) TestWhenConfirming_RowsLeasedByAnotherProcessorAreNotConfirmed() {
+	err := this.store.Confirm(this.ctx, []messaging.Dispatch{{MessageID: 1}, {MessageID: 2}})
+
+	this.So(err, should.BeNil)
+	this.So(this.execStatements, should.Resemble, []string{"UPDATE Messages SET dispatched = ? WHERE dispatched IS NULL " +
+		"AND (claimed_by IS NULL OR claimed_by = ?) AND id IN (?, ?);"})
+	this.So(this.execArgs[0], should.Resemble, []interface{}{this.now, "a", int64(1), int64(2)})
+}
+func (this *LeasingStoreFixture) TestWhenDialectDoesNotReferToClockOfDatabase_LeasesAreMeasuredUsingClockOfProcessor() {
+	this.store = this.newStore(struct{ Dialect }{PostgreSQL})
+
+	_ = this.store.Renew(this.ctx)
+
+	this.So(this.execStatements, should.Resemble, []string{
+		"UPDATE Messages SET claimed_until = $1 WHERE claimed_by = $2 AND dispatched IS NULL;"})
+	this.So(this.execArgs[0], should.Resemble, []interface{}{this.now.Add(time.Minute), "a"})
+}
+
+func messageIDs(dispatches []messaging.Dispatch) (ids []uint64) {
+	for _, dispatch := range dispatches {
+		ids = append(ids, dispatch.MessageID)
+	}
+	return ids
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *LeasingStoreFixture) ExecContext(_ context.Context, statement string, args ...interface{}) (sql.Result, error) {
+	this.execStatements = append(this.execStatements, statement)
+	this.execArgs = append(this.execArgs, args)
+	return this, this.execError
+}
+func (this *LeasingStoreFixture) LastInsertId() (int64, error) { return 1, nil }
+func (this *LeasingStoreFixture) RowsAffected() (int64, error) { return this.rowsAffected, nil }
+
+func (this *LeasingStoreFixture) QueryContext(_ context.Context, statement string, args ...interface{}) (adapter.QueryResult, error) {
+	this.queryStatements = append(this.queryStatements, statement)
+	this.queryArgs = append(this.queryArgs, args)
+	result := this.queryResults[0]
+	this.queryResults = this.queryResults[1:]
+	return result, nil
+}
+func (this *LeasingStoreFixture) QueryRowContext(_ context.Context, _ string, _ ...interface{}) adapter.RowScanner {
+	panic("nop")
+}
+
+// identityRows is the result of a query selecting only the identity of each row.
+type identityRows struct {
+	identities []int64
+	index      int
+}
+
+func (this *identityRows) Scan(fields ...interface{}) error {
+	*(fields[0].(*int64)) = this.identities[this.index-1]
+	return nil
+}
+func (this *identityRows) Next() bool {
+	this.index++
+	return this.index <= len(this.identities)
+}
+func (this *identityRows) Err() error   { return nil }
+func (this *identityRows) Close() error { return nil }
@@ -0,0 +1,103 @@
+package sqlmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
+)
+
+// queueReader opens streams of the rows written to a named queue. Establishing topology binds the queue to each of the
+// topics such that dispatches subsequently written to those topics are written to the queue.
+type queueReader struct {
+	db       adapter.Handle
+	dialect  Dialect
+	bindings string
+	config   configuration
+	logger   logger
+}
+
+func newQueueReader(config configuration) messaging.Reader {
+	return queueReader{
+		db:       config.StorageHandle,
+		dialect:  config.Dialect,
+		bindings: bindingsTableName(config.TableName),
+		config:   config,
+		logger:   config.Logger,
+	}
+}
+
+func (this queueReader) Stream(ctx context.Context, settings messaging.StreamConfig) (messaging.Stream, error) {
+	if len(settings.StreamName) == 0 {
+		return nil, errStreamNameRequired
+	}
+
+	if settings.EstablishTopology {
+		if err := this.bindTopics(ctx, settings); err != nil {
+			this.logger.Printf("[WARN] Unable to establish topology, queue binding failed [%s].", err)
+			return nil, err
+		}
+	} else if settings.VerifyTopology {
+		if err := this.verifyTopology(ctx, settings); err != nil {
+			this.logger.Printf("[WARN] Unable to verify topology [%s].", err)
+			return nil, err
+		}
+	}
+
+	this.logger.Printf("[INFO] Consumer opened for queue [%s], awaiting messages...", settings.StreamName)
+	return newQueueStream(settings, this.config), nil
+}
+func (this queueReader) bindTopics(ctx context.Context, settings messaging.StreamConfig) error {
+	statement := fmt.Sprintf("INSERT INTO %s (queue, topic) VALUES (%s, %s);",
+		this.bindings, this.dialect.Placeholder(1), this.dialect.Placeholder(2))
+
+	for _, topic := range settings.Topics {
+		if bound, err := this.isBound(ctx, settings.StreamName, topic); err != nil {
+			return err
+		} else if bound {
+			continue
+		}
+
+		if _, err := this.db.ExecContext(ctx, statement, settings.StreamName, topic); err != nil {
+			if bound, _ := this.isBound(ctx, settings.StreamName, topic); !bound {
+				return err
+			} // otherwise another consumer bound it in the meantime
+		}
+	}
+
+	return nil
+}
+func (this queueReader) verifyTopology(ctx context.Context, settings messaging.StreamConfig) error {
+	var missing []string
+	for _, topic := range settings.Topics {
+		if bound, err := this.isBound(ctx, settings.StreamName, topic); err != nil {
+			return err
+		} else if !bound {
+			missing = append(missing, topic)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: queue [%s], topics [%s]", errMissingBinding, settings.StreamName, strings.Join(missing, ", "))
+}
+func (this queueReader) isBound(ctx context.Context, queue, topic string) (bool, error) {
+	statement := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE queue = %s AND topic = %s;",
+		this.bindings, this.dialect.Placeholder(1), this.dialect.Placeholder(2))
+
+	var count int
+	err := this.db.QueryRowContext(ctx, statement, queue, topic).Scan(&count)
+	return count > 0, err
+}
+
+func (this queueReader) Close() error { return nil }
+
+var (
+	errStreamNameRequired = errors.New("streams read from a queue must be named")
+	errMissingBinding     = errors.New("the queue isn't bound to one or more topics")
+)
@@ -0,0 +1,140 @@
+package sqlmq
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
+)
+
+func TestQueueReaderFixture(t *testing.T) {
+	gunit.Run(new(QueueReaderFixture), t)
+}
+
+type QueueReaderFixture struct {
+	*gunit.Fixture
+
+	ctx    context.Context
+	reader messaging.Reader
+
+	bound          map[string]bool
+	boundError     error
+	execStatements []string
+	execArgs       [][]interface{}
+	execError      error
+	bindOnExec     bool
+}
+
+func (this *QueueReaderFixture) Setup() {
+	this.ctx = context.Background()
+	this.bound = make(map[string]bool)
+
+	config := configuration{}
+	Options.apply(Options.StorageHandle(&sql.DB{}), Options.Dialect(PostgreSQL))(&config)
+	config.StorageHandle = this
+	this.reader = newQueueReader(config)
+}
+
+func (this *QueueReaderFixture) TestWhenStreamIsNotNamed_ReturnError() {
+	stream, err := this.reader.Stream(this.ctx, messaging.StreamConfig{TemporaryStream: true})
+
+	this.So(stream, should.BeNil)
+	this.So(err, should.Equal, errStreamNameRequired)
+}
+func (this *QueueReaderFixture) TestWhenEstablishingTopology_BindQueueToEachTopicNotYetBound() {
+	this.bound["queue:a"] = true
+	settings := messaging.StreamConfig{StreamName: "queue", Topics: []string{"a", "b"}, EstablishTopology: true}
+
+	stream, err := this.reader.Stream(this.ctx, settings)
+
+	this.So(err, should.BeNil)
+	this.So(stream, should.HaveSameTypeAs, &queueStream{})
+	this.So(this.execStatements, should.Resemble, []string{"INSERT INTO Messages_bindings (queue, topic) VALUES ($1, $2);"})
+	this.So(this.execArgs, should.Resemble, [][]interface{}{{"queue", "b"}})
+}
+func (this *QueueReaderFixture) TestWhenBindingFails_ReturnError() {
+	this.execError = errors.New("")
+
+	stream, err := this.reader.Stream(this.ctx, messaging.StreamConfig{StreamName: "queue", Topics: []string{"a"}, EstablishTopology: true})
+
+	this.So(stream, should.BeNil)
+	this.So(err, should.Equal, this.execError)
+}
+func (this *QueueReaderFixture) TestWhenBindingFailsBecauseAnotherConsumerBoundQueueMeanwhile_OpenStream() {
+	this.execError = errors.New("")
+	this.bindOnExec = true
+
+	stream, err := this.reader.Stream(this.ctx, messaging.StreamConfig{StreamName: "queue", Topics: []string{"a"}, EstablishTopology: true})
+
+	this.So(stream, should.NotBeNil)
+	this.So(err, should.BeNil)
+}
+func (this *QueueReaderFixture) TestWhenCheckingBindingFails_ReturnError() {
+	this.boundError = errors.New("")
+
+	stream, err := this.reader.Stream(this.ctx, messaging.StreamConfig{StreamName: "queue", Topics: []string{"a"}, EstablishTopology: true})
+
+	this.So(stream, should.BeNil)
+	this.So(err, should.Equal, this.boundError)
+	this.So(this.execStatements, should.BeEmpty)
+}
+func (this *QueueReaderFixture) TestWhenVerifyingTopology_ReportTopicsToWhichQueueIsNotBound() {
+	this.bound["queue:a"] = true
+	settings := messaging.StreamConfig{StreamName: "queue", Topics: []string{"a", "b", "c"}, VerifyTopology: true}
+
+	stream, err := this.reader.Stream(this.ctx, settings)
+
+	this.So(stream, should.BeNil)
+	this.So(errors.Is(err, errMissingBinding), should.BeTrue)
+	this.So(err.Error(), should.EndWith, "queue [queue], topics [b, c]")
+	this.So(this.execStatements, should.BeEmpty)
+}
+func (this *QueueReaderFixture) TestWhenVerifyingCompleteTopology_OpenStream() {
+	this.bound["queue:a"] = true
+
+	stream, err := this.reader.Stream(this.ctx, messaging.StreamConfig{StreamName: "queue", Topics: []string{"a"}, VerifyTopology: true})
+
+	this.So(stream, should.NotBeNil)
+	this.So(err, should.BeNil)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *QueueReaderFixture) QueryRowContext(_ context.Context, statement string, args ...interface{}) adapter.RowScanner {
+	this.So(statement, should.Equal, "SELECT COUNT(*) FROM Messages_bindings WHERE queue = $1 AND topic = $2;")
+	return boundCount{bound: this.bound[args[0].(string)+":"+args[1].(string)], err: this.boundError}
+}
+func (this *QueueReaderFixture) ExecContext(_ context.Context, statement string, args ...interface{}) (sql.Result, error) {
+	this.execStatements = append(this.execStatements, statement)
+	this.execArgs = append(this.execArgs, args)
+	if this.bindOnExec {
+		this.bound[args[0].(string)+":"+args[1].(string)] = true
+	}
+	return nil, this.execError
+}
+func (this *QueueReaderFixture) QueryContext(_ context.Context, _ string, _ ...interface{}) (adapter.QueryResult, error) {
+	panic("nop")
+}
+func (this *QueueReaderFixture) BeginTx(_ context.Context, _ *sql.TxOptions) (adapter.Transaction, error) {
+	panic("nop")
+}
+func (this *QueueReaderFixture) DBHandle() *sql.DB { panic("nop") }
+func (this *QueueReaderFixture) Close() error      { panic("nop") }
+
+// boundCount is the result of counting the bindings of a queue to a topic.
+type boundCount struct {
+	bound bool
+	err   error
+}
+
+func (this boundCount) Scan(fields ...interface{}) error {
+	if this.bound {
+		*(fields[0].(*int)) = 1
+	}
+	return this.err
+}
@@ -0,0 +1,272 @@
+package sqlmq
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
+)
+
+// queueStream reads the rows of a queue, each of which is claimed by a single stream at a time. A claimed row is
+// invisible to other streams until its visibility timeout lapses, after which it's delivered again unless it has been
+// acknowledged (and thereby deleted) in the meantime.
+type queueStream struct {
+	ctx       context.Context
+	shutdown  context.CancelFunc
+	parent    context.Context
+	db        adapter.Handle
+	dialect   Dialect
+	table     string
+	name      string
+	owner     string
+	capacity  int
+	pageSize  int
+	ordering  string
+	timeout   time.Duration
+	interval  time.Duration
+	retryWait time.Duration
+	now       func() time.Time
+	logger    logger
+
+	mutex  sync.Mutex
+	buffer []messaging.Delivery
+}
+
+func newQueueStream(settings messaging.StreamConfig, config configuration) messaging.Stream {
+	ctx, shutdown := context.WithCancel(config.Context)
+
+	capacity := int(settings.BufferCapacity)
+	if capacity == 0 {
+		capacity = 1
+	}
+
+	ordering := "id"
+	if settings.MaxPriority > 0 {
+		ordering = "priority DESC, id"
+	}
+
+	return &queueStream{
+		ctx:       ctx,
+		shutdown:  shutdown,
+		parent:    config.Context,
+		db:        config.StorageHandle,
+		dialect:   config.Dialect,
+		table:     queueTableName(config.TableName),
+		name:      settings.StreamName,
+		owner:     newInstanceID(),
+		capacity:  capacity,
+		pageSize:  config.PageSize,
+		ordering:  ordering,
+		timeout:   config.VisibilityTimeout,
+		interval:  config.PollInterval,
+		retryWait: config.Sleep,
+		now:       config.Now,
+		logger:    config.Logger,
+	}
+}
+
+func (this *queueStream) Read(ctx context.Context, target *messaging.Delivery) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for len(this.buffer) == 0 {
+		if this.ctx.Err() != nil {
+			return io.EOF // closed
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := this.claim(ctx); err != nil {
+			this.logger.Printf("[WARN] Unable to claim messages from queue [%s]: %s", this.name, err)
+			if err = this.unlockedWait(ctx, this.retryWait); err != nil {
+				return err
+			}
+		} else if len(this.buffer) == 0 {
+			if err = this.unlockedWait(ctx, this.interval); err != nil {
+				return err
+			}
+		}
+	}
+
+	*target = this.buffer[0]
+	this.buffer[0] = messaging.Delivery{} // clear it out to avoid a memory leak
+	this.buffer = this.buffer[1:]
+	return nil
+}
+func (this *queueStream) claim(ctx context.Context) error {
+	now := this.now()
+	candidates, err := this.candidates(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	// another stream may claim any of the candidates in the meantime, only those still visible are claimed
+	visibleAt := this.dialect.Timestamp(now.Add(this.timeout).UTC().Truncate(time.Millisecond))
+	for _, page := range identityPages(candidates, this.pageSize) {
+		const updateFormat = "UPDATE %s SET claimed_by = %s, visible_at = %s, delivery_count = delivery_count + 1 " +
+			"WHERE visible_at <= %s AND id IN (%s);"
+		statement := fmt.Sprintf(updateFormat, this.table, this.dialect.Placeholder(1), this.dialect.Placeholder(2),
+			this.dialect.Placeholder(3), placeholders(this.dialect, 4, len(page)))
+		args := append([]interface{}{this.owner, visibleAt, this.dialect.Timestamp(now)}, page...)
+		if _, err = this.db.ExecContext(ctx, statement, args...); err != nil {
+			return err
+		}
+
+		const claimedFormat = "SELECT id, delivery_count, message_id, %s FROM %s " +
+			"WHERE claimed_by = %s AND visible_at = %s AND id IN (%s) ORDER BY %s;"
+		statement = fmt.Sprintf(claimedFormat, storedColumns, this.table, this.dialect.Placeholder(1),
+			this.dialect.Placeholder(2), placeholders(this.dialect, 3, len(page)), this.ordering)
+		if err = this.load(ctx, now, statement, append([]interface{}{this.owner, visibleAt}, page...)...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+func (this *queueStream) candidates(ctx context.Context, now time.Time) ([]interface{}, error) {
+	const statementFormat = "SELECT id FROM %s WHERE queue = %s AND visible_at <= %s ORDER BY %s LIMIT %d;"
+	statement := fmt.Sprintf(statementFormat, this.table, this.dialect.Placeholder(1), this.dialect.Placeholder(2),
+		this.ordering, this.capacity)
+	rows, err := this.db.QueryContext(ctx, statement, this.name, this.dialect.Timestamp(now))
+	if err != nil {
+		return nil, err
+	}
+	defer closeResource(rows)
+
+	identities := make([]interface{}, 0, this.capacity)
+	for rows.Next() {
+		var identity int64
+		if err = rows.Scan(&identity); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+func (this *queueStream) load(ctx context.Context, now time.Time, statement string, args ...interface{}) error {
+	rows, err := this.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return err
+	}
+	defer closeResource(rows)
+
+	var expired []messaging.Delivery
+	for rows.Next() {
+		var dispatch messaging.Dispatch
+		var identity, count uint64
+		var messageID int64
+		if err = scanStored(rows, &dispatch, &identity, &count, &messageID); err != nil {
+			return err
+		}
+
+		delivery := newDelivery(identity, count, uint64(messageID), dispatch)
+		if dispatch.Expiration > 0 && !now.Before(dispatch.Timestamp.Add(dispatch.Expiration)) {
+			expired = append(expired, delivery)
+		} else {
+			this.buffer = append(this.buffer, delivery)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	return this.delete(ctx, expired) // like a broker, expired messages are discarded rather than delivered
+}
+
+func (this *queueStream) Acknowledge(ctx context.Context, deliveries ...messaging.Delivery) error {
+	if err := this.delete(ctx, deliveries); err != nil {
+		this.logger.Printf("[WARN] Unable to acknowledge deliveries from queue [%s]: %s", this.name, err)
+		return err
+	}
+
+	return nil
+}
+
+// delete removes the rows of the deliveries unless their visibility timeout lapsed and another stream has claimed them.
+func (this *queueStream) delete(ctx context.Context, deliveries []messaging.Delivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(deliveries)+1)
+	for _, delivery := range deliveries {
+		args = append(args, int64(delivery.DeliveryID))
+	}
+
+	statement := fmt.Sprintf("DELETE FROM %s WHERE id IN (%s) AND claimed_by = %s;",
+		this.table, placeholders(this.dialect, 1, len(deliveries)), this.dialect.Placeholder(len(deliveries)+1))
+	_, err := this.db.ExecContext(ctx, statement, append(args, this.owner)...)
+	return err
+}
+
+// unlockedWait releases the mutex while waiting such that the stream can be closed (and other readers can proceed) in
+// the meantime.
+func (this *queueStream) unlockedWait(ctx context.Context, duration time.Duration) error {
+	this.mutex.Unlock()
+	defer this.mutex.Lock()
+	return this.wait(ctx, duration)
+}
+func (this *queueStream) wait(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-this.ctx.Done():
+		return io.EOF
+	}
+}
+
+// Close stops reading and makes the rows which were claimed but not yet read visible to other streams again.
+func (this *queueStream) Close() error {
+	this.shutdown()
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if len(this.buffer) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(this.buffer)+1)
+	args = append(args, this.dialect.Timestamp(this.now()))
+	for _, delivery := range this.buffer {
+		args = append(args, int64(delivery.DeliveryID))
+	}
+
+	statement := fmt.Sprintf("UPDATE %s SET visible_at = %s, claimed_by = NULL WHERE id IN (%s) AND claimed_by = %s;",
+		this.table, this.dialect.Placeholder(1), placeholders(this.dialect, 2, len(this.buffer)), this.dialect.Placeholder(len(args)+1))
+	_, err := this.db.ExecContext(this.parent, statement, append(args, this.owner)...)
+	this.buffer = nil
+	return err
+}
+
+func newDelivery(identity, count, messageID uint64, dispatch messaging.Dispatch) messaging.Delivery {
+	return messaging.Delivery{
+		DeliveryID:      identity,
+		DeliveryCount:   count,
+		Redelivered:     count > 1,
+		SourceID:        dispatch.SourceID,
+		MessageID:       messageID,
+		CorrelationID:   dispatch.CorrelationID,
+		Timestamp:       dispatch.Timestamp,
+		Durable:         dispatch.Durable,
+		Priority:        dispatch.Priority,
+		Topic:           dispatch.Topic,
+		Partition:       dispatch.Partition,
+		MessageType:     dispatch.MessageType,
+		ContentType:     dispatch.ContentType,
+		ContentEncoding: dispatch.ContentEncoding,
+		Payload:         dispatch.Payload,
+		Headers:         dispatch.Headers,
+	}
+}
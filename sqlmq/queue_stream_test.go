@@ -0,0 +1,200 @@
+package sqlmq
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
+)
+
+func TestQueueStreamFixture(t *testing.T) {
+	gunit.Run(new(QueueStreamFixture), t)
+}
+
+type QueueStreamFixture struct {
+	*gunit.Fixture
+
+	ctx    context.Context
+	now    time.Time
+	config configuration
+
+	queryStatements []string
+	queryArgs       [][]interface{}
+	queryResults    []adapter.QueryResult
+	queryError      error
+	execStatements  []string
+	execArgs        [][]interface{}
+	execError       error
+}
+
+func (this *QueueStreamFixture) Setup() {
+	this.ctx = context.Background()
+	this.now = time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	Options.apply(
+		Options.StorageHandle(&sql.DB{}),
+		Options.Dialect(PostgreSQL),
+		Options.VisibilityTimeout(time.Minute),
+		Options.PollInterval(time.Hour),
+		Options.Now(func() time.Time { return this.now }),
+	)(&this.config)
+	this.config.StorageHandle = this
+}
+func (this *QueueStreamFixture) newStream(settings messaging.StreamConfig) *queueStream {
+	settings.StreamName = "queue"
+	return newQueueStream(settings, this.config).(*queueStream)
+}
+
+func (this *QueueStreamFixture) TestWhenClaiming_ClaimCandidatesAndLoadThoseStillVisible() {
+	this.queryResults = []adapter.QueryResult{&identityRows{identities: []int64{1, 2}}, &identityRows{}}
+	stream := this.newStream(messaging.StreamConfig{BufferCapacity: 8})
+	visibleAt := PostgreSQL.Timestamp(this.now.Add(time.Minute))
+
+	this.So(this.tryRead(stream), should.Resemble, context.DeadlineExceeded) // both were claimed by another stream
+
+	this.So(this.queryStatements, should.Resemble, []string{
+		"SELECT id FROM Messages_queue WHERE queue = $1 AND visible_at <= $2 ORDER BY id LIMIT 8;",
+		"SELECT id, delivery_count, message_id, " + storedColumns + " FROM Messages_queue " +
+			"WHERE claimed_by = $1 AND visible_at = $2 AND id IN ($3, $4) ORDER BY id;",
+	})
+	this.So(this.queryArgs, should.Resemble, [][]interface{}{
+		{"queue", PostgreSQL.Timestamp(this.now)},
+		{stream.owner, visibleAt, int64(1), int64(2)},
+	})
+	this.So(this.execStatements, should.Resemble, []string{"UPDATE Messages_queue SET claimed_by = $1, visible_at = $2, " +
+		"delivery_count = delivery_count + 1 WHERE visible_at <= $3 AND id IN ($4, $5);"})
+	this.So(this.execArgs, should.Resemble, [][]interface{}{
+		{stream.owner, visibleAt, PostgreSQL.Timestamp(this.now), int64(1), int64(2)},
+	})
+}
+func (this *QueueStreamFixture) TestWhenStreamIsPrioritized_ClaimHigherPrioritiesFirst() {
+	stream := this.newStream(messaging.StreamConfig{MaxPriority: 10})
+
+	_ = this.tryRead(stream)
+
+	this.So(this.queryStatements, should.Resemble, []string{
+		"SELECT id FROM Messages_queue WHERE queue = $1 AND visible_at <= $2 ORDER BY priority DESC, id LIMIT 1;"})
+}
+func (this *QueueStreamFixture) TestWhenMoreCandidatesThanPage_ClaimEachPageSeparately() {
+	this.config.PageSize = 2
+	this.queryResults = []adapter.QueryResult{
+		&identityRows{identities: []int64{1, 2, 3, 4, 5}}, &identityRows{}, &identityRows{}, &identityRows{}}
+	stream := this.newStream(messaging.StreamConfig{BufferCapacity: 8})
+
+	_ = this.tryRead(stream)
+
+	this.So(this.execStatements, should.HaveLength, 3)
+	this.So(this.execArgs[0][3:], should.Resemble, []interface{}{int64(1), int64(2)})
+	this.So(this.execArgs[1][3:], should.Resemble, []interface{}{int64(3), int64(4)})
+	this.So(this.execArgs[2][3:], should.Resemble, []interface{}{int64(5)})
+}
+func (this *QueueStreamFixture) TestWhenClaimingFails_RetryUntilContextIsDone() {
+	this.queryError = errors.New("")
+	this.config.Sleep = time.Millisecond
+
+	this.So(this.tryRead(this.newStream(messaging.StreamConfig{})), should.Resemble, context.DeadlineExceeded)
+	this.So(len(this.queryStatements), should.BeGreaterThan, 1)
+}
+func (this *QueueStreamFixture) TestWhenAcknowledged_RowsClaimedByStreamAreRemoved() {
+	stream := this.newStream(messaging.StreamConfig{})
+
+	err := stream.Acknowledge(this.ctx, messaging.Delivery{DeliveryID: 1}, messaging.Delivery{DeliveryID: 2})
+
+	this.So(err, should.BeNil)
+	this.So(this.execStatements, should.Resemble, []string{
+		"DELETE FROM Messages_queue WHERE id IN ($1, $2) AND claimed_by = $3;"})
+	this.So(this.execArgs, should.Resemble, [][]interface{}{{int64(1), int64(2), stream.owner}})
+}
+func (this *QueueStreamFixture) TestWhenAcknowledgingNothing_Nop() {
+	this.So(this.newStream(messaging.StreamConfig{}).Acknowledge(this.ctx), should.BeNil)
+	this.So(this.execStatements, should.BeEmpty)
+}
+func (this *QueueStreamFixture) TestWhenAcknowledgingFails_ReturnError() {
+	this.execError = errors.New("")
+
+	err := this.newStream(messaging.StreamConfig{}).Acknowledge(this.ctx, messaging.Delivery{DeliveryID: 1})
+
+	this.So(err, should.Equal, this.execError)
+}
+func (this *QueueStreamFixture) TestWhenClosedWithUnreadRows_ReleaseThem() {
+	stream := this.newStream(messaging.StreamConfig{})
+	stream.buffer = []messaging.Delivery{{DeliveryID: 1}, {DeliveryID: 2}}
+
+	this.So(stream.Close(), should.BeNil)
+
+	this.So(this.tryRead(stream), should.Equal, io.EOF)
+	this.So(this.execStatements, should.Resemble, []string{
+		"UPDATE Messages_queue SET visible_at = $1, claimed_by = NULL WHERE id IN ($2, $3) AND claimed_by = $4;"})
+	this.So(this.execArgs, should.Resemble, [][]interface{}{
+		{PostgreSQL.Timestamp(this.now), int64(1), int64(2), stream.owner}})
+}
+func (this *QueueStreamFixture) TestWhenWaitingForRows_ClosingAndReadingConcurrentlyAreNotBlocked() {
+	stream := this.newStream(messaging.StreamConfig{})
+	waiting := make(chan error, 1)
+	go func() {
+		var delivery messaging.Delivery
+		waiting <- stream.Read(this.ctx, &delivery)
+	}()
+	time.Sleep(time.Millisecond * 10) // the first reader is waiting to poll again
+
+	this.So(this.tryRead(stream), should.Resemble, context.DeadlineExceeded)
+	closed := make(chan error, 1)
+	go func() { closed <- stream.Close() }()
+
+	select {
+	case err := <-closed:
+		this.So(err, should.BeNil)
+	case <-time.After(time.Second):
+		this.So("Close should not wait for the poll interval to lapse", should.BeEmpty)
+	}
+	select {
+	case err := <-waiting:
+		this.So(err, should.Equal, io.EOF)
+	case <-time.After(time.Second):
+		this.So("Read should stop once closed", should.BeEmpty)
+	}
+}
+
+func (this *QueueStreamFixture) tryRead(stream messaging.Stream) error {
+	ctx, cancel := context.WithTimeout(this.ctx, time.Millisecond*10)
+	defer cancel()
+
+	var delivery messaging.Delivery
+	return stream.Read(ctx, &delivery)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *QueueStreamFixture) QueryContext(_ context.Context, statement string, args ...interface{}) (adapter.QueryResult, error) {
+	this.queryStatements = append(this.queryStatements, statement)
+	this.queryArgs = append(this.queryArgs, args)
+	if this.queryError != nil {
+		return nil, this.queryError
+	}
+	if len(this.queryResults) == 0 {
+		return &identityRows{}, nil
+	}
+	result := this.queryResults[0]
+	this.queryResults = this.queryResults[1:]
+	return result, nil
+}
+func (this *QueueStreamFixture) ExecContext(_ context.Context, statement string, args ...interface{}) (sql.Result, error) {
+	this.execStatements = append(this.execStatements, statement)
+	this.execArgs = append(this.execArgs, args)
+	return nil, this.execError
+}
+func (this *QueueStreamFixture) QueryRowContext(_ context.Context, _ string, _ ...interface{}) adapter.RowScanner {
+	panic("nop")
+}
+func (this *QueueStreamFixture) BeginTx(_ context.Context, _ *sql.TxOptions) (adapter.Transaction, error) {
+	panic("nop")
+}
+func (this *QueueStreamFixture) DBHandle() *sql.DB { panic("nop") }
+func (this *QueueStreamFixture) Close() error      { panic("nop") }
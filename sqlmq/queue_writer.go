@@ -0,0 +1,131 @@
+package sqlmq
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
+)
+
+// queueWriter writes each dispatch to every queue bound to its topic. Like a broker, dispatches written to a topic to
+// which no queue is bound are dropped.
+type queueWriter struct {
+	db       adapter.Handle
+	store    dispatchStore
+	dialect  Dialect
+	queue    string
+	bindings string
+	now      func() time.Time
+	logger   logger
+}
+
+func newQueueWriter(config configuration) messaging.Writer {
+	return queueWriter{
+		db:       config.StorageHandle,
+		store:    newMessageStore(config.StorageHandle, config.Dialect, config.TableName, config.PageSize, config.Now),
+		dialect:  config.Dialect,
+		queue:    queueTableName(config.TableName),
+		bindings: bindingsTableName(config.TableName),
+		now:      config.Now,
+		logger:   config.Logger,
+	}
+}
+
+func (this queueWriter) Write(ctx context.Context, dispatches ...messaging.Dispatch) (int, error) {
+	if len(dispatches) == 0 {
+		return 0, nil
+	}
+
+	tx, err := this.db.BeginTx(ctx, nil)
+	if err != nil {
+		this.logger.Printf("[WARN] Unable to begin new storage transaction [%s].", err)
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err = this.write(ctx, tx, dispatches); err != nil {
+		this.logger.Printf("[WARN] Unable to write messages to queue [%s].", err)
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		this.logger.Printf("[WARN] Unable to commit messages to queue [%s].", err)
+		return 0, err
+	}
+
+	return len(dispatches), nil
+}
+func (this queueWriter) write(ctx context.Context, tx adapter.ReadWriter, dispatches []messaging.Dispatch) error {
+	now := this.now().UTC().Truncate(time.Millisecond) // the precision of the datetime columns
+	statement := this.insertStatement()
+	bound := make(map[string][]string)
+
+	for _, dispatch := range dispatches {
+		queues, found := bound[dispatch.Topic]
+		if !found {
+			var err error
+			if queues, err = this.boundQueues(ctx, tx, dispatch.Topic); err != nil {
+				return err
+			}
+			bound[dispatch.Topic] = queues
+		}
+
+		if dispatch.Timestamp.IsZero() {
+			dispatch.Timestamp = now
+		}
+
+		visible := now
+		if dispatch.DeliverAt.After(now) {
+			visible = dispatch.DeliverAt // held until it's due
+		}
+
+		values, err := this.store.storedValues(dispatch)
+		if err != nil {
+			return err
+		}
+
+		for _, queue := range queues {
+			args := append([]interface{}{queue, this.dialect.Timestamp(visible), int64(dispatch.MessageID)}, values...)
+			if _, err = tx.ExecContext(ctx, statement, args...); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+func (this queueWriter) insertStatement() string {
+	placeholders := make([]string, 0, storedColumnCount+3)
+	for position := 1; position <= storedColumnCount+3; position++ {
+		placeholders = append(placeholders, this.dialect.Placeholder(position))
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (queue, visible_at, message_id, %s) VALUES (%s);",
+		this.queue, storedColumns, strings.Join(placeholders, ", "))
+}
+func (this queueWriter) boundQueues(ctx context.Context, reader adapter.Reader, topic string) (queues []string, err error) {
+	statement := fmt.Sprintf("SELECT queue FROM %s WHERE topic = %s ORDER BY queue;", this.bindings, this.dialect.Placeholder(1))
+	rows, err := reader.QueryContext(ctx, statement, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer closeResource(rows)
+
+	for rows.Next() {
+		var queue string
+		if err = rows.Scan(&queue); err != nil {
+			return nil, err
+		}
+		queues = append(queues, queue)
+	}
+
+	return queues, rows.Err()
+}
+
+func (this queueWriter) Close() error { return nil }
+
+func queueTableName(table string) string    { return table + "_queue" }
+func bindingsTableName(table string) string { return table + "_bindings" }
@@ -0,0 +1,202 @@
+package sqlmq
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
+)
+
+func TestQueueWriterFixture(t *testing.T) {
+	gunit.Run(new(QueueWriterFixture), t)
+}
+
+type QueueWriterFixture struct {
+	*gunit.Fixture
+
+	ctx    context.Context
+	now    time.Time
+	writer messaging.Writer
+
+	beginError  error
+	bindings    map[string][]string
+	queryTopics []interface{}
+	queryError  error
+	execArgs    [][]interface{}
+	execError   error
+	commitError error
+	committed   int
+	rolledBack  int
+}
+
+func (this *QueueWriterFixture) Setup() {
+	this.ctx = context.Background()
+	this.now = time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	this.bindings = map[string][]string{"topic1": {"a", "b"}, "topic2": {"c"}}
+
+	config := configuration{}
+	Options.apply(
+		Options.StorageHandle(&sql.DB{}),
+		Options.Dialect(SQLite),
+		Options.Now(func() time.Time { return this.now }),
+	)(&config)
+	config.StorageHandle = this
+	this.writer = newQueueWriter(config)
+}
+
+func (this *QueueWriterFixture) TestWhenWritingToTopic_WriteRowToEachBoundQueue() {
+	count, err := this.writer.Write(this.ctx,
+		messaging.Dispatch{MessageID: 7, Topic: "topic1", MessageType: "type", Payload: []byte("1")},
+		messaging.Dispatch{MessageID: 8, Topic: "topic1", MessageType: "type", Payload: []byte("2")})
+
+	this.So(err, should.BeNil)
+	this.So(count, should.Equal, 2)
+	this.So(this.queryTopics, should.Resemble, []interface{}{"topic1"}) // bound queues are looked up once per topic
+	this.So(this.queued(), should.Resemble, [][]interface{}{
+		{"a", SQLite.Timestamp(this.now), int64(7)},
+		{"b", SQLite.Timestamp(this.now), int64(7)},
+		{"a", SQLite.Timestamp(this.now), int64(8)},
+		{"b", SQLite.Timestamp(this.now), int64(8)},
+	})
+	this.So(this.committed, should.Equal, 1)
+}
+func (this *QueueWriterFixture) TestWhenWritingToUnboundTopic_DispatchIsDropped() {
+	count, err := this.writer.Write(this.ctx, messaging.Dispatch{Topic: "topic3", MessageType: "type", Payload: []byte("1")})
+
+	this.So(err, should.BeNil)
+	this.So(count, should.Equal, 1)
+	this.So(this.queued(), should.BeEmpty)
+	this.So(this.committed, should.Equal, 1)
+}
+func (this *QueueWriterFixture) TestWhenWritingScheduledDispatch_RowIsInvisibleUntilDue() {
+	deliverAt := this.now.Add(time.Hour)
+
+	_, err := this.writer.Write(this.ctx,
+		messaging.Dispatch{Topic: "topic2", MessageType: "now", Payload: []byte("1")},
+		messaging.Dispatch{Topic: "topic2", MessageType: "later", Payload: []byte("2"), DeliverAt: deliverAt})
+
+	this.So(err, should.BeNil)
+	this.So(this.queued(), should.Resemble, [][]interface{}{
+		{"c", SQLite.Timestamp(this.now), int64(0)},
+		{"c", SQLite.Timestamp(deliverAt), int64(0)},
+	})
+}
+func (this *QueueWriterFixture) TestWhenBeginningTransactionFails_ReturnError() {
+	this.beginError = errors.New("")
+
+	count, err := this.writer.Write(this.ctx, messaging.Dispatch{Topic: "topic1", MessageType: "type"})
+
+	this.So(err, should.Equal, this.beginError)
+	this.So(count, should.Equal, 0)
+}
+func (this *QueueWriterFixture) TestWhenLookingUpBoundQueuesFails_NothingIsWritten() {
+	this.queryError = errors.New("")
+
+	count, err := this.writer.Write(this.ctx, messaging.Dispatch{Topic: "topic1", MessageType: "type"})
+
+	this.So(err, should.Equal, this.queryError)
+	this.So(count, should.Equal, 0)
+	this.So(this.committed, should.Equal, 0)
+	this.So(this.rolledBack, should.Equal, 1)
+}
+func (this *QueueWriterFixture) TestWhenWritingFails_NothingIsWritten() {
+	_, err := this.writer.Write(this.ctx,
+		messaging.Dispatch{Topic: "topic1", MessageType: "type", Payload: []byte("1")},
+		messaging.Dispatch{Topic: "topic1", MessageType: "type", Payload: []byte("2"),
+			Headers: map[string]interface{}{"unregistered": struct{}{}}})
+
+	this.So(err, should.NotBeNil)
+	this.So(this.committed, should.Equal, 0)
+	this.So(this.rolledBack, should.Equal, 1)
+}
+func (this *QueueWriterFixture) TestWhenInsertingFails_ReturnError() {
+	this.execError = errors.New("")
+
+	count, err := this.writer.Write(this.ctx, messaging.Dispatch{Topic: "topic1", MessageType: "type"})
+
+	this.So(err, should.Equal, this.execError)
+	this.So(count, should.Equal, 0)
+	this.So(this.committed, should.Equal, 0)
+}
+func (this *QueueWriterFixture) TestWhenCommittingFails_ReturnError() {
+	this.commitError = errors.New("")
+
+	count, err := this.writer.Write(this.ctx, messaging.Dispatch{Topic: "topic1", MessageType: "type"})
+
+	this.So(err, should.Equal, this.commitError)
+	this.So(count, should.Equal, 0)
+}
+func (this *QueueWriterFixture) TestWhenWritingNothing_Nop() {
+	this.beginError = errors.New("")
+
+	count, err := this.writer.Write(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(count, should.Equal, 0)
+}
+
+// queued returns the queue, visibility and message identity with which each row was inserted.
+func (this *QueueWriterFixture) queued() (queued [][]interface{}) {
+	for _, args := range this.execArgs {
+		queued = append(queued, args[:3])
+	}
+	return queued
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *QueueWriterFixture) BeginTx(_ context.Context, _ *sql.TxOptions) (adapter.Transaction, error) {
+	return this, this.beginError
+}
+func (this *QueueWriterFixture) QueryContext(_ context.Context, statement string, args ...interface{}) (adapter.QueryResult, error) {
+	this.So(statement, should.Equal, "SELECT queue FROM Messages_bindings WHERE topic = ? ORDER BY queue;")
+	this.queryTopics = append(this.queryTopics, args...)
+	if this.queryError != nil {
+		return nil, this.queryError
+	}
+	return &queueRows{queues: this.bindings[args[0].(string)]}, nil
+}
+func (this *QueueWriterFixture) ExecContext(_ context.Context, statement string, args ...interface{}) (sql.Result, error) {
+	this.So(statement, should.StartWith, "INSERT INTO Messages_queue (queue, visible_at, message_id, ")
+	this.execArgs = append(this.execArgs, args)
+	return nil, this.execError
+}
+func (this *QueueWriterFixture) Commit() error {
+	this.committed++
+	return this.commitError
+}
+func (this *QueueWriterFixture) Rollback() error {
+	if this.committed == 0 {
+		this.rolledBack++
+	}
+	return nil
+}
+func (this *QueueWriterFixture) QueryRowContext(_ context.Context, _ string, _ ...interface{}) adapter.RowScanner {
+	panic("nop")
+}
+func (this *QueueWriterFixture) TxHandle() *sql.Tx { panic("nop") }
+func (this *QueueWriterFixture) DBHandle() *sql.DB { panic("nop") }
+func (this *QueueWriterFixture) Close() error      { panic("nop") }
+
+// queueRows is the result of a query selecting the queues bound to a topic.
+type queueRows struct {
+	queues []string
+	index  int
+}
+
+func (this *queueRows) Scan(fields ...interface{}) error {
+	*(fields[0].(*string)) = this.queues[this.index-1]
+	return nil
+}
+func (this *queueRows) Next() bool {
+	this.index++
+	return this.index <= len(this.queues)
+}
+func (this *queueRows) Err() error   { return nil }
+func (this *queueRows) Close() error { return nil }
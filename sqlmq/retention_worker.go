@@ -0,0 +1,167 @@
+package sqlmq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/smartystreets/messaging/v3"
+	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
+)
+
+// retentionWorker periodically deletes (or moves to an archive table) the rows of dispatches which were confirmed
+// longer ago than the retention period. Rows are removed in bounded batches, each within its own transaction, such that
+// locks are held only briefly. The archive table is created (unless it exists) before the first rows are moved to it.
+type retentionWorker struct {
+	ctx      context.Context
+	shutdown context.CancelFunc
+	db       adapter.Handle
+	dialect  Dialect
+	table    string
+	archive  string
+	archived bool // the archive table exists
+	period   time.Duration
+	batch    int
+	interval time.Duration
+	now      func() time.Time
+	logger   logger
+	monitor  purgeMonitor
+}
+
+func newRetentionWorker(config configuration) messaging.ListenCloser {
+	ctx, shutdown := context.WithCancel(config.Context)
+	return &retentionWorker{
+		ctx:      ctx,
+		shutdown: shutdown,
+		db:       config.StorageHandle,
+		dialect:  config.Dialect,
+		table:    config.TableName,
+		archive:  config.ArchiveTableName,
+		period:   config.RetentionPeriod,
+		batch:    config.PurgeBatchSize,
+		interval: config.PurgeInterval,
+		now:      config.Now,
+		logger:   config.Logger,
+		monitor:  newPurgeMonitor(config.Monitor),
+	}
+}
+func newPurgeMonitor(value monitor) purgeMonitor {
+	if monitor, ok := value.(purgeMonitor); ok {
+		return monitor
+	}
+	return nop{}
+}
+
+func (this *retentionWorker) Listen() {
+	for this.isAlive() {
+		this.purge()
+		this.sleep()
+	}
+}
+func (this *retentionWorker) purge() {
+	for this.isAlive() {
+		count, err := this.purgeBatch()
+		if err != nil {
+			this.logger.Printf("[WARN] Unable to purge dispatched messages from durable storage [%s].", err)
+			return
+		}
+
+		if count > 0 {
+			this.monitor.MessagePurged(count)
+		}
+
+		if count < this.batch {
+			return // nothing else has expired (yet)
+		}
+	}
+}
+func (this *retentionWorker) purgeBatch() (int, error) {
+	identities, err := this.expired()
+	if err != nil || len(identities) == 0 {
+		return 0, err
+	}
+
+	if err = this.createArchive(); err != nil {
+		return 0, err
+	}
+
+	tx, err := this.db.BeginTx(this.ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if len(this.archive) > 0 {
+		const statementFormat = "INSERT INTO %s (id, dispatched, %s) SELECT id, dispatched, %s FROM %s WHERE id IN (%s);"
+		statement := fmt.Sprintf(statementFormat, this.archive, storedColumns, storedColumns, this.table,
+			placeholders(this.dialect, 1, len(identities)))
+		if _, err = tx.ExecContext(this.ctx, statement, identities...); err != nil {
+			return 0, err
+		}
+	}
+
+	statement := fmt.Sprintf("DELETE FROM %s WHERE id IN (%s);", this.table, placeholders(this.dialect, 1, len(identities)))
+	result, err := tx.ExecContext(this.ctx, statement, identities...)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	affected, _ := result.RowsAffected()
+	return int(affected), nil
+}
+func (this *retentionWorker) createArchive() error {
+	if len(this.archive) == 0 || this.archived {
+		return nil
+	}
+
+	_, err := this.db.ExecContext(this.ctx, archiveStatement(this.dialect, this.archive))
+	this.archived = err == nil
+	return err
+}
+func (this *retentionWorker) expired() ([]interface{}, error) {
+	const statementFormat = "SELECT id FROM %s WHERE dispatched < %s ORDER BY dispatched, id LIMIT %d;"
+	statement := fmt.Sprintf(statementFormat, this.table, this.dialect.Placeholder(1), this.batch)
+	rows, err := this.db.QueryContext(this.ctx, statement, this.dialect.Timestamp(this.now().Add(-this.period)))
+	if err != nil {
+		return nil, err
+	}
+	defer closeResource(rows)
+
+	identities := make([]interface{}, 0, this.batch)
+	for rows.Next() {
+		var identity int64
+		if err := rows.Scan(&identity); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+func (this *retentionWorker) isAlive() bool {
+	select {
+	case <-this.ctx.Done():
+		return false
+	default:
+		return true
+	}
+}
+func (this *retentionWorker) sleep() {
+	timer := time.NewTimer(this.interval)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-this.ctx.Done():
+	}
+}
+
+func (this *retentionWorker) Close() error {
+	this.shutdown()
+	return nil
+}
@@ -0,0 +1,226 @@
+package sqlmq
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
+)
+
+func TestRetentionWorkerFixture(t *testing.T) {
+	gunit.Run(new(RetentionWorkerFixture), t)
+}
+
+type RetentionWorkerFixture struct {
+	*gunit.Fixture
+	nopMonitor
+
+	now    time.Time
+	worker *retentionWorker
+
+	queryStatements []string
+	queryArgs       [][]interface{}
+	queryResults    []adapter.QueryResult
+	queryError      error
+	execStatements  []string
+	execArgs        [][]interface{}
+	execErrors      map[string]error
+	committed       int
+
+	purged      []int
+	logged      []string
+	stopOnPurge bool
+}
+
+func (this *RetentionWorkerFixture) Setup() {
+	this.now = time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	this.execErrors = make(map[string]error)
+	this.initializeWorker(Options.PurgeBatchSize(1000))
+}
+func (this *RetentionWorkerFixture) initializeWorker(options ...option) {
+	config := configuration{}
+	Options.apply(append([]option{
+		Options.StorageHandle(&sql.DB{}),
+		Options.Dialect(MySQL),
+		Options.RetentionPeriod(time.Hour),
+		Options.PurgeInterval(time.Millisecond),
+		Options.Now(func() time.Time { return this.now }),
+		Options.Logger(this),
+		Options.Monitor(this),
+	}, options...)...)(&config)
+	config.StorageHandle = this
+	this.worker = newRetentionWorker(config).(*retentionWorker)
+}
+
+func (this *RetentionWorkerFixture) TestRowsConfirmedLongerAgoThanRetentionPeriodArePurged() {
+	this.queryResults = []adapter.QueryResult{&identityRows{identities: []int64{1, 2}}}
+
+	this.worker.purge()
+
+	this.So(this.queryStatements, should.Resemble, []string{
+		"SELECT id FROM Messages WHERE dispatched < ? ORDER BY dispatched, id LIMIT 1000;"})
+	this.So(this.queryArgs, should.Resemble, [][]interface{}{{MySQL.Timestamp(this.now.Add(-time.Hour))}})
+	this.So(this.execStatements, should.Resemble, []string{"DELETE FROM Messages WHERE id IN (?, ?);"})
+	this.So(this.execArgs, should.Resemble, [][]interface{}{{int64(1), int64(2)}})
+	this.So(this.committed, should.Equal, 1)
+	this.So(this.purged, should.Resemble, []int{2})
+}
+func (this *RetentionWorkerFixture) TestWhenNothingHasExpired_NothingIsReported() {
+	this.worker.purge()
+
+	this.So(this.execStatements, should.BeEmpty)
+	this.So(this.purged, should.BeEmpty)
+}
+func (this *RetentionWorkerFixture) TestRowsArePurgedInBoundedBatches() {
+	this.initializeWorker(Options.PurgeBatchSize(2))
+	this.queryResults = []adapter.QueryResult{
+		&identityRows{identities: []int64{1, 2}},
+		&identityRows{identities: []int64{3, 4}},
+		&identityRows{identities: []int64{5}},
+	}
+
+	this.worker.purge()
+
+	this.So(this.queryStatements, should.HaveLength, 3)
+	this.So(this.queryStatements[0], should.EndWith, "LIMIT 2;")
+	this.So(this.execArgs, should.Resemble, [][]interface{}{{int64(1), int64(2)}, {int64(3), int64(4)}, {int64(5)}})
+	this.So(this.committed, should.Equal, 3)
+	this.So(this.purged, should.Resemble, []int{2, 2, 1})
+}
+func (this *RetentionWorkerFixture) TestWhenArchiving_ArchiveTableIsCreatedOnceAndPurgedRowsAreMovedToIt() {
+	this.initializeWorker(Options.ArchiveTableName("Archive"))
+	this.queryResults = []adapter.QueryResult{&identityRows{identities: []int64{1}}, &identityRows{identities: []int64{2}}}
+
+	this.worker.purge()
+	this.worker.purge()
+
+	this.So(this.execStatements, should.Resemble, []string{
+		archiveStatement(MySQL, "Archive"),
+		"INSERT INTO Archive (id, dispatched, " + storedColumns + ") SELECT id, dispatched, " + storedColumns +
+			" FROM Messages WHERE id IN (?);",
+		"DELETE FROM Messages WHERE id IN (?);",
+		"INSERT INTO Archive (id, dispatched, " + storedColumns + ") SELECT id, dispatched, " + storedColumns +
+			" FROM Messages WHERE id IN (?);",
+		"DELETE FROM Messages WHERE id IN (?);",
+	})
+	this.So(this.purged, should.Resemble, []int{1, 1})
+}
+func (this *RetentionWorkerFixture) TestWhenCreatingArchiveFails_NothingIsDeletedAndCreationIsRetried() {
+	this.initializeWorker(Options.ArchiveTableName("Archive"))
+	this.execErrors[archiveStatement(MySQL, "Archive")] = errors.New("")
+	this.queryResults = []adapter.QueryResult{&identityRows{identities: []int64{1}}, &identityRows{identities: []int64{1}}}
+
+	this.worker.purge()
+	this.worker.purge()
+
+	this.So(this.execStatements, should.Resemble, []string{archiveStatement(MySQL, "Archive"), archiveStatement(MySQL, "Archive")})
+	this.So(this.purged, should.BeEmpty)
+	this.So(this.logged, should.HaveLength, 2)
+}
+func (this *RetentionWorkerFixture) TestWhenArchivingFails_NothingIsDeleted() {
+	this.initializeWorker(Options.ArchiveTableName("Archive"))
+	this.execErrors["INSERT INTO Archive (id, dispatched, "+storedColumns+") SELECT id, dispatched, "+storedColumns+
+		" FROM Messages WHERE id IN (?);"] = errors.New("")
+	this.queryResults = []adapter.QueryResult{&identityRows{identities: []int64{1}}}
+
+	this.worker.purge()
+
+	this.So(this.execStatements, should.HaveLength, 2)
+	this.So(this.committed, should.Equal, 0)
+	this.So(this.purged, should.BeEmpty)
+	this.So(this.logged, should.HaveLength, 1)
+}
+func (this *RetentionWorkerFixture) TestWhenQueryingExpiredRowsFails_NothingIsDeleted() {
+	this.queryError = errors.New("")
+
+	this.worker.purge()
+
+	this.So(this.execStatements, should.BeEmpty)
+	this.So(this.logged, should.HaveLength, 1)
+}
+func (this *RetentionWorkerFixture) TestWhenListening_PurgePeriodicallyUntilClosed() {
+	this.stopOnPurge = true
+	this.queryResults = []adapter.QueryResult{&identityRows{}, &identityRows{identities: []int64{1}}}
+	listening := make(chan struct{})
+	go func() {
+		defer close(listening)
+		this.worker.Listen()
+	}()
+
+	select {
+	case <-listening:
+	case <-time.After(time.Second):
+		_ = this.worker.Close()
+		this.So("Listen should exit once closed", should.BeEmpty)
+	}
+	this.So(this.purged, should.Resemble, []int{1})
+}
+func (this *RetentionWorkerFixture) TestWhenRetentionPeriodIsNotConfigured_NoWorkerIsCreated() {
+	config := configuration{}
+	Options.apply(Options.StorageHandle(&sql.DB{}))(&config)
+
+	this.So(config.Retention, should.BeNil)
+}
+
+func (this *RetentionWorkerFixture) TestWhenMonitorDoesNotObservePurges_NothingIsReported() {
+	this.So(newPurgeMonitor(this), should.Equal, this)
+	this.So(newPurgeMonitor(nopMonitor{}), should.Resemble, nop{})
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *RetentionWorkerFixture) Printf(format string, args ...interface{}) {
+	this.logged = append(this.logged, fmt.Sprintf(format, args...))
+}
+
+func (this *RetentionWorkerFixture) MessagePurged(count int) {
+	this.purged = append(this.purged, count)
+	if this.stopOnPurge {
+		_ = this.worker.Close()
+	}
+}
+
+func (this *RetentionWorkerFixture) QueryContext(_ context.Context, statement string, args ...interface{}) (adapter.QueryResult, error) {
+	this.queryStatements = append(this.queryStatements, statement)
+	this.queryArgs = append(this.queryArgs, args)
+	if this.queryError != nil {
+		return nil, this.queryError
+	}
+	if len(this.queryResults) == 0 {
+		return &identityRows{}, nil
+	}
+	result := this.queryResults[0]
+	this.queryResults = this.queryResults[1:]
+	return result, nil
+}
+func (this *RetentionWorkerFixture) ExecContext(_ context.Context, statement string, args ...interface{}) (sql.Result, error) {
+	this.execStatements = append(this.execStatements, statement)
+	this.execArgs = append(this.execArgs, args)
+	return driverResult(len(args)), this.execErrors[statement]
+}
+func (this *RetentionWorkerFixture) BeginTx(_ context.Context, _ *sql.TxOptions) (adapter.Transaction, error) {
+	return this, nil
+}
+func (this *RetentionWorkerFixture) Commit() error {
+	this.committed++
+	return nil
+}
+func (this *RetentionWorkerFixture) Rollback() error { return nil }
+func (this *RetentionWorkerFixture) QueryRowContext(_ context.Context, _ string, _ ...interface{}) adapter.RowScanner {
+	panic("nop")
+}
+func (this *RetentionWorkerFixture) TxHandle() *sql.Tx { panic("nop") }
+func (this *RetentionWorkerFixture) DBHandle() *sql.DB { panic("nop") }
+func (this *RetentionWorkerFixture) Close() error      { panic("nop") }
+
+// driverResult is the result of a statement which affected the given number of rows.
+type driverResult int64
+
+func (this driverResult) LastInsertId() (int64, error) { return 0, nil }
+func (this driverResult) RowsAffected() (int64, error) { return int64(this), nil }
@@ -0,0 +1,282 @@
+package sqlmq
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
+)
+
+// SchemaManager creates or upgrades the table in which dispatches are stored (along with the companion tables of the
+// queue, named after it with "_queue" and "_bindings" suffixes) such that each has the columns and indexes expected by
+// this package. Each migration applied is recorded (by version) in a companion table named after the table with a
+// "_migrations" suffix. A table which predates the companion table is recognized by its columns and adopted at the
+// latest version up to which every column introduced is present.
+type SchemaManager interface {
+	// Plan reports the migrations that Migrate would apply without applying any of them.
+	Plan(ctx context.Context) ([]Migration, error)
+	// Migrate applies, in order, each migration which hasn't yet been applied and reports those which were.
+	Migrate(ctx context.Context) ([]Migration, error)
+}
+
+func NewSchemaManager(options ...option) SchemaManager {
+	var config configuration
+	Options.apply(options...)(&config)
+	return newSchemaManager(config)
+}
+
+type Migration struct {
+	Version     int
+	Description string
+	Statements  []string
+
+	columns []columnSet // those introduced by the migration, used to recognize tables created without a manager
+}
+
+type columnSet struct{ table, names string }
+
+func (this Migration) String() string { return fmt.Sprintf("%d: %s", this.Version, this.Description) }
+
+func migrations(dialect Dialect, table string) []Migration {
+	queue, bindings := queueTableName(table), bindingsTableName(table)
+
+	return []Migration{
+		{Version: 1, Description: "create table", Statements: []string{
+			fmt.Sprintf("CREATE TABLE %s (id %s, dispatched %s NULL, type varchar(256) NOT NULL, payload %s NOT NULL);",
+				table, dialect.Column(IdentityColumn), dialect.Column(TimestampColumn), dialect.Column(BinaryColumn)),
+			fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (dispatched, id);", indexName(table, "dispatched"), table),
+		}, columns: []columnSet{{table, "id, dispatched, type, payload"}}},
+		{Version: 2, Description: "add scheduled delivery", Statements: []string{
+			addColumn(table, "deliver_at", dialect.Column(TimestampColumn), "NULL"),
+		}, columns: []columnSet{{table, "deliver_at"}}},
+		{Version: 3, Description: "add priority", Statements: []string{
+			addColumn(table, "priority", dialect.Column(SmallIntColumn), "NOT NULL DEFAULT 0"),
+		}, columns: []columnSet{{table, "priority"}}},
+		{Version: 4, Description: "add dispatch envelope", Statements: []string{
+			addColumn(table, "source_id", dialect.Column(IntegerColumn), "NOT NULL DEFAULT 0"),
+			addColumn(table, "correlation_id", dialect.Column(IntegerColumn), "NOT NULL DEFAULT 0"),
+			addColumn(table, "created", dialect.Column(TimestampColumn), "NULL"),
+			addColumn(table, "expiration", dialect.Column(IntegerColumn), "NOT NULL DEFAULT 0"),
+			addColumn(table, "durable", dialect.Column(SmallIntColumn), "NOT NULL DEFAULT 0"),
+			addColumn(table, "topic", "varchar(256)", "NOT NULL DEFAULT ''"),
+			addColumn(table, "partition_id", dialect.Column(IntegerColumn), "NOT NULL DEFAULT 0"),
+			addColumn(table, "content_type", "varchar(256)", "NOT NULL DEFAULT ''"),
+			addColumn(table, "content_encoding", "varchar(256)", "NOT NULL DEFAULT ''"),
+			addColumn(table, "headers", dialect.Column(BinaryColumn), "NULL"),
+			// the values with which rows stored beforehand were sent; their timestamp is assigned when loaded
+			fmt.Sprintf("UPDATE %s SET durable = 1, topic = type, content_type = 'application/json';", table),
+		}, columns: []columnSet{{table, "source_id, correlation_id, created, expiration, durable, topic, partition_id, " +
+			"content_type, content_encoding, headers"}}},
+		{Version: 5, Description: "add lease", Statements: []string{
+			addColumn(table, "claimed_by", "varchar(64)", "NULL"),
+			addColumn(table, "claimed_until", dialect.Column(TimestampColumn), "NULL"),
+		}, columns: []columnSet{{table, "claimed_by, claimed_until"}}},
+		{Version: 6, Description: "add queue", Statements: []string{
+			fmt.Sprintf("CREATE TABLE %s (id %s, queue varchar(256) NOT NULL, visible_at %s NOT NULL, "+
+				"delivery_count %s NOT NULL DEFAULT 0, claimed_by varchar(64) NULL, message_id %s NOT NULL DEFAULT 0, "+
+				"type varchar(256) NOT NULL, payload %s NOT NULL, deliver_at %s NULL, priority %s NOT NULL DEFAULT 0, "+
+				"source_id %s NOT NULL DEFAULT 0, correlation_id %s NOT NULL DEFAULT 0, created %s NULL, "+
+				"expiration %s NOT NULL DEFAULT 0, durable %s NOT NULL DEFAULT 0, topic varchar(256) NOT NULL DEFAULT '', "+
+				"partition_id %s NOT NULL DEFAULT 0, content_type varchar(256) NOT NULL DEFAULT '', "+
+				"content_encoding varchar(256) NOT NULL DEFAULT '', headers %s NULL);", queue,
+				dialect.Column(IdentityColumn), dialect.Column(TimestampColumn), dialect.Column(IntegerColumn),
+				dialect.Column(IntegerColumn), dialect.Column(BinaryColumn), dialect.Column(TimestampColumn),
+				dialect.Column(SmallIntColumn), dialect.Column(IntegerColumn), dialect.Column(IntegerColumn),
+				dialect.Column(TimestampColumn), dialect.Column(IntegerColumn), dialect.Column(SmallIntColumn),
+				dialect.Column(IntegerColumn), dialect.Column(BinaryColumn)),
+			fmt.Sprintf("CREATE INDEX %s ON %s (queue, visible_at, id);", indexName(queue, "visible"), queue),
+			fmt.Sprintf("CREATE TABLE %s (queue varchar(256) NOT NULL, topic varchar(256) NOT NULL, "+
+				"PRIMARY KEY (queue, topic));", bindings),
+		}, columns: []columnSet{
+			{queue, "id, queue, visible_at, delivery_count, claimed_by, message_id, type, payload, deliver_at, priority, " +
+				"source_id, correlation_id, created, expiration, durable, topic, partition_id, content_type, " +
+				"content_encoding, headers"},
+			{bindings, "queue, topic"},
+		}},
+		{Version: 7, Description: "add insertion time", Statements: insertedStatements(dialect, table),
+			columns: []columnSet{{table, "inserted"}}},
+		{Version: 8, Description: "add claim token", Statements: []string{
+			addColumn(table, "claim_token", "varchar(32)", "NULL"),
+			fmt.Sprintf("CREATE INDEX %s ON %s (claimed_by, claimed_until);", indexName(table, "claimed"), table),
+		}, columns: []columnSet{{table, "claim_token"}}},
+	}
+}
+
+// archiveStatement creates (unless it exists) the table to which the retention worker moves purged rows. Its columns
+// are those copied from the table in which dispatches are stored, the identity of each row is preserved as it was.
+func archiveStatement(dialect Dialect, archive string) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id %s NOT NULL PRIMARY KEY, dispatched %s NOT NULL, "+
+		"type varchar(256) NOT NULL, payload %s NOT NULL, deliver_at %s NULL, priority %s NOT NULL DEFAULT 0, "+
+		"source_id %s NOT NULL DEFAULT 0, correlation_id %s NOT NULL DEFAULT 0, created %s NULL, "+
+		"expiration %s NOT NULL DEFAULT 0, durable %s NOT NULL DEFAULT 0, topic varchar(256) NOT NULL DEFAULT '', "+
+		"partition_id %s NOT NULL DEFAULT 0, content_type varchar(256) NOT NULL DEFAULT '', "+
+		"content_encoding varchar(256) NOT NULL DEFAULT '', headers %s NULL);", archive,
+		dialect.Column(IntegerColumn), dialect.Column(TimestampColumn), dialect.Column(BinaryColumn),
+		dialect.Column(TimestampColumn), dialect.Column(SmallIntColumn), dialect.Column(IntegerColumn),
+		dialect.Column(IntegerColumn), dialect.Column(TimestampColumn), dialect.Column(IntegerColumn),
+		dialect.Column(SmallIntColumn), dialect.Column(IntegerColumn), dialect.Column(BinaryColumn))
+}
+func insertedStatements(dialect Dialect, table string) []string {
+	statements := []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN inserted %s;", table, dialect.Column(InsertedColumn))}
+	if trigger, ok := dialect.(insertionTrigger); ok {
+		statements = append(statements, trigger.triggerStatement(table))
+	}
+	return statements
+}
+func indexName(table, suffix string) string {
+	return "ix_" + strings.ToLower(strings.Replace(table, ".", "_", -1)) + "_" + suffix
+}
+func addColumn(table, column, columnType, constraints string) string {
+	return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s %s;", table, column, columnType, constraints)
+}
+
+type defaultSchemaManager struct {
+	db         adapter.Handle
+	dialect    Dialect
+	table      string
+	history    string
+	migrations []Migration
+	now        func() time.Time
+	logger     logger
+}
+
+func newSchemaManager(config configuration) SchemaManager {
+	return &defaultSchemaManager{
+		db:         config.StorageHandle,
+		dialect:    config.Dialect,
+		table:      config.TableName,
+		history:    config.TableName + "_migrations",
+		migrations: migrations(config.Dialect, config.TableName),
+		now:        config.Now,
+		logger:     config.Logger,
+	}
+}
+
+func (this *defaultSchemaManager) Plan(ctx context.Context) ([]Migration, error) {
+	var ignored int
+	if err := this.db.QueryRowContext(ctx, "SELECT 1;").Scan(&ignored); err != nil {
+		return nil, err // distinguishes being unable to connect from the tables not existing
+	}
+
+	version, err := this.recordedVersion(ctx)
+	if err != nil || version == 0 {
+		version = this.existingVersion(ctx) // nothing has been recorded (yet) or the history table doesn't exist
+	}
+
+	return this.pending(version), nil
+}
+func (this *defaultSchemaManager) Migrate(ctx context.Context) (applied []Migration, err error) {
+	const statementFormat = "CREATE TABLE IF NOT EXISTS %s " +
+		"(version integer NOT NULL PRIMARY KEY, description varchar(256) NOT NULL, applied %s NOT NULL);"
+	statement := fmt.Sprintf(statementFormat, this.history, this.dialect.Column(TimestampColumn))
+	if _, err = this.db.ExecContext(ctx, statement); err != nil {
+		return nil, err
+	}
+
+	version, err := this.recordedVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if version == 0 {
+		if version, err = this.adopt(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, migration := range this.pending(version) {
+		if err = this.apply(ctx, migration.Statements, migration); err != nil {
+			this.logger.Printf("[WARN] Unable to apply schema migration [%s] to table [%s]: %s", migration, this.table, err)
+			return applied, err
+		}
+
+		this.logger.Printf("[INFO] Applied schema migration [%s] to table [%s].", migration, this.table)
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+func (this *defaultSchemaManager) recordedVersion(ctx context.Context) (int, error) {
+	var version sql.NullInt64
+	err := this.db.QueryRowContext(ctx, fmt.Sprintf("SELECT MAX(version) FROM %s;", this.history)).Scan(&version)
+	return int(version.Int64), err
+}
+
+// existingVersion is that of the last migration before the first whose columns aren't all present, a migration which
+// was only partly applied (or whose columns were added by hand) isn't mistaken for having been applied.
+func (this *defaultSchemaManager) existingVersion(ctx context.Context) int {
+	for _, migration := range this.migrations {
+		if len(migration.columns) == 0 || !this.columnsExist(ctx, migration.columns) {
+			return migration.Version - 1
+		}
+	}
+	return len(this.migrations)
+}
+func (this *defaultSchemaManager) columnsExist(ctx context.Context, columns []columnSet) bool {
+	for _, set := range columns {
+		rows, err := this.db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE 1 = 0;", set.names, set.table))
+		if err != nil {
+			return false
+		}
+		closeResource(rows)
+	}
+	return true
+}
+func (this *defaultSchemaManager) adopt(ctx context.Context) (int, error) {
+	version := this.existingVersion(ctx)
+	if version == 0 {
+		return 0, nil
+	}
+
+	if err := this.apply(ctx, nil, this.migrations[:version]...); err != nil {
+		return 0, err
+	}
+
+	this.logger.Printf("[INFO] Adopted existing table [%s] at schema version [%d].", this.table, version)
+	return version, nil
+}
+func (this *defaultSchemaManager) pending(version int) []Migration {
+	if version >= len(this.migrations) {
+		return nil
+	}
+	return this.migrations[version:]
+}
+
+// apply executes the statements and records the migrations within a single transaction, although some databases
+// (e.g. MySQL) implicitly commit each schema-altering statement.
+func (this *defaultSchemaManager) apply(ctx context.Context, statements []string, migrations ...Migration) error {
+	tx, err := this.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, statement := range statements {
+		if _, err = tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	statement := fmt.Sprintf("INSERT INTO %s (version, description, applied) VALUES (%s, %s, %s);", this.history,
+		this.dialect.Placeholder(1), this.dialect.Placeholder(2), this.dialect.Placeholder(3))
+	for _, migration := range migrations {
+		applied := this.dialect.Timestamp(this.now())
+		if _, err = tx.ExecContext(ctx, statement, migration.Version, migration.Description, applied); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func isValidTableName(value string) bool { return tableNamePattern.MatchString(value) }
+
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+var errInvalidTableName = errors.New("the table name must be a (optionally schema-qualified) SQL identifier")
@@ -0,0 +1,171 @@
+package sqlmq
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/smartystreets/assertions/should"
+	"github.com/smartystreets/gunit"
+	"github.com/smartystreets/messaging/v3/sqlmq/adapter"
+)
+
+func TestSchemaManagerFixture(t *testing.T) {
+	gunit.Run(new(SchemaManagerFixture), t)
+}
+
+type SchemaManagerFixture struct {
+	*gunit.Fixture
+
+	ctx     context.Context
+	manager SchemaManager
+
+	connectError  error
+	recorded      int
+	recordedError error
+	execError     error
+}
+
+func (this *SchemaManagerFixture) Setup() {
+	this.ctx = context.Background()
+
+	config := configuration{}
+	Options.apply(Options.StorageHandle(&sql.DB{}), Options.Dialect(SQLite))(&config)
+	config.StorageHandle = this
+	this.manager = newSchemaManager(config)
+}
+
+func (this *SchemaManagerFixture) TestWhenPlanningWithoutConnectivity_ReturnError() {
+	this.connectError = errors.New("")
+
+	planned, err := this.manager.Plan(this.ctx)
+
+	this.So(err, should.Equal, this.connectError)
+	this.So(planned, should.BeEmpty)
+}
+func (this *SchemaManagerFixture) TestWhenPlanning_MigrationsAfterRecordedVersionArePending() {
+	this.recorded = 6
+
+	planned, err := this.manager.Plan(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(versions(planned), should.Resemble, []int{7, 8})
+}
+func (this *SchemaManagerFixture) TestWhenCreatingHistoryTableFails_NothingIsApplied() {
+	this.execError = errors.New("")
+
+	applied, err := this.manager.Migrate(this.ctx)
+
+	this.So(err, should.Equal, this.execError)
+	this.So(applied, should.BeEmpty)
+}
+func (this *SchemaManagerFixture) TestWhenReadingRecordedVersionFails_NothingIsApplied() {
+	this.recordedError = errors.New("")
+
+	applied, err := this.manager.Migrate(this.ctx)
+
+	this.So(err, should.Equal, this.recordedError)
+	this.So(applied, should.BeEmpty)
+}
+func (this *SchemaManagerFixture) TestWhenEveryMigrationIsRecorded_NothingIsApplied() {
+	this.recorded = 8
+
+	applied, err := this.manager.Migrate(this.ctx)
+
+	this.So(err, should.BeNil)
+	this.So(applied, should.BeEmpty)
+}
+func (this *SchemaManagerFixture) TestMigrationStatementsPerDialect() {
+	mysql := migrations(MySQL, "Messages")
+	postgres := migrations(PostgreSQL, "outbox.Messages")
+
+	this.So(mysql[0].Statements, should.Resemble, []string{
+		"CREATE TABLE Messages (id bigint unsigned AUTO_INCREMENT NOT NULL PRIMARY KEY, dispatched datetime(3) NULL, " +
+			"type varchar(256) NOT NULL, payload mediumblob NOT NULL);",
+		"CREATE UNIQUE INDEX ix_messages_dispatched ON Messages (dispatched, id);",
+	})
+	this.So(mysql[2].Statements, should.Resemble, []string{
+		"ALTER TABLE Messages ADD COLUMN priority tinyint unsigned NOT NULL DEFAULT 0;",
+	})
+	this.So(postgres[0].Statements[1], should.Equal,
+		"CREATE UNIQUE INDEX ix_outbox_messages_dispatched ON outbox.Messages (dispatched, id);")
+	this.So(postgres[1].Statements, should.Resemble, []string{
+		"ALTER TABLE outbox.Messages ADD COLUMN deliver_at timestamp(3) NULL;",
+	})
+	this.So(postgres[1].String(), should.Equal, "2: add scheduled delivery")
+	this.So(postgres[3].Statements[0], should.Equal, "ALTER TABLE outbox.Messages ADD COLUMN source_id bigint NOT NULL DEFAULT 0;")
+	this.So(mysql[6].Statements, should.Resemble, []string{
+		"ALTER TABLE Messages ADD COLUMN inserted datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3);",
+	})
+	this.So(postgres[6].Statements, should.Resemble, []string{
+		"ALTER TABLE outbox.Messages ADD COLUMN inserted timestamp(3) NOT NULL DEFAULT (now() AT TIME ZONE 'utc');",
+	})
+	this.So(migrations(SQLite, "outbox.Messages")[6].Statements, should.Resemble, []string{
+		"ALTER TABLE outbox.Messages ADD COLUMN inserted datetime NULL;",
+		"CREATE TRIGGER outbox.tr_messages_inserted AFTER INSERT ON Messages FOR EACH ROW WHEN NEW.inserted IS NULL " +
+			"BEGIN UPDATE Messages SET inserted = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id; END;",
+	})
+}
+
+func (this *SchemaManagerFixture) TestMySQLScriptCorrespondsToMigrations() {
+	script, err := ioutil.ReadFile("_schema_mysql.sql")
+
+	this.So(err, should.BeNil)
+	this.So(string(script), should.Equal, schemaScript(migrations(MySQL, "Messages")))
+}
+
+func schemaScript(migrations []Migration) string {
+	script := "-- The statements applied by the schema manager (see cmd/schema) to create the table from scratch, a table\n" +
+		"-- created using this script is recognized by its columns and adopted by the manager.\n"
+	for _, migration := range migrations {
+		script += "\n-- " + migration.String() + "\n" + strings.Join(migration.Statements, "\n") + "\n"
+	}
+	return script
+}
+func versions(migrations []Migration) (versions []int) {
+	for _, migration := range migrations {
+		versions = append(versions, migration.Version)
+	}
+	return versions
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (this *SchemaManagerFixture) QueryRowContext(_ context.Context, statement string, _ ...interface{}) adapter.RowScanner {
+	if statement == "SELECT 1;" {
+		return versionRow{err: this.connectError}
+	}
+	this.So(statement, should.Equal, "SELECT MAX(version) FROM Messages_migrations;")
+	return versionRow{version: this.recorded, err: this.recordedError}
+}
+func (this *SchemaManagerFixture) ExecContext(_ context.Context, statement string, _ ...interface{}) (sql.Result, error) {
+	this.So(statement, should.StartWith, "CREATE TABLE IF NOT EXISTS Messages_migrations ")
+	return nil, this.execError
+}
+func (this *SchemaManagerFixture) QueryContext(_ context.Context, _ string, _ ...interface{}) (adapter.QueryResult, error) {
+	panic("nop")
+}
+func (this *SchemaManagerFixture) BeginTx(_ context.Context, _ *sql.TxOptions) (adapter.Transaction, error) {
+	panic("nop")
+}
+func (this *SchemaManagerFixture) DBHandle() *sql.DB { panic("nop") }
+func (this *SchemaManagerFixture) Close() error      { panic("nop") }
+
+// versionRow is the result of a query selecting a single (schema version) value.
+type versionRow struct {
+	version int
+	err     error
+}
+
+func (this versionRow) Scan(fields ...interface{}) error {
+	switch field := fields[0].(type) {
+	case *int:
+		*field = this.version
+	case *sql.NullInt64:
+		*field = sql.NullInt64{Int64: int64(this.version), Valid: this.version > 0}
+	}
+	return this.err
+}
@@ -14,6 +14,13 @@ type Subscription struct {
 	handlers          []messaging.Handler
 	bufferCapacity    uint16
 	establishTopology bool
+	verifyTopology    bool // passively check that the topology exists when it isn't being established
+	temporaryQueue    bool // a broker-named queue that only lives as long as the subscriber's connection
+	singleActive      bool // standby consumers only receive messages when the active consumer disappears
+	consumerPriority  int32
+	maxPriority       uint8 // the highest message priority honored by the queue, zero disables prioritization
+	replayable        bool  // an append-only log which consumers re-read from a stored or starting sequence
+	sequence          uint64
 	batchCapacity     uint16
 	handleDelivery    bool
 	bufferTimeout     time.Duration // the amount of time to rest and buffer between batches (instead of going as quickly as possible)
@@ -24,11 +31,18 @@ type Subscription struct {
 
 func (this Subscription) streamConfig() messaging.StreamConfig {
 	return messaging.StreamConfig{
-		EstablishTopology: this.establishTopology,
-		ExclusiveStream:   len(this.handlers) <= 1,
-		BufferCapacity:    this.bufferCapacity,
-		StreamName:        this.queue,
-		Topics:            this.topics,
+		EstablishTopology:    this.establishTopology,
+		VerifyTopology:       this.verifyTopology,
+		ExclusiveStream:      len(this.handlers) <= 1,
+		BufferCapacity:       this.bufferCapacity,
+		StreamName:           this.queue,
+		TemporaryStream:      this.temporaryQueue,
+		SingleActiveConsumer: this.singleActive,
+		ConsumerPriority:     this.consumerPriority,
+		MaxPriority:          this.maxPriority,
+		Replayable:           this.replayable,
+		Sequence:             this.sequence,
+		Topics:               this.topics,
 	}
 }
 func (this Subscription) hardShutdown(potentialParent context.Context) (context.Context, context.CancelFunc) {
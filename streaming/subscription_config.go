@@ -47,6 +47,27 @@ func (subscriptionSingleton) BufferDelayBetweenBatches(value time.Duration) subs
 func (subscriptionSingleton) EstablishTopology(value bool) subscriptionOption {
 	return func(this *Subscription) { this.establishTopology = value }
 }
+func (subscriptionSingleton) VerifyTopology(value bool) subscriptionOption {
+	return func(this *Subscription) { this.verifyTopology = value }
+}
+func (subscriptionSingleton) TemporaryQueue(value bool) subscriptionOption {
+	return func(this *Subscription) { this.temporaryQueue = value }
+}
+func (subscriptionSingleton) SingleActiveConsumer(value bool) subscriptionOption {
+	return func(this *Subscription) { this.singleActive = value }
+}
+func (subscriptionSingleton) ConsumerPriority(value int32) subscriptionOption {
+	return func(this *Subscription) { this.consumerPriority = value }
+}
+func (subscriptionSingleton) MaxPriority(value uint8) subscriptionOption {
+	return func(this *Subscription) { this.maxPriority = value }
+}
+func (subscriptionSingleton) Replayable(value bool) subscriptionOption {
+	return func(this *Subscription) { this.replayable = value }
+}
+func (subscriptionSingleton) StartingSequence(value uint64) subscriptionOption {
+	return func(this *Subscription) { this.sequence = value }
+}
 func (subscriptionSingleton) Topics(values ...string) subscriptionOption {
 	return func(this *Subscription) { this.topics = values }
 }
@@ -94,6 +115,13 @@ func (subscriptionSingleton) defaults(options ...subscriptionOption) []subscript
 	const defaultBatchCapacity = 1
 	const defaultBatchDelay = 0
 	const defaultEstablishTopology = true
+	const defaultVerifyTopology = false
+	const defaultTemporaryQueue = false
+	const defaultSingleActiveConsumer = false
+	const defaultConsumerPriority = 0
+	const defaultMaxPriority = 0
+	const defaultReplayable = false
+	const defaultStartingSequence = 0
 	const defaultPassFullDeliveryToHandler = false
 	const defaultReconnectDelay = time.Second * 5
 	const defaultShutdownStrategy = ShutdownStrategyDrain
@@ -104,6 +132,13 @@ func (subscriptionSingleton) defaults(options ...subscriptionOption) []subscript
 		SubscriptionOptions.BatchCapacity(defaultBatchCapacity),
 		SubscriptionOptions.BufferDelayBetweenBatches(defaultBatchDelay),
 		SubscriptionOptions.EstablishTopology(defaultEstablishTopology),
+		SubscriptionOptions.VerifyTopology(defaultVerifyTopology),
+		SubscriptionOptions.TemporaryQueue(defaultTemporaryQueue),
+		SubscriptionOptions.SingleActiveConsumer(defaultSingleActiveConsumer),
+		SubscriptionOptions.ConsumerPriority(defaultConsumerPriority),
+		SubscriptionOptions.MaxPriority(defaultMaxPriority),
+		SubscriptionOptions.Replayable(defaultReplayable),
+		SubscriptionOptions.StartingSequence(defaultStartingSequence),
 		SubscriptionOptions.FullDeliveryToHandler(defaultPassFullDeliveryToHandler),
 		SubscriptionOptions.ReconnectDelay(defaultReconnectDelay),
 		SubscriptionOptions.ShutdownStrategy(defaultShutdownStrategy, defaultShutdownTimeout),
@@ -46,6 +46,13 @@ func (this *SubscriptionConfigFixture) TestWhenValuesAreProvided_SubscriptionSho
 		SubscriptionOptions.BufferCapacity(2),
 		SubscriptionOptions.BufferDelayBetweenBatches(3),
 		SubscriptionOptions.EstablishTopology(true),
+		SubscriptionOptions.VerifyTopology(true),
+		SubscriptionOptions.TemporaryQueue(true),
+		SubscriptionOptions.SingleActiveConsumer(true),
+		SubscriptionOptions.ConsumerPriority(7),
+		SubscriptionOptions.MaxPriority(10),
+		SubscriptionOptions.Replayable(true),
+		SubscriptionOptions.StartingSequence(8),
 		SubscriptionOptions.FullDeliveryToHandler(true),
 		SubscriptionOptions.ReconnectDelay(5),
 		SubscriptionOptions.ShutdownStrategy(ShutdownStrategyCurrentBatch, 4),
@@ -58,6 +65,13 @@ func (this *SubscriptionConfigFixture) TestWhenValuesAreProvided_SubscriptionSho
 		handlers:          []messaging.Handler{nil},
 		bufferCapacity:    2,
 		establishTopology: true,
+		verifyTopology:    true,
+		temporaryQueue:    true,
+		singleActive:      true,
+		consumerPriority:  7,
+		maxPriority:       10,
+		replayable:        true,
+		sequence:          8,
 		batchCapacity:     1,
 		handleDelivery:    true,
 		bufferTimeout:     3,